@@ -3,48 +3,222 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
 	"crypto/sha3"
+	"crypto/subtle"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"expvar"
 	"fmt"
+	"io"
 	"log"
+	"math"
+	mrand "math/rand"
 	"net"
 	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
+	"syscall"
 	"time"
 
+	"golang.org/x/crypto/argon2"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protowire"
 	// "your_project/proto" // In a real project, this would be your generated gRPC proto package
 )
 
 // --- Mock gRPC Protobuf Definitions (replace with actual generated code) ---
 // These structs mimic the generated gRPC types for demonstration.
 type Transaction struct {
-	Hash      []byte
-	Sender    []byte
-	Recipient []byte
-	Amount    uint64
-	Signature []byte
+	Hash       []byte
+	Sender     []byte
+	Recipient  []byte
+	Amount     uint64
+	Timestamp  uint64 // Unix seconds, covered by the signature to bound replay
+	ChainId    string // Network the transaction is valid on, covered by the signature
+	Kind       TxKind // What the transaction does; the zero value is a vote
+	Payload    []byte // Kind-specific data, e.g. an encoded ValidatorChange
+	Signature  []byte
+	SigScheme  SigScheme  // How Sender and Signature are encoded; the zero value is Ed25519
+	BallotType BallotType // For votes: valid, abstain or spoiled; the zero value is a vote for Recipient
+	Fee        uint64     // Paid to the block proposer, or burned, per the genesis fee policy
+	Nonce      []byte     // For votes revealed under commit-reveal: the nonce their commitment was made with
+	Version    uint32     // Wire format version; 0 predates versioning and is read as version 1
+}
+
+// TxKind distinguishes votes from governance transactions.
+type TxKind uint32
+
+const (
+	TxKindVote              TxKind = 0 // Recipient is the candidate ID, Payload the election ID
+	TxKindValidatorChange   TxKind = 1 // Payload is a ValidatorChange
+	TxKindRegisterCandidate TxKind = 2 // Payload is a CandidateRegistration
+	TxKindGenesis           TxKind = 3 // Payload is a GenesisState; only valid in the genesis block
+	TxKindVoteCommit        TxKind = 4 // Recipient is a VoteCommitment, Payload the election ID
+)
+
+// Newest wire format versions this node can read. A format change adds a
+// version and a decoder for it, so nodes can be upgraded before anyone sends
+// the new format; until then, older nodes refuse it with ErrUnknownVersion
+// rather than misreading it.
+const (
+	TxVersion    = 1
+	BlockVersion = 1
+)
+
+// SigScheme identifies the signature algorithm of a transaction's sender key.
+// The scheme is not covered by the signature: verifying under the wrong scheme
+// fails anyway, since the key and signature encodings differ.
+type SigScheme uint32
+
+const (
+	SigSchemeEd25519   SigScheme = 0
+	SigSchemeSecp256k1 SigScheme = 1 // ECDSA over SHA-256, for hardware-wallet compatibility
+)
+
+// BallotType distinguishes a vote for a candidate from an abstention or a
+// spoiled ballot, which count towards turnout but not towards any candidate.
+type BallotType uint32
+
+const (
+	BallotValid   BallotType = 0 // Recipient is the candidate voted for
+	BallotAbstain BallotType = 1 // Voter took part but chose no candidate; Recipient is empty
+	BallotSpoiled BallotType = 2 // Ballot was cast but is unusable, e.g. marked for several candidates; Recipient is empty
+)
+
+// ballotTypeNames are the names accepted by /vote and used in JSON.
+var ballotTypeNames = map[BallotType]string{
+	BallotValid:   "valid",
+	BallotAbstain: "abstain",
+	BallotSpoiled: "spoiled",
+}
+
+func (b BallotType) String() string {
+	if name, ok := ballotTypeNames[b]; ok {
+		return name
+	}
+	return fmt.Sprintf("BallotType(%d)", uint32(b))
+}
+
+// MarshalText encodes the ballot type by name, e.g. as a JSON map key.
+func (b BallotType) MarshalText() ([]byte, error) {
+	return []byte(b.String()), nil
+}
+
+// ParseBallotType returns the ballot type with the given name.
+func ParseBallotType(name string) (BallotType, error) {
+	for b, n := range ballotTypeNames {
+		if n == name {
+			return b, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown ballot type %q", name)
+}
+
+// SigningBytes returns the message the sender signs: every field except Hash,
+// Signature and SigScheme. Including ChainId stops a transaction signed for one
+// network being replayed on another. BallotType, Fee, Nonce and Version are
+// appended only when set, so the signing bytes of ordinary votes are unchanged.
+func (tx *Transaction) SigningBytes() []byte {
+	msg := fmt.Sprintf("%s|%d|%x|%x|%d|%d|%x", tx.ChainId, tx.Kind, tx.Sender, tx.Recipient, tx.Amount, tx.Timestamp, tx.Payload)
+	if tx.BallotType != BallotValid {
+		msg += fmt.Sprintf("|%d", tx.BallotType)
+	}
+	if tx.Fee != 0 {
+		msg += fmt.Sprintf("|fee=%d", tx.Fee)
+	}
+	if len(tx.Nonce) > 0 {
+		msg += fmt.Sprintf("|nonce=%x", tx.Nonce)
+	}
+	if tx.Version != 0 {
+		msg += fmt.Sprintf("|v=%d", tx.Version)
+	}
+	return []byte(msg)
 }
 
 type BlockHeader struct {
+	Hash          []byte // Chain hash (see Hasher) of the other header fields
 	Version       uint32
 	PrevBlockHash []byte
 	MerkleRoot    []byte
 	Timestamp     uint64
 	Height        uint64
+	ChainId       string
+	Proposer      []byte // Validator key the block's fees are credited to
+	Fees          uint64 // Sum of the Fee of every transaction in the block
 }
 
 type Block struct {
-	Header *BlockHeader
+	Header       *BlockHeader
 	Transactions []*Transaction
 }
 
+type HandshakeRequest struct {
+	ChainId         string
+	Addr            string
+	Timestamp       int64  // Sender's clock in Unix milliseconds, used to measure skew
+	GenesisHash     []byte // Peers must agree on genesis, not just the chain ID
+	ProtocolVersion string // See ProtocolVersion; empty from nodes that predate it
+	NodeId          string // See P2PNode.NodeID; informational, for logs
+	Challenge       []byte // Random bytes the responder signs to prove its identity key
+}
+type HandshakeResponse struct {
+	ChainId         string
+	Timestamp       int64 // Responder's clock in Unix milliseconds
+	GenesisHash     []byte
+	ProtocolVersion string
+	NodeId          string
+	IdentityKey     []byte // Responder's Ed25519 identity key; NodeId must be derived from it
+	IdentitySig     []byte // IdentityKey's signature over handshakeProof of the request's Challenge
+	TipHeight       uint64 // Height of the responder's best block
+}
+
 type GetKnownPeersRequest struct{}
 type GetKnownPeersResponse struct {
-	PeerAddresses []string
+	PeerAddresses []string       // Addresses of Peers, kept for older clients
+	Peers         []*PeerAddress // Healthy peers with their last-contact time, freshest first
+}
+
+type PeerAddress struct {
+	Addr     string
+	LastSeen uint64 // Unix seconds of the last successful contact
+}
+
+type GetPeerInfoRequest struct{}
+type GetPeerInfoResponse struct {
+	Self  *PeerInfo   // The answering node
+	Peers []*PeerInfo // Its connected peers, highest tip first
+}
+
+// PeerInfo is what a node knows about one peer from its handshake and sync.
+type PeerInfo struct {
+	Addr            string // Advertised address
+	NodeId          string // Empty unless IdentityKey is set
+	IdentityKey     []byte // Set only if the peer proved it in the handshake
+	ProtocolVersion string
+	TipHeight       uint64 // Best block height last reported by the peer
 }
 
 type SendTransactionRequest struct {
@@ -52,6 +226,55 @@ type SendTransactionRequest struct {
 }
 type SendTransactionResponse struct {
 	Success bool
+	Receipt *TxReceipt // Signed proof of acceptance, set when Success is true
+}
+
+// TxReceipt is a node's signed statement that it accepted a transaction.
+type TxReceipt struct {
+	TxHash     []byte `json:"tx_hash"`
+	NodePubKey []byte `json:"node_pub_key"` // Ed25519 identity key of the accepting node
+	Timestamp  uint64 `json:"timestamp"`    // Unix seconds when the node accepted the transaction
+	Signature  []byte `json:"signature"`
+}
+
+// SigningBytes returns the message the accepting node signs.
+func (r *TxReceipt) SigningBytes() []byte {
+	return []byte(fmt.Sprintf("receipt|%x|%x|%d", r.TxHash, r.NodePubKey, r.Timestamp))
+}
+
+type GetBlockRequest struct {
+	Height uint64
+}
+type GetBlockResponse struct {
+	Block *Block
+}
+
+type GetBlocksRequest struct {
+	FromHeight uint64
+	Count      uint64
+}
+type GetBlocksResponse struct {
+	Blocks    []*Block // Consecutive blocks from FromHeight; short if the peer's chain ends sooner
+	TipHeight uint64   // Height of the peer's best block when it answered
+}
+
+type GetStatusRequest struct{}
+type GetStatusResponse struct {
+	TipHeight    uint64 // Height of the peer's best block
+	TipHash      []byte
+	PrunedHeight uint64 // Highest height whose block body the peer has pruned; 0 if it has every block
+}
+
+type GetMempoolRequest struct{}
+type GetMempoolResponse struct {
+	TxHashes [][]byte // Hashes of every pending transaction, oldest first
+}
+
+type GetTransactionsRequest struct {
+	Hashes [][]byte
+}
+type GetTransactionsResponse struct {
+	Transactions []*Transaction // The requested transactions the peer still has pending
 }
 
 type SendBlockRequest struct {
@@ -63,313 +286,4205 @@ type SendBlockResponse struct {
 
 // NodeServiceServer interface (mimics generated gRPC server interface)
 type NodeServiceServer interface {
+	Handshake(context.Context, *HandshakeRequest) (*HandshakeResponse, error)
 	GetKnownPeers(context.Context, *GetKnownPeersRequest) (*GetKnownPeersResponse, error)
+	GetPeerInfo(context.Context, *GetPeerInfoRequest) (*GetPeerInfoResponse, error)
 	SendTransaction(context.Context, *SendTransactionRequest) (*SendTransactionResponse, error)
 	SendBlock(context.Context, *SendBlockRequest) (*SendBlockResponse, error)
+	GetBlock(context.Context, *GetBlockRequest) (*GetBlockResponse, error)
+	GetBlocks(context.Context, *GetBlocksRequest) (*GetBlocksResponse, error)
+	GetStatus(context.Context, *GetStatusRequest) (*GetStatusResponse, error)
+	GetMempool(context.Context, *GetMempoolRequest) (*GetMempoolResponse, error)
+	GetTransactions(context.Context, *GetTransactionsRequest) (*GetTransactionsResponse, error)
 }
 
 // NodeServiceClient interface (mimics generated gRPC client interface)
 type NodeServiceClient interface {
+	Handshake(ctx context.Context, in *HandshakeRequest, opts ...grpc.CallOption) (*HandshakeResponse, error)
 	GetKnownPeers(ctx context.Context, in *GetKnownPeersRequest, opts ...grpc.CallOption) (*GetKnownPeersResponse, error)
+	GetPeerInfo(ctx context.Context, in *GetPeerInfoRequest, opts ...grpc.CallOption) (*GetPeerInfoResponse, error)
 	SendTransaction(ctx context.Context, in *SendTransactionRequest, opts ...grpc.CallOption) (*SendTransactionResponse, error)
 	SendBlock(ctx context.Context, in *SendBlockRequest, opts ...grpc.CallOption) (*SendBlockResponse, error)
+	GetBlock(ctx context.Context, in *GetBlockRequest, opts ...grpc.CallOption) (*GetBlockResponse, error)
+	GetBlocks(ctx context.Context, in *GetBlocksRequest, opts ...grpc.CallOption) (*GetBlocksResponse, error)
+	GetStatus(ctx context.Context, in *GetStatusRequest, opts ...grpc.CallOption) (*GetStatusResponse, error)
+	GetMempool(ctx context.Context, in *GetMempoolRequest, opts ...grpc.CallOption) (*GetMempoolResponse, error)
+	GetTransactions(ctx context.Context, in *GetTransactionsRequest, opts ...grpc.CallOption) (*GetTransactionsResponse, error)
 }
 
-// --- End Mock gRPC Protobuf Definitions ---
+// CandidateTally is one candidate's vote count in an ElectionStatus.
+type CandidateTally struct {
+	Candidate string `json:"candidate"`
+	Votes     uint64 `json:"votes"`
+	Percent   string `json:"percent,omitempty"` // Share of valid votes as a decimal, e.g. "33.34"; see setPercentages
+}
 
-// P2PNode represents a lightweight network node for NaijaVote
-type P2PNode struct {
-	Addr        string
-	Peers       map[string]NodeServiceClient
-	KnownNodes  map[string]bool
-	TxPool      chan *Transaction // For incoming vote transactions
-	BlockChan   chan *Block       // For incoming blocks
-	mu          sync.RWMutex
-	grpcServer  *grpc.Server
-	// Mock Rust Consensus Engine interaction
-	// rustEngine *consensus.NaijaConsensusEngine // Conceptual link
+// ElectionStatus mirrors the ElectionStatus message in proto/election_status.proto.
+type ElectionStatus struct {
+	ElectionID            string           `json:"election_id,omitempty"` // Empty for the default election
+	TotalVotes            uint64           `json:"total_votes"`
+	Candidates            []CandidateTally `json:"candidates"` // Sorted by votes descending, then candidate ID
+	LatestBlockHash       string           `json:"latest_block_hash"`
+	BlockHeight           uint64           `json:"block_height"`
+	FinalityTimeSeconds   uint32           `json:"finality_time_seconds"`
+	ValidatorsActive      uint32           `json:"validators_active"`
+	Isolated              bool             `json:"isolated"`                 // Node has lost all peers
+	PeerClockSkewMs       map[string]int64 `json:"peer_clock_skew_ms"`       // Peer clock minus ours, per connected peer
+	InclusionLatencyP50Ms uint64           `json:"inclusion_latency_p50_ms"` // Time from mempool admission to block, over recent transactions
+	InclusionLatencyP95Ms uint64           `json:"inclusion_latency_p95_ms"`
+	AbstainVotes          uint64           `json:"abstain_votes"` // Turnout that counts towards no candidate
+	SpoiledVotes          uint64           `json:"spoiled_votes"`
+	Leaders               []string         `json:"leaders"` // Candidates with the most votes, by candidate ID; several if tied
+	Tie                   bool             `json:"tie"`     // More than one candidate shares the most votes
 }
 
-// NewP2PNode creates a new P2P network node
-func NewP2PNode(addr string) *P2PNode {
-	return &P2PNode{
-		Addr:       addr,
-		Peers:      make(map[string]NodeServiceClient),
-		KnownNodes: make(map[string]bool),
-		TxPool:     make(chan *Transaction, 1000),
-		BlockChan:  make(chan *Block, 100),
+// MarshalProto encodes the status in protobuf wire format.
+// In a real project, this would be proto.Marshal on the generated message.
+func (m *ElectionStatus) MarshalProto() []byte {
+	var b []byte
+	b = protowire.AppendTag(b, 1, protowire.VarintType)
+	b = protowire.AppendVarint(b, m.TotalVotes)
+	for _, tally := range m.Candidates {
+		var entry []byte
+		entry = protowire.AppendTag(entry, 1, protowire.BytesType)
+		entry = protowire.AppendString(entry, tally.Candidate)
+		entry = protowire.AppendTag(entry, 2, protowire.VarintType)
+		entry = protowire.AppendVarint(entry, tally.Votes)
+		if tally.Percent != "" {
+			entry = protowire.AppendTag(entry, 3, protowire.BytesType)
+			entry = protowire.AppendString(entry, tally.Percent)
+		}
+		b = protowire.AppendTag(b, 2, protowire.BytesType)
+		b = protowire.AppendBytes(b, entry)
 	}
+	b = protowire.AppendTag(b, 3, protowire.BytesType)
+	b = protowire.AppendString(b, m.LatestBlockHash)
+	b = protowire.AppendTag(b, 4, protowire.VarintType)
+	b = protowire.AppendVarint(b, m.BlockHeight)
+	b = protowire.AppendTag(b, 5, protowire.VarintType)
+	b = protowire.AppendVarint(b, uint64(m.FinalityTimeSeconds))
+	b = protowire.AppendTag(b, 6, protowire.VarintType)
+	b = protowire.AppendVarint(b, uint64(m.ValidatorsActive))
+	b = protowire.AppendTag(b, 7, protowire.VarintType)
+	b = protowire.AppendVarint(b, protowire.EncodeBool(m.Isolated))
+	for addr, skew := range m.PeerClockSkewMs {
+		var entry []byte
+		entry = protowire.AppendTag(entry, 1, protowire.BytesType)
+		entry = protowire.AppendString(entry, addr)
+		entry = protowire.AppendTag(entry, 2, protowire.VarintType)
+		entry = protowire.AppendVarint(entry, protowire.EncodeZigZag(skew))
+		b = protowire.AppendTag(b, 8, protowire.BytesType)
+		b = protowire.AppendBytes(b, entry)
+	}
+	b = protowire.AppendTag(b, 9, protowire.VarintType)
+	b = protowire.AppendVarint(b, m.InclusionLatencyP50Ms)
+	b = protowire.AppendTag(b, 10, protowire.VarintType)
+	b = protowire.AppendVarint(b, m.InclusionLatencyP95Ms)
+	b = protowire.AppendTag(b, 11, protowire.VarintType)
+	b = protowire.AppendVarint(b, m.AbstainVotes)
+	b = protowire.AppendTag(b, 12, protowire.VarintType)
+	b = protowire.AppendVarint(b, m.SpoiledVotes)
+	for _, leader := range m.Leaders {
+		b = protowire.AppendTag(b, 13, protowire.BytesType)
+		b = protowire.AppendString(b, leader)
+	}
+	b = protowire.AppendTag(b, 14, protowire.VarintType)
+	b = protowire.AppendVarint(b, protowire.EncodeBool(m.Tie))
+	if m.ElectionID != "" {
+		b = protowire.AppendTag(b, 15, protowire.BytesType)
+		b = protowire.AppendString(b, m.ElectionID)
+	}
+	return b
 }
 
-// StartGRPCServer starts the gRPC server for the node.
-// This method should be run in a goroutine.
-func (n *P2PNode) StartGRPCServer() {
-	lis, err := net.Listen("tcp", n.Addr)
-	if err != nil {
-		log.Fatalf("failed to listen: %v", err)
+// MarshalProto encodes the transaction in protobuf wire format (see proto/transaction.proto).
+// In a real project, this would be proto.Marshal on the generated message.
+func (tx *Transaction) MarshalProto() []byte {
+	var b []byte
+	b = protowire.AppendTag(b, 1, protowire.BytesType)
+	b = protowire.AppendBytes(b, tx.Hash)
+	b = protowire.AppendTag(b, 2, protowire.BytesType)
+	b = protowire.AppendBytes(b, tx.Sender)
+	b = protowire.AppendTag(b, 3, protowire.BytesType)
+	b = protowire.AppendBytes(b, tx.Recipient)
+	b = protowire.AppendTag(b, 4, protowire.VarintType)
+	b = protowire.AppendVarint(b, tx.Amount)
+	b = protowire.AppendTag(b, 5, protowire.VarintType)
+	b = protowire.AppendVarint(b, tx.Timestamp)
+	b = protowire.AppendTag(b, 6, protowire.BytesType)
+	b = protowire.AppendString(b, tx.ChainId)
+	b = protowire.AppendTag(b, 7, protowire.BytesType)
+	b = protowire.AppendBytes(b, tx.Signature)
+	b = protowire.AppendTag(b, 8, protowire.VarintType)
+	b = protowire.AppendVarint(b, uint64(tx.Kind))
+	b = protowire.AppendTag(b, 9, protowire.BytesType)
+	b = protowire.AppendBytes(b, tx.Payload)
+	b = protowire.AppendTag(b, 10, protowire.VarintType)
+	b = protowire.AppendVarint(b, uint64(tx.SigScheme))
+	b = protowire.AppendTag(b, 11, protowire.VarintType)
+	b = protowire.AppendVarint(b, uint64(tx.BallotType))
+	if tx.Fee != 0 {
+		b = protowire.AppendTag(b, 12, protowire.VarintType)
+		b = protowire.AppendVarint(b, tx.Fee)
 	}
-	n.grpcServer = grpc.NewServer()
-	// In a real project: pb.RegisterNodeServiceServer(n.grpcServer, n)
-	log.Printf("gRPC server listening on %s", n.Addr)
-	if err := n.grpcServer.Serve(lis); err != nil {
-		log.Fatalf("failed to serve: %v", err)
+	if len(tx.Nonce) > 0 {
+		b = protowire.AppendTag(b, 13, protowire.BytesType)
+		b = protowire.AppendBytes(b, tx.Nonce)
 	}
+	if tx.Version != 0 {
+		b = protowire.AppendTag(b, 14, protowire.VarintType)
+		b = protowire.AppendVarint(b, uint64(tx.Version))
+	}
+	return b
 }
 
-// ConnectToPeer establishes a gRPC connection to another peer.
-func (n *P2PNode) ConnectToPeer(peerAddr string) error {
-	n.mu.Lock()
-	defer n.mu.Unlock()
-
-	if _, ok := n.Peers[peerAddr]; ok {
-		return nil
+// peekVersion returns the varint at field num of the message b, or 0 if it is
+// absent, so a decoder can choose how to read the rest of the message.
+func peekVersion(b []byte, num protowire.Number) (uint64, error) {
+	var version uint64
+	for len(b) > 0 {
+		field, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return 0, protowire.ParseError(n)
+		}
+		b = b[n:]
+		if field == num && typ == protowire.VarintType {
+			v, n := protowire.ConsumeVarint(b)
+			if n < 0 {
+				return 0, protowire.ParseError(n)
+			}
+			version, b = v, b[n:]
+			continue
+		}
+		n = protowire.ConsumeFieldValue(field, typ, b)
+		if n < 0 {
+			return 0, protowire.ParseError(n)
+		}
+		b = b[n:]
 	}
+	return version, nil
+}
 
-	conn, err := grpc.Dial(peerAddr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+// UnmarshalProto decodes a transaction from protobuf wire format, dispatching
+// on its version. A version newer than TxVersion fails with ErrUnknownVersion.
+func (tx *Transaction) UnmarshalProto(b []byte) error {
+	version, err := peekVersion(b, 14)
 	if err != nil {
-		return fmt.Errorf("failed to connect to peer %s: %v", peerAddr, err)
+		return err
 	}
-	client := &mockNodeServiceClient{} // Replace with actual gRPC client
-	n.Peers[peerAddr] = client
-	n.KnownNodes[peerAddr] = true
-	log.Printf("Connected to peer: %s", peerAddr)
-	return nil
-}
-
-// DiscoverPeers periodically discovers and connects to new peers.
-// This method should be run in a goroutine.
-func (n *P2PNode) DiscoverPeers(initialPeers []string) {
-	for _, peer := range initialPeers {
-		n.KnownNodes[peer] = true
+	switch version {
+	case 0, 1:
+		return tx.unmarshalV1(b)
+	default:
+		return fmt.Errorf("%w: transaction version %d, newest known is %d", ErrUnknownVersion, version, TxVersion)
 	}
+}
 
-	ticker := time.NewTicker(30 * time.Second)
-	defer ticker.Stop()
-
-	for range ticker.C {
-		n.mu.RLock()
-		peersToQuery := make([]string, 0, len(n.Peers))
-		for addr := range n.Peers {
-			peersToQuery = append(peersToQuery, addr)
+// unmarshalV1 decodes a version 1 transaction. Unknown fields are skipped, as
+// a generated decoder would.
+func (tx *Transaction) unmarshalV1(b []byte) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
 		}
-		n.mu.RUnlock()
-
-		for _, peerAddr := range peersToQuery {
-			client, ok := n.Peers[peerAddr]
-			if !ok {
-				continue
+		b = b[n:]
+		switch {
+		case typ == protowire.BytesType && (num == 1 || num == 2 || num == 3 || num == 6 || num == 7 || num == 9 || num == 13):
+			v, n := protowire.ConsumeBytes(b)
+			if n < 0 {
+				return protowire.ParseError(n)
 			}
-			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-			resp, err := client.GetKnownPeers(ctx, &GetKnownPeersRequest{})
-			cancel()
-			if err != nil {
-				log.Printf("Failed to get peers from %s: %v", peerAddr, err)
-				n.mu.Lock()
-				delete(n.Peers, peerAddr)
-				n.mu.Unlock()
-				continue
+			b = b[n:]
+			switch num {
+			case 1:
+				tx.Hash = append([]byte(nil), v...)
+			case 2:
+				tx.Sender = append([]byte(nil), v...)
+			case 3:
+				tx.Recipient = append([]byte(nil), v...)
+			case 6:
+				tx.ChainId = string(v)
+			case 7:
+				tx.Signature = append([]byte(nil), v...)
+			case 9:
+				tx.Payload = append([]byte(nil), v...)
+			case 13:
+				tx.Nonce = append([]byte(nil), v...)
 			}
-			for _, newPeerAddr := range resp.GetPeerAddresses() {
-				if newPeerAddr != n.Addr {
-					n.mu.Lock()
-					if _, known := n.KnownNodes[newPeerAddr]; !known {
-						n.KnownNodes[newPeerAddr] = true
-						go n.ConnectToPeer(newPeerAddr)
-					}
-					n.mu.Unlock()
-				}
+		case typ == protowire.VarintType && (num == 4 || num == 5 || num == 8 || (num >= 10 && num <= 12) || num == 14):
+			v, n := protowire.ConsumeVarint(b)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			b = b[n:]
+			switch num {
+			case 4:
+				tx.Amount = v
+			case 5:
+				tx.Timestamp = v
+			case 8:
+				tx.Kind = TxKind(v)
+			case 10:
+				tx.SigScheme = SigScheme(v)
+			case 11:
+				tx.BallotType = BallotType(v)
+			case 12:
+				tx.Fee = v
+			case 14:
+				tx.Version = uint32(v)
+			}
+		default:
+			n := protowire.ConsumeFieldValue(num, typ, b)
+			if n < 0 {
+				return protowire.ParseError(n)
 			}
+			b = b[n:]
 		}
 	}
+	return nil
 }
 
-// BroadcastTransaction broadcasts a transaction to all connected peers.
-func (n *P2PNode) BroadcastTransaction(tx *Transaction) {
-	n.mu.RLock()
-	defer n.mu.RUnlock()
+// --- End Mock gRPC Protobuf Definitions ---
 
-	for addr, client := range n.Peers {
-		go func(addr string, client NodeServiceClient) {
-			ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
-			_, err := client.SendTransaction(ctx, &SendTransactionRequest{Transaction: tx})
-			cancel()
-			if err != nil {
-				log.Printf("Failed to send transaction to %s: %v", addr, err)
-			}
-		}(addr, client)
+// Default replay window for incoming transactions
+const (
+	DefaultMaxTxAge       = 10 * time.Minute
+	DefaultMaxTxClockSkew = 30 * time.Second
+)
+
+// DefaultMaxPeerClockSkew is how far a peer's clock may drift from ours before
+// we warn. Larger skew makes a node reject valid blocks and tokens as too-future.
+const DefaultMaxPeerClockSkew = 5 * time.Second
+
+// ProtocolVersion is the "major.minor" wire protocol version sent in the
+// handshake. Peers must share the major version; a minor bump only adds
+// fields older nodes ignore.
+const ProtocolVersion = "1.0"
+
+// checkProtocolVersion returns an error unless version shares our major
+// version. Peers that predate versioning send none and are treated as 1.0.
+func checkProtocolVersion(version string) error {
+	if version == "" {
+		version = "1.0"
+	}
+	ours, _, _ := strings.Cut(ProtocolVersion, ".")
+	theirs, _, _ := strings.Cut(version, ".")
+	major, err := strconv.ParseUint(theirs, 10, 32)
+	if err != nil {
+		return fmt.Errorf("malformed protocol version %q", version)
+	}
+	if strconv.FormatUint(major, 10) != ours {
+		return fmt.Errorf("incompatible protocol version %s, expected %s.x", version, ours)
 	}
+	return nil
 }
 
-// --- gRPC Service Method Implementations (for P2PNode to act as a server) ---
+// Peer exchange (PEX) limits. Only peers contacted within PeerHealthyWindow are
+// shared or dialed, so dead addresses don't circulate through the network.
+const (
+	MaxPeerExchange   = 32
+	PeerHealthyWindow = 10 * time.Minute
+)
 
-func (n *P2PNode) GetKnownPeers(ctx context.Context, req *GetKnownPeersRequest) (*GetKnownPeersResponse, error) {
-	n.mu.RLock()
-	defer n.mu.RUnlock()
-	peers := make([]string, 0, len(n.KnownNodes))
-	for addr := range n.KnownNodes {
-		peers = append(peers, addr)
-	}
-	return &GetKnownPeersResponse{PeerAddresses: peers}, nil
+// DefaultChainID identifies the network a node belongs to. Testnets and devnets
+// must override it so their nodes never peer with or accept messages from mainnet.
+const DefaultChainID = "naijavote-mainnet"
+
+// DefaultGossipFanout sends each message to sqrt(peers) peers
+const DefaultGossipFanout = 1.0
+
+// DefaultBroadcastQueueLen is how many gossip sends may wait for one peer
+// before the node starts dropping them.
+const DefaultBroadcastQueueLen = 256
+
+// DropPolicy chooses which send is dropped when a peer's outbound queue is
+// full.
+type DropPolicy int
+
+const (
+	DropOldest DropPolicy = iota // Drop the longest-waiting send, favouring fresh messages
+	DropNewest                   // Drop the send being queued, favouring those already waiting
+)
+
+// Gossip sends dropped because a peer's outbound queue was full. The per-peer
+// counts show which peers cannot keep up; an address's entry is removed when
+// the node forgets the address, so the map is bounded by MaxKnownNodes.
+var (
+	broadcastDrops      = expvar.NewMap("p2p_broadcast_drops")
+	broadcastDropsTotal = expvar.NewInt("p2p_broadcast_drops_total")
+)
+
+// Bootstrap backoff: DiscoverPeers retries its seed peers starting at
+// BootstrapInitialBackoff and doubling up to BootstrapMaxBackoff until one connects.
+const (
+	BootstrapInitialBackoff = 500 * time.Millisecond
+	BootstrapMaxBackoff     = 30 * time.Second
+)
+
+// DefaultIsolationRetryInterval is how often an isolated node (zero peers) retries
+// its bootstrap and known peers, much faster than the normal discovery cadence.
+const DefaultIsolationRetryInterval = 5 * time.Second
+
+// DefaultDialTimeout bounds how long ConnectToPeer waits to connect to a peer
+// and complete the handshake.
+const DefaultDialTimeout = 5 * time.Second
+
+// DefaultMaxKnownNodes caps how many peer addresses a node remembers.
+const DefaultMaxKnownNodes = 1000
+
+// Reconnection to saved peers on startup: up to DefaultReconnectWorkers dials
+// run at once, and dialing stops once DefaultTargetPeers peers are connected.
+const (
+	DefaultReconnectWorkers = 4
+	DefaultTargetPeers      = 8
+)
+
+// Inbound RPC limits per remote host. Each request over the limit costs the
+// peer RateLimitPenalty score, and each block over the chain's size limits
+// OversizedBlockPenalty; a peer whose score falls to BanScore is banned.
+// An inbound peer that is not a known peer address is refused for
+// InboundBanDuration, and its score is forgotten InboundRecordTTL after its
+// last penalty.
+const (
+	DefaultPeerRPCLimit   = 200
+	DefaultPeerRPCWindow  = time.Second
+	RateLimitPenalty      = 1
+	OversizedBlockPenalty = 20
+	BanScore              = -100
+	InboundBanDuration    = time.Hour
+	InboundRecordTTL      = 10 * time.Minute
+)
+
+// isolationEvents counts how many times this node has lost all of its peers.
+var isolationEvents = expvar.NewInt("p2p_isolation_events")
+
+// P2PNode represents a lightweight network node for NaijaVote
+type P2PNode struct {
+	Addr                   string        // Bind address for the gRPC server
+	AdvertiseAddr          string        // Address peers use to reach us (e.g. behind NAT); defaults to Addr
+	ChainID                string        // Network identifier checked in the handshake and on every message
+	Mode                   NodeMode      // Whether this node proposes blocks (Validator) or only syncs and serves
+	Mempool                *Mempool      // Accepted transactions awaiting a block
+	BlockChan              chan *Block   // For incoming blocks
+	Orphans                *OrphanPool   // Blocks received ahead of their parent, held until it is imported
+	Chain                  *Chain        // Local copy of the blockchain
+	Audit                  *AuditLog     // Optional append-only record of accepted transactions
+	WAL                    *TxWAL        // Optional write-ahead log of client transactions awaiting a block
+	Results                *ResultStore  // Finalized election results; kept only in memory unless opened from a file
+	MaxTxAge               time.Duration // Oldest acceptable transaction timestamp
+	MaxTxSkew              time.Duration // How far in the future a transaction timestamp may be
+	IsolationRetryInterval time.Duration // Reconnect cadence while the node has no peers
+	DialTimeout            time.Duration // How long to wait to connect to and handshake with a peer
+	SyncWorkers            int           // Goroutines validating each batch of synced blocks; 1 validates serially
+	SyncStallTimeout       time.Duration // Longest wait for one batch of blocks before sync falls back to another peer
+	VerifyWorkers          int           // Goroutines verifying the signatures of a transaction batch, capped at runtime.NumCPU(); 1 verifies serially
+	Transport              Transport     // How the node dials and serves peers; gRPC unless replaced, e.g. by a MemoryNetwork
+	MaxKnownNodes          int           // Cap on known peer addresses; least recently useful addresses are evicted
+	ReconnectWorkers       int           // Saved peer addresses dialed at once on startup; 1 dials serially
+	TargetPeers            int           // Connected peers at which startup stops dialing saved addresses; 0 dials them all
+	PeerLimiter            *RateLimiter  // Inbound RPCs allowed per remote host; excess requests get ResourceExhausted
+	MaxInboundConns        int           // Inbound peer connections held open at once across all listen addresses; 0 means no limit
+	TLSConfig              *tls.Config   // Transport security for the gRPC server and outbound dials
+	AllowInsecure          bool          // Explicit opt-in to plaintext gRPC; never enable in production
+	AdminToken             string        // Bearer token for /admin endpoints, which are disabled while it is empty
+	ReadOnlyHTTP           bool          // Serve only /status, /results, /blocks, /version and /health over HTTP, e.g. on a public replica
+	AdminHTTPAddr          string        // Serve /admin and /debug endpoints only on this address, e.g. localhost:8081; empty serves them with the public API
+	GossipFanout           float64       // Broadcast to GossipFanout * sqrt(connected peers) peers per message
+	ResultPrecision        int           // Decimal places of the candidate percentages on /status, up to MaxResultPrecision
+	BroadcastQueueLen      int           // Block or transaction sends queued per peer before dropping; see BroadcastDropPolicy
+	BroadcastDropPolicy    DropPolicy    // Which send a full peer queue drops
+	MempoolHighWater       float64       // Mempool saturation above which /vote returns 503
+	MempoolSweepInterval   time.Duration // How often expired transactions are swept from the mempool
+	MaxMempoolAge          time.Duration // Longest a transaction may wait in the mempool; 0 disables the limit
+	RebroadcastAfter       time.Duration // Pending time after which a transaction is gossiped again; 0 disables rebroadcast
+	MaxRebroadcasts        int           // Most times one transaction is rebroadcast
+	BlockInterval          time.Duration // How often a validator checks whether it should propose
+	RoundTimeout           time.Duration // Wait for a round's leader before passing to the next; 0 never advances
+	MinPeersToPropose      int           // Connected peers a validator needs before it proposes; 0 proposes even when alone
+	AntiEntropyInterval    time.Duration // How often to reconcile mempools with random peers
+	AntiEntropyPeers       int           // Peers sampled per anti-entropy round
+	MaxPeerClockSkew       time.Duration // Clock difference above which a peer is reported as skewed
+	RefuseSkewedPeers      bool          // Refuse to peer with skewed nodes instead of only warning
+	ResyncOnDivergence     bool          // After sync, reorg onto a peer's heavier branch when our chains differ at the same height
+	FinalityDepth          uint64        // Blocks on top of an election's closing block before its result is frozen
+	FinalizeInterval       time.Duration // How often FinalizeElections looks for elections to finalize
+	mu                     sync.RWMutex
+	identityKey            ed25519.PrivateKey        // Signs transaction receipts
+	nodeID                 string                    // Derived from identityKey; see NodeID
+	peers                  map[string]*peerState     // Every known peer address and its connection state
+	inbound                map[string]*inboundRecord // Misbehaviour of inbound peers by remotePeer key; see PenalizePeer
+	nextInboundPrune       time.Time                 // When PenalizePeer next drops forgotten inbound records
+	bootstrapPeers         []string                  // Seed peers passed to DiscoverPeers, retried when isolated
+	isolated               bool                      // True after the node lost its last peer, until it reconnects
+	synced                 bool                      // True once SyncWithPeer has caught up to a peer's tip
+	waitingForPeers        bool                      // True while ProposeBlock is held back by MinPeersToPropose
+	shedding               bool                      // True while LoadShedding last found the node overloaded
+	seenBlocks             *seenSet                  // Recently received block hashes, so each is imported and relayed once
+	roundHeight            uint64                    // Height the current proposal round is for
+	round                  uint32                    // Proposal round at roundHeight; see Round
+	roundStarted           time.Time                 // When the current round began
+	startedAt              time.Time                 // When the node was created, for /nodeinfo uptime
+	inclusionLatency       *LatencyTracker           // Recent mempool-to-block latencies, reported on /status
+	explorer               explorerCache             // Last /explorer/summary; see ExplorerSummary
+	submissions            chan *Transaction         // Accepted /vote and /vote/commit transactions awaiting broadcast; see BroadcastSubmissions
+	broadcasts             sync.WaitGroup            // In-flight outbound sends, waited on by Close
+	outboxMu               sync.Mutex
+	outboxes               map[string]*peerOutbox // Gossip sends waiting per peer address; see enqueueSend
+	sendsClosed            bool                   // Close has queued its last sends, so no more may start; guarded by outboxMu
+	closeOnce              sync.Once
+	closing                context.Context // Cancelled by Close so in-flight dials and retry loops stop
+	stopClosing            context.CancelFunc
+	rngMu                  sync.Mutex
+	rng                    *mrand.Rand // Source for gossip peer sampling; seeded from crypto/rand unless replaced by UseRandSource
+	// Mock Rust Consensus Engine interaction
+	// rustEngine *consensus.NaijaConsensusEngine // Conceptual link
 }
 
-func (n *P2PNode) SendTransaction(ctx context.Context, req *SendTransactionRequest) (*SendTransactionResponse, error) {
-	log.Printf("Node %s received transaction: %x", n.Addr, req.GetTransaction().GetHash())
-	// In a real system: Validate, add to mempool, re-broadcast if new.
-	// Then, the full node would pass this to the Rust consensus engine.
-	select {
-	case n.TxPool <- req.GetTransaction():
-	default:
-		log.Printf("TxPool full, dropping transaction from %x", req.GetTransaction().GetHash())
+// NewP2PNode creates a new P2P network node
+func NewP2PNode(addr string) *P2PNode {
+	// Replaced via UseIdentityKey by deployments that keep a key across restarts
+	_, identityKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		log.Fatalf("failed to generate node identity key: %v", err)
 	}
-	return &SendTransactionResponse{Success: true}, nil
+	closing, stopClosing := context.WithCancel(context.Background())
+	chain, err := NewChain(GenesisBlock(DefaultGenesisConfig()))
+	if err != nil {
+		log.Fatalf("invalid default genesis: %v", err)
+	}
+	n := &P2PNode{
+		Addr:                   addr,
+		ChainID:                DefaultChainID,
+		Mode:                   ModeFull,
+		peers:                  make(map[string]*peerState),
+		inbound:                make(map[string]*inboundRecord),
+		outboxes:               make(map[string]*peerOutbox),
+		identityKey:            identityKey,
+		nodeID:                 NodeIDFor(identityKey.Public().(ed25519.PublicKey)),
+		closing:                closing,
+		stopClosing:            stopClosing,
+		Mempool:                NewMempool(DefaultMempoolCapacity),
+		BlockChan:              make(chan *Block, 100),
+		Orphans:                NewOrphanPool(),
+		Results:                NewResultStore(),
+		submissions:            make(chan *Transaction, DefaultSubmitQueueLen),
+		MaxTxAge:               DefaultMaxTxAge,
+		MaxTxSkew:              DefaultMaxTxClockSkew,
+		GossipFanout:           DefaultGossipFanout,
+		ResultPrecision:        DefaultResultPrecision,
+		BroadcastQueueLen:      DefaultBroadcastQueueLen,
+		MempoolHighWater:       DefaultMempoolHighWater,
+		MempoolSweepInterval:   DefaultMempoolSweepInterval,
+		MaxMempoolAge:          DefaultMaxMempoolAge,
+		RebroadcastAfter:       DefaultRebroadcastAfter,
+		MaxRebroadcasts:        DefaultMaxRebroadcasts,
+		BlockInterval:          DefaultBlockInterval,
+		SyncWorkers:            runtime.GOMAXPROCS(0),
+		SyncStallTimeout:       DefaultSyncStallTimeout,
+		VerifyWorkers:          runtime.NumCPU(),
+		RoundTimeout:           DefaultRoundTimeout,
+		AntiEntropyInterval:    DefaultAntiEntropyInterval,
+		AntiEntropyPeers:       DefaultAntiEntropyPeers,
+		MaxPeerClockSkew:       DefaultMaxPeerClockSkew,
+		IsolationRetryInterval: DefaultIsolationRetryInterval,
+		FinalityDepth:          DefaultFinalityDepth,
+		FinalizeInterval:       DefaultFinalizeInterval,
+		DialTimeout:            DefaultDialTimeout,
+		MaxKnownNodes:          DefaultMaxKnownNodes,
+		ReconnectWorkers:       DefaultReconnectWorkers,
+		TargetPeers:            DefaultTargetPeers,
+		PeerLimiter:            NewRateLimiter(DefaultPeerRPCLimit, DefaultPeerRPCWindow),
+		MaxInboundConns:        DefaultMaxInboundConns,
+		rng:                    newRand(),
+		startedAt:              time.Now(),
+		inclusionLatency:       NewLatencyTracker(),
+		seenBlocks:             newSeenSet(SeenBlocksCapacity),
+	}
+	n.Transport = &grpcTransport{node: n}
+	n.UseChain(chain)
+	return n
 }
 
-func (n *P2PNode) SendBlock(ctx context.Context, req *SendBlockRequest) (*SendBlockResponse, error) {
-	log.Printf("Node %s received block: %x at height %d", n.Addr, req.GetBlock().GetHeader().GetHash(), req.GetBlock().GetHeader().GetHeight())
-	// In a real system: Validate block using Rust consensus engine, add to chain, re-broadcast.
-	select {
-	case n.BlockChan <- req.GetBlock():
-	default:
-		log.Printf("BlockChan full, dropping block from %x", req.GetBlock().GetHeader().GetHash())
-	}
-	return &SendBlockResponse{Success: true}, nil
+// UseChain makes chain the node's chain, handing the node's mempool to it so
+// transactions leave the mempool atomically with their block being applied, and
+// having the mempool tell time by the chain's clock. Call it before the node
+// starts.
+func (n *P2PNode) UseChain(chain *Chain) {
+	chain.Mempool = n.Mempool
+	chain.OnInclude = n.observeInclusion
+	n.Mempool.Clock = chain.now
+	n.Chain = chain
 }
 
-// --- Mock gRPC Client Implementation ---
-type mockNodeServiceClient struct{}
+// gRPC server metrics, keyed by full method name.
+var (
+	grpcRequests      = expvar.NewMap("grpc_requests")
+	grpcErrors        = expvar.NewMap("grpc_errors")
+	grpcLatencyMicros = expvar.NewMap("grpc_latency_micros") // Cumulative; divide by grpc_requests for the mean
+	grpcPanics        = expvar.NewInt("grpc_panics")
+	grpcRateLimited   = expvar.NewInt("grpc_rate_limited")
+	grpcBannedPeers   = expvar.NewInt("grpc_banned_peer_requests")
+	grpcOversized     = expvar.NewInt("grpc_oversized_blocks")
+)
 
-func (m *mockNodeServiceClient) GetKnownPeers(ctx context.Context, in *GetKnownPeersRequest, opts ...grpc.CallOption) (*GetKnownPeersResponse, error) {
-	return &GetKnownPeersResponse{PeerAddresses: []string{"localhost:50052", "localhost:50053"}}, nil
+// recoveryInterceptor turns a panic in a handler into an Internal error so one
+// malformed peer request cannot crash the node.
+func recoveryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			grpcPanics.Add(1)
+			log.Printf("ERROR: panic in %s: %v\n%s", info.FullMethod, r, debug.Stack())
+			resp, err = nil, status.Errorf(codes.Internal, "internal error handling %s", info.FullMethod)
+		}
+	}()
+	return handler(ctx, req)
 }
 
-func (m *mockNodeServiceClient) SendTransaction(ctx context.Context, in *SendTransactionRequest, opts ...grpc.CallOption) (*SendTransactionResponse, error) {
-	return &SendTransactionResponse{Success: true}, nil
+// loggingInterceptor logs each request with its duration and resulting status code.
+func loggingInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	start := time.Now()
+	resp, err := handler(ctx, req)
+	log.Printf("gRPC %s %s in %v", info.FullMethod, status.Code(err), time.Since(start))
+	return resp, err
 }
 
-func (m *mockNodeServiceClient) SendBlock(ctx context.Context, in *SendBlockRequest, opts ...grpc.CallOption) (*SendBlockResponse, error) {
-	return &SendBlockResponse{Success: true}, nil
+// metricsInterceptor records per-method request counts, errors and latency.
+func metricsInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	start := time.Now()
+	resp, err := handler(ctx, req)
+	grpcRequests.Add(info.FullMethod, 1)
+	grpcLatencyMicros.Add(info.FullMethod, time.Since(start).Microseconds())
+	if err != nil {
+		grpcErrors.Add(info.FullMethod, 1)
+	}
+	return resp, err
 }
 
-// --- HTTP API Handlers for Frontend Interaction ---
-
-// Mock voter registry (off-chain, for demonstration)
-var voterRegistry = make(map[string]string) // NIN/BVN -> HashedPassword
+// rateLimitInterceptor refuses requests from banned peers with
+// PermissionDenied, and rejects requests from a remote host over PeerLimiter
+// with ResourceExhausted, so one noisy or hostile peer cannot starve the rest.
+// Every rejection also penalizes the peer that sent it.
+func (n *P2PNode) rateLimitInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	key, ok := remotePeer(ctx)
+	if !ok {
+		return handler(ctx, req)
+	}
+	if n.peerBanned(key, time.Now()) {
+		grpcBannedPeers.Add(1)
+		return nil, status.Errorf(codes.PermissionDenied, "peer %s is banned", key)
+	}
+	host, _ := remoteHost(ctx)
+	if !n.PeerLimiter.Allow(host) {
+		grpcRateLimited.Add(1)
+		n.PenalizePeer(key, RateLimitPenalty)
+		return nil, status.Errorf(codes.ResourceExhausted, "rate limit of %d requests per %s exceeded", n.PeerLimiter.Limit, n.PeerLimiter.Window)
+	}
+	return handler(ctx, req)
+}
 
-func hashNINBVN(ninBvn string) string {
-	hasher := sha3.New256()
-	hasher.Write([]byte(ninBvn))
-	return hex.EncodeToString(hasher.Sum(nil))
+// remoteHost returns the host of the peer that sent an inbound RPC.
+func remoteHost(ctx context.Context) (string, bool) {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return "", false
+	}
+	return addrHost(p.Addr.String()), true
 }
 
-// RegisterVoter handles voter registration requests
-func RegisterVoter(w http.ResponseWriter, r *http.Request) {
-	if r.Method != "POST" {
-		http.Error(w, "Only POST method is allowed", http.StatusMethodNotAllowed)
-		return
+// addrHost returns the host of a host:port address, or addr itself if it has
+// no port.
+func addrHost(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
 	}
+	return host
+}
 
-	var req struct {
-		NIN_BVN  string `json:"nin_bvn"`
-		Password string `json:"password"`
+// remotePeer returns the key the sender of an inbound RPC is scored and banned
+// under: the identity key its TLS certificate proves (see identityPeerKey), or
+// else its remote host, as for PeerLimiter. A source port proves nothing, so a
+// peer that reconnects from a new one keeps its score and any ban; peers
+// behind one NAT share both.
+func remotePeer(ctx context.Context) (string, bool) {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return "", false
 	}
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
-		return
+	if info, ok := p.AuthInfo.(credentials.TLSInfo); ok && len(info.State.PeerCertificates) > 0 {
+		if key, ok := info.State.PeerCertificates[0].PublicKey.(ed25519.PublicKey); ok {
+			return identityPeerKey(key), true
+		}
 	}
+	return addrHost(p.Addr.String()), true
+}
 
-	hashedNINBVN := hashNINBVN(req.NIN_BVN)
-	// In a real system: Verify NIN/BVN with NIMC, then generate and sign voting token.
-	// For now, just store a mock.
-	voterRegistry[hashedNINBVN] = "mock_hashed_password" // Store hashed password
-	log.Printf("Voter registered: %s (hashed)", hashedNINBVN)
-
-	// Simulate generating a cryptographically signed voting token
-	votingToken := fmt.Sprintf("VOTETOKEN_%s_%d", hashedNINBVN, time.Now().Unix())
+// identityPeerKey returns the remotePeer key of a peer proving identity key.
+func identityPeerKey(key []byte) string {
+	return "id:" + hex.EncodeToString(key)
+}
 
-	json.NewEncoder(w).Encode(map[string]string{
-		"message": "Voter registered successfully",
-		"voting_token": votingToken,
-	})
+// inboundRecord is the misbehaviour score of an inbound peer by remotePeer key.
+type inboundRecord struct {
+	score       int
+	lastPenalty time.Time
+	bannedUntil time.Time // Zero unless the score reached BanScore
 }
 
-// SubmitVote handles vote submission requests
-func SubmitVote(node *P2PNode, w http.ResponseWriter, r *http.Request) {
-	if r.Method != "POST" {
-		http.Error(w, "Only POST method is allowed", http.StatusMethodNotAllowed)
-		return
+// PenalizePeer lowers the score of the inbound peer with remotePeer key by
+// penalty, refusing it for InboundBanDuration once it reaches BanScore. Known
+// peers whose host or handshake identity match key are penalized, and banned,
+// the same way.
+func (n *P2PNode) PenalizePeer(key string, penalty int) {
+	if key == "" {
+		return // Not from a remote peer, e.g. a block we produced
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	now := time.Now()
+	n.pruneInbound(now)
+	rec, ok := n.inbound[key]
+	if !ok {
+		rec = &inboundRecord{}
+		n.inbound[key] = rec
+	}
+	rec.score -= penalty
+	rec.lastPenalty = now
+	if rec.score <= BanScore && !now.Before(rec.bannedUntil) {
+		rec.bannedUntil = now.Add(InboundBanDuration)
+		rec.score = 0 // Starts clean once the ban ends
+		log.Printf("Banned inbound peer %s for %s", key, InboundBanDuration)
 	}
 
-	var req struct {
-		VoterID    string `json:"voter_id"` // This would be the voting token or public key
-		ElectionID string `json:"election_id"`
-		Candidate  string `json:"candidate"`
-		Signature  string `json:"signature"` // Signed transaction by the client
+	banned := false
+	for addr, ps := range n.peers {
+		if ps.state == PeerBanned || !ps.matchesPeerKey(addr, key) {
+			continue
+		}
+		ps.score -= penalty
+		if ps.score <= BanScore {
+			n.transition(addr, PeerBanned)
+			log.Printf("Banned peer %s: score %d", addr, ps.score)
+			banned = true
+		}
 	}
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
-		return
+	if banned {
+		n.checkIsolated()
 	}
+}
 
-	// In a real system:
-	// 1. Verify signature using Ed25519.
-	// 2. Check if voter_id (voting token) is valid and hasn't voted.
-	// 3. Create a VoteTransaction struct.
-	// 4. Pass to P2PNode to broadcast.
-	log.Printf("Received vote from %s for %s in election %s", req.VoterID, req.Candidate, req.ElectionID)
+// matchesPeerKey reports whether the known peer at addr is the inbound peer
+// with remotePeer key: it is at that host, or proved that identity key.
+func (ps *peerState) matchesPeerKey(addr, key string) bool {
+	return addrHost(addr) == key || (len(ps.identityKey) > 0 && identityPeerKey(ps.identityKey) == key)
+}
 
-	// Simulate creating a blockchain transaction
-	txHash := sha3.New256()
-	txHash.Write([]byte(fmt.Sprintf("%s%s%s", req.VoterID, req.ElectionID, req.Candidate)))
-	mockTx := &Transaction{
-		Hash:      txHash.Sum(nil),
-		Sender:    []byte(req.VoterID),
-		Recipient: []byte(req.Candidate),
-		Amount:    1, // Represents one vote
-		Signature: []byte(req.Signature),
+// pruneInbound drops, at most once per InboundRecordTTL, the inbound records
+// that are not banned and were last penalized over InboundRecordTTL ago, so
+// peers cycling through addresses cannot grow the map without bound.
+// Callers must hold n.mu.
+func (n *P2PNode) pruneInbound(now time.Time) {
+	if now.Before(n.nextInboundPrune) {
+		return
 	}
+	n.nextInboundPrune = now.Add(InboundRecordTTL)
+	for key, rec := range n.inbound {
+		if now.Sub(rec.lastPenalty) >= InboundRecordTTL && !now.Before(rec.bannedUntil) {
+			delete(n.inbound, key)
+		}
+	}
+}
 
-	node.BroadcastTransaction(mockTx) // Broadcast to other P2P nodes
+// peerBanned reports whether the inbound peer with remotePeer key is banned,
+// either for its own misbehaviour or because it shares the host, or proves the
+// identity, of a banned known peer.
+func (n *P2PNode) peerBanned(key string, now time.Time) bool {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	if rec, ok := n.inbound[key]; ok && now.Before(rec.bannedUntil) {
+		return true
+	}
+	for addr, ps := range n.peers {
+		if ps.state == PeerBanned && ps.matchesPeerKey(addr, key) {
+			return true
+		}
+	}
+	return false
+}
 
-	json.NewEncoder(w).Encode(map[string]string{
-		"message": "Vote submitted and broadcasted successfully. Awaiting blockchain finality.",
-		"tx_hash": hex.EncodeToString(mockTx.Hash),
-	})
+// PeerScore returns the misbehaviour score of addr, a known peer address or
+// an inbound remotePeer key; 0 is a clean record.
+func (n *P2PNode) PeerScore(addr string) int {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	if ps, ok := n.peers[addr]; ok {
+		return ps.score
+	}
+	if rec, ok := n.inbound[addr]; ok {
+		return rec.score
+	}
+	return 0
 }
 
-// GetElectionStatus provides real-time election data
-func GetElectionStatus(w http.ResponseWriter, r *http.Request) {
-	// In a real system: Query the local blockchain state (managed by Rust engine)
-	// For now, return mock data.
-	status := map[string]interface{}{
-		"total_votes":   12345,
-		"candidates": map[string]int{
-			"Candidate A": 5000,
-			"Candidate B": 4000,
-			"Candidate C": 3345,
-		},
-		"latest_block_hash": "0x" + hex.EncodeToString([]byte{0xab, 0xcd, 0xef, 0x12, 0x34, 0x56, 0x78, 0x90}) + "...",
-		"block_height":      123456,
-		"finality_time_seconds": 3,
-		"validators_active": 21,
+// StartGRPCServer starts the gRPC server for the node on every listen address
+// (e.g. an IPv4 and an IPv6 address, or a public and a private interface).
+// With no addresses it listens on n.Addr; peers are always told AdvertisedAddr.
+// Addresses that fail to bind are logged and skipped as long as at least one succeeds.
+// It refuses to start unless TLSConfig is set or AllowInsecure is explicitly enabled.
+// This method blocks until the server stops and should be run in a goroutine.
+func (n *P2PNode) StartGRPCServer(listenAddrs ...string) error {
+	if err := validateAdvertiseAddr(n.AdvertisedAddr()); err != nil {
+		return err
 	}
-	json.NewEncoder(w).Encode(status)
+	if len(listenAddrs) == 0 {
+		listenAddrs = []string{n.Addr}
+	}
+	return n.Transport.Serve(n, listenAddrs...)
 }
 
-func main() {
-	// Initialize P2P Node (conceptual)
-	p2pNode := NewP2PNode("localhost:50051")
-	go p2pNode.StartGRPCServer()
-	go p2pNode.DiscoverPeers([]string{"localhost:50052"}) // Seed with a dummy peer
+// AdvertisedAddr returns the address peers should dial to reach this node.
+func (n *P2PNode) AdvertisedAddr() string {
+	if n.AdvertiseAddr != "" {
+		return n.AdvertiseAddr
+	}
+	return n.Addr
+}
 
-	// Start HTTP API Server
-	http.HandleFunc("/register", RegisterVoter)
-	http.HandleFunc("/vote", func(w http.ResponseWriter, r *http.Request) {
-		SubmitVote(p2pNode, w, r)
-	})
-	http.HandleFunc("/status", GetElectionStatus)
+// isSelf reports whether addr refers to this node, by bind or advertised address.
+func (n *P2PNode) isSelf(addr string) bool {
+	return addr == n.Addr || addr == n.AdvertisedAddr()
+}
 
-	log.Println("HTTP API server starting on :8080")
-	log.Fatal(http.ListenAndServe(":8080", nil))
+// validateAdvertiseAddr rejects addresses peers could not dial: a missing or
+// zero port, or a wildcard host such as 0.0.0.0 or [::].
+func validateAdvertiseAddr(addr string) error {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Errorf("invalid advertise address %q: %v", addr, err)
+	}
+	if p, err := strconv.Atoi(port); err != nil || p <= 0 || p > 65535 {
+		return fmt.Errorf("advertise address %q has an invalid port", addr)
+	}
+	if host == "" {
+		return fmt.Errorf("advertise address %q has no host; set AdvertiseAddr to a reachable address", addr)
+	}
+	if ip := net.ParseIP(host); ip != nil && ip.IsUnspecified() {
+		return fmt.Errorf("advertise address %q is a wildcard address; set AdvertiseAddr to a reachable address", addr)
+	}
+	return nil
+}
+
+// errNoTransportSecurity is returned when neither TLS nor insecure mode is configured.
+var errNoTransportSecurity = errors.New("no TLS configured and AllowInsecure is not set; refusing to use plaintext gRPC")
+
+// transportCredentials returns TLS credentials when configured, plaintext only
+// when AllowInsecure is explicitly set, and an error otherwise.
+func (n *P2PNode) transportCredentials() (credentials.TransportCredentials, error) {
+	if n.TLSConfig != nil {
+		return credentials.NewTLS(n.TLSConfig), nil
+	}
+	if n.AllowInsecure {
+		return insecure.NewCredentials(), nil
+	}
+	return nil, errNoTransportSecurity
+}
+
+// --- Peer State ---
+
+// PeerState is where a peer address is in its connection lifecycle.
+type PeerState int
+
+const (
+	PeerDiscovered  PeerState = iota // Known address, never dialed
+	PeerConnecting                   // Dialing
+	PeerHandshaking                  // Dialed; exchanging handshakes
+	PeerConnected                    // Handshake succeeded; the peer receives gossip
+	PeerFailed                       // Last attempt failed or the connection dropped; may be retried
+	PeerBanned                       // Never dialed or gossiped to until unbanned
+)
+
+func (s PeerState) String() string {
+	switch s {
+	case PeerDiscovered:
+		return "discovered"
+	case PeerConnecting:
+		return "connecting"
+	case PeerHandshaking:
+		return "handshaking"
+	case PeerConnected:
+		return "connected"
+	case PeerFailed:
+		return "failed"
+	case PeerBanned:
+		return "banned"
+	default:
+		return fmt.Sprintf("PeerState(%d)", int(s))
+	}
+}
+
+// peerTransitions lists the legal moves out of each state.
+var peerTransitions = map[PeerState][]PeerState{
+	PeerDiscovered:  {PeerConnecting, PeerBanned},
+	PeerConnecting:  {PeerHandshaking, PeerFailed, PeerBanned},
+	PeerHandshaking: {PeerConnected, PeerFailed, PeerBanned},
+	PeerConnected:   {PeerFailed, PeerBanned},
+	PeerFailed:      {PeerConnecting, PeerBanned},
+	PeerBanned:      {PeerDiscovered},
+}
+
+// peerState is everything the node tracks about one peer address.
+type peerState struct {
+	state       PeerState
+	client      NodeServiceClient // Set only while Connected
+	knownSince  time.Time         // When the address was first learned
+	lastContact time.Time         // Last successful exchange; zero if never reached
+	clockSkew   time.Duration     // Peer clock minus ours, measured at handshake
+	failures    int               // Consecutive failed attempts or drops
+	successes   int               // Successful connections over the address's lifetime
+	nodeID      string            // Node ID the peer proved at its last handshake; empty if it proved no identity key
+	identityKey []byte            // Identity key the peer proved at its last handshake; nil if it did not
+	version     string            // Protocol version the peer reported at its last handshake
+	tipHeight   uint64            // Peer's best block height, from its handshake and later sync requests
+	score       int               // Misbehaviour penalties; banned at BanScore, reset by UnbanPeer
+}
+
+// transition moves addr to state to, returning an error if addr is unknown or
+// the move is not in peerTransitions. Callers must hold n.mu.
+func (n *P2PNode) transition(addr string, to PeerState) error {
+	ps, ok := n.peers[addr]
+	if !ok {
+		return fmt.Errorf("peer %s is not known", addr)
+	}
+	if !slices.Contains(peerTransitions[ps.state], to) {
+		return fmt.Errorf("peer %s cannot move from %s to %s", addr, ps.state, to)
+	}
+	if ps.state == PeerConnected {
+		ps.client = nil
+		ps.clockSkew = 0
+	}
+	switch to {
+	case PeerConnected:
+		ps.lastContact = time.Now()
+		ps.failures = 0
+		ps.successes++
+	case PeerFailed:
+		ps.failures++
+	case PeerDiscovered:
+		ps.score = 0 // Only reachable from Banned
+	}
+	ps.state = to
+	return nil
+}
+
+// connectedPeers returns the clients of every Connected peer. Callers must hold n.mu.
+func (n *P2PNode) connectedPeers() map[string]NodeServiceClient {
+	peers := make(map[string]NodeServiceClient)
+	for addr, ps := range n.peers {
+		if ps.state == PeerConnected {
+			peers[addr] = ps.client
+		}
+	}
+	return peers
+}
+
+// Peer returns the client for addr if it is connected.
+func (n *P2PNode) Peer(addr string) (NodeServiceClient, bool) {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	ps, ok := n.peers[addr]
+	if !ok || ps.state != PeerConnected {
+		return nil, false
+	}
+	return ps.client, true
+}
+
+// PeerCount returns the number of connected peers.
+func (n *P2PNode) PeerCount() int {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	return len(n.connectedPeers())
+}
+
+// PeerStates returns the state of every known peer address.
+func (n *P2PNode) PeerStates() map[string]PeerState {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	states := make(map[string]PeerState, len(n.peers))
+	for addr, ps := range n.peers {
+		states[addr] = ps.state
+	}
+	return states
+}
+
+// BanPeer disconnects addr, if connected, and refuses to dial it until UnbanPeer.
+func (n *P2PNode) BanPeer(addr string) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.addKnownNode(addr)
+	if err := n.transition(addr, PeerBanned); err != nil {
+		return err
+	}
+	log.Printf("Banned peer %s", addr)
+	n.checkIsolated()
+	return nil
+}
+
+// UnbanPeer makes a banned addr eligible for discovery and dialing again, and
+// lifts any inbound ban on it or on the identity it last proved. Given a bare
+// host, it unbans every known peer on that host.
+func (n *P2PNode) UnbanPeer(addr string) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	delete(n.inbound, addr)
+	delete(n.inbound, addrHost(addr))
+	if ps, ok := n.peers[addr]; ok && len(ps.identityKey) > 0 {
+		delete(n.inbound, identityPeerKey(ps.identityKey))
+	}
+	if _, ok := n.peers[addr]; ok {
+		return n.transition(addr, PeerDiscovered)
+	}
+	for known, ps := range n.peers {
+		if ps.state == PeerBanned && ps.matchesPeerKey(known, addr) {
+			if err := n.transition(known, PeerDiscovered); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// ConnectToPeer establishes a gRPC connection to another peer, moving it
+// through Connecting and Handshaking to Connected, or to Failed. Cancelling ctx
+// aborts the dial and handshake, which also give up after DialTimeout.
+func (n *P2PNode) ConnectToPeer(ctx context.Context, peerAddr string) error {
+	n.mu.Lock()
+	n.addKnownNode(peerAddr)
+	switch n.peers[peerAddr].state {
+	case PeerConnected, PeerConnecting, PeerHandshaking:
+		n.mu.Unlock()
+		return nil // Already connected, or another goroutine is connecting
+	}
+	err := n.transition(peerAddr, PeerConnecting) // Fails for banned peers
+	n.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	// The node lock is not held while dialing or handshaking, so a slow peer
+	// does not stall gossip, discovery or RPCs to other peers.
+	client, hello, err := n.dialPeer(ctx, peerAddr)
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if err != nil {
+		n.transition(peerAddr, PeerFailed) // No-op if the peer was banned meanwhile
+		return err
+	}
+	if err := n.transition(peerAddr, PeerConnected); err != nil {
+		closeClient(client) // Banned mid-handshake; nothing else will close it
+		return err
+	}
+	ps := n.peers[peerAddr]
+	ps.client = client
+	ps.clockSkew = hello.skew
+	ps.nodeID = hello.nodeID
+	ps.identityKey = hello.identityKey
+	ps.version = hello.version
+	ps.tipHeight = hello.tipHeight
+	log.Printf("Connected to peer: %s (node %s)", peerAddr, hello.nodeID)
+	if n.isolated {
+		n.isolated = false
+		log.Printf("Node %s reconnected via %s and is no longer isolated", n, peerAddr)
+	}
+	return nil
+}
+
+// peerHello is what the node learns about a peer from the handshake.
+type peerHello struct {
+	skew        time.Duration // Measured clock skew
+	nodeID      string        // Empty unless derived from a proven identityKey
+	identityKey []byte        // Nil if the peer did not prove an identity key
+	version     string
+	tipHeight   uint64
+}
+
+// handshakeProof returns the message a handshake responder signs with its
+// identity key to prove it holds the key. It binds the chain ID so a proof
+// cannot be replayed across networks.
+func handshakeProof(chainID string, challenge []byte) []byte {
+	return []byte(fmt.Sprintf("handshake|%q|%x", chainID, challenge))
+}
+
+// dialPeer dials peerAddr and performs the handshake, returning the client and
+// what the peer told us about itself. A peer that sends an identity key must
+// prove it by signing our challenge, and its node ID must be derived from it.
+// A peer that proves no key is recorded without a node ID, since anyone could
+// claim any ID.
+func (n *P2PNode) dialPeer(ctx context.Context, peerAddr string) (NodeServiceClient, peerHello, error) {
+	client, err := n.Transport.Dial(ctx, peerAddr)
+	if err != nil {
+		return nil, peerHello{}, fmt.Errorf("failed to connect to peer %s: %w", peerAddr, err)
+	}
+	n.mu.Lock()
+	err = n.transition(peerAddr, PeerHandshaking)
+	n.mu.Unlock()
+	if err != nil {
+		closeClient(client)
+		return nil, peerHello{}, err
+	}
+
+	challenge := make([]byte, 32)
+	if _, err := rand.Read(challenge); err != nil {
+		closeClient(client)
+		return nil, peerHello{}, fmt.Errorf("failed to generate handshake challenge: %v", err)
+	}
+
+	// Refuse peers from a different network
+	hsCtx, cancel := context.WithTimeout(ctx, n.DialTimeout)
+	sent := time.Now()
+	resp, err := client.Handshake(hsCtx, &HandshakeRequest{ChainId: n.ChainID, Addr: n.AdvertisedAddr(), Timestamp: sent.UnixMilli(), GenesisHash: n.Chain.Genesis().Header.Hash, ProtocolVersion: ProtocolVersion, NodeId: n.NodeID(), Challenge: challenge})
+	received := time.Now()
+	cancel()
+	if err != nil {
+		closeClient(client)
+		return nil, peerHello{}, fmt.Errorf("handshake with peer %s failed: %v", peerAddr, err)
+	}
+	if resp.GetChainId() != n.ChainID {
+		closeClient(client)
+		return nil, peerHello{}, fmt.Errorf("peer %s is on chain %q, expected %q", peerAddr, resp.GetChainId(), n.ChainID)
+	}
+	if !bytes.Equal(resp.GetGenesisHash(), n.Chain.Genesis().Header.Hash) {
+		closeClient(client)
+		return nil, peerHello{}, fmt.Errorf("peer %s has genesis %x, expected %x", peerAddr, resp.GetGenesisHash(), n.Chain.Genesis().Header.Hash)
+	}
+	if err := checkProtocolVersion(resp.GetProtocolVersion()); err != nil {
+		closeClient(client)
+		return nil, peerHello{}, fmt.Errorf("peer %s: %v", peerAddr, err)
+	}
+	// Compare the peer's clock against the midpoint of the round trip
+	skew := time.UnixMilli(resp.GetTimestamp()).Sub(sent.Add(received.Sub(sent) / 2))
+	if err := n.checkPeerClockSkew(peerAddr, skew); err != nil {
+		closeClient(client)
+		return nil, peerHello{}, err
+	}
+	hello := peerHello{skew: skew, version: resp.GetProtocolVersion(), tipHeight: resp.GetTipHeight()}
+	if key := resp.GetIdentityKey(); key != nil {
+		if len(key) != ed25519.PublicKeySize || !ed25519.Verify(key, handshakeProof(n.ChainID, challenge), resp.GetIdentitySig()) {
+			closeClient(client)
+			return nil, peerHello{}, fmt.Errorf("peer %s failed to prove its identity key", peerAddr)
+		}
+		if id := NodeIDFor(key); id != resp.GetNodeId() {
+			closeClient(client)
+			return nil, peerHello{}, fmt.Errorf("peer %s reported node ID %s, but its identity key gives %s", peerAddr, resp.GetNodeId(), id)
+		}
+		hello.nodeID, hello.identityKey = resp.GetNodeId(), key
+	}
+	return client, hello, nil
+}
+
+// closeClient releases a client the node is abandoning, if its transport holds
+// a connection open.
+func closeClient(client NodeServiceClient) {
+	if c, ok := client.(io.Closer); ok {
+		c.Close()
+	}
+}
+
+// DiscoverPeers connects to the seed peers, then periodically discovers and
+// connects to new peers, except while the node sheds load, until the node
+// closes. This method should be run in a goroutine.
+func (n *P2PNode) DiscoverPeers(initialPeers []string) {
+	n.mu.Lock()
+	n.bootstrapPeers = initialPeers
+	for _, peer := range initialPeers {
+		n.addKnownNode(peer)
+	}
+	n.mu.Unlock()
+
+	n.connectKnownPeers()
+	n.connectToSeeds(initialPeers)
+	if err := n.SyncFromBestPeer(n.closing); err != nil {
+		log.Printf("Initial sync incomplete: %v", err)
+	}
+
+	ticker := time.NewTicker(30 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-n.closing.Done():
+			return
+		}
+		if n.LoadShedding() {
+			continue // Discovery can wait until the node has capacity again
+		}
+		n.mu.RLock()
+		peersToQuery := n.connectedPeers()
+		n.mu.RUnlock()
+
+		for peerAddr, client := range peersToQuery {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			resp, err := client.GetKnownPeers(ctx, &GetKnownPeersRequest{})
+			cancel()
+			if err != nil {
+				log.Printf("Failed to get peers from %s: %v", peerAddr, err)
+				n.removePeer(peerAddr)
+				continue
+			}
+			n.markContact(peerAddr)
+			for _, newPeerAddr := range freshPeerAddresses(resp, time.Now()) {
+				if !n.isSelf(newPeerAddr) {
+					n.mu.Lock()
+					if _, known := n.peers[newPeerAddr]; !known {
+						n.addKnownNode(newPeerAddr)
+						go n.ConnectToPeer(n.closing, newPeerAddr)
+					}
+					n.mu.Unlock()
+				}
+			}
+		}
+	}
+}
+
+// addKnownNode remembers addr as Discovered if it is new, first evicting the least
+// recently useful address if that would exceed MaxKnownNodes. Callers must hold n.mu.
+func (n *P2PNode) addKnownNode(addr string) {
+	if _, ok := n.peers[addr]; ok {
+		return
+	}
+	if n.MaxKnownNodes > 0 && len(n.peers) >= n.MaxKnownNodes {
+		n.evictKnownNodes(len(n.peers) - n.MaxKnownNodes + 1)
+	}
+	n.peers[addr] = &peerState{state: PeerDiscovered, knownSince: time.Now()}
+}
+
+// evictKnownNodes forgets up to count Discovered or Failed addresses that are not
+// seeds. Addresses we never reached go first, oldest-learned first, then those
+// whose last successful contact is oldest. Callers must hold n.mu.
+func (n *P2PNode) evictKnownNodes(count int) {
+	seeds := make(map[string]bool, len(n.bootstrapPeers))
+	for _, addr := range n.bootstrapPeers {
+		seeds[addr] = true
+	}
+	var candidates []string
+	for addr, ps := range n.peers {
+		if (ps.state == PeerDiscovered || ps.state == PeerFailed) && !seeds[addr] {
+			candidates = append(candidates, addr)
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		pi, pj := n.peers[candidates[i]], n.peers[candidates[j]]
+		reachedI, reachedJ := !pi.lastContact.IsZero(), !pj.lastContact.IsZero()
+		if reachedI != reachedJ {
+			return !reachedI
+		}
+		ci, cj := pi.lastContact, pj.lastContact
+		if !reachedI {
+			ci, cj = pi.knownSince, pj.knownSince
+		}
+		if !ci.Equal(cj) {
+			return ci.Before(cj)
+		}
+		return candidates[i] < candidates[j]
+	})
+	for _, addr := range candidates[:min(count, len(candidates))] {
+		delete(n.peers, addr)
+		broadcastDrops.Delete(addr)
+	}
+}
+
+// --- Address Book ---
+
+// PeerRecord is one entry of the address book written by SavePeers.
+type PeerRecord struct {
+	Addr      string `json:"addr"`
+	LastSeen  int64  `json:"last_seen,omitempty"` // Unix seconds of the last successful contact
+	Successes int    `json:"successes"`
+	Failures  int    `json:"failures"` // Consecutive failures since the last success
+	Score     int    `json:"score"`    // Misbehaviour penalties; see BanScore
+	Banned    bool   `json:"banned,omitempty"`
+}
+
+// SavePeers atomically writes every known peer address and its history to
+// path as JSON, so a restarted node can reconnect to historically good peers
+// first. Best-first, the same order reconnection uses.
+func (n *P2PNode) SavePeers(path string) error {
+	n.mu.RLock()
+	records := make([]PeerRecord, 0, len(n.peers))
+	for _, addr := range n.reconnectOrder(true) {
+		ps := n.peers[addr]
+		rec := PeerRecord{Addr: addr, Successes: ps.successes, Failures: ps.failures, Score: ps.score, Banned: ps.state == PeerBanned}
+		if !ps.lastContact.IsZero() {
+			rec.LastSeen = ps.lastContact.Unix()
+		}
+		records = append(records, rec)
+	}
+	n.mu.RUnlock()
+
+	data, err := json.MarshalIndent(records, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("failed to save peers: %w", err)
+	}
+	return os.Rename(tmp, path)
+}
+
+// LoadPeers restores an address book written by SavePeers. Addresses come back
+// Discovered, or Banned if they were, with their history intact. A missing file
+// is not an error: the node starts with no known peers, as on first run.
+func (n *P2PNode) LoadPeers(path string) error {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to load peers: %w", err)
+	}
+	var records []PeerRecord
+	if err := json.Unmarshal(data, &records); err != nil {
+		return fmt.Errorf("failed to load peers from %s: %w", path, err)
+	}
+
+	now := time.Now()
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for _, rec := range records {
+		if rec.Addr == "" || n.isSelf(rec.Addr) {
+			continue
+		}
+		if _, known := n.peers[rec.Addr]; known {
+			continue
+		}
+		n.addKnownNode(rec.Addr)
+		ps := n.peers[rec.Addr]
+		ps.successes, ps.failures, ps.score = rec.Successes, rec.Failures, rec.Score
+		if rec.LastSeen > 0 {
+			ps.lastContact = time.Unix(min(rec.LastSeen, now.Unix()), 0) // A file from a clock ahead of ours must not outrank live contacts
+		}
+		if rec.Banned {
+			ps.state = PeerBanned
+		}
+	}
+	log.Printf("Loaded %d peer addresses from %s", len(records), path)
+	return nil
+}
+
+// reconnectOrder returns known addresses best first: by successes minus
+// consecutive failures plus score, then most recent contact, then address.
+// Banned addresses are left out unless includeBanned. Callers must hold n.mu.
+func (n *P2PNode) reconnectOrder(includeBanned bool) []string {
+	addrs := make([]string, 0, len(n.peers))
+	for addr, ps := range n.peers {
+		if includeBanned || ps.state != PeerBanned {
+			addrs = append(addrs, addr)
+		}
+	}
+	reputation := func(ps *peerState) int { return ps.successes - ps.failures + ps.score }
+	sort.Slice(addrs, func(i, j int) bool {
+		pi, pj := n.peers[addrs[i]], n.peers[addrs[j]]
+		if ri, rj := reputation(pi), reputation(pj); ri != rj {
+			return ri > rj
+		}
+		if !pi.lastContact.Equal(pj.lastContact) {
+			return pi.lastContact.After(pj.lastContact)
+		}
+		return addrs[i] < addrs[j]
+	})
+	return addrs
+}
+
+// connectKnownPeers dials the known addresses this node has reached before,
+// best first, e.g. those restored by LoadPeers, so a restarted node rejoins
+// through peers that served it well rather than only through its seeds. Up to
+// ReconnectWorkers dials run at once, and no new dial starts once TargetPeers
+// peers are connected or the node is closing.
+func (n *P2PNode) connectKnownPeers() {
+	n.mu.RLock()
+	var addrs []string
+	for _, addr := range n.reconnectOrder(false) {
+		if n.peers[addr].successes > 0 {
+			addrs = append(addrs, addr)
+		}
+	}
+	n.mu.RUnlock()
+
+	sem := make(chan struct{}, max(n.ReconnectWorkers, 1))
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for _, addr := range addrs {
+		select {
+		case sem <- struct{}{}:
+		case <-n.closing.Done():
+			return
+		}
+		if n.TargetPeers > 0 && n.PeerCount() >= n.TargetPeers {
+			<-sem
+			return
+		}
+		wg.Add(1)
+		go func(addr string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			if err := n.ConnectToPeer(n.closing, addr); err != nil {
+				log.Printf("Failed to reconnect to known peer %s: %v", addr, err)
+			}
+		}(addr)
+	}
+}
+
+// markContact records a successful exchange with a peer.
+func (n *P2PNode) markContact(addr string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if ps, ok := n.peers[addr]; ok {
+		ps.lastContact = time.Now()
+	}
+}
+
+// freshPeerAddresses orders the addresses in a GetKnownPeers response freshest
+// first and drops any not contacted within PeerHealthyWindow. Contact times
+// after now count as now, so a peer cannot put its addresses ahead of others
+// by claiming future contact. Responses from peers that only fill
+// PeerAddresses are used as-is.
+func freshPeerAddresses(resp *GetKnownPeersResponse, now time.Time) []string {
+	if len(resp.GetPeers()) == 0 {
+		return resp.GetPeerAddresses()
+	}
+	nowSecs := uint64(now.Unix())
+	seen := func(p *PeerAddress) uint64 { return min(p.GetLastSeen(), nowSecs) }
+	peers := append([]*PeerAddress(nil), resp.GetPeers()...)
+	sort.SliceStable(peers, func(i, j int) bool { return seen(peers[i]) > seen(peers[j]) })
+
+	cutoff := uint64(now.Add(-PeerHealthyWindow).Unix())
+	addrs := make([]string, 0, len(peers))
+	for _, p := range peers {
+		if seen(p) < cutoff {
+			break // Sorted, so every remaining entry is stale too
+		}
+		addrs = append(addrs, p.GetAddr())
+	}
+	return addrs
+}
+
+// removePeer marks a connected peer Failed after the connection drops.
+func (n *P2PNode) removePeer(addr string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if ps, ok := n.peers[addr]; !ok || ps.state != PeerConnected {
+		return
+	}
+	n.transition(addr, PeerFailed)
+	n.checkIsolated()
+}
+
+// checkIsolated enters the isolated state once the last peer is gone: the node
+// is partitioned and broadcasts silently go nowhere, so it aggressively retries
+// bootstrap and known peers until one connects. Callers must hold n.mu.
+func (n *P2PNode) checkIsolated() {
+	if len(n.connectedPeers()) == 0 && !n.isolated {
+		n.isolated = true
+		isolationEvents.Add(1)
+		log.Printf("WARNING: node %s lost all peers and is isolated; retrying every %s", n, n.IsolationRetryInterval)
+		go n.reconnectWhileIsolated()
+	}
+}
+
+// connectToSeeds dials every seed with exponential backoff until the node has
+// a peer, so a node started just before its seeds joins as soon as they come up
+// instead of waiting a full discovery cycle. Retries wait at most
+// BootstrapMaxBackoff and stop when the node closes or has no seed but itself.
+func (n *P2PNode) connectToSeeds(seeds []string) {
+	backoff := BootstrapInitialBackoff
+	for {
+		dialed := false
+		for _, addr := range seeds {
+			if n.isSelf(addr) {
+				continue
+			}
+			if n.closing.Err() != nil {
+				return
+			}
+			dialed = true
+			if err := n.ConnectToPeer(n.closing, addr); err != nil {
+				log.Printf("Bootstrap: failed to connect to seed %s: %v", addr, err)
+			}
+		}
+		if !dialed || n.PeerCount() > 0 {
+			return // Connected, to a seed or a saved peer, or nothing to dial
+		}
+		log.Printf("Bootstrap: no seeds reachable, retrying in %s", backoff)
+		select {
+		case <-time.After(backoff):
+		case <-n.closing.Done():
+			return
+		}
+		backoff = min(backoff*2, BootstrapMaxBackoff)
+	}
+}
+
+// reconnectWhileIsolated retries bootstrap and known peers on a short interval
+// until the node has at least one connection again.
+func (n *P2PNode) reconnectWhileIsolated() {
+	ticker := time.NewTicker(n.IsolationRetryInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-n.closing.Done():
+			return
+		}
+		n.mu.RLock()
+		if !n.isolated {
+			n.mu.RUnlock()
+			return
+		}
+		candidates := append(n.reconnectOrder(false), n.bootstrapPeers...)
+		n.mu.RUnlock()
+
+		for _, addr := range candidates {
+			if n.isSelf(addr) {
+				continue
+			}
+			if err := n.ConnectToPeer(n.closing, addr); err != nil {
+				log.Printf("Isolated node %s failed to reconnect to %s: %v", n, addr, err)
+			}
+		}
+	}
+}
+
+// checkPeerClockSkew warns when a peer's clock differs from ours by more than
+// MaxPeerClockSkew, and returns an error if RefuseSkewedPeers is set.
+func (n *P2PNode) checkPeerClockSkew(peerAddr string, skew time.Duration) error {
+	if skew.Abs() <= n.MaxPeerClockSkew {
+		return nil
+	}
+	log.Printf("WARNING: peer %s clock is %s off from ours (limit %s); check its NTP configuration", peerAddr, skew, n.MaxPeerClockSkew)
+	if n.RefuseSkewedPeers {
+		return fmt.Errorf("peer %s clock skew %s exceeds %s", peerAddr, skew, n.MaxPeerClockSkew)
+	}
+	return nil
+}
+
+// PeerClockSkews returns the clock skew measured for each connected peer at handshake.
+func (n *P2PNode) PeerClockSkews() map[string]time.Duration {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	skews := make(map[string]time.Duration)
+	for addr, ps := range n.peers {
+		if ps.state == PeerConnected {
+			skews[addr] = ps.clockSkew
+		}
+	}
+	return skews
+}
+
+// Isolated reports whether the node has lost all of its peers.
+func (n *P2PNode) Isolated() bool {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	return n.isolated
+}
+
+// gossipTargets picks the subset of connected peers a message is pushed to:
+// ceil(GossipFanout * sqrt(connected peers)) peers chosen at random from the seeded
+// source. Broadcasting to every peer costs O(peers^2) traffic in dense networks,
+// while re-broadcast by receivers still reaches the whole network with high probability.
+// Callers must hold n.mu.
+func (n *P2PNode) gossipTargets() map[string]NodeServiceClient {
+	return n.randomPeers(int(math.Ceil(n.GossipFanout * math.Sqrt(float64(len(n.connectedPeers()))))))
+}
+
+// randomPeers returns count connected peers (at least one, at most all) chosen
+// at random from the seeded source. Callers must hold n.mu.
+func (n *P2PNode) randomPeers(count int) map[string]NodeServiceClient {
+	peers := n.connectedPeers()
+	addrs := make([]string, 0, len(peers))
+	for addr := range peers {
+		addrs = append(addrs, addr)
+	}
+	sort.Strings(addrs) // Map order is random; sort so the seeded source alone drives selection
+
+	if count < 1 {
+		count = 1
+	}
+	if count > len(addrs) {
+		count = len(addrs)
+	}
+
+	n.rngMu.Lock()
+	n.rng.Shuffle(len(addrs), func(i, j int) { addrs[i], addrs[j] = addrs[j], addrs[i] })
+	n.rngMu.Unlock()
+
+	targets := make(map[string]NodeServiceClient, count)
+	for _, addr := range addrs[:count] {
+		targets[addr] = peers[addr]
+	}
+	return targets
+}
+
+// BroadcastTransaction gossips a transaction to a random subset of connected peers.
+func (n *P2PNode) BroadcastTransaction(tx *Transaction) {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+
+	for addr, client := range n.gossipTargets() {
+		n.enqueueSend(addr, false, func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+			_, err := client.SendTransaction(ctx, &SendTransactionRequest{Transaction: tx})
+			cancel()
+			if err != nil {
+				log.Printf("Failed to send transaction to %s: %v", addr, err)
+			}
+		})
+	}
+}
+
+// ErrQuorumUnreachable is returned by ConfirmTransaction when too few peers are
+// connected, or too many have refused the transaction, to reach the quorum.
+var ErrQuorumUnreachable = errors.New("confirmation quorum cannot be reached")
+
+// PeerConfirmation is a peer's signed acceptance of a transaction.
+type PeerConfirmation struct {
+	Peer    string     `json:"peer"`
+	Receipt *TxReceipt `json:"receipt"`
+}
+
+// confirmingPeer is a connected peer asked to confirm a transaction, with the
+// identity key it proved at handshake.
+type confirmingPeer struct {
+	addr   string
+	client NodeServiceClient
+	key    ed25519.PublicKey
+}
+
+// confirmingPeers returns one connected peer for each distinct identity key
+// proven at handshake. Peers that proved no key cannot sign a receipt we can
+// attribute to them, and several addresses proving the same key are one node.
+// Callers must hold n.mu.
+func (n *P2PNode) confirmingPeers() map[string]confirmingPeer {
+	peers := make(map[string]confirmingPeer)
+	for addr, ps := range n.peers {
+		if ps.state == PeerConnected && len(ps.identityKey) == ed25519.PublicKeySize {
+			peers[string(ps.identityKey)] = confirmingPeer{addr: addr, client: ps.client, key: ps.identityKey}
+		}
+	}
+	return peers
+}
+
+// ConfirmTransaction sends tx to one connected peer per proven identity key,
+// not just a gossip sample, and returns once quorum distinct keys have
+// accepted it with a receipt signed by the key their peer proved at
+// handshake. If ctx ends first, or quorum can no longer be reached, it
+// returns the confirmations collected so far with an error. Sends continue in
+// the background after it returns, as for BroadcastTransaction.
+func (n *P2PNode) ConfirmTransaction(ctx context.Context, tx *Transaction, quorum int) ([]PeerConfirmation, error) {
+	n.mu.RLock()
+	peers := n.confirmingPeers()
+	n.mu.RUnlock()
+	if len(peers) < quorum {
+		n.BroadcastTransaction(tx) // Still propagate it; the caller reports the missing quorum
+		return nil, fmt.Errorf("%w: %d peers with proven identities connected, %d confirmations required", ErrQuorumUnreachable, len(peers), quorum)
+	}
+
+	results := make(chan *PeerConfirmation, len(peers)) // nil for a peer that did not confirm
+	for _, peer := range peers {
+		if !n.trackSend() {
+			return nil, fmt.Errorf("%w: node is shutting down", ErrQuorumUnreachable)
+		}
+		go func(addr string, client NodeServiceClient, key ed25519.PublicKey) {
+			defer n.broadcasts.Done()
+			sendCtx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+			resp, err := client.SendTransaction(sendCtx, &SendTransactionRequest{Transaction: tx})
+			cancel()
+			if err == nil && !resp.GetSuccess() {
+				err = fmt.Errorf("peer did not accept it")
+			}
+			if err == nil && !bytes.Equal(resp.GetReceipt().GetTxHash(), tx.GetHash()) {
+				err = fmt.Errorf("receipt is for another transaction")
+			}
+			if err == nil {
+				err = VerifyReceipt(resp.GetReceipt(), key)
+			}
+			if err != nil {
+				log.Printf("Peer %s did not confirm transaction %x: %v", addr, tx.GetHash(), err)
+				results <- nil
+				return
+			}
+			results <- &PeerConfirmation{Peer: addr, Receipt: resp.GetReceipt()}
+		}(peer.addr, peer.client, peer.key)
+	}
+
+	var confirmed []PeerConfirmation
+	for remaining := len(peers); len(confirmed) < quorum; remaining-- {
+		if len(confirmed)+remaining < quorum {
+			return confirmed, fmt.Errorf("%w: %d of %d peers confirmed", ErrQuorumUnreachable, len(confirmed), len(peers))
+		}
+		select {
+		case c := <-results:
+			if c != nil {
+				confirmed = append(confirmed, *c)
+			}
+		case <-ctx.Done():
+			return confirmed, ctx.Err()
+		}
+	}
+	return confirmed, nil
+}
+
+// BroadcastBlock gossips a block to a random subset of connected peers.
+func (n *P2PNode) BroadcastBlock(block *Block) {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+
+	for addr, client := range n.gossipTargets() {
+		n.enqueueSend(addr, true, func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			_, err := client.SendBlock(ctx, &SendBlockRequest{Block: block})
+			cancel()
+			if err != nil {
+				log.Printf("Failed to send block to %s: %v", addr, err)
+			}
+		})
+	}
+}
+
+// peerOutbox holds the gossip sends waiting for one peer.
+type peerOutbox struct {
+	blocks  []func() // Block sends, which run before any transaction send
+	txs     []func()
+	running bool // A drainOutbox goroutine is sending them
+}
+
+// enqueueSend queues send for the peer at addr, to run after the sends
+// already queued for it. Each peer's sends run one at a time on a single
+// goroutine, so a slow peer holds up only its own queue. Block sends are
+// queued separately and run first, so a backlog of transaction gossip never
+// delays a block. Once either queue holds BroadcastQueueLen sends, one is
+// dropped according to BroadcastDropPolicy and counted in p2p_broadcast_drops
+// and p2p_broadcast_drops_total. Once Close has queued its last sends, send
+// is dropped without running.
+func (n *P2PNode) enqueueSend(addr string, block bool, send func()) {
+	n.outboxMu.Lock()
+	defer n.outboxMu.Unlock()
+	if n.sendsClosed {
+		return
+	}
+	box, ok := n.outboxes[addr]
+	if !ok {
+		box = &peerOutbox{}
+		n.outboxes[addr] = box
+	}
+	queue := &box.txs
+	if block {
+		queue = &box.blocks
+	}
+	if len(*queue) >= max(n.BroadcastQueueLen, 1) {
+		broadcastDrops.Add(addr, 1)
+		broadcastDropsTotal.Add(1)
+		if n.BroadcastDropPolicy == DropNewest {
+			return
+		}
+		*queue = (*queue)[1:]
+		n.broadcasts.Done()
+	}
+	n.broadcasts.Add(1)
+	*queue = append(*queue, send)
+	if !box.running {
+		box.running = true
+		go n.drainOutbox(addr, box)
+	}
+}
+
+// trackSend counts an outbound send that runs outside enqueueSend in
+// n.broadcasts, which Close waits on, and reports true, unless Close has
+// already queued its last sends; then the send must not start.
+func (n *P2PNode) trackSend() bool {
+	n.outboxMu.Lock()
+	defer n.outboxMu.Unlock()
+	if n.sendsClosed {
+		return false
+	}
+	n.broadcasts.Add(1)
+	return true
+}
+
+// drainOutbox runs the sends queued in box until it is empty, then removes it.
+func (n *P2PNode) drainOutbox(addr string, box *peerOutbox) {
+	for {
+		n.outboxMu.Lock()
+		var send func()
+		switch {
+		case len(box.blocks) > 0:
+			send, box.blocks = box.blocks[0], box.blocks[1:]
+		case len(box.txs) > 0:
+			send, box.txs = box.txs[0], box.txs[1:]
+		default:
+			box.running = false
+			delete(n.outboxes, addr)
+			n.outboxMu.Unlock()
+			return
+		}
+		n.outboxMu.Unlock()
+
+		send()
+		n.broadcasts.Done()
+	}
+}
+
+// Close shuts the node down without dropping pending transactions. It stops
+// the background loops, re-broadcasts everything in the mempool, stops the
+// transport (letting in-flight RPCs finish), broadcasts anything those RPCs
+// added, refuses any further sends, waits for outbound sends, and only then
+// closes BlockChan.
+// ctx bounds how long Close waits for outbound sends.
+func (n *P2PNode) Close(ctx context.Context) error {
+	var err error
+	n.closeOnce.Do(func() {
+		n.stopClosing() // Abort in-flight dials; no new peers are needed while shutting down
+		sent := make(map[string]bool)
+		n.broadcastPending(sent)
+		n.Transport.Stop()
+		n.broadcastPending(sent) // Transactions received while the server was stopping
+		drained := len(sent)
+		n.outboxMu.Lock()
+		n.sendsClosed = true // broadcasts.Add must not race the Wait below
+		n.outboxMu.Unlock()
+
+		done := make(chan struct{})
+		go func() {
+			n.broadcasts.Wait()
+			close(done)
+		}()
+		select {
+		case <-done:
+			log.Printf("Node %s broadcast %d queued transactions before shutdown", n, drained)
+		case <-ctx.Done():
+			err = fmt.Errorf("shutdown timed out waiting for broadcasts: %w", ctx.Err())
+		}
+
+		close(n.BlockChan)
+	})
+	return err
+}
+
+// broadcastPending broadcasts every pending transaction not already in sent,
+// adding each to sent.
+func (n *P2PNode) broadcastPending(sent map[string]bool) {
+	for _, tx := range n.Mempool.Pending() {
+		key := fmt.Sprintf("%x", tx.GetHash())
+		if sent[key] {
+			continue
+		}
+		n.BroadcastTransaction(tx)
+		sent[key] = true
+	}
+}
+
+// --- gRPC Service Method Implementations (for P2PNode to act as a server) ---
+
+// Handshake is a gRPC method called by a connecting peer. Peers on a different
+// chain are refused so testnet and mainnet traffic never mixes.
+func (n *P2PNode) Handshake(ctx context.Context, req *HandshakeRequest) (*HandshakeResponse, error) {
+	if req.GetChainId() != n.ChainID {
+		log.Printf("Refusing handshake from %s: chain %q, expected %q", req.GetAddr(), req.GetChainId(), n.ChainID)
+		return nil, status.Errorf(codes.FailedPrecondition, "chain ID mismatch: got %q, expected %q", req.GetChainId(), n.ChainID)
+	}
+	if genesis := n.Chain.Genesis().Header.Hash; !bytes.Equal(req.GetGenesisHash(), genesis) {
+		log.Printf("Refusing handshake from %s: genesis %x, expected %x", req.GetAddr(), req.GetGenesisHash(), genesis)
+		return nil, status.Errorf(codes.FailedPrecondition, "genesis mismatch: got %x, expected %x", req.GetGenesisHash(), genesis)
+	}
+	if err := checkProtocolVersion(req.GetProtocolVersion()); err != nil {
+		log.Printf("Refusing handshake from %s: %v", req.GetAddr(), err)
+		return nil, status.Error(codes.FailedPrecondition, err.Error())
+	}
+	now := time.Now()
+	if err := n.checkPeerClockSkew(req.GetAddr(), time.UnixMilli(req.GetTimestamp()).Sub(now)); err != nil {
+		return nil, status.Error(codes.FailedPrecondition, err.Error())
+	}
+	resp := &HandshakeResponse{ChainId: n.ChainID, Timestamp: now.UnixMilli(), GenesisHash: n.Chain.Genesis().Header.Hash, ProtocolVersion: ProtocolVersion, NodeId: n.NodeID(), TipHeight: n.Chain.Height()}
+	if challenge := req.GetChallenge(); len(challenge) > 0 {
+		resp.IdentityKey = n.identityKey.Public().(ed25519.PublicKey)
+		resp.IdentitySig = ed25519.Sign(n.identityKey, handshakeProof(n.ChainID, challenge))
+	}
+	return resp, nil
+}
+
+// GetKnownPeers returns our own advertised address followed by recently-healthy
+// connected peers, freshest first, capped at MaxPeerExchange.
+func (n *P2PNode) GetKnownPeers(ctx context.Context, req *GetKnownPeersRequest) (*GetKnownPeersResponse, error) {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+
+	now := time.Now()
+	cutoff := now.Add(-PeerHealthyWindow)
+	peers := make([]*PeerAddress, 0, len(n.peers)+1)
+	for addr, ps := range n.peers {
+		if ps.state != PeerConnected {
+			continue
+		}
+		seen := ps.lastContact
+		if seen.Before(cutoff) {
+			continue // Connected but silent; may be dead
+		}
+		peers = append(peers, &PeerAddress{Addr: addr, LastSeen: uint64(seen.Unix())})
+	}
+	sort.Slice(peers, func(i, j int) bool { return peers[i].LastSeen > peers[j].LastSeen })
+	// We are always reachable to whoever asked, so advertise ourselves first
+	peers = append([]*PeerAddress{{Addr: n.AdvertisedAddr(), LastSeen: uint64(now.Unix())}}, peers...)
+	if len(peers) > MaxPeerExchange {
+		peers = peers[:MaxPeerExchange]
+	}
+
+	addrs := make([]string, len(peers))
+	for i, p := range peers {
+		addrs[i] = p.Addr
+	}
+	return &GetKnownPeersResponse{PeerAddresses: addrs, Peers: peers}, nil
+}
+
+// GetPeerInfo returns what the node knows about itself and each connected
+// peer: identity, advertised address, protocol version and tip height, so the
+// caller can prefer peers further ahead when discovering and syncing.
+func (n *P2PNode) GetPeerInfo(ctx context.Context, req *GetPeerInfoRequest) (*GetPeerInfoResponse, error) {
+	self := &PeerInfo{
+		Addr:            n.AdvertisedAddr(),
+		NodeId:          n.NodeID(),
+		IdentityKey:     n.identityKey.Public().(ed25519.PublicKey),
+		ProtocolVersion: ProtocolVersion,
+		TipHeight:       n.Chain.Height(),
+	}
+
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	var peers []*PeerInfo
+	for addr, ps := range n.peers {
+		if ps.state != PeerConnected {
+			continue
+		}
+		peers = append(peers, &PeerInfo{Addr: addr, NodeId: ps.nodeID, IdentityKey: ps.identityKey, ProtocolVersion: ps.version, TipHeight: ps.tipHeight})
+	}
+	sort.Slice(peers, func(i, j int) bool {
+		if peers[i].TipHeight != peers[j].TipHeight {
+			return peers[i].TipHeight > peers[j].TipHeight
+		}
+		return peers[i].Addr < peers[j].Addr
+	})
+	return &GetPeerInfoResponse{Self: self, Peers: peers}, nil
+}
+
+// notePeerTip records the tip height addr reported outside the handshake.
+func (n *P2PNode) notePeerTip(addr string, height uint64) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if ps, ok := n.peers[addr]; ok {
+		ps.tipHeight = height
+	}
+}
+
+func (n *P2PNode) SendTransaction(ctx context.Context, req *SendTransactionRequest) (*SendTransactionResponse, error) {
+	if req.GetTransaction() == nil {
+		return &SendTransactionResponse{Success: false}, status.Error(codes.InvalidArgument, "transaction is missing")
+	}
+	log.Printf("Node %s received transaction: %x", n, req.GetTransaction().GetHash())
+	if err := n.checkPeerTx(req.GetTransaction()); err != nil {
+		log.Printf("Rejecting transaction %x: %v", req.GetTransaction().GetHash(), err)
+		return &SendTransactionResponse{Success: false}, status.Error(codes.InvalidArgument, err.Error())
+	}
+	// In a real system: Validate, add to mempool, re-broadcast if new.
+	// Then, the full node would pass this to the Rust consensus engine.
+	switch err := n.Mempool.Add(req.GetTransaction()); {
+	case errors.Is(err, ErrMempoolFull):
+		log.Printf("Mempool full, dropping transaction %x", req.GetTransaction().GetHash())
+		return &SendTransactionResponse{Success: false}, nil // No receipt for a transaction we dropped
+	case err == nil:
+		n.recordAccepted(req.GetTransaction())
+	}
+	// A transaction we already hold is acknowledged again without a second audit entry
+	return &SendTransactionResponse{Success: true, Receipt: n.SignReceipt(req.GetTransaction().GetHash())}, nil
+}
+
+// checkPeerTx applies the admission checks for a transaction relayed by a
+// peer, whether gossiped or fetched by anti-entropy. Like a client's, its hash
+// and signature must verify, so a peer cannot forge or alter votes.
+func (n *P2PNode) checkPeerTx(tx *Transaction) error {
+	if tx == nil {
+		return fmt.Errorf("%w: transaction is missing", ErrMalformedTx)
+	}
+	if tx.GetChainId() != n.ChainID {
+		return fmt.Errorf("%w: transaction is for chain %q, expected %q", ErrWrongChain, tx.GetChainId(), n.ChainID)
+	}
+	if err := n.checkTxTimestamp(tx, n.Chain.now()); err != nil {
+		return err
+	}
+	if err := VerifyTransaction(n.Chain.Hasher(), tx); err != nil {
+		return fmt.Errorf("transaction %x: %w", tx.GetHash(), err)
+	}
+	return n.checkTxKind(tx)
+}
+
+// PublicKey returns the node's Ed25519 identity public key, against which its receipts verify.
+func (n *P2PNode) PublicKey() ed25519.PublicKey {
+	return n.identityKey.Public().(ed25519.PublicKey)
+}
+
+// NodeIDLen is how many bytes of the identity key's hash make up a node ID.
+const NodeIDLen = 8
+
+// NodeIDFor returns the node ID of the node with identity key pub: the hex of
+// the first NodeIDLen bytes of its SHA3-256 hash. It does not follow the
+// genesis hash algorithm, so a node keeps its ID on every network.
+func NodeIDFor(pub ed25519.PublicKey) string {
+	sum := sha3.Sum256(pub)
+	return hex.EncodeToString(sum[:NodeIDLen])
+}
+
+// NodeID returns the node's ID, which identifies it in logs, handshakes and
+// admin output. Unlike Addr it survives address changes, and it is stable
+// across restarts as long as the identity key is.
+func (n *P2PNode) NodeID() string {
+	return n.nodeID
+}
+
+// String returns the node's ID and listen address, for logs.
+func (n *P2PNode) String() string {
+	return n.nodeID + "@" + n.Addr
+}
+
+// UseIdentityKey replaces the node's randomly generated identity key, e.g.
+// with one from LoadIdentityKey so that its ID and receipt signatures survive
+// restarts. Call it before the node starts.
+func (n *P2PNode) UseIdentityKey(key ed25519.PrivateKey) {
+	n.identityKey = key
+	n.nodeID = NodeIDFor(key.Public().(ed25519.PublicKey))
+}
+
+// UseRandSource replaces the source of the node's random choices, such as
+// which peers a message is gossiped to, e.g. with rand.NewSource(seed) so a
+// test reproduces them exactly: nodes given the same seed and the same peers
+// make the same choices. Call it before the node starts.
+func (n *P2PNode) UseRandSource(src mrand.Source) {
+	n.rngMu.Lock()
+	defer n.rngMu.Unlock()
+	n.rng = mrand.New(src)
+}
+
+// newRand returns a generator seeded from crypto/rand, so peers cannot
+// predict a node's random choices.
+func newRand() *mrand.Rand {
+	var seed [8]byte
+	if _, err := rand.Read(seed[:]); err != nil {
+		log.Fatalf("failed to seed random source: %v", err)
+	}
+	return mrand.New(mrand.NewSource(int64(binary.LittleEndian.Uint64(seed[:]))))
+}
+
+// LoadIdentityKey reads a hex-encoded Ed25519 seed from path. If the file does
+// not exist, as on first run, a new key is generated and its seed written
+// there readable only by the owner (0600), creating the directory if needed,
+// and the new node ID is logged. A key file readable by other users is still
+// loaded, with a warning.
+func LoadIdentityKey(path string) (ed25519.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return generateIdentityKey(path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load identity key: %w", err)
+	}
+	if info, err := os.Stat(path); err == nil && info.Mode().Perm()&0077 != 0 {
+		log.Printf("Warning: identity key %s is accessible to other users (mode %v); restrict it with chmod 600", path, info.Mode().Perm())
+	}
+	seed, err := hex.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil || len(seed) != ed25519.SeedSize {
+		return nil, fmt.Errorf("identity key in %s must be a hex-encoded %d-byte seed", path, ed25519.SeedSize)
+	}
+	return ed25519.NewKeyFromSeed(seed), nil
+}
+
+// generateIdentityKey creates a new identity key and saves its seed to path for
+// LoadIdentityKey, through writeFileAtomic so a crash never leaves a truncated
+// key behind.
+func generateIdentityKey(path string) (ed25519.PrivateKey, error) {
+	pub, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate identity key: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, fmt.Errorf("failed to save identity key: %w", err)
+	}
+	if err := writeFileAtomic(path, []byte(hex.EncodeToString(key.Seed())), 0600); err != nil {
+		return nil, fmt.Errorf("failed to save identity key: %w", err)
+	}
+	log.Printf("Generated a new identity key in %s: node ID %s", path, NodeIDFor(pub))
+	return key, nil
+}
+
+// writeFileAtomic replaces path with data: it writes a temporary file with
+// mode perm, syncs it, renames it into place and syncs the directory, so after
+// a crash path holds either its old contents or all of data.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp := path + ".tmp"
+	os.Remove(tmp) // OpenFile keeps the mode of a leftover file, which may be looser
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_EXCL|os.O_WRONLY, perm)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	dir, err := os.Open(filepath.Dir(path))
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	return dir.Sync() // Persist the rename itself
+}
+
+// SignReceipt produces a signed receipt stating this node accepted txHash now.
+// Submitters can present it as proof of delivery when resolving disputes.
+func (n *P2PNode) SignReceipt(txHash []byte) *TxReceipt {
+	receipt := &TxReceipt{
+		TxHash:     txHash,
+		NodePubKey: n.PublicKey(),
+		Timestamp:  uint64(time.Now().Unix()),
+	}
+	receipt.Signature = ed25519.Sign(n.identityKey, receipt.SigningBytes())
+	return receipt
+}
+
+// VerifyReceipt checks that receipt was signed by the node with public key nodePubKey.
+func VerifyReceipt(receipt *TxReceipt, nodePubKey ed25519.PublicKey) error {
+	if receipt == nil {
+		return fmt.Errorf("receipt is missing")
+	}
+	if !bytes.Equal(receipt.NodePubKey, nodePubKey) {
+		return fmt.Errorf("receipt was issued by %x, expected %x", receipt.NodePubKey, []byte(nodePubKey))
+	}
+	if !ed25519.Verify(nodePubKey, receipt.SigningBytes(), receipt.Signature) {
+		return fmt.Errorf("receipt signature is invalid")
+	}
+	return nil
+}
+
+// checkTxTimestamp rejects transactions outside the replay window [now - MaxTxAge, now + MaxTxSkew].
+func (n *P2PNode) checkTxTimestamp(tx *Transaction, now time.Time) error {
+	ts := time.Unix(int64(tx.GetTimestamp()), 0)
+	if ts.Before(now.Add(-n.MaxTxAge)) {
+		return fmt.Errorf("%w: %s is older than %s", ErrStaleTx, ts.UTC().Format(time.RFC3339), n.MaxTxAge)
+	}
+	if ts.After(now.Add(n.MaxTxSkew)) {
+		return fmt.Errorf("%w: %s is more than %s in the future", ErrStaleTx, ts.UTC().Format(time.RFC3339), n.MaxTxSkew)
+	}
+	return nil
+}
+
+func (n *P2PNode) SendBlock(ctx context.Context, req *SendBlockRequest) (*SendBlockResponse, error) {
+	if req.GetBlock() == nil {
+		return &SendBlockResponse{Success: false}, status.Error(codes.InvalidArgument, "block is missing")
+	}
+	if req.GetBlock().GetHeader() == nil {
+		return &SendBlockResponse{Success: false}, status.Error(codes.InvalidArgument, "block header is missing")
+	}
+	log.Printf("Node %s received block: %x at height %d", n, req.GetBlock().GetHeader().GetHash(), req.GetBlock().GetHeader().GetHeight())
+	if req.GetBlock().GetHeader().GetChainId() != n.ChainID {
+		log.Printf("Rejecting block %x from chain %q", req.GetBlock().GetHeader().GetHash(), req.GetBlock().GetHeader().GetChainId())
+		return &SendBlockResponse{Success: false}, status.Errorf(codes.InvalidArgument, "block is for chain %q, expected %q", req.GetBlock().GetHeader().GetChainId(), n.ChainID)
+	}
+	// Refuse oversized blocks before they reach full validation, which is
+	// costly for a huge block, and penalize the peer that sent one
+	if err := n.Chain.checkBlockLimits(req.GetBlock()); err != nil {
+		grpcOversized.Add(1)
+		if key, ok := remotePeer(ctx); ok {
+			n.PenalizePeer(key, OversizedBlockPenalty)
+		}
+		return &SendBlockResponse{Success: false}, status.Error(codes.ResourceExhausted, err.Error())
+	}
+	if !n.seenBlocks.Add(req.GetBlock().GetHeader().GetHash()) {
+		return &SendBlockResponse{Success: true}, nil // Already imported or queued; relays echo blocks back
+	}
+	// A block beyond the next height cannot be imported until its parent
+	// arrives, so it waits in the orphan pool instead of the import queue
+	if req.GetBlock().GetHeader().GetHeight() > n.Chain.Height()+1 {
+		host, _ := remoteHost(ctx)
+		key, _ := remotePeer(ctx)
+		if err := n.holdOrphan(req.GetBlock(), host, key); err != nil {
+			n.seenBlocks.Forget(req.GetBlock().GetHeader().GetHash())
+			return &SendBlockResponse{Success: false}, status.Error(codes.ResourceExhausted, err.Error())
+		}
+		return &SendBlockResponse{Success: true}, nil
+	}
+	// In a real system: Validate block using Rust consensus engine, add to chain, re-broadcast.
+	select {
+	case n.BlockChan <- req.GetBlock():
+	default:
+		log.Printf("BlockChan full, dropping block from %x", req.GetBlock().GetHeader().GetHash())
+		n.seenBlocks.Forget(req.GetBlock().GetHeader().GetHash())
+	}
+	return &SendBlockResponse{Success: true}, nil
+}
+
+// --- Mock gRPC Client Implementation ---
+type mockNodeServiceClient struct{}
+
+func (m *mockNodeServiceClient) Handshake(ctx context.Context, in *HandshakeRequest, opts ...grpc.CallOption) (*HandshakeResponse, error) {
+	// Simulate a peer on the same chain and genesis with a synchronized clock
+	return &HandshakeResponse{ChainId: in.ChainId, Timestamp: time.Now().UnixMilli(), GenesisHash: in.GenesisHash, ProtocolVersion: in.ProtocolVersion}, nil
+}
+
+func (m *mockNodeServiceClient) GetKnownPeers(ctx context.Context, in *GetKnownPeersRequest, opts ...grpc.CallOption) (*GetKnownPeersResponse, error) {
+	now := uint64(time.Now().Unix())
+	return &GetKnownPeersResponse{
+		PeerAddresses: []string{"localhost:50052", "localhost:50053"},
+		Peers: []*PeerAddress{
+			{Addr: "localhost:50052", LastSeen: now},
+			{Addr: "localhost:50053", LastSeen: now},
+		},
+	}, nil
+}
+
+func (m *mockNodeServiceClient) GetPeerInfo(ctx context.Context, in *GetPeerInfoRequest, opts ...grpc.CallOption) (*GetPeerInfoResponse, error) {
+	// Simulate a peer with no peers of its own
+	return &GetPeerInfoResponse{Self: &PeerInfo{Addr: "localhost:50052", ProtocolVersion: ProtocolVersion}}, nil
+}
+
+func (m *mockNodeServiceClient) SendTransaction(ctx context.Context, in *SendTransactionRequest, opts ...grpc.CallOption) (*SendTransactionResponse, error) {
+	return &SendTransactionResponse{Success: true}, nil
+}
+
+func (m *mockNodeServiceClient) SendBlock(ctx context.Context, in *SendBlockRequest, opts ...grpc.CallOption) (*SendBlockResponse, error) {
+	return &SendBlockResponse{Success: true}, nil
+}
+
+func (m *mockNodeServiceClient) GetBlock(ctx context.Context, in *GetBlockRequest, opts ...grpc.CallOption) (*GetBlockResponse, error) {
+	return nil, status.Errorf(codes.NotFound, "no block at height %d", in.Height)
+}
+
+func (m *mockNodeServiceClient) GetBlocks(ctx context.Context, in *GetBlocksRequest, opts ...grpc.CallOption) (*GetBlocksResponse, error) {
+	// Simulate a peer with no blocks beyond ours
+	return &GetBlocksResponse{}, nil
+}
+
+func (m *mockNodeServiceClient) GetStatus(ctx context.Context, in *GetStatusRequest, opts ...grpc.CallOption) (*GetStatusResponse, error) {
+	// Simulate a peer with only the genesis block
+	return &GetStatusResponse{}, nil
+}
+
+func (m *mockNodeServiceClient) GetMempool(ctx context.Context, in *GetMempoolRequest, opts ...grpc.CallOption) (*GetMempoolResponse, error) {
+	// Simulate a peer with an empty mempool
+	return &GetMempoolResponse{}, nil
+}
+
+func (m *mockNodeServiceClient) GetTransactions(ctx context.Context, in *GetTransactionsRequest, opts ...grpc.CallOption) (*GetTransactionsResponse, error) {
+	return &GetTransactionsResponse{}, nil
+}
+
+// --- HTTP API Handlers for Frontend Interaction ---
+
+// Machine-readable error codes returned in the JSON error envelope.
+// Clients should branch on these rather than on the human-readable message.
+const (
+	ErrCodeMethodNotAllowed    = "METHOD_NOT_ALLOWED"
+	ErrCodeInvalidRequest      = "INVALID_REQUEST"
+	ErrCodeUnauthorized        = "UNAUTHORIZED"
+	ErrCodeRateLimited         = "RATE_LIMITED"
+	ErrCodeInvalidSignature    = "INVALID_SIGNATURE"
+	ErrCodeAlreadyVoted        = "ALREADY_VOTED"
+	ErrCodeElectionClosed      = "ELECTION_CLOSED"
+	ErrCodeMempoolFull         = "MEMPOOL_FULL"
+	ErrCodeUnknownCandidate    = "UNKNOWN_CANDIDATE"
+	ErrCodeCandidateExists     = "CANDIDATE_EXISTS"
+	ErrCodeWrongPhase          = "WRONG_PHASE"
+	ErrCodeNotFound            = "NOT_FOUND"
+	ErrCodeInternal            = "INTERNAL"
+	ErrCodeQuorumNotReached    = "QUORUM_NOT_REACHED"
+	ErrCodeBadReveal           = "BAD_REVEAL"
+	ErrCodeNotVerified         = "IDENTITY_NOT_VERIFIED"
+	ErrCodeUnavailable         = "UNAVAILABLE"
+	ErrCodeIdempotencyConflict = "IDEMPOTENCY_CONFLICT"
+)
+
+// errorResponse is the JSON error envelope: {"error": {"code": "...", "message": "..."}}
+type errorResponse struct {
+	Error errorBody `json:"error"`
+}
+
+type errorBody struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// writeError writes a JSON error envelope with the given HTTP status.
+func writeError(w http.ResponseWriter, status int, code, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(errorResponse{Error: errorBody{Code: code, Message: msg}})
+}
+
+// httpPanics counts HTTP requests whose handler panicked.
+var httpPanics = expvar.NewInt("http_panics")
+
+// recoverHTTP turns a panic in next into a logged stack trace and a 500 JSON
+// error, so one bad request cannot take down the node. http.ErrAbortHandler
+// is re-raised, as it is the standard way to abort a response deliberately.
+func recoverHTTP(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			rec := recover()
+			if rec == nil {
+				return
+			}
+			if rec == http.ErrAbortHandler {
+				panic(rec)
+			}
+			httpPanics.Add(1)
+			log.Printf("ERROR: panic serving %s %s: %v\n%s", r.Method, r.URL.Path, rec, debug.Stack())
+			writeError(w, http.StatusInternalServerError, ErrCodeInternal, "internal error")
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Argon2id parameters for voter password hashing (RFC 9106 second recommended option)
+const (
+	argon2Time    = 3
+	argon2Memory  = 64 * 1024 // KiB
+	argon2Threads = 4
+	argon2KeyLen  = 32
+	argon2SaltLen = 16
+)
+
+// VoterRecord holds the credentials stored for a registered voter.
+type VoterRecord struct {
+	PasswordSalt []byte
+	PasswordHash []byte // argon2id(password, salt)
+}
+
+// VoterStore is the off-chain voter registry, keyed by hashed NIN/BVN.
+type VoterStore struct {
+	mu     sync.RWMutex
+	voters map[string]*VoterRecord
+}
+
+// NewVoterStore creates an empty voter registry
+func NewVoterStore() *VoterStore {
+	return &VoterStore{voters: make(map[string]*VoterRecord)}
+}
+
+// ErrDuplicateRegistration is returned by Register for a voter who is already
+// registered. Re-registering would replace the password, letting anyone who
+// knows a voter's NIN/BVN take over their account.
+var ErrDuplicateRegistration = errors.New("voter is already registered")
+
+// Register stores the voter's password hashed with argon2id and a fresh per-user
+// salt. It fails with ErrDuplicateRegistration if hashedID is already registered.
+func (s *VoterStore) Register(hashedID, password string) error {
+	salt := make([]byte, argon2SaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return fmt.Errorf("failed to generate salt: %v", err)
+	}
+	record := &VoterRecord{
+		PasswordSalt: salt,
+		PasswordHash: hashPassword(password, salt),
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.voters[hashedID]; ok {
+		return ErrDuplicateRegistration
+	}
+	s.voters[hashedID] = record
+	return nil
+}
+
+// unknownVoter stands in for an unregistered voter in VerifyPassword, so that
+// logging in as one costs the same KDF run as a registered voter and response
+// times do not reveal who is registered. Its hash matches no password.
+var unknownVoter = &VoterRecord{
+	PasswordSalt: make([]byte, argon2SaltLen),
+	PasswordHash: make([]byte, argon2KeyLen),
+}
+
+// VerifyPassword reports whether password matches the stored hash for hashedID.
+// It takes as long for an unregistered hashedID as for a registered one.
+func (s *VoterStore) VerifyPassword(hashedID, password string) bool {
+	s.mu.RLock()
+	record, ok := s.voters[hashedID]
+	s.mu.RUnlock()
+	if !ok {
+		record = unknownVoter
+	}
+	candidate := hashPassword(password, record.PasswordSalt)
+	return subtle.ConstantTimeCompare(candidate, record.PasswordHash) == 1 && ok
+}
+
+// Registered reports whether hashedID is registered.
+func (s *VoterStore) Registered(hashedID string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	_, ok := s.voters[hashedID]
+	return ok
+}
+
+// Count returns how many voters are registered.
+func (s *VoterStore) Count() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.voters)
+}
+
+func hashPassword(password string, salt []byte) []byte {
+	return argon2.IDKey([]byte(password), salt, argon2Time, argon2Memory, argon2Threads, argon2KeyLen)
+}
+
+// Off-chain voter registry (for demonstration)
+var voterStore = NewVoterStore()
+
+// MinVoterIDKeyLen is the shortest key UseVoterIDKey accepts.
+const MinVoterIDKeyLen = 32
+
+// voterIDKey keys the HMAC that turns an NIN/BVN into a voter ID. NINs and
+// BVNs are short numbers, so a plain hash of one could be reversed by trying
+// them all; without the key it cannot. Every node must share the key, so that
+// a voter has the same ID wherever they vote; main loads it with
+// UseVoterIDKey. The random default only suits tests.
+var voterIDKey = func() []byte {
+	key := make([]byte, MinVoterIDKeyLen)
+	if _, err := rand.Read(key); err != nil {
+		log.Fatalf("failed to generate voter ID key: %v", err)
+	}
+	return key
+}()
+
+// UseVoterIDKey sets the secret key voter IDs are derived with. Call it before
+// any voter registers.
+func UseVoterIDKey(key []byte) error {
+	if len(key) < MinVoterIDKeyLen {
+		return fmt.Errorf("voter ID key must be at least %d bytes, got %d", MinVoterIDKeyLen, len(key))
+	}
+	voterIDKey = key
+	return nil
+}
+
+// hashNINBVN returns the voter ID for ninBvn: the hex HMAC-SHA256 of it under
+// voterIDKey.
+func hashNINBVN(ninBvn string) string {
+	mac := hmac.New(sha256.New, voterIDKey)
+	mac.Write([]byte(ninBvn))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// --- Voting Tokens ---
+
+// votingTokenTTL is how long an issued voting token remains valid.
+const votingTokenTTL = 24 * time.Hour
+
+// Ed25519 key used to sign voting tokens. In a real system this would be loaded
+// from the node's key store rather than generated at startup.
+var tokenPublicKey, tokenPrivateKey, _ = ed25519.GenerateKey(rand.Reader)
+
+// issueVotingToken creates a signed voting token of the form
+// base64(hashedID|expiry).base64(signature).
+func issueVotingToken(hashedID string) string {
+	payload := []byte(fmt.Sprintf("%s|%d", hashedID, time.Now().Add(votingTokenTTL).Unix()))
+	sig := ed25519.Sign(tokenPrivateKey, payload)
+	return base64.RawURLEncoding.EncodeToString(payload) + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+// verifyVotingToken checks the token signature and expiry and returns the hashed voter ID.
+func verifyVotingToken(token string) (string, error) {
+	encPayload, encSig, ok := strings.Cut(token, ".")
+	if !ok {
+		return "", fmt.Errorf("malformed voting token")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encPayload)
+	if err != nil {
+		return "", fmt.Errorf("malformed voting token payload: %v", err)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(encSig)
+	if err != nil {
+		return "", fmt.Errorf("malformed voting token signature: %v", err)
+	}
+	if !ed25519.Verify(tokenPublicKey, payload, sig) {
+		return "", fmt.Errorf("invalid voting token signature")
+	}
+	hashedID, expiryStr, ok := strings.Cut(string(payload), "|")
+	if !ok {
+		return "", fmt.Errorf("malformed voting token payload")
+	}
+	expiry, err := strconv.ParseInt(expiryStr, 10, 64)
+	if err != nil {
+		return "", fmt.Errorf("malformed voting token expiry: %v", err)
+	}
+	if time.Now().Unix() > expiry {
+		return "", fmt.Errorf("voting token expired")
+	}
+	return hashedID, nil
+}
+
+// --- Admin Authentication ---
+
+// requireAdmin checks the request's bearer token against node.AdminToken,
+// writing a 401 and returning false if it does not match.
+func requireAdmin(node *P2PNode, w http.ResponseWriter, r *http.Request) bool {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if node.AdminToken == "" || !ok || subtle.ConstantTimeCompare([]byte(token), []byte(node.AdminToken)) != 1 {
+		writeError(w, http.StatusUnauthorized, ErrCodeUnauthorized, "admin token required")
+		return false
+	}
+	return true
+}
+
+// --- Rate Limiting ---
+
+// RateLimiter allows up to Limit events per key within each Window. Windows
+// that have ended are pruned about once per Window, so keys seen once, such
+// as the addresses of one-off clients, do not accumulate.
+type RateLimiter struct {
+	Limit     int
+	Window    time.Duration
+	mu        sync.Mutex
+	windows   map[string]*rateWindow
+	nextPrune time.Time
+}
+
+type rateWindow struct {
+	start time.Time
+	count int
+}
+
+// NewRateLimiter creates a fixed-window rate limiter
+func NewRateLimiter(limit int, window time.Duration) *RateLimiter {
+	return &RateLimiter{
+		Limit:   limit,
+		Window:  window,
+		windows: make(map[string]*rateWindow),
+	}
+}
+
+// Allow records an event for key and reports whether it is within the limit.
+func (l *RateLimiter) Allow(key string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	if !now.Before(l.nextPrune) {
+		l.prune(now)
+	}
+	win, ok := l.windows[key]
+	if !ok || now.Sub(win.start) >= l.Window {
+		l.windows[key] = &rateWindow{start: now, count: 1}
+		return true
+	}
+	if win.count >= l.Limit {
+		return false
+	}
+	win.count++
+	return true
+}
+
+// prune drops the windows that have ended by now. Callers hold l.mu.
+func (l *RateLimiter) prune(now time.Time) {
+	for key, win := range l.windows {
+		if now.Sub(win.start) >= l.Window {
+			delete(l.windows, key)
+		}
+	}
+	l.nextPrune = now.Add(l.Window)
+}
+
+// Len returns how many keys the limiter is tracking.
+func (l *RateLimiter) Len() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.windows)
+}
+
+// Login attempts are limited per client IP to slow down password guessing.
+var loginLimiter = NewRateLimiter(5, time.Minute)
+
+// clientIP extracts the remote IP from the request, without the port.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// RegisterVoter handles voter registration requests
+func RegisterVoter(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		writeError(w, http.StatusMethodNotAllowed, ErrCodeMethodNotAllowed, "Only POST method is allowed")
+		return
+	}
+
+	var req struct {
+		NIN_BVN  string `json:"nin_bvn"`
+		Password string `json:"password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, ErrCodeInvalidRequest, err.Error())
+		return
+	}
+
+	// Skip re-registrations before asking the identity authority, which may
+	// charge per lookup. They get the same response as a new registration,
+	// after the same KDF run, so /register does not reveal who is registered;
+	// Register still catches a concurrent duplicate
+	hashedNINBVN := hashNINBVN(req.NIN_BVN)
+	if voterStore.Registered(hashedNINBVN) {
+		hashPassword(req.Password, unknownVoter.PasswordSalt)
+		writeRegistered(w)
+		return
+	}
+
+	verified, err := identityVerifier.Verify(req.NIN_BVN)
+	if err != nil {
+		log.Printf("Identity verification unavailable: %v", err)
+		writeError(w, http.StatusServiceUnavailable, ErrCodeUnavailable, "Identity verification is unavailable, try again later")
+		return
+	}
+	if !verified {
+		writeError(w, http.StatusUnprocessableEntity, ErrCodeNotVerified, "NIN/BVN could not be verified")
+		return
+	}
+
+	if err := voterStore.Register(hashedNINBVN, req.Password); err != nil {
+		if errors.Is(err, ErrDuplicateRegistration) {
+			writeRegistered(w)
+			return
+		}
+		writeError(w, http.StatusInternalServerError, ErrCodeInternal, err.Error())
+		return
+	}
+	log.Printf("Voter registered: %s (hashed)", hashedNINBVN)
+	writeRegistered(w)
+}
+
+// writeRegistered answers a /register request for a verified NIN/BVN. New and
+// existing registrations get the same answer, with no voting token, which
+// the voter gets from /login instead; an existing registration keeps its
+// password.
+func writeRegistered(w http.ResponseWriter) {
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "Registration received; log in with your NIN/BVN and password for a voting token",
+	})
+}
+
+// Login re-authenticates a registered voter and issues a fresh voting token
+func Login(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		writeError(w, http.StatusMethodNotAllowed, ErrCodeMethodNotAllowed, "Only POST method is allowed")
+		return
+	}
+	if !loginLimiter.Allow(clientIP(r)) {
+		writeError(w, http.StatusTooManyRequests, ErrCodeRateLimited, "Too many login attempts, try again later")
+		return
+	}
+
+	var req struct {
+		NIN_BVN  string `json:"nin_bvn"`
+		Password string `json:"password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, ErrCodeInvalidRequest, err.Error())
+		return
+	}
+
+	hashedNINBVN := hashNINBVN(req.NIN_BVN)
+	// Unknown voters and wrong passwords get the same response to avoid leaking registrations.
+	if !voterStore.VerifyPassword(hashedNINBVN, req.Password) {
+		writeError(w, http.StatusUnauthorized, ErrCodeUnauthorized, "Invalid NIN/BVN or password")
+		return
+	}
+	log.Printf("Voter logged in: %s (hashed)", hashedNINBVN)
+
+	json.NewEncoder(w).Encode(map[string]string{
+		"message":      "Login successful",
+		"voting_token": issueVotingToken(hashedNINBVN),
+	})
+}
+
+// --- Vote Deduplication ---
+
+// idempotencyKeyTTL is how long a /vote response is remembered for its Idempotency-Key.
+const idempotencyKeyTTL = 10 * time.Minute
+
+// idempotencySweepInterval is how often expired Idempotency-Keys are dropped.
+const idempotencySweepInterval = time.Minute
+
+// maxVoteBodyBytes bounds a /vote request body.
+const maxVoteBodyBytes = 16 << 10
+
+// Idempotency errors, returned by IdempotencyCache.Reserve.
+var (
+	ErrIdempotencyInFlight = errors.New("a request with this Idempotency-Key is still in progress")
+	ErrIdempotencyMismatch = errors.New("Idempotency-Key was already used with a different request")
+)
+
+// IdempotencyCache remembers responses by client-supplied Idempotency-Key so
+// that retried requests return the original response instead of re-executing.
+// Callers scope keys to the client (see idempotencyScope), so one client
+// cannot replay another's response by guessing its key, and a key is reserved
+// before the request runs, so concurrent retries cannot both execute it.
+type IdempotencyCache struct {
+	ttl     time.Duration
+	mu      sync.Mutex
+	entries map[string]*idempotencyEntry
+}
+
+type idempotencyEntry struct {
+	bodyHash    []byte // Hash of the request body the key was first used with
+	done        bool   // The request has finished and the response below is set
+	status      int
+	contentType string
+	body        []byte
+	expires     time.Time
+}
+
+// NewIdempotencyCache creates a cache whose entries expire after ttl
+func NewIdempotencyCache(ttl time.Duration) *IdempotencyCache {
+	return &IdempotencyCache{ttl: ttl, entries: make(map[string]*idempotencyEntry)}
+}
+
+// idempotencyScope returns the cache key for a client's Idempotency-Key.
+func idempotencyScope(clientID, key string) string {
+	return clientID + "|" + key
+}
+
+// Reserve claims key for a request whose body hashes to bodyHash. It returns
+// nil, nil if the key is new, and the caller must then Complete or Release it.
+// If a finished request with the same body used the key, its entry is returned
+// for the caller to replay. It fails with ErrIdempotencyInFlight while the key
+// is reserved by another request, and with ErrIdempotencyMismatch if the key
+// was used with a different body.
+func (c *IdempotencyCache) Reserve(key string, bodyHash []byte, now time.Time) (*idempotencyEntry, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if entry, ok := c.entries[key]; ok && now.Before(entry.expires) {
+		switch {
+		case !bytes.Equal(entry.bodyHash, bodyHash):
+			return nil, ErrIdempotencyMismatch
+		case !entry.done:
+			return nil, ErrIdempotencyInFlight
+		}
+		return entry, nil
+	}
+	c.entries[key] = &idempotencyEntry{bodyHash: bodyHash, expires: now.Add(c.ttl)}
+	return nil, nil
+}
+
+// Complete stores the response to the request that reserved key.
+func (c *IdempotencyCache) Complete(key string, status int, contentType string, body []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if entry, ok := c.entries[key]; ok {
+		entry.done, entry.status, entry.contentType, entry.body = true, status, contentType, body
+	}
+}
+
+// Release drops the reservation of a request that failed, so a retry with the
+// same key runs again instead of replaying the failure.
+func (c *IdempotencyCache) Release(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if entry, ok := c.entries[key]; ok && !entry.done {
+		delete(c.entries, key)
+	}
+}
+
+// Sweep drops the entries expired at now, returning how many it dropped.
+func (c *IdempotencyCache) Sweep(now time.Time) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	swept := 0
+	for key, entry := range c.entries {
+		if !now.Before(entry.expires) {
+			delete(c.entries, key)
+			swept++
+		}
+	}
+	return swept
+}
+
+// Len returns how many keys the cache holds, including expired ones not yet swept.
+func (c *IdempotencyCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.entries)
+}
+
+// replay writes a stored response.
+func (e *idempotencyEntry) replay(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", e.contentType)
+	w.WriteHeader(e.status)
+	w.Write(e.body)
+}
+
+// SweepIdempotencyKeys drops expired /vote Idempotency-Keys every
+// idempotencySweepInterval until the node closes. This method should be run
+// in a goroutine.
+func (n *P2PNode) SweepIdempotencyKeys() {
+	ticker := time.NewTicker(idempotencySweepInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			voteIdempotency.Sweep(now)
+		case <-n.closing.Done():
+			return
+		}
+	}
+}
+
+// VotedSet tracks which voters have already voted in each election.
+type VotedSet struct {
+	mu    sync.Mutex
+	voted map[string]bool // voterID|electionID -> voted
+}
+
+// NewVotedSet creates an empty double-vote set
+func NewVotedSet() *VotedSet {
+	return &VotedSet{voted: make(map[string]bool)}
+}
+
+// MarkVoted records the vote and returns false if the voter already voted in the election.
+func (v *VotedSet) MarkVoted(voterID, electionID string) bool {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	key := voterID + "|" + electionID
+	if v.voted[key] {
+		return false
+	}
+	v.voted[key] = true
+	return true
+}
+
+// Unmark forgets a vote that was marked but could not be accepted, so the voter can retry.
+func (v *VotedSet) Unmark(voterID, electionID string) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	delete(v.voted, voterID+"|"+electionID)
+}
+
+// mempoolRetryAfter is the Retry-After hint sent when /vote sheds load.
+const mempoolRetryAfter = 5 * time.Second
+
+// writeMempoolFull tells the client the node is too busy to accept a vote right now.
+func writeMempoolFull(w http.ResponseWriter) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(mempoolRetryAfter.Seconds())))
+	writeError(w, http.StatusServiceUnavailable, ErrCodeMempoolFull, "Node is at capacity, retry later")
+}
+
+// --- Load Shedding ---
+
+// LoadShedThreshold is how full the block import queue or the vote submission
+// queue may get before the node sheds load.
+const LoadShedThreshold = 0.75
+
+// loadShedEvents counts how many times the node has started shedding load.
+var loadShedEvents = expvar.NewInt("load_shed_events")
+
+// overloadReason returns why the node is overloaded, or "" if it is healthy.
+// The mempool is overloaded at MempoolHighWater; the import queue means
+// validation cannot keep up, and the submission queue that gossip cannot.
+// Peer outboxes are not counted: one slow peer backs up only its own, and
+// must not make the node refuse votes.
+func (n *P2PNode) overloadReason() string {
+	if saturation := n.Mempool.Saturation(); saturation >= n.MempoolHighWater {
+		return fmt.Sprintf("mempool is %.0f%% full", saturation*100)
+	}
+	if backlogged(len(n.BlockChan), cap(n.BlockChan)) {
+		return fmt.Sprintf("%d blocks awaiting import", len(n.BlockChan))
+	}
+	if backlogged(len(n.submissions), cap(n.submissions)) {
+		return fmt.Sprintf("%d votes awaiting broadcast", len(n.submissions))
+	}
+	return ""
+}
+
+// backlogged reports whether a queue holding queued of capacity is past LoadShedThreshold.
+func backlogged(queued, capacity int) bool {
+	return capacity > 0 && float64(queued) >= LoadShedThreshold*float64(capacity)
+}
+
+// LoadShedding assesses the node's health and reports whether it is
+// overloaded. While it is, /vote, /vote/commit, /tx and /tx/batch refuse
+// submissions with 503 and discovery, anti-entropy and rebroadcast rounds are
+// skipped, leaving the node's capacity to importing and relaying blocks.
+// Transitions are logged.
+func (n *P2PNode) LoadShedding() bool {
+	reason := n.overloadReason()
+	shedding := reason != ""
+	n.mu.Lock()
+	was := n.shedding
+	n.shedding = shedding
+	n.mu.Unlock()
+	switch {
+	case shedding && !was:
+		loadShedEvents.Add(1)
+		log.Printf("WARNING: node %s is overloaded and shedding load: %s", n, reason)
+	case !shedding && was:
+		log.Printf("Node %s recovered; no longer shedding load", n)
+	}
+	return shedding
+}
+
+// MaxCandidateIDLen bounds the candidate identifier a vote carries as its Recipient.
+const MaxCandidateIDLen = 64
+
+// parseVoteParties checks the voter ID and candidate of a /vote request and
+// returns them as a transaction's Sender and Recipient. The voter ID must be a
+// hex-encoded Ed25519 public key; callers keying anything on it should use
+// hex.EncodeToString(sender), since "AB" and "ab" decode to the same key.
+func parseVoteParties(voterID, candidate string) (sender, recipient []byte, err error) {
+	sender, err = hex.DecodeString(voterID)
+	if err != nil || len(sender) != ed25519.PublicKeySize {
+		return nil, nil, fmt.Errorf("voter_id must be a hex-encoded %d-byte public key", ed25519.PublicKeySize)
+	}
+	if len(candidate) > MaxCandidateIDLen {
+		return nil, nil, fmt.Errorf("candidate must be at most %d bytes", MaxCandidateIDLen)
+	}
+	return sender, []byte(candidate), nil
+}
+
+var (
+	voteIdempotency = NewIdempotencyCache(idempotencyKeyTTL)
+	votedSet        = NewVotedSet()
+)
+
+// DefaultSubmitQueueLen is how many accepted votes may await broadcast before
+// /vote refuses more; see SetSubmitQueueLen.
+const DefaultSubmitQueueLen = 1024
+
+// SetSubmitQueueLen replaces the submission queue with one holding size
+// transactions. Call it before the node starts.
+func (n *P2PNode) SetSubmitQueueLen(size int) {
+	n.submissions = make(chan *Transaction, max(size, 1))
+}
+
+// submitQueueFull reports whether the submission queue has no room left.
+func (n *P2PNode) submitQueueFull() bool {
+	return len(n.submissions) >= cap(n.submissions)
+}
+
+// queueSubmission hands tx to BroadcastSubmissions without blocking. It
+// reports false if the queue is full, e.g. filled since submitQueueFull was
+// checked; the mempool still holds tx, so RebroadcastPending sends it instead.
+func (n *P2PNode) queueSubmission(tx *Transaction) bool {
+	select {
+	case n.submissions <- tx:
+		return true
+	default:
+		log.Printf("Submission queue full, leaving vote %x for rebroadcast", tx.Hash)
+		return false
+	}
+}
+
+// BroadcastSubmissions broadcasts the transactions /vote and /vote/commit
+// queue, in the order they were accepted, so a vote's HTTP response never
+// waits on peers. It stops when the node closes; Close broadcasts the whole
+// mempool, so votes still queued then are not lost. This method should be run in a goroutine.
+func (n *P2PNode) BroadcastSubmissions() {
+	for {
+		select {
+		case tx := <-n.submissions:
+			n.BroadcastTransaction(tx)
+		case <-n.closing.Done():
+			return
+		}
+	}
+}
+
+// SubmitVote handles vote submission requests.
+// Clients may send an Idempotency-Key header so that retries of the same
+// submission return the original response instead of broadcasting again.
+// The vote is queued for BroadcastSubmissions and answered with 202 Accepted
+// straight away; clients follow its inclusion at status_url. With ?quorum=,
+// the response instead waits for peer confirmations as before; a vote short of
+// its quorum stays accepted and pending, so the voter cannot vote again.
+func SubmitVote(node *P2PNode, w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		writeError(w, http.StatusMethodNotAllowed, ErrCodeMethodNotAllowed, "Only POST method is allowed")
+		return
+	}
+	quorum, quorumTimeout, err := parseQuorum(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, ErrCodeInvalidRequest, err.Error())
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxVoteBodyBytes))
+	if err != nil {
+		writeError(w, http.StatusRequestEntityTooLarge, ErrCodeInvalidRequest, fmt.Sprintf("request body must be at most %d bytes", maxVoteBodyBytes))
+		return
+	}
+	var req struct {
+		VoterID    string `json:"voter_id"` // This would be the voting token or public key
+		ElectionID string `json:"election_id"`
+		Candidate  string `json:"candidate"`
+		BallotType string `json:"ballot_type"` // "valid" (the default), "abstain" or "spoiled"
+		Nonce      string `json:"nonce"`       // Hex; reveals a vote committed to under commit-reveal voting
+		Timestamp  uint64 `json:"timestamp"`   // Unix seconds; part of what the voter signs
+		Signature  string `json:"signature"`   // Hex Ed25519 signature of the vote's SigningBytes by voter_id
+	}
+	if err := json.Unmarshal(body, &req); err != nil {
+		writeError(w, http.StatusBadRequest, ErrCodeInvalidRequest, err.Error())
+		return
+	}
+
+	// A retry replays the original response; a failed request releases its
+	// key, so its retry runs again
+	var idempotencyKey string
+	if key := r.Header.Get("Idempotency-Key"); key != "" {
+		idempotencyKey = idempotencyScope(strings.ToLower(req.VoterID), key)
+		prev, err := voteIdempotency.Reserve(idempotencyKey, node.Chain.Hasher().Sum(body), time.Now())
+		switch {
+		case errors.Is(err, ErrIdempotencyInFlight):
+			writeError(w, http.StatusConflict, ErrCodeIdempotencyConflict, err.Error())
+			return
+		case err != nil:
+			writeError(w, http.StatusUnprocessableEntity, ErrCodeIdempotencyConflict, err.Error())
+			return
+		case prev != nil:
+			prev.replay(w)
+			return
+		}
+		defer voteIdempotency.Release(idempotencyKey) // No-op once completed
+	}
+
+	// Refuse votes up front rather than accept ones the mempool would have to drop
+	// or the submission queue could not take, or while the node sheds load
+	if node.LoadShedding() || node.submitQueueFull() {
+		writeMempoolFull(w)
+		return
+	}
+
+	ballot := BallotValid
+	if req.BallotType != "" {
+		var err error
+		if ballot, err = ParseBallotType(req.BallotType); err != nil {
+			writeError(w, http.StatusBadRequest, ErrCodeInvalidRequest, err.Error())
+			return
+		}
+	}
+	if (ballot == BallotValid) != (req.Candidate != "") {
+		writeError(w, http.StatusBadRequest, ErrCodeInvalidRequest, "A candidate is required for a valid ballot and not allowed otherwise")
+		return
+	}
+	sender, recipient, err := parseVoteParties(req.VoterID, req.Candidate)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, ErrCodeInvalidRequest, err.Error())
+		return
+	}
+	// Hex is case-insensitive; key the double-vote check and the hash on one spelling
+	req.VoterID = hex.EncodeToString(sender)
+	nonce, err := hex.DecodeString(req.Nonce)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, ErrCodeInvalidRequest, "nonce must be hex")
+		return
+	}
+	signature, err := hex.DecodeString(req.Signature)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, ErrCodeInvalidRequest, "signature must be hex")
+		return
+	}
+
+	log.Printf("Received %s vote from %s for %s in election %s", ballot, req.VoterID, req.Candidate, req.ElectionID)
+
+	// The voter signs exactly the transaction the node builds, so neither this
+	// node nor a relay can change the vote
+	mockTx := &Transaction{
+		Sender:     sender,
+		Recipient:  recipient,
+		Amount:     VoteAmount, // Represents one vote
+		Timestamp:  req.Timestamp,
+		ChainId:    node.ChainID,
+		Payload:    []byte(req.ElectionID),
+		Signature:  signature,
+		BallotType: ballot,
+		Nonce:      nonce,
+	}
+	mockTx.Hash = node.Chain.Hasher().Sum(mockTx.SigningBytes())
+	if err := node.checkTxTimestamp(mockTx, node.Chain.now()); err != nil {
+		writeError(w, http.StatusBadRequest, ErrCodeInvalidRequest, err.Error())
+		return
+	}
+	if err := VerifyTransaction(node.Chain.Hasher(), mockTx); err != nil {
+		code := ErrCodeInvalidRequest
+		if errors.Is(err, ErrBadSignature) {
+			code = ErrCodeInvalidSignature
+		}
+		writeError(w, http.StatusBadRequest, code, err.Error())
+		return
+	}
+	// The chain refuses a voter it has already counted, even if this node's
+	// double-vote set was lost, and a candidate the registry does not know
+	if err := node.Chain.checkElectionTx(mockTx, nil); err != nil {
+		status, code := txKindErrorStatus(err)
+		writeError(w, status, code, err.Error())
+		return
+	}
+	if err := node.Chain.checkElectionWindow(mockTx, node.Chain.now()); err != nil {
+		writeError(w, http.StatusConflict, ErrCodeElectionClosed, err.Error())
+		return
+	}
+	if err := node.Chain.checkCommitReveal(mockTx, node.Chain.Height()+1, nil); err != nil {
+		writeCommitRevealError(w, err)
+		return
+	}
+
+	// The double-vote set also catches retries that arrive without (or with a different) Idempotency-Key.
+	if !votedSet.MarkVoted(req.VoterID, req.ElectionID) {
+		writeError(w, http.StatusConflict, ErrCodeAlreadyVoted, "Voter has already voted in this election")
+		return
+	}
+
+	switch err := node.Mempool.Add(mockTx); {
+	case err == nil:
+		node.recordAccepted(mockTx)
+		node.logOutbound(mockTx)
+	case !errors.Is(err, ErrTxKnown):
+		votedSet.Unmark(req.VoterID, req.ElectionID)
+		writeMempoolFull(w)
+		return
+	}
+	// A vote the mempool already holds was audited and logged when first accepted
+
+	txHash := hex.EncodeToString(mockTx.Hash)
+	resp := map[string]interface{}{
+		"tx_hash":    txHash,
+		"receipt":    node.SignReceipt(mockTx.Hash), // Proof this node accepted the vote
+		"status_url": "/tx/" + txHash + "/wait",
+	}
+	code := http.StatusAccepted
+	if quorum > 0 {
+		// Without a quorum the vote stays accepted: it is in the mempool, WAL
+		// and audit log and has reached some peers, so the voter is still marked
+		confirmations, ok := broadcastWithQuorum(node, w, r, mockTx, quorum, quorumTimeout)
+		if !ok {
+			return
+		}
+		resp["message"] = "Vote submitted and confirmed by peers. Awaiting blockchain finality."
+		resp["confirmations"] = confirmations
+		code = http.StatusOK
+	} else if node.queueSubmission(mockTx) {
+		resp["message"] = "Vote accepted and queued for broadcast. Awaiting blockchain finality."
+	} else {
+		resp["message"] = "Vote accepted; it will be broadcast on the next rebroadcast round. Awaiting blockchain finality."
+	}
+	encoded, _ := json.Marshal(resp)
+	encoded = append(encoded, '\n')
+	if idempotencyKey != "" {
+		voteIdempotency.Complete(idempotencyKey, code, contentTypeJSON, encoded)
+	}
+	w.Header().Set("Content-Type", contentTypeJSON)
+	w.WriteHeader(code)
+	w.Write(encoded)
+}
+
+// --- Raw Transaction Submission ---
+
+// maxRawTxBytes bounds the decoded size of a transaction submitted to /tx.
+const maxRawTxBytes = 4096
+
+// maxRawTxBodyBytes bounds a /tx request body: room for a hex-encoded
+// transaction of maxRawTxBytes plus the JSON around it.
+const maxRawTxBodyBytes = 2*maxRawTxBytes + 1024
+
+// Encodings a raw transaction may be submitted in, named by the "encoding"
+// field of a /tx or /tx/batch request.
+const (
+	TxEncodingHex       = "hex"
+	TxEncodingBase64    = "base64"    // Standard alphabet, padded
+	TxEncodingBase64URL = "base64url" // URL-safe alphabet; padding optional
+)
+
+// VerifyTransaction checks a transaction's format and signature, whether a
+// client built it or a peer relayed it. The version must be one this node
+// knows, the sender must be a public key of the transaction's SigScheme, the
+// amount must be VoteAmount, the hash must be that of the signing bytes under
+// hasher, the chain's, and the signature must cover those same bytes. A
+// validator change has no sender: the approvals in its payload, checked by
+// validateChange, stand in for a signature, so only its hash is checked.
+func VerifyTransaction(hasher Hasher, tx *Transaction) error {
+	if err := checkTxVersion(tx); err != nil {
+		return err
+	}
+	if tx.GetKind() == TxKindValidatorChange {
+		if !bytes.Equal(tx.GetHash(), hasher.Sum(tx.SigningBytes())) {
+			return ErrBadTxHash
+		}
+		return nil
+	}
+	v, ok := verifiers[tx.SigScheme]
+	if !ok {
+		return fmt.Errorf("%w: unknown signature scheme %d", ErrMalformedTx, tx.SigScheme)
+	}
+	if len(tx.Sender) != v.PublicKeySize() {
+		return fmt.Errorf("%w: sender must be a %d-byte %s public key, got %d bytes", ErrMalformedTx, v.PublicKeySize(), v.Name(), len(tx.Sender))
+	}
+	if err := checkVoteAmount(tx); err != nil {
+		return err
+	}
+	if err := checkBallot(tx); err != nil {
+		return err
+	}
+	if len(tx.Signature) != v.SignatureSize() {
+		return fmt.Errorf("%w: %s signature must be %d bytes, got %d", ErrMalformedTx, v.Name(), v.SignatureSize(), len(tx.Signature))
+	}
+	msg := tx.SigningBytes()
+	if !bytes.Equal(tx.Hash, hasher.Sum(msg)) {
+		return ErrBadTxHash
+	}
+	if !v.Verify(tx.Sender, msg, tx.Signature) {
+		return ErrBadSignature
+	}
+	return nil
+}
+
+// VerifyTransactions runs VerifyTransaction over txs on up to workers
+// goroutines, capped at runtime.NumCPU() since verification is CPU-bound, and
+// returns each transaction's result by index, so the outcome does not depend
+// on scheduling.
+func VerifyTransactions(hasher Hasher, txs []*Transaction, workers int) []error {
+	errs := make([]error, len(txs))
+	workers = min(max(workers, 1), runtime.NumCPU(), len(txs))
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				errs[i] = VerifyTransaction(hasher, txs[i])
+			}
+		}()
+	}
+	for i := range txs {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+	return errs
+}
+
+// decodeRawTx decodes a transaction in the named encoding. The client must
+// name it: many strings are both valid hex and valid base64, and guessing
+// would decode them to different bytes than were sent.
+func decodeRawTx(encoded, encoding string) ([]byte, error) {
+	var raw []byte
+	var err error
+	switch encoding {
+	case TxEncodingHex:
+		raw, err = hex.DecodeString(strings.TrimPrefix(encoded, "0x"))
+	case TxEncodingBase64:
+		raw, err = base64.StdEncoding.DecodeString(encoded)
+	case TxEncodingBase64URL:
+		raw, err = base64.RawURLEncoding.DecodeString(strings.TrimRight(encoded, "="))
+	case "":
+		return nil, fmt.Errorf("encoding is required: %q, %q or %q", TxEncodingHex, TxEncodingBase64, TxEncodingBase64URL)
+	default:
+		return nil, fmt.Errorf("unknown encoding %q: use %q, %q or %q", encoding, TxEncodingHex, TxEncodingBase64, TxEncodingBase64URL)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("transaction is not valid %s: %v", encoding, err)
+	}
+	return raw, nil
+}
+
+// SubmitRawTransaction handles POST /tx with a client-built, signed transaction
+// ({"tx": "<encoded protobuf>", "encoding": "hex"}, or "base64" or
+// "base64url"), validates it and broadcasts it as-is.
+// Unlike /vote, the node does not construct anything on the client's behalf.
+func SubmitRawTransaction(node *P2PNode, w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		writeError(w, http.StatusMethodNotAllowed, ErrCodeMethodNotAllowed, "Only POST method is allowed")
+		return
+	}
+	if node.LoadShedding() {
+		writeMempoolFull(w)
+		return
+	}
+	quorum, quorumTimeout, err := parseQuorum(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, ErrCodeInvalidRequest, err.Error())
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxRawTxBodyBytes))
+	if err != nil {
+		writeError(w, http.StatusRequestEntityTooLarge, ErrCodeInvalidRequest, fmt.Sprintf("request body must be at most %d bytes", maxRawTxBodyBytes))
+		return
+	}
+	var req struct {
+		Tx       string `json:"tx"`
+		Encoding string `json:"encoding"` // TxEncodingHex, TxEncodingBase64 or TxEncodingBase64URL
+	}
+	if err := json.Unmarshal(body, &req); err != nil {
+		writeError(w, http.StatusBadRequest, ErrCodeInvalidRequest, err.Error())
+		return
+	}
+	raw, err := decodeRawTx(req.Tx, req.Encoding)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, ErrCodeInvalidRequest, err.Error())
+		return
+	}
+	if len(raw) > maxRawTxBytes {
+		writeError(w, http.StatusBadRequest, ErrCodeInvalidRequest, fmt.Sprintf("transaction exceeds %d bytes", maxRawTxBytes))
+		return
+	}
+
+	tx := &Transaction{}
+	if err := tx.UnmarshalProto(raw); err != nil {
+		writeError(w, http.StatusBadRequest, ErrCodeInvalidRequest, fmt.Sprintf("malformed transaction: %v", err))
+		return
+	}
+	if tx.ChainId != node.ChainID {
+		writeError(w, http.StatusBadRequest, ErrCodeInvalidRequest, fmt.Sprintf("transaction is for chain %q, expected %q", tx.ChainId, node.ChainID))
+		return
+	}
+	if err := node.checkTxTimestamp(tx, node.Chain.now()); err != nil {
+		writeError(w, http.StatusBadRequest, ErrCodeInvalidRequest, err.Error())
+		return
+	}
+	if err := VerifyTransaction(node.Chain.Hasher(), tx); err != nil {
+		code := ErrCodeInvalidRequest
+		if errors.Is(err, ErrBadSignature) {
+			code = ErrCodeInvalidSignature
+		}
+		writeError(w, http.StatusBadRequest, code, err.Error())
+		return
+	}
+	if err := node.checkTxKind(tx); err != nil {
+		status, code := txKindErrorStatus(err)
+		writeError(w, status, code, err.Error())
+		return
+	}
+
+	log.Printf("Accepted raw transaction %x from %x", tx.Hash, tx.Sender)
+	switch err := node.Mempool.Add(tx); {
+	case errors.Is(err, ErrMempoolFull):
+		writeMempoolFull(w)
+		return
+	case err == nil:
+		node.recordAccepted(tx)
+		node.logOutbound(tx)
+	}
+	confirmations, ok := broadcastWithQuorum(node, w, r, tx, quorum, quorumTimeout)
+	if !ok {
+		return
+	}
+
+	resp := map[string]interface{}{
+		"message": "Transaction accepted and broadcasted.",
+		"tx_hash": hex.EncodeToString(tx.Hash),
+	}
+	if quorum > 0 {
+		resp["confirmations"] = confirmations
+	}
+	w.Header().Set("Content-Type", contentTypeJSON)
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// txKindErrorStatus returns the HTTP status and error code for a transaction
+// refused by checkTxKind.
+func txKindErrorStatus(err error) (int, string) {
+	switch {
+	case errors.Is(err, ErrUnknownCandidate):
+		return http.StatusBadRequest, ErrCodeUnknownCandidate
+	case errors.Is(err, ErrDuplicateVote):
+		return http.StatusConflict, ErrCodeAlreadyVoted
+	case errors.Is(err, ErrDuplicateCandidate):
+		return http.StatusConflict, ErrCodeCandidateExists
+	case errors.Is(err, ErrElectionClosed):
+		return http.StatusConflict, ErrCodeElectionClosed
+	case errors.Is(err, ErrCommitRevealPhase):
+		return http.StatusConflict, ErrCodeWrongPhase
+	default:
+		return http.StatusBadRequest, ErrCodeInvalidRequest
+	}
+}
+
+// MaxTxBatch is the most transactions one /tx/batch request may submit.
+const MaxTxBatch = 1000
+
+// maxTxBatchBodyBytes bounds a /tx/batch request body: room for MaxTxBatch
+// hex-encoded transactions of maxRawTxBytes, quoted and comma-separated.
+const maxTxBatchBodyBytes = MaxTxBatch*(2*maxRawTxBytes+3) + 1024
+
+// SubmitRawTransactions handles POST /tx/batch, the bulk form of /tx for
+// clients such as polling-unit aggregators that submit many signed
+// transactions at once. Signatures are verified in parallel on up to
+// node.VerifyWorkers goroutines. The batch is all or nothing: if any
+// transaction is invalid, the first by position is reported, and if the
+// mempool cannot take every new transaction, it answers 503; either way none
+// are accepted.
+func SubmitRawTransactions(node *P2PNode, w http.ResponseWriter, r *http.Request) {
+	if node.LoadShedding() {
+		writeMempoolFull(w)
+		return
+	}
+	var req struct {
+		Txs      []string `json:"txs"`
+		Encoding string   `json:"encoding"` // Of every transaction in Txs; see decodeRawTx
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxTxBatchBodyBytes)).Decode(&req); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeError(w, http.StatusRequestEntityTooLarge, ErrCodeInvalidRequest, fmt.Sprintf("request body must be at most %d bytes", maxTxBatchBodyBytes))
+			return
+		}
+		writeError(w, http.StatusBadRequest, ErrCodeInvalidRequest, err.Error())
+		return
+	}
+	if len(req.Txs) == 0 || len(req.Txs) > MaxTxBatch {
+		writeError(w, http.StatusBadRequest, ErrCodeInvalidRequest, fmt.Sprintf("a batch must hold 1 to %d transactions", MaxTxBatch))
+		return
+	}
+
+	now := node.Chain.now()
+	txs := make([]*Transaction, len(req.Txs))
+	for i, encoded := range req.Txs {
+		raw, err := decodeRawTx(encoded, req.Encoding)
+		if err == nil && len(raw) > maxRawTxBytes {
+			err = fmt.Errorf("transaction exceeds %d bytes", maxRawTxBytes)
+		}
+		tx := &Transaction{}
+		if err == nil {
+			if err = tx.UnmarshalProto(raw); err != nil {
+				err = fmt.Errorf("malformed transaction: %v", err)
+			}
+		}
+		if err == nil && tx.ChainId != node.ChainID {
+			err = fmt.Errorf("transaction is for chain %q, expected %q", tx.ChainId, node.ChainID)
+		}
+		if err == nil {
+			err = node.checkTxTimestamp(tx, now)
+		}
+		if err != nil {
+			writeError(w, http.StatusBadRequest, ErrCodeInvalidRequest, fmt.Sprintf("transaction %d: %v", i, err))
+			return
+		}
+		txs[i] = tx
+	}
+	for i, err := range VerifyTransactions(node.Chain.Hasher(), txs, node.VerifyWorkers) {
+		if err != nil {
+			code := ErrCodeInvalidRequest
+			if errors.Is(err, ErrBadSignature) {
+				code = ErrCodeInvalidSignature
+			}
+			writeError(w, http.StatusBadRequest, code, fmt.Sprintf("transaction %d: %v", i, err))
+			return
+		}
+	}
+	for i, tx := range txs {
+		if err := node.checkTxKind(tx); err != nil {
+			status, code := txKindErrorStatus(err)
+			writeError(w, status, code, fmt.Sprintf("transaction %d: %v", i, err))
+			return
+		}
+	}
+
+	added, err := node.Mempool.AddBatch(txs)
+	if err != nil {
+		writeMempoolFull(w)
+		return
+	}
+	hashes := make([]string, len(txs))
+	for i, tx := range txs {
+		if added[i] {
+			node.recordAccepted(tx)
+			node.logOutbound(tx)
+		}
+		node.BroadcastTransaction(tx)
+		hashes[i] = hex.EncodeToString(tx.Hash)
+	}
+	log.Printf("Accepted a batch of %d raw transactions", len(txs))
+
+	w.Header().Set("Content-Type", contentTypeJSON)
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message":   "Transactions accepted and broadcasted.",
+		"tx_hashes": hashes,
+	})
+}
+
+// defaultQuorumTimeout is how long /tx and /vote wait for ?quorum= peer
+// confirmations when the client gives no ?timeout=.
+const defaultQuorumTimeout = 10 * time.Second
+
+// parseQuorum reads the optional ?quorum=<peers> and ?timeout=<seconds> of a
+// submission. A quorum of 0 means fire-and-forget gossip.
+func parseQuorum(r *http.Request) (int, time.Duration, error) {
+	query := r.URL.Query()
+	quorum, timeout := 0, defaultQuorumTimeout
+	if v := query.Get("quorum"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return 0, 0, fmt.Errorf("quorum must be a positive number of peers")
+		}
+		quorum = n
+	}
+	if v := query.Get("timeout"); v != "" && quorum > 0 {
+		secs, err := strconv.Atoi(v)
+		if err != nil || secs <= 0 {
+			return 0, 0, fmt.Errorf("timeout must be a positive number of seconds")
+		}
+		timeout = min(time.Duration(secs)*time.Second, maxLongPollTimeout)
+	}
+	return quorum, timeout, nil
+}
+
+// broadcastWithQuorum gossips tx, or with a positive quorum sends it to every
+// peer with a proven identity and waits up to timeout for that many confirmations. On failure it
+// writes the error response, reporting that the transaction was still accepted
+// here, and returns false.
+func broadcastWithQuorum(node *P2PNode, w http.ResponseWriter, r *http.Request, tx *Transaction, quorum int, timeout time.Duration) ([]PeerConfirmation, bool) {
+	if quorum == 0 {
+		node.BroadcastTransaction(tx)
+		return nil, true
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+	confirmations, err := node.ConfirmTransaction(ctx, tx, quorum)
+	if err != nil {
+		status := http.StatusGatewayTimeout
+		if errors.Is(err, ErrQuorumUnreachable) {
+			status = http.StatusServiceUnavailable
+		}
+		writeError(w, status, ErrCodeQuorumNotReached,
+			fmt.Sprintf("transaction %x was accepted by this node but confirmed by %d of %d required peers: %v", tx.GetHash(), len(confirmations), quorum, err))
+		return nil, false
+	}
+	return confirmations, true
+}
+
+// Long-poll bounds for GET /tx/{hash}/wait. Clients may ask for a shorter wait
+// with ?timeout=<seconds>; anything longer is capped.
+const (
+	defaultLongPollTimeout = 30 * time.Second
+	maxLongPollTimeout     = 60 * time.Second
+)
+
+// WaitForTransaction handles GET /tx/{hash}/wait, long-polling until the
+// transaction is included in a block. On timeout it answers with
+// "included": false so the client can simply poll again.
+func WaitForTransaction(node *P2PNode, w http.ResponseWriter, r *http.Request) {
+	txHash, err := hex.DecodeString(strings.TrimPrefix(r.PathValue("hash"), "0x"))
+	if err != nil || len(txHash) == 0 {
+		writeError(w, http.StatusBadRequest, ErrCodeInvalidRequest, "transaction hash must be hex")
+		return
+	}
+	timeout := defaultLongPollTimeout
+	if v := r.URL.Query().Get("timeout"); v != "" {
+		secs, err := strconv.Atoi(v)
+		if err != nil || secs <= 0 {
+			writeError(w, http.StatusBadRequest, ErrCodeInvalidRequest, "timeout must be a positive number of seconds")
+			return
+		}
+		timeout = min(time.Duration(secs)*time.Second, maxLongPollTimeout)
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+	resp := map[string]interface{}{"tx_hash": hex.EncodeToString(txHash), "included": false}
+	if height, err := node.WaitForInclusion(ctx, txHash); err == nil {
+		resp["included"] = true
+		resp["height"] = height
+	}
+	w.Header().Set("Content-Type", contentTypeJSON)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// TxInfo is the JSON form of a Transaction, with byte fields hex-encoded.
+type TxInfo struct {
+	Hash       string     `json:"hash"`
+	Sender     string     `json:"sender"`
+	Recipient  string     `json:"recipient,omitempty"`
+	Amount     uint64     `json:"amount"`
+	Timestamp  uint64     `json:"timestamp"`
+	ChainID    string     `json:"chain_id"`
+	Kind       TxKind     `json:"kind"`
+	Payload    string     `json:"payload,omitempty"`
+	Signature  string     `json:"signature"`
+	SigScheme  SigScheme  `json:"sig_scheme"`
+	BallotType BallotType `json:"ballot_type,omitempty"`
+	Fee        uint64     `json:"fee,omitempty"`
+}
+
+// BlockInfo is the JSON form of a Block served by the block lookup endpoints.
+type BlockInfo struct {
+	Hash          string   `json:"hash"`
+	Version       uint32   `json:"version"`
+	PrevBlockHash string   `json:"prev_block_hash"`
+	MerkleRoot    string   `json:"merkle_root"`
+	Timestamp     uint64   `json:"timestamp"`
+	Height        uint64   `json:"height"`
+	ChainID       string   `json:"chain_id"`
+	Proposer      string   `json:"proposer,omitempty"`
+	Fees          uint64   `json:"fees"`
+	Transactions  []TxInfo `json:"transactions"`
+}
+
+// NewBlockInfo converts blk to its JSON form.
+func NewBlockInfo(blk *Block) BlockInfo {
+	h := blk.Header
+	info := BlockInfo{
+		Hash:          hex.EncodeToString(h.GetHash()),
+		Version:       h.GetVersion(),
+		PrevBlockHash: hex.EncodeToString(h.GetPrevBlockHash()),
+		MerkleRoot:    hex.EncodeToString(h.GetMerkleRoot()),
+		Timestamp:     h.GetTimestamp(),
+		Height:        h.GetHeight(),
+		ChainID:       h.GetChainId(),
+		Proposer:      hex.EncodeToString(h.GetProposer()),
+		Fees:          h.GetFees(),
+		Transactions:  make([]TxInfo, 0, len(blk.Transactions)),
+	}
+	for _, tx := range blk.Transactions {
+		info.Transactions = append(info.Transactions, TxInfo{
+			Hash:       hex.EncodeToString(tx.GetHash()),
+			Sender:     hex.EncodeToString(tx.GetSender()),
+			Recipient:  hex.EncodeToString(tx.GetRecipient()),
+			Amount:     tx.GetAmount(),
+			Timestamp:  tx.GetTimestamp(),
+			ChainID:    tx.GetChainId(),
+			Kind:       tx.GetKind(),
+			Payload:    hex.EncodeToString(tx.GetPayload()),
+			Signature:  hex.EncodeToString(tx.GetSignature()),
+			SigScheme:  tx.GetSigScheme(),
+			BallotType: tx.GetBallotType(),
+			Fee:        tx.GetFee(),
+		})
+	}
+	return info
+}
+
+// GetBlockByHash handles GET /block/hash/{hex_hash}, returning the full block
+// with that header hash, as found in a receipt or explorer link.
+func GetBlockByHash(node *P2PNode, w http.ResponseWriter, r *http.Request) {
+	hash, err := hex.DecodeString(strings.TrimPrefix(r.PathValue("hex_hash"), "0x"))
+	if err != nil || len(hash) == 0 {
+		writeError(w, http.StatusBadRequest, ErrCodeInvalidRequest, "block hash must be hex")
+		return
+	}
+	blk, ok := node.Chain.GetByHash(hash)
+	if !ok {
+		writeError(w, http.StatusNotFound, ErrCodeNotFound, "no block with that hash")
+		return
+	}
+	w.Header().Set("Content-Type", contentTypeJSON)
+	json.NewEncoder(w).Encode(NewBlockInfo(blk))
+}
+
+// Page sizes for GET /blocks.
+const (
+	defaultBlocksPage = 20
+	maxBlocksPage     = 100
+)
+
+// ListBlocks handles GET /blocks?from=&limit=, returning up to limit blocks of
+// the best chain in height order from height from, or the latest limit blocks
+// without it. Blocks whose bodies a pruned node no longer holds are left out.
+func ListBlocks(node *P2PNode, w http.ResponseWriter, r *http.Request) {
+	limit := defaultBlocksPage
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			writeError(w, http.StatusBadRequest, ErrCodeInvalidRequest, "limit must be a positive number of blocks")
+			return
+		}
+		limit = min(n, maxBlocksPage)
+	}
+	tip := node.Chain.Height()
+	from := tip + 1 - min(uint64(limit), tip+1)
+	if v := r.URL.Query().Get("from"); v != "" {
+		var err error
+		if from, err = strconv.ParseUint(v, 10, 64); err != nil {
+			writeError(w, http.StatusBadRequest, ErrCodeInvalidRequest, "from must be a block height")
+			return
+		}
+	}
+
+	blocks := []BlockInfo{}
+	for h := from; h <= tip && h-from < uint64(limit); h++ {
+		if blk, ok := node.Chain.GetBlock(h); ok {
+			blocks = append(blocks, NewBlockInfo(blk))
+		}
+	}
+	w.Header().Set("Content-Type", contentTypeJSON)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"height": tip,
+		"blocks": blocks,
+	})
+}
+
+// GetHealth handles GET /health for load balancers and uptime checks. It
+// answers 200 while the node can take votes, and 503 with the reason while it
+// is overloaded (see overloadReason). An isolated node is still healthy, since
+// it queues votes until it reconnects, but says so.
+func GetHealth(node *P2PNode, w http.ResponseWriter, r *http.Request) {
+	resp := map[string]interface{}{"status": "ok", "height": node.Chain.Height(), "isolated": node.Isolated()}
+	code := http.StatusOK
+	if reason := node.overloadReason(); reason != "" {
+		resp["status"], resp["reason"] = "unavailable", reason
+		code = http.StatusServiceUnavailable
+	}
+	w.Header().Set("Content-Type", contentTypeJSON)
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// GetTallyDigest handles GET /debug/tally-digest?election_id=, returning a
+// hash of the election's full tally and the height it was counted to, so
+// monitoring can alert when nodes at the same height disagree. Recounting
+// walks the whole chain, so it needs the admin token.
+func GetTallyDigest(node *P2PNode, w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(node, w, r) {
+		return
+	}
+	electionID := r.URL.Query().Get("election_id")
+	height, digest := node.Chain.TallyDigest(electionID)
+	w.Header().Set("Content-Type", contentTypeJSON)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"election_id": electionID,
+		"height":      height,
+		"digest":      hex.EncodeToString(digest),
+	})
+}
+
+// ReplayState handles POST /admin/replay, rebuilding the node's derived state
+// from its chain (see P2PNode.Replay) and returning the resulting tally digest.
+func ReplayState(node *P2PNode, w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(node, w, r) {
+		return
+	}
+	if err := node.Replay(); err != nil {
+		writeError(w, http.StatusInternalServerError, ErrCodeInternal, err.Error())
+		return
+	}
+	height, digest := node.Chain.TallyDigest("")
+	w.Header().Set("Content-Type", contentTypeJSON)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"node_id":      node.NodeID(),
+		"height":       height,
+		"tally_digest": hex.EncodeToString(digest),
+	})
+}
+
+// sortedTallies converts per-candidate counts into a slice ordered by votes
+// descending, ties broken by candidate ID, so responses are stable and cacheable.
+func sortedTallies(counts map[string]uint64) []CandidateTally {
+	tallies := make([]CandidateTally, 0, len(counts))
+	for candidate, votes := range counts {
+		tallies = append(tallies, CandidateTally{Candidate: candidate, Votes: votes})
+	}
+	sort.Slice(tallies, func(i, j int) bool {
+		if tallies[i].Votes != tallies[j].Votes {
+			return tallies[i].Votes > tallies[j].Votes
+		}
+		return tallies[i].Candidate < tallies[j].Candidate
+	})
+	return tallies
+}
+
+// Candidate percentages on /status have DefaultResultPrecision decimal places
+// unless P2PNode.ResultPrecision says otherwise, and at most MaxResultPrecision,
+// which keeps the fixed-point arithmetic in setPercentages within uint64.
+const (
+	DefaultResultPrecision = 2
+	MaxResultPrecision     = 6
+)
+
+// setPercentages sets each tally's Percent to its share of the valid votes in
+// tallies, with precision decimal places. Abstentions and spoiled ballots are
+// not in tallies, so they do not count towards the denominator. Shares are
+// rounded by the largest-remainder method: each is rounded down, then the
+// units still missing from 100% go to the largest remainders, ties in the
+// order of tallies, so the displayed percentages always sum to exactly 100.
+func setPercentages(tallies []CandidateTally, precision int) {
+	precision = min(max(precision, 0), MaxResultPrecision)
+	var valid uint64
+	for _, tally := range tallies {
+		valid += tally.Votes
+	}
+	scale := uint64(100)
+	for range precision {
+		scale *= 10
+	}
+
+	shares := make([]uint64, len(tallies))
+	remainders := make([]uint64, len(tallies))
+	left := scale
+	for i, tally := range tallies {
+		if valid == 0 {
+			break
+		}
+		shares[i] = tally.Votes * scale / valid
+		remainders[i] = tally.Votes * scale % valid
+		left -= shares[i]
+	}
+	if valid > 0 {
+		order := make([]int, len(tallies))
+		for i := range order {
+			order[i] = i
+		}
+		sort.SliceStable(order, func(a, b int) bool { return remainders[order[a]] > remainders[order[b]] })
+		for _, i := range order[:left] {
+			shares[i]++
+		}
+	}
+
+	unit := scale / 100
+	for i := range tallies {
+		if precision == 0 {
+			tallies[i].Percent = strconv.FormatUint(shares[i], 10)
+			continue
+		}
+		tallies[i].Percent = fmt.Sprintf("%d.%0*d", shares[i]/unit, precision, shares[i]%unit)
+	}
+}
+
+// leadingCandidates returns every candidate sharing the highest vote count in
+// tallies, as ordered by sortedTallies (so by candidate ID), and whether there
+// is more than one. Nodes with the same counts always report the same leaders;
+// a tie is reported as such rather than resolved by picking one.
+func leadingCandidates(tallies []CandidateTally) ([]string, bool) {
+	leaders := []string{}
+	for _, tally := range tallies {
+		if tally.Votes == 0 || tally.Votes != tallies[0].Votes {
+			break
+		}
+		leaders = append(leaders, tally.Candidate)
+	}
+	return leaders, len(leaders) > 1
+}
+
+// Content types supported by GetElectionStatus
+const (
+	contentTypeJSON     = "application/json"
+	contentTypeProtobuf = "application/x-protobuf"
+)
+
+// GetElectionStatus provides real-time data for the election named by the
+// election_id parameter; /elections lists them. Without one it reports the
+// default election, which /vote records ballots in when they name none, as it
+// did before elections were tallied independently. Counts are never summed
+// across elections.
+// Clients sending `Accept: application/x-protobuf` get the binary ElectionStatus
+// message instead of JSON, which is much smaller for low-bandwidth mobile clients.
+func GetElectionStatus(node *P2PNode, w http.ResponseWriter, r *http.Request) {
+	electionID := r.URL.Query().Get("election_id") // Empty for the default election
+
+	// The chain fields come from the same cached aggregates as /explorer/summary
+	summary := node.ExplorerSummary()
+	status := &ElectionStatus{
+		ElectionID:            electionID,
+		TotalVotes:            node.Chain.Tally.ElectionTotal(electionID),
+		Candidates:            sortedTallies(node.Chain.Tally.ElectionCounts(electionID)),
+		LatestBlockHash:       "0x" + summary.TipHash,
+		BlockHeight:           summary.TipHeight,
+		FinalityTimeSeconds:   uint32(math.Round(summary.AvgFinalitySeconds)),
+		ValidatorsActive:      uint32(summary.ValidatorsActive),
+		Isolated:              node.Isolated(),
+		PeerClockSkewMs:       make(map[string]int64),
+		InclusionLatencyP50Ms: uint64(node.inclusionLatency.Percentile(50).Milliseconds()),
+		InclusionLatencyP95Ms: uint64(node.inclusionLatency.Percentile(95).Milliseconds()),
+	}
+	ballots := node.Chain.Tally.ElectionBallots(electionID)
+	status.AbstainVotes, status.SpoiledVotes = ballots[BallotAbstain], ballots[BallotSpoiled]
+	setPercentages(status.Candidates, node.ResultPrecision)
+	status.Leaders, status.Tie = leadingCandidates(status.Candidates)
+	for addr, skew := range node.PeerClockSkews() {
+		status.PeerClockSkewMs[addr] = skew.Milliseconds()
+	}
+
+	if strings.Contains(r.Header.Get("Accept"), contentTypeProtobuf) {
+		w.Header().Set("Content-Type", contentTypeProtobuf)
+		w.Write(status.MarshalProto())
+		return
+	}
+	w.Header().Set("Content-Type", contentTypeJSON)
+	json.NewEncoder(w).Encode(status)
+}
+
+// NodeVersion is reported by /nodeinfo and /version.
+const NodeVersion = "0.1.0"
+
+// BuildCommit is the source revision the binary was built from, set at build
+// time with -ldflags "-X main.BuildCommit=$(git rev-parse --short HEAD)".
+var BuildCommit = "unknown"
+
+// NodeInfo is the /nodeinfo response: a cheap, one-stop diagnostic summary of the node.
+type NodeInfo struct {
+	NodeID        string `json:"node_id"`
+	Version       string `json:"version"`
+	UptimeSeconds uint64 `json:"uptime_seconds"`
+	ChainID       string `json:"chain_id"`
+	TipHeight     uint64 `json:"tip_height"`
+	TipHash       string `json:"tip_hash"`
+	PeerCount     int    `json:"peer_count"`
+	MempoolSize   int    `json:"mempool_size"`
+	Synced        bool   `json:"synced"`
+	// A validator below MinPeersToPropose, holding off proposing until more peers connect
+	WaitingForPeers bool `json:"waiting_for_peers"`
+	// The node is overloaded and refusing votes; see P2PNode.LoadShedding
+	LoadShedding bool `json:"load_shedding"`
+}
+
+// GetNodeInfo handles GET /nodeinfo. Unlike /status it describes the node, not
+// the election, and only reads counters, never scanning the chain.
+func GetNodeInfo(node *P2PNode, w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		writeError(w, http.StatusMethodNotAllowed, ErrCodeMethodNotAllowed, "Only GET method is allowed")
+		return
+	}
+
+	// Both take node.mu themselves, and a read lock must not be taken twice:
+	// a writer queued in between would deadlock the handler
+	tip := node.Chain.Tip()
+	shedding := node.LoadShedding()
+	peers := node.PeerCount()
+	node.mu.RLock()
+	info := NodeInfo{
+		NodeID:        node.NodeID(),
+		Version:       NodeVersion,
+		UptimeSeconds: uint64(time.Since(node.startedAt).Seconds()),
+		ChainID:       node.ChainID,
+		TipHeight:     tip.Header.Height,
+		TipHash:       hex.EncodeToString(tip.Header.Hash),
+		PeerCount:     peers,
+		Synced:        node.synced,
+	}
+	info.WaitingForPeers = node.waitingForPeers
+	info.LoadShedding = shedding
+	node.mu.RUnlock()
+	info.MempoolSize = node.Mempool.Len()
+
+	w.Header().Set("Content-Type", contentTypeJSON)
+	json.NewEncoder(w).Encode(info)
+}
+
+// VersionInfo is the /version response.
+type VersionInfo struct {
+	Version         string `json:"version"`
+	Commit          string `json:"commit"`
+	ProtocolVersion string `json:"protocol_version"`
+	GoVersion       string `json:"go_version"`
+}
+
+// GetVersion handles GET /version, so operators can check what each node runs
+// during a rolling upgrade.
+func GetVersion(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", contentTypeJSON)
+	json.NewEncoder(w).Encode(VersionInfo{
+		Version:         NodeVersion,
+		Commit:          BuildCommit,
+		ProtocolVersion: ProtocolVersion,
+		GoVersion:       runtime.Version(),
+	})
+}
+
+// --- Node Lifecycle ---
+
+// NewAPIHandler routes the HTTP API to node's handlers, recovering from any
+// panic in them. With node.ReadOnlyHTTP set, only /status, /results, /blocks,
+// /version and /health are routed, so every other path returns 404. With
+// node.AdminHTTPAddr set, admin and debug paths return 404 here and are
+// served by NewAdminHandler instead.
+func NewAPIHandler(node *P2PNode) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		GetElectionStatus(node, w, r)
+	})
+	mux.HandleFunc("GET /results", func(w http.ResponseWriter, r *http.Request) {
+		GetElectionResults(node, w, r)
+	})
+	mux.HandleFunc("GET /blocks", func(w http.ResponseWriter, r *http.Request) {
+		ListBlocks(node, w, r)
+	})
+	mux.HandleFunc("GET /version", GetVersion)
+	mux.HandleFunc("GET /health", func(w http.ResponseWriter, r *http.Request) {
+		GetHealth(node, w, r)
+	})
+	if node.ReadOnlyHTTP {
+		return recoverHTTP(mux)
+	}
+
+	mux.HandleFunc("GET /elections", func(w http.ResponseWriter, r *http.Request) {
+		ListElections(node, w, r)
+	})
+	mux.HandleFunc("GET /elections/{id}/turnout", func(w http.ResponseWriter, r *http.Request) {
+		GetElectionTurnout(node, w, r)
+	})
+	mux.HandleFunc("GET /block/hash/{hex_hash}", func(w http.ResponseWriter, r *http.Request) {
+		GetBlockByHash(node, w, r)
+	})
+	mux.HandleFunc("GET /explorer/summary", func(w http.ResponseWriter, r *http.Request) {
+		GetExplorerSummary(node, w, r)
+	})
+	mux.HandleFunc("GET /validators", func(w http.ResponseWriter, r *http.Request) {
+		ListValidators(node, w, r)
+	})
+	mux.HandleFunc("/register", RegisterVoter)
+	mux.HandleFunc("/login", Login)
+	mux.HandleFunc("/vote", func(w http.ResponseWriter, r *http.Request) {
+		SubmitVote(node, w, r)
+	})
+	mux.HandleFunc("POST /vote/commit", func(w http.ResponseWriter, r *http.Request) {
+		SubmitVoteCommitment(node, w, r)
+	})
+	mux.HandleFunc("GET /vote/{tx_hash}/receipt", func(w http.ResponseWriter, r *http.Request) {
+		GetVoteReceipt(node, w, r)
+	})
+	mux.HandleFunc("/tx", func(w http.ResponseWriter, r *http.Request) {
+		SubmitRawTransaction(node, w, r)
+	})
+	mux.HandleFunc("POST /tx/batch", func(w http.ResponseWriter, r *http.Request) {
+		SubmitRawTransactions(node, w, r)
+	})
+	mux.HandleFunc("GET /tx/{hash}/wait", func(w http.ResponseWriter, r *http.Request) {
+		WaitForTransaction(node, w, r)
+	})
+	mux.HandleFunc("/nodeinfo", func(w http.ResponseWriter, r *http.Request) {
+		GetNodeInfo(node, w, r)
+	})
+	if node.AdminHTTPAddr == "" {
+		registerAdminRoutes(mux, node)
+	}
+	return recoverHTTP(mux)
+}
+
+// NewAdminHandler routes only the admin and debug endpoints, for the separate
+// listener on node.AdminHTTPAddr. Operators bind it to a private interface so
+// admin access can be firewalled apart from the public API; the endpoints
+// still require node.AdminToken where they did before.
+func NewAdminHandler(node *P2PNode) http.Handler {
+	mux := http.NewServeMux()
+	registerAdminRoutes(mux, node)
+	return recoverHTTP(mux)
+}
+
+// registerAdminRoutes adds the /admin and /debug endpoints to mux.
+func registerAdminRoutes(mux *http.ServeMux, node *P2PNode) {
+	mux.HandleFunc("GET /debug/tally-digest", func(w http.ResponseWriter, r *http.Request) {
+		GetTallyDigest(node, w, r)
+	})
+	mux.HandleFunc("POST /admin/replay", func(w http.ResponseWriter, r *http.Request) {
+		ReplayState(node, w, r)
+	})
+	mux.HandleFunc("GET /admin/mempool", func(w http.ResponseWriter, r *http.Request) {
+		ListMempool(node, w, r)
+	})
+}
+
+// FullNode ties the HTTP API to the P2P node so they can be shut down in order.
+type FullNode struct {
+	HTTPServer  *http.Server
+	AdminServer *http.Server // Serves NewAdminHandler when the node has an AdminHTTPAddr; nil otherwise
+	P2P         *P2PNode
+	PeersFile   string // Address book saved on Close; empty disables it
+}
+
+// Close stops the node without losing in-flight votes: first the HTTP server
+// stops accepting new votes (waiting for in-flight requests), then the P2P
+// node drains and broadcasts its queued transactions, stops gRPC, and closes
+// its channels. The audit log is closed last.
+func (fn *FullNode) Close(ctx context.Context) error {
+	if err := fn.HTTPServer.Shutdown(ctx); err != nil {
+		return fmt.Errorf("failed to stop HTTP server: %w", err)
+	}
+	if fn.AdminServer != nil {
+		if err := fn.AdminServer.Shutdown(ctx); err != nil {
+			return fmt.Errorf("failed to stop admin HTTP server: %w", err)
+		}
+	}
+	if err := fn.P2P.Close(ctx); err != nil {
+		return err
+	}
+	if fn.PeersFile != "" {
+		if err := fn.P2P.SavePeers(fn.PeersFile); err != nil {
+			return err
+		}
+	}
+	if fn.P2P.WAL != nil {
+		if err := fn.P2P.WAL.Close(); err != nil {
+			return err
+		}
+	}
+	if err := fn.P2P.Results.Close(); err != nil {
+		return err
+	}
+	if fn.P2P.Audit != nil {
+		return fn.P2P.Audit.Close()
+	}
+	return nil
+}
+
+// backgroundLoops returns, by name, the loops a running node needs, with peer
+// discovery starting from seeds. Each returns once the node closes.
+func (n *P2PNode) backgroundLoops(seeds []string) map[string]func() {
+	return map[string]func(){
+		"DiscoverPeers":        func() { n.DiscoverPeers(seeds) },
+		"ImportBlocks":         n.ImportBlocks,
+		"SweepMempool":         n.SweepMempool,
+		"SweepIdempotencyKeys": n.SweepIdempotencyKeys,
+		"RebroadcastPending":   n.RebroadcastPending,
+		"BroadcastSubmissions": n.BroadcastSubmissions,
+		"ProduceBlocks":        n.ProduceBlocks,
+		"RunAntiEntropy":       n.RunAntiEntropy,
+		"ConfirmTxWAL":         n.ConfirmTxWAL,
+		"ReplayTxWAL":          n.ReplayTxWAL,
+		"FinalizeElections":    n.FinalizeElections,
+	}
+}
+
+// StartBackground runs each of the node's background loops in its own
+// goroutine. Call it once the node is configured; Close stops them.
+func (n *P2PNode) StartBackground(seeds []string) {
+	for _, loop := range n.backgroundLoops(seeds) {
+		go loop()
+	}
+}
+
+func main() {
+	logConfig := DefaultLogConfig()
+	logConfig.File = "node.log"
+	closeLog := SetupLogging(logConfig)
+	defer closeLog()
+
+	genesis := DefaultGenesisConfig()
+	chain, err := NewChain(GenesisBlock(genesis))
+	if err != nil {
+		log.Fatalf("invalid genesis config: %v", err)
+	}
+
+	chain.KeepBlocks, _ = strconv.ParseUint(os.Getenv("NODE_KEEP_BLOCKS"), 10, 64) // Unset or 0 runs an archive node
+	if workers, err := strconv.Atoi(os.Getenv("NODE_VERIFY_WORKERS")); err == nil && workers > 0 {
+		chain.VerifyWorkers = workers // Unset verifies on every CPU
+	}
+
+	// Initialize P2P Node (conceptual)
+	p2pNode := NewP2PNode("localhost:50051")
+	p2pNode.UseChain(chain)
+	p2pNode.VerifyWorkers = chain.VerifyWorkers
+	identityKey, err := LoadIdentityKey("identity.key")
+	if err != nil {
+		log.Fatalf("Identity key: %v", err)
+	}
+	p2pNode.UseIdentityKey(identityKey)
+	// NODE_VALIDATOR_TLS restricts gRPC to mutual TLS between genesis validators
+	if validatorTLS, _ := strconv.ParseBool(os.Getenv("NODE_VALIDATOR_TLS")); validatorTLS {
+		base, err := LoadValidatorTLS(os.Getenv("NODE_TLS_CERT_FILE"), os.Getenv("NODE_TLS_KEY_FILE"), os.Getenv("NODE_TLS_CA_FILE"))
+		if err != nil {
+			log.Fatalf("Validator TLS: %v", err)
+		}
+		if err := p2pNode.EnableValidatorTLS(base); err != nil {
+			log.Fatalf("Validator TLS: %v", err)
+		}
+	} else {
+		p2pNode.AllowInsecure = true // Conceptual demo only; configure TLSConfig in production
+	}
+	p2pNode.AdminToken = os.Getenv("NODE_ADMIN_TOKEN")
+	p2pNode.ReadOnlyHTTP, _ = strconv.ParseBool(os.Getenv("NODE_READ_ONLY_HTTP"))
+	p2pNode.ResyncOnDivergence, _ = strconv.ParseBool(os.Getenv("NODE_RESYNC_ON_DIVERGENCE"))
+	p2pNode.AdminHTTPAddr = os.Getenv("NODE_ADMIN_ADDR") // e.g. localhost:8081
+	if maxOrphanBytes, err := strconv.Atoi(os.Getenv("NODE_MAX_ORPHAN_BYTES")); err == nil && maxOrphanBytes > 0 {
+		p2pNode.Orphans.MaxBytes = maxOrphanBytes
+	}
+	// NODE_VOTER_ID_KEY is the hex secret shared by every node for voter IDs
+	voterKey, err := hex.DecodeString(os.Getenv("NODE_VOTER_ID_KEY"))
+	if err == nil {
+		err = UseVoterIDKey(voterKey)
+	}
+	if err != nil {
+		log.Fatalf("Voter ID key: set NODE_VOTER_ID_KEY to the network's hex secret: %v", err)
+	}
+	if url := os.Getenv("IDENTITY_VERIFY_URL"); url != "" {
+		verifier := NewHTTPIdentityVerifier(url)
+		verifier.Token = os.Getenv("IDENTITY_VERIFY_TOKEN")
+		identityVerifier = verifier
+	} else if dev, _ := strconv.ParseBool(os.Getenv("IDENTITY_VERIFY_DEV_ACCEPT_ALL")); dev {
+		log.Printf("WARNING: IDENTITY_VERIFY_DEV_ACCEPT_ALL is set; every NIN/BVN is accepted without verification")
+		identityVerifier = MockIdentityVerifier{}
+	} else {
+		log.Fatalf("Identity verification: set IDENTITY_VERIFY_URL, or IDENTITY_VERIFY_DEV_ACCEPT_ALL=true for development")
+	}
+	audit, err := OpenAuditLog("audit.log")
+	if err != nil {
+		log.Fatalf("Audit log: %v", err)
+	}
+	p2pNode.Audit = audit
+	wal, err := OpenTxWAL("tx.wal")
+	if err != nil {
+		log.Fatalf("Transaction WAL: %v", err)
+	}
+	p2pNode.WAL = wal
+	results, err := OpenResultStore("results.log")
+	if err != nil {
+		log.Fatalf("Election results: %v", err)
+	}
+	p2pNode.Results = results
+	const peersFile = "peers.json"
+	if err := p2pNode.LoadPeers(peersFile); err != nil {
+		log.Printf("Starting without saved peers: %v", err)
+	}
+	go func() {
+		if err := p2pNode.StartGRPCServer(); err != nil {
+			log.Fatalf("gRPC server failed: %v", err)
+		}
+	}()
+	p2pNode.StartBackground([]string{"localhost:50052"}) // Seed with a dummy peer
+
+	fullNode := &FullNode{
+		HTTPServer: &http.Server{Addr: ":8080", Handler: NewAPIHandler(p2pNode)},
+		P2P:        p2pNode,
+		PeersFile:  peersFile,
+	}
+	go func() {
+		log.Println("HTTP API server starting on :8080")
+		if err := fullNode.HTTPServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatalf("HTTP server failed: %v", err)
+		}
+	}()
+	if p2pNode.AdminHTTPAddr != "" {
+		fullNode.AdminServer = &http.Server{Addr: p2pNode.AdminHTTPAddr, Handler: NewAdminHandler(p2pNode)}
+		go func() {
+			log.Printf("Admin HTTP server starting on %s", p2pNode.AdminHTTPAddr)
+			if err := fullNode.AdminServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				log.Fatalf("Admin HTTP server failed: %v", err)
+			}
+		}()
+	}
+
+	// Shut down in order on SIGINT/SIGTERM so queued votes are not lost
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
+	<-sigCh
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := fullNode.Close(ctx); err != nil {
+		log.Printf("Unclean shutdown: %v", err)
+	}
 }
 </pre>
 
@@ -0,0 +1,2517 @@
+// go_backend_api_snippet_test.go
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
+	"log"
+	"maps"
+	mrand "math/rand"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/decred/dcrd/dcrec/secp256k1/v4"
+	"github.com/decred/dcrd/dcrec/secp256k1/v4/ecdsa"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// useVoterStore gives the test an empty voter registry and an identity
+// verifier accepting everyone, restoring the package defaults afterwards.
+func useVoterStore(t *testing.T) {
+	t.Helper()
+	oldStore, oldVerifier := voterStore, identityVerifier
+	voterStore, identityVerifier = NewVoterStore(), MockIdentityVerifier{}
+	t.Cleanup(func() { voterStore, identityVerifier = oldStore, oldVerifier })
+}
+
+// newTestNode returns an unstarted node on a fresh default chain, with empty
+// double-vote sets so votes from other tests do not count against it.
+func newTestNode(t *testing.T) *P2PNode {
+	t.Helper()
+	oldVoted, oldCommitted := votedSet, committedSet
+	votedSet, committedSet = NewVotedSet(), NewVotedSet()
+	t.Cleanup(func() { votedSet, committedSet = oldVoted, oldCommitted })
+	return NewP2PNode("127.0.0.1:0")
+}
+
+// testVoter is a voter made by testVoterID: its key, and the time it signs
+// its ballots at, fixed so that a resubmitted ballot is byte-for-byte the same.
+type testVoter struct {
+	priv     ed25519.PrivateKey
+	signedAt time.Time
+}
+
+// testVoters holds the voters made by testVoterID, by hex public key.
+var testVoters sync.Map
+
+// testVoterID returns the hex public key of a fresh voter, whose ballots
+// signBallot can sign.
+func testVoterID(t *testing.T) string {
+	t.Helper()
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := hex.EncodeToString(pub)
+	testVoters.Store(id, testVoter{priv: priv, signedAt: time.Now()})
+	return id
+}
+
+// signBallot returns the /vote request body for vote on node's chain, signed
+// by the voter testVoterID made for vote's voter_id.
+func signBallot(tb testing.TB, node *P2PNode, vote map[string]string) map[string]any {
+	tb.Helper()
+	v, ok := testVoters.Load(strings.ToLower(vote["voter_id"]))
+	if !ok {
+		tb.Fatalf("voter %s was not made by testVoterID", vote["voter_id"])
+	}
+	voter := v.(testVoter)
+	return signBallotAt(tb, voter.priv, node.ChainID, voter.signedAt, vote)
+}
+
+// signBallotAt returns the /vote request body for vote on chainID, signed by
+// priv at time at.
+func signBallotAt(tb testing.TB, priv ed25519.PrivateKey, chainID string, at time.Time, vote map[string]string) map[string]any {
+	tb.Helper()
+	ballot := BallotValid
+	if vote["ballot_type"] != "" {
+		var err error
+		if ballot, err = ParseBallotType(vote["ballot_type"]); err != nil {
+			tb.Fatal(err)
+		}
+	}
+	nonce, err := hex.DecodeString(vote["nonce"])
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tx := &Transaction{
+		Sender:     priv.Public().(ed25519.PublicKey),
+		Recipient:  []byte(vote["candidate"]),
+		Amount:     VoteAmount,
+		Timestamp:  uint64(at.Unix()),
+		ChainId:    chainID,
+		Payload:    []byte(vote["election_id"]),
+		BallotType: ballot,
+		Nonce:      nonce,
+	}
+	body := map[string]any{"timestamp": tx.Timestamp, "signature": hex.EncodeToString(ed25519.Sign(priv, tx.SigningBytes()))}
+	for k, v := range vote {
+		body[k] = v
+	}
+	return body
+}
+
+// postJSON sends body as JSON to handler and returns the recorded response.
+func postJSON(t *testing.T, handler http.HandlerFunc, path string, body interface{}) *httptest.ResponseRecorder {
+	t.Helper()
+	data, err := json.Marshal(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	handler(rr, httptest.NewRequest(http.MethodPost, path, bytes.NewReader(data)))
+	return rr
+}
+
+// errorCode returns the code of the JSON error envelope in rr, failing the
+// test if the body is not one.
+func errorCode(t *testing.T, rr *httptest.ResponseRecorder) string {
+	t.Helper()
+	var resp errorResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil || resp.Error.Code == "" {
+		t.Fatalf("response %q is not an error envelope: %v", rr.Body.String(), err)
+	}
+	return resp.Error.Code
+}
+
+func TestErrorEnvelopeShape(t *testing.T) {
+	node := newTestNode(t)
+	useVoterStore(t)
+	api := NewAPIHandler(node)
+	signed, err := json.Marshal(signBallot(t, node, map[string]string{"voter_id": testVoterID(t), "election_id": "e1", "candidate": "candidate-a"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	vote := string(signed)
+	if rr := postJSON(t, api.ServeHTTP, "/vote", json.RawMessage(vote)); rr.Code != http.StatusAccepted {
+		t.Fatalf("first vote: status %d, body %s", rr.Code, rr.Body)
+	}
+
+	for _, tc := range []struct {
+		name, method, path, body string
+		status                   int
+		code                     string
+	}{
+		{"wrong method", http.MethodGet, "/vote", "", http.StatusMethodNotAllowed, ErrCodeMethodNotAllowed},
+		{"malformed body", http.MethodPost, "/vote", "{", http.StatusBadRequest, ErrCodeInvalidRequest},
+		{"second vote", http.MethodPost, "/vote", vote, http.StatusConflict, ErrCodeAlreadyVoted},
+		{"bad hash", http.MethodGet, "/tx/zz/wait", "", http.StatusBadRequest, ErrCodeInvalidRequest},
+		{"missing block", http.MethodGet, "/block/hash/00", "", http.StatusNotFound, ErrCodeNotFound},
+	} {
+		rr := httptest.NewRecorder()
+		api.ServeHTTP(rr, httptest.NewRequest(tc.method, tc.path, strings.NewReader(tc.body)))
+		if rr.Code != tc.status {
+			t.Errorf("%s: status %d, want %d", tc.name, rr.Code, tc.status)
+		}
+		if ct := rr.Header().Get("Content-Type"); ct != "application/json" {
+			t.Errorf("%s: Content-Type %q, want application/json", tc.name, ct)
+		}
+		// Exactly {"error": {"code": "...", "message": "..."}}, nothing else
+		var envelope map[string]map[string]string
+		if err := json.Unmarshal(rr.Body.Bytes(), &envelope); err != nil {
+			t.Errorf("%s: body %q is not an error envelope: %v", tc.name, rr.Body, err)
+			continue
+		}
+		body, ok := envelope["error"]
+		if len(envelope) != 1 || !ok || len(body) != 2 || body["message"] == "" {
+			t.Errorf("%s: envelope %v, want only error.code and error.message", tc.name, envelope)
+		}
+		if body["code"] != tc.code {
+			t.Errorf("%s: code %q, want %q", tc.name, body["code"], tc.code)
+		}
+	}
+}
+
+func TestVerifyPassword(t *testing.T) {
+	store := NewVoterStore()
+	if err := store.Register("voter", "correct horse"); err != nil {
+		t.Fatal(err)
+	}
+	if !store.VerifyPassword("voter", "correct horse") {
+		t.Error("correct password did not verify")
+	}
+	if store.VerifyPassword("voter", "wrong horse") {
+		t.Error("wrong password verified")
+	}
+	if store.VerifyPassword("stranger", "correct horse") {
+		t.Error("unregistered voter verified")
+	}
+}
+
+func TestRegisterSaltsPasswords(t *testing.T) {
+	store := NewVoterStore()
+	for _, id := range []string{"a", "b"} {
+		if err := store.Register(id, "same password"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	a, b := store.voters["a"], store.voters["b"]
+	if bytes.Equal(a.PasswordSalt, b.PasswordSalt) {
+		t.Error("two registrations share a salt")
+	}
+	if bytes.Equal(a.PasswordHash, b.PasswordHash) {
+		t.Error("identical passwords produced identical hashes")
+	}
+	if bytes.Contains(a.PasswordHash, []byte("same password")) {
+		t.Error("password is stored in the clear")
+	}
+}
+
+func TestRegisterRejectsDuplicate(t *testing.T) {
+	store := NewVoterStore()
+	if err := store.Register("voter", "original"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Register("voter", "takeover"); !errors.Is(err, ErrDuplicateRegistration) {
+		t.Fatalf("second registration: got %v, want ErrDuplicateRegistration", err)
+	}
+	if !store.VerifyPassword("voter", "original") || store.VerifyPassword("voter", "takeover") {
+		t.Error("duplicate registration replaced the password")
+	}
+}
+
+func TestRegisterVoterDuplicateIsIndistinguishable(t *testing.T) {
+	useVoterStore(t)
+	req := map[string]string{"nin_bvn": "12345678901", "password": "original"}
+	first := postJSON(t, RegisterVoter, "/register", req)
+	if first.Code != http.StatusOK {
+		t.Fatalf("first registration: status %d, body %s", first.Code, first.Body)
+	}
+	req["password"] = "takeover"
+	second := postJSON(t, RegisterVoter, "/register", req)
+	if second.Code != first.Code || second.Body.String() != first.Body.String() {
+		t.Errorf("second registration answered %d %s, first %d %s", second.Code, second.Body, first.Code, first.Body)
+	}
+	if strings.Contains(first.Body.String(), "voting_token") {
+		t.Error("registration issued a voting token, which only a new registration would get")
+	}
+	if !voterStore.VerifyPassword(hashNINBVN("12345678901"), "original") {
+		t.Error("duplicate registration replaced the password")
+	}
+}
+
+func TestVoterIDIsKeyed(t *testing.T) {
+	oldKey := voterIDKey
+	t.Cleanup(func() { voterIDKey = oldKey })
+	id := hashNINBVN("12345678901")
+	if id == hex.EncodeToString(testHash([]byte("12345678901"))) {
+		t.Error("voter ID is a plain hash of the NIN/BVN")
+	}
+	if err := UseVoterIDKey(make([]byte, MinVoterIDKeyLen-1)); err == nil {
+		t.Error("short voter ID key accepted")
+	}
+	if err := UseVoterIDKey(bytes.Repeat([]byte{1}, MinVoterIDKeyLen)); err != nil {
+		t.Fatal(err)
+	}
+	if other := hashNINBVN("12345678901"); other == id || len(other) != 2*ed25519.PublicKeySize {
+		t.Errorf("voter ID %s under another key, want a different %d-byte ID", other, ed25519.PublicKeySize)
+	}
+}
+
+func TestParseVoteParties(t *testing.T) {
+	voter := testVoterID(t)
+	tests := []struct {
+		name, voterID, candidate string
+		ok                       bool
+	}{
+		{"valid", voter, "candidate-a", true},
+		{"no candidate", voter, "", true},
+		{"longest candidate", voter, strings.Repeat("c", MaxCandidateIDLen), true},
+		{"empty sender", "", "candidate-a", false},
+		{"short sender", voter[:len(voter)-2], "candidate-a", false},
+		{"long sender", voter + "00", "candidate-a", false},
+		{"non-hex sender", strings.Repeat("zz", ed25519.PublicKeySize), "candidate-a", false},
+		{"oversized candidate", voter, strings.Repeat("c", MaxCandidateIDLen+1), false},
+	}
+	for _, tt := range tests {
+		sender, recipient, err := parseVoteParties(tt.voterID, tt.candidate)
+		if (err == nil) != tt.ok {
+			t.Errorf("%s: got err %v, want ok %v", tt.name, err, tt.ok)
+			continue
+		}
+		if tt.ok && (len(sender) != ed25519.PublicKeySize || string(recipient) != tt.candidate) {
+			t.Errorf("%s: got sender %x, recipient %q", tt.name, sender, recipient)
+		}
+	}
+}
+
+func TestSubmitVoteNormalizesVoterID(t *testing.T) {
+	node := newTestNode(t)
+	voter := testVoterID(t)
+	vote := map[string]string{"voter_id": voter, "election_id": "e1", "candidate": "candidate-a"}
+	if rr := postVote(t, node, "", vote); rr.Code != http.StatusAccepted {
+		t.Fatalf("first vote: status %d, body %s", rr.Code, rr.Body)
+	}
+	vote["voter_id"] = strings.ToUpper(voter)
+	rr := postVote(t, node, "", vote)
+	if rr.Code != http.StatusConflict || errorCode(t, rr) != ErrCodeAlreadyVoted {
+		t.Fatalf("same voter in upper case: status %d, body %s", rr.Code, rr.Body)
+	}
+	if n := node.Mempool.Len(); n != 1 {
+		t.Errorf("mempool holds %d votes, want 1", n)
+	}
+}
+
+func TestSubmitVoteRequiresVoterSignature(t *testing.T) {
+	node := newTestNode(t)
+	submit := func(w http.ResponseWriter, r *http.Request) { SubmitVote(node, w, r) }
+	vote := map[string]string{"voter_id": testVoterID(t), "election_id": "e1", "candidate": "candidate-a"}
+
+	if rr := postJSON(t, submit, "/vote", vote); rr.Code != http.StatusBadRequest {
+		t.Errorf("unsigned vote: status %d, body %s", rr.Code, rr.Body)
+	}
+	altered := signBallot(t, node, vote)
+	altered["candidate"] = "candidate-b"
+	if rr := postJSON(t, submit, "/vote", altered); rr.Code != http.StatusBadRequest || errorCode(t, rr) != ErrCodeInvalidSignature {
+		t.Errorf("vote altered after signing: status %d, body %s", rr.Code, rr.Body)
+	}
+	if rr := postVote(t, node, "", vote); rr.Code != http.StatusAccepted {
+		t.Fatalf("signed vote: status %d, body %s", rr.Code, rr.Body)
+	}
+	if tx := <-node.submissions; VerifyTransaction(node.Chain.Hasher(), tx) != nil {
+		t.Errorf("accepted vote does not verify: %v", VerifyTransaction(node.Chain.Hasher(), tx))
+	}
+}
+
+func TestGetNodeInfoReportsNode(t *testing.T) {
+	node := newTestNode(t)
+	extendChain(t, node.Chain, 2)
+	node.peers["127.0.0.1:50052"] = &peerState{state: PeerConnected, client: &mockNodeServiceClient{}}
+	if err := node.Mempool.Add(testVote("e", "a", 10)); err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	NewAPIHandler(node).ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/nodeinfo", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("status %d, body %s", rr.Code, rr.Body)
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(rr.Body.Bytes(), &fields); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"node_id", "version", "uptime_seconds", "chain_id", "tip_height", "tip_hash", "peer_count", "mempool_size", "synced"} {
+		if _, ok := fields[name]; !ok {
+			t.Errorf("/nodeinfo has no %s", name)
+		}
+	}
+	var info NodeInfo
+	if err := json.Unmarshal(rr.Body.Bytes(), &info); err != nil {
+		t.Fatal(err)
+	}
+	tip := node.Chain.Tip().Header
+	if info.TipHeight != tip.Height || info.TipHash != hex.EncodeToString(tip.Hash) {
+		t.Errorf("tip %d %s, want %d %x", info.TipHeight, info.TipHash, tip.Height, tip.Hash)
+	}
+	if info.NodeID != node.NodeID() || info.Version != NodeVersion || info.ChainID != node.ChainID || info.PeerCount != 1 || info.MempoolSize != 1 {
+		t.Errorf("got %+v", info)
+	}
+}
+
+func TestGetNodeInfoUnderPeerChurn(t *testing.T) {
+	node := newTestNode(t)
+	node.peers["127.0.0.1:50052"] = &peerState{state: PeerConnected, client: &mockNodeServiceClient{}}
+
+	// A writer contending for node.mu deadlocks a handler that read-locks twice
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				node.mu.Lock()
+				node.mu.Unlock()
+			}
+		}
+	}()
+
+	done := make(chan NodeInfo)
+	go func() {
+		var info NodeInfo
+		for i := 0; i < 20000; i++ {
+			rr := httptest.NewRecorder()
+			GetNodeInfo(node, rr, httptest.NewRequest(http.MethodGet, "/nodeinfo", nil))
+			info = NodeInfo{}
+			json.Unmarshal(rr.Body.Bytes(), &info)
+		}
+		done <- info
+	}()
+	select {
+	case info := <-done:
+		if info.PeerCount != 1 {
+			t.Errorf("peer_count %d, want 1", info.PeerCount)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("GetNodeInfo deadlocked")
+	}
+}
+
+func TestTallyDigestRequiresAdmin(t *testing.T) {
+	node := newTestNode(t)
+	node.AdminToken = "secret"
+	api := NewAPIHandler(node)
+
+	rr := httptest.NewRecorder()
+	api.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/debug/tally-digest", nil))
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("without a token: status %d, want 401", rr.Code)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/debug/tally-digest?election_id=e", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	rr = httptest.NewRecorder()
+	api.ServeHTTP(rr, req)
+	var resp struct {
+		Digest string `json:"digest"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); rr.Code != http.StatusOK || err != nil || resp.Digest == "" {
+		t.Errorf("with the token: status %d, body %s", rr.Code, rr.Body)
+	}
+}
+
+func TestElectionStatusIsPerElection(t *testing.T) {
+	node := newTestNode(t)
+	blk := testBlock(node.Chain.Tip(),
+		testVote("president", "alice", 1), testVote("president", "alice", 2),
+		testVote("senate", "bob", 3), testVote("", "carol", 4))
+	if err := node.Chain.AppendBlock(blk); err != nil {
+		t.Fatal(err)
+	}
+	api := NewAPIHandler(node)
+
+	for election, want := range map[string]map[string]uint64{
+		"president": {"alice": 2},
+		"senate":    {"bob": 1},
+		"":          {"carol": 1}, // Without election_id, as clients asked before elections were split
+	} {
+		path := "/status"
+		if election != "" {
+			path += "?election_id=" + election
+		}
+		rr := httptest.NewRecorder()
+		api.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, path, nil))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("%s: status %d, body %s", path, rr.Code, rr.Body)
+		}
+		var status ElectionStatus
+		if err := json.Unmarshal(rr.Body.Bytes(), &status); err != nil {
+			t.Fatalf("%s: %v", election, err)
+		}
+		got := make(map[string]uint64)
+		for _, tally := range status.Candidates {
+			got[tally.Candidate] = tally.Votes
+		}
+		if status.ElectionID != election || !maps.Equal(got, want) || status.TotalVotes != sum(want) {
+			t.Errorf("%s: got %+v, want candidates %v", path, status, want)
+		}
+	}
+}
+
+func TestElectionStatusOrderIsStable(t *testing.T) {
+	node := newTestNode(t)
+	var votes []*Transaction
+	for i, candidate := range []string{"dave", "carol", "bob", "alice", "carol", "erin", "bob", "alice"} {
+		votes = append(votes, testVote("e", candidate, i+1))
+	}
+	if err := node.Chain.AppendBlock(testBlock(node.Chain.Tip(), votes...)); err != nil {
+		t.Fatal(err)
+	}
+	api := NewAPIHandler(node)
+
+	var first string
+	for i := 0; i < 20; i++ {
+		rr := httptest.NewRecorder()
+		api.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/status?election_id=e", nil))
+		if i == 0 {
+			first = rr.Body.String()
+		} else if rr.Body.String() != first {
+			t.Fatalf("call %d returned\n%s\nfirst call returned\n%s", i, rr.Body, first)
+		}
+	}
+	var status ElectionStatus
+	if err := json.Unmarshal([]byte(first), &status); err != nil {
+		t.Fatal(err)
+	}
+	var order []string
+	for _, tally := range status.Candidates {
+		order = append(order, tally.Candidate)
+	}
+	// Most votes first, ties by candidate ID
+	if want := []string{"alice", "bob", "carol", "dave", "erin"}; !slices.Equal(order, want) {
+		t.Errorf("candidates in order %v, want %v", order, want)
+	}
+}
+
+func TestElectionStatusContentNegotiation(t *testing.T) {
+	node := newTestNode(t)
+	blk := testBlock(node.Chain.Tip(), testVote("e", "alice", 1), testVote("e", "alice", 2), testVote("e", "bob", 3))
+	if err := node.Chain.AppendBlock(blk); err != nil {
+		t.Fatal(err)
+	}
+	api := NewAPIHandler(node)
+	get := func(accept string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/status?election_id=e", nil)
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		rr := httptest.NewRecorder()
+		api.ServeHTTP(rr, req)
+		return rr
+	}
+	want := map[string]uint64{"alice": 2, "bob": 1}
+
+	rr := get("")
+	var status ElectionStatus
+	if rr.Header().Get("Content-Type") != contentTypeJSON {
+		t.Errorf("default Content-Type %q, want %q", rr.Header().Get("Content-Type"), contentTypeJSON)
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &status); err != nil {
+		t.Fatal(err)
+	}
+	if status.TotalVotes != 3 || status.BlockHeight != 1 || len(status.Candidates) != len(want) {
+		t.Errorf("JSON status %+v, want 3 votes at height 1", status)
+	}
+
+	rr = get(contentTypeProtobuf)
+	if rr.Header().Get("Content-Type") != contentTypeProtobuf {
+		t.Fatalf("Content-Type %q, want %q", rr.Header().Get("Content-Type"), contentTypeProtobuf)
+	}
+	if rr.Body.Len() >= len(get(contentTypeJSON).Body.Bytes()) {
+		t.Errorf("protobuf body of %d bytes is no smaller than the JSON", rr.Body.Len())
+	}
+	var (
+		total, height uint64
+		election      string
+		got           = make(map[string]uint64)
+	)
+	b := rr.Body.Bytes()
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			t.Fatal(protowire.ParseError(n))
+		}
+		b = b[n:]
+		switch {
+		case num == 1 && typ == protowire.VarintType:
+			total, n = protowire.ConsumeVarint(b)
+		case num == 4 && typ == protowire.VarintType:
+			height, n = protowire.ConsumeVarint(b)
+		case num == 15 && typ == protowire.BytesType:
+			election, n = protowire.ConsumeString(b)
+		case num == 2 && typ == protowire.BytesType:
+			var entry []byte
+			entry, n = protowire.ConsumeBytes(b)
+			var candidate string
+			var votes uint64
+			for len(entry) > 0 {
+				num, typ, m := protowire.ConsumeTag(entry)
+				entry = entry[m:]
+				switch {
+				case num == 1:
+					candidate, m = protowire.ConsumeString(entry)
+				case num == 2:
+					votes, m = protowire.ConsumeVarint(entry)
+				default:
+					m = protowire.ConsumeFieldValue(num, typ, entry)
+				}
+				if m < 0 {
+					t.Fatal(protowire.ParseError(m))
+				}
+				entry = entry[m:]
+			}
+			got[candidate] = votes
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, b)
+		}
+		if n < 0 {
+			t.Fatal(protowire.ParseError(n))
+		}
+		b = b[n:]
+	}
+	if total != 3 || height != 1 || election != "e" || !maps.Equal(got, want) {
+		t.Errorf("protobuf status: %d votes at height %d for %q, candidates %v; want 3 at 1 for \"e\", %v", total, height, election, got, want)
+	}
+}
+
+// sum adds up the values of counts.
+func sum(counts map[string]uint64) uint64 {
+	var total uint64
+	for _, n := range counts {
+		total += n
+	}
+	return total
+}
+
+// postVote sends a /vote request for vote, signed by its voter (see signBallot),
+// with the given Idempotency-Key, if any.
+func postVote(t *testing.T, node *P2PNode, key string, vote map[string]string) *httptest.ResponseRecorder {
+	t.Helper()
+	data, err := json.Marshal(signBallot(t, node, vote))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest(http.MethodPost, "/vote", bytes.NewReader(data))
+	if key != "" {
+		req.Header.Set("Idempotency-Key", key)
+	}
+	rr := httptest.NewRecorder()
+	SubmitVote(node, rr, req)
+	return rr
+}
+
+// useIdempotencyCache gives the test an empty /vote Idempotency-Key cache.
+func useIdempotencyCache(t *testing.T) {
+	t.Helper()
+	old := voteIdempotency
+	voteIdempotency = NewIdempotencyCache(idempotencyKeyTTL)
+	t.Cleanup(func() { voteIdempotency = old })
+}
+
+func TestSubmitVoteIdempotencyKeyReplays(t *testing.T) {
+	node := newTestNode(t)
+	useIdempotencyCache(t)
+	vote := map[string]string{"voter_id": testVoterID(t), "election_id": "e1", "candidate": "candidate-a"}
+
+	first := postVote(t, node, "key-1", vote)
+	if first.Code != http.StatusAccepted {
+		t.Fatalf("first submission: status %d, body %s", first.Code, first.Body)
+	}
+	retry := postVote(t, node, "key-1", vote)
+	if retry.Code != first.Code || retry.Body.String() != first.Body.String() || retry.Header().Get("Content-Type") != first.Header().Get("Content-Type") {
+		t.Errorf("retry got %d %q %s, first got %d %q %s", retry.Code, retry.Header().Get("Content-Type"), retry.Body,
+			first.Code, first.Header().Get("Content-Type"), first.Body)
+	}
+	if queued := len(node.submissions); queued != 1 {
+		t.Errorf("%d votes queued for broadcast, want 1", queued)
+	}
+
+	vote["candidate"] = "candidate-b"
+	if rr := postVote(t, node, "key-1", vote); rr.Code != http.StatusUnprocessableEntity || errorCode(t, rr) != ErrCodeIdempotencyConflict {
+		t.Errorf("key reused with another body: status %d, body %s", rr.Code, rr.Body)
+	}
+}
+
+func TestLoadSheddingRefusesVotesWhenMempoolSaturated(t *testing.T) {
+	node := newTestNode(t)
+	useIdempotencyCache(t)
+	node.Mempool = NewMempool(10)
+
+	// A backed-up peer outbox alone is not node overload
+	node.outboxes["slow-peer:1"] = &peerOutbox{txs: make([]func(), max(node.BroadcastQueueLen, 1))}
+	if node.LoadShedding() {
+		t.Fatal("shedding load for one slow peer's outbox")
+	}
+
+	for node.Mempool.Saturation() < node.MempoolHighWater {
+		if err := node.Mempool.Add(signedVote(t)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	vote := map[string]string{"voter_id": testVoterID(t), "election_id": "e1", "candidate": "candidate-a"}
+	if rr := postVote(t, node, "", vote); rr.Code != http.StatusServiceUnavailable || errorCode(t, rr) != ErrCodeMempoolFull {
+		t.Fatalf("vote while saturated: status %d, body %s", rr.Code, rr.Body)
+	}
+	raw := signedVote(t)
+	if rr := postRawTx(t, node, hex.EncodeToString(raw.MarshalProto()), TxEncodingHex); rr.Code != http.StatusServiceUnavailable || errorCode(t, rr) != ErrCodeMempoolFull {
+		t.Errorf("raw transaction while saturated: status %d, body %s", rr.Code, rr.Body)
+	}
+	if _, ok := node.Mempool.Get(raw.Hash); ok {
+		t.Error("raw transaction admitted while shedding load")
+	}
+	rr := httptest.NewRecorder()
+	GetNodeInfo(node, rr, httptest.NewRequest(http.MethodGet, "/nodeinfo", nil))
+	var info NodeInfo
+	if err := json.Unmarshal(rr.Body.Bytes(), &info); err != nil {
+		t.Fatal(err)
+	}
+	if !info.LoadShedding {
+		t.Error("/nodeinfo does not report load shedding")
+	}
+}
+
+func TestResubmittedVoteIsRecordedOnce(t *testing.T) {
+	node := newTestNode(t)
+	useIdempotencyCache(t)
+	node.Chain.Clock = NewManualClock(time.Now()).Now // Same timestamp, so the resubmission is the same transaction
+	dir := t.TempDir()
+	path, walPath := filepath.Join(dir, "audit.log"), filepath.Join(dir, "tx.wal")
+	audit, err := OpenAuditLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { audit.Close() })
+	node.Audit = audit
+	node.WAL = openTestWAL(t, walPath)
+
+	vote := map[string]string{"voter_id": testVoterID(t), "election_id": "e1", "candidate": "candidate-a"}
+	for i := 0; i < 2; i++ {
+		if rr := postVote(t, node, "", vote); rr.Code != http.StatusAccepted {
+			t.Fatalf("submission %d: status %d, body %s", i+1, rr.Code, rr.Body)
+		}
+		votedSet.Unmark(vote["voter_id"], vote["election_id"]) // As if the double-vote set had been lost
+	}
+	if seq, _, err := readAuditLog(path); err != nil || seq != 1 {
+		t.Errorf("audit log has %d entries (err %v), want 1", seq, err)
+	}
+	data, err := os.ReadFile(walPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := bytes.Count(data, []byte(`"op":"add"`)); n != 1 {
+		t.Errorf("WAL has %d add records, want 1", n)
+	}
+}
+
+// slowPeer accepts transactions after delay, reporting each one on sent.
+type slowPeer struct {
+	mockNodeServiceClient
+	delay time.Duration
+	sent  chan []byte
+}
+
+func (p *slowPeer) SendTransaction(ctx context.Context, in *SendTransactionRequest, opts ...grpc.CallOption) (*SendTransactionResponse, error) {
+	time.Sleep(p.delay)
+	p.sent <- in.GetTransaction().GetHash()
+	return &SendTransactionResponse{Success: true}, nil
+}
+
+func TestSubmitVoteAnswersBeforeSlowPeers(t *testing.T) {
+	node := newTestNode(t)
+	useIdempotencyCache(t)
+	sent := make(chan []byte, 3)
+	for i := 0; i < 3; i++ {
+		node.peers[fmt.Sprintf("slow-%d:1", i)] = &peerState{state: PeerConnected, client: &slowPeer{delay: 200 * time.Millisecond, sent: sent}}
+	}
+	go node.BroadcastSubmissions()
+	t.Cleanup(node.stopClosing)
+
+	start := time.Now()
+	rr := postVote(t, node, "", map[string]string{"voter_id": testVoterID(t), "election_id": "e1", "candidate": "candidate-a"})
+	if elapsed := time.Since(start); elapsed >= 100*time.Millisecond {
+		t.Errorf("/vote took %s with slow peers", elapsed)
+	}
+	var resp struct {
+		Message string `json:"message"`
+		TxHash  string `json:"tx_hash"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); rr.Code != http.StatusAccepted || err != nil {
+		t.Fatalf("status %d, body %s", rr.Code, rr.Body)
+	}
+	if !strings.Contains(resp.Message, "queued for broadcast") {
+		t.Errorf("message %q does not say the vote was queued", resp.Message)
+	}
+	select {
+	case hash := <-sent:
+		if hex.EncodeToString(hash) != resp.TxHash {
+			t.Errorf("broadcast %x, want %s", hash, resp.TxHash)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("vote was never broadcast")
+	}
+}
+
+func TestCloseBroadcastsPendingTransactions(t *testing.T) {
+	node := newTestNode(t)
+	sent := make(chan []byte, 6)
+	for i := 0; i < 2; i++ {
+		node.peers[fmt.Sprintf("slow-%d:1", i)] = &peerState{state: PeerConnected, client: &slowPeer{delay: 20 * time.Millisecond, sent: sent}}
+	}
+	node.GossipFanout = 2
+	want := make(map[string]int)
+	for i := 1; i <= 3; i++ {
+		tx := testVote("e", "candidate-a", i)
+		if err := node.Mempool.Add(tx); err != nil {
+			t.Fatal(err)
+		}
+		want[hex.EncodeToString(tx.Hash)] = 2
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := node.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+	// Every send finished before Close returned
+	got := make(map[string]int)
+	for len(sent) > 0 {
+		got[hex.EncodeToString(<-sent)]++
+	}
+	if !maps.Equal(got, want) {
+		t.Errorf("broadcast before shutdown %v, want each pending transaction to both peers %v", got, want)
+	}
+	if _, open := <-node.BlockChan; open {
+		t.Error("BlockChan still open after Close")
+	}
+}
+
+func TestCloseStopsBackgroundLoops(t *testing.T) {
+	node := newTestNode(t)
+	node.MempoolSweepInterval = time.Millisecond
+	node.RebroadcastAfter = time.Millisecond
+	node.AntiEntropyInterval = time.Millisecond
+	node.BlockInterval = time.Millisecond
+	node.IsolationRetryInterval = time.Millisecond
+	node.WAL = openTestWAL(t, filepath.Join(t.TempDir(), "tx.wal"))
+	if err := node.WAL.Append(signedVote(t)); err != nil { // Replayed once a peer connects, which none does
+		t.Fatal(err)
+	}
+	loops := map[string]func(){
+		"SweepMempool":       node.SweepMempool,
+		"RebroadcastPending": node.RebroadcastPending,
+		"RunAntiEntropy":     node.RunAntiEntropy,
+		"ProduceBlocks":      node.ProduceBlocks,
+		"DiscoverPeers":      func() { node.DiscoverPeers(nil) },
+		"ReplayTxWAL":        node.ReplayTxWAL,
+		"ConfirmTxWAL":       node.ConfirmTxWAL,
+	}
+	done := make(map[string]chan struct{})
+	for name, loop := range loops {
+		stopped := make(chan struct{})
+		done[name] = stopped
+		go func() {
+			loop()
+			close(stopped)
+		}()
+	}
+	time.Sleep(20 * time.Millisecond) // Let every loop tick a few times
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := node.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+	for name, ch := range done {
+		select {
+		case <-ch:
+		case <-time.After(5 * time.Second):
+			t.Errorf("%s still running after Close", name)
+		}
+	}
+	ran := make(chan struct{}, 1)
+	node.enqueueSend("peer:1", false, func() { ran <- struct{}{} })
+	if node.trackSend() {
+		t.Error("trackSend allowed a send after Close")
+	}
+	select {
+	case <-ran:
+		t.Error("send queued after Close ran")
+	case <-time.After(20 * time.Millisecond):
+	}
+}
+
+func TestStartBackgroundImportsPeerBlocks(t *testing.T) {
+	node := newTestNode(t)
+	loops := node.backgroundLoops(nil)
+	for _, name := range []string{"ImportBlocks", "BroadcastSubmissions", "ProduceBlocks", "ReplayTxWAL", "FinalizeElections"} {
+		if loops[name] == nil {
+			t.Errorf("background loops lack %s", name)
+		}
+	}
+	node.StartBackground(nil)
+
+	blk := testBlock(node.Chain.Tip(), testVote("e", "a", 1))
+	if _, err := node.SendBlock(inboundCtx("10.0.0.9:5000", nil), &SendBlockRequest{Block: blk}); err != nil {
+		t.Fatal(err)
+	}
+	for deadline := time.Now().Add(5 * time.Second); node.Chain.Height() < 1; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("block received over gRPC was never imported")
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := node.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestQueueSubmissionReportsFullQueue(t *testing.T) {
+	node := newTestNode(t)
+	node.submissions = make(chan *Transaction, 1)
+	if !node.queueSubmission(signedVote(t)) {
+		t.Fatal("refused with room in the queue")
+	}
+	if node.queueSubmission(signedVote(t)) {
+		t.Error("reported a vote queued into a full queue")
+	}
+}
+
+func TestSubmitVoteIdempotencyKeyIsPerVoter(t *testing.T) {
+	node := newTestNode(t)
+	useIdempotencyCache(t)
+	for i := 0; i < 2; i++ {
+		vote := map[string]string{"voter_id": testVoterID(t), "election_id": "e1", "candidate": "candidate-a"}
+		if rr := postVote(t, node, "same-key", vote); rr.Code != http.StatusAccepted {
+			t.Fatalf("voter %d: status %d, body %s", i, rr.Code, rr.Body)
+		}
+	}
+	if queued := len(node.submissions); queued != 2 {
+		t.Errorf("%d votes queued, want one per voter", queued)
+	}
+}
+
+func TestSubmitVoteFailureReleasesIdempotencyKey(t *testing.T) {
+	node := newTestNode(t)
+	useIdempotencyCache(t)
+	vote := map[string]string{"voter_id": testVoterID(t), "election_id": "e1", "candidate": "candidate-a"}
+
+	full := node.submissions
+	node.submissions = make(chan *Transaction) // Unbuffered, so always full
+	if rr := postVote(t, node, "key-1", vote); rr.Code != http.StatusServiceUnavailable {
+		t.Fatalf("with a full queue: status %d, want 503", rr.Code)
+	}
+	node.submissions = full
+	if rr := postVote(t, node, "key-1", vote); rr.Code != http.StatusAccepted {
+		t.Errorf("retry after the failure: status %d, body %s", rr.Code, rr.Body)
+	}
+}
+
+func TestIdempotencyCacheReservesAndSweeps(t *testing.T) {
+	c := NewIdempotencyCache(time.Minute)
+	now := time.Now()
+	body := testHash([]byte("body"))
+	if prev, err := c.Reserve("k", body, now); prev != nil || err != nil {
+		t.Fatalf("new key: got %v, %v", prev, err)
+	}
+	if _, err := c.Reserve("k", body, now); !errors.Is(err, ErrIdempotencyInFlight) {
+		t.Errorf("reserved key: got %v, want ErrIdempotencyInFlight", err)
+	}
+	c.Complete("k", http.StatusAccepted, contentTypeJSON, []byte("{}"))
+	if prev, err := c.Reserve("k", body, now); err != nil || prev == nil || prev.status != http.StatusAccepted {
+		t.Errorf("completed key: got %+v, %v", prev, err)
+	}
+
+	if swept := c.Sweep(now.Add(30 * time.Second)); swept != 0 {
+		t.Errorf("swept %d live keys", swept)
+	}
+	if swept := c.Sweep(now.Add(time.Minute)); swept != 1 || c.Len() != 0 {
+		t.Errorf("swept %d expired keys, %d left", swept, c.Len())
+	}
+}
+
+func TestLogin(t *testing.T) {
+	useVoterStore(t)
+	oldLimiter := loginLimiter
+	loginLimiter = NewRateLimiter(5, time.Minute)
+	t.Cleanup(func() { loginLimiter = oldLimiter })
+	if err := voterStore.Register(hashNINBVN("12345678901"), "correct horse"); err != nil {
+		t.Fatal(err)
+	}
+
+	rr := postJSON(t, Login, "/login", map[string]string{"nin_bvn": "12345678901", "password": "correct horse"})
+	var resp map[string]string
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); rr.Code != http.StatusOK || err != nil || resp["voting_token"] == "" {
+		t.Errorf("correct password: status %d, body %s", rr.Code, rr.Body)
+	}
+	for name, req := range map[string]map[string]string{
+		"wrong password": {"nin_bvn": "12345678901", "password": "wrong horse"},
+		"unknown voter":  {"nin_bvn": "99999999999", "password": "correct horse"},
+		"empty password": {"nin_bvn": "99999999999", "password": ""},
+	} {
+		if rr := postJSON(t, Login, "/login", req); rr.Code != http.StatusUnauthorized || errorCode(t, rr) != ErrCodeUnauthorized {
+			t.Errorf("%s: status %d, body %s", name, rr.Code, rr.Body)
+		}
+	}
+
+	// Four attempts so far from the same address; the limit is five
+	postJSON(t, Login, "/login", map[string]string{"nin_bvn": "12345678901", "password": "wrong horse"})
+	if rr := postJSON(t, Login, "/login", map[string]string{"nin_bvn": "12345678901", "password": "correct horse"}); rr.Code != http.StatusTooManyRequests {
+		t.Errorf("sixth attempt: status %d, want 429", rr.Code)
+	}
+}
+
+func TestRateLimiterPrunesEndedWindows(t *testing.T) {
+	l := NewRateLimiter(1, 20*time.Millisecond)
+	for _, key := range []string{"a", "b", "c"} {
+		if !l.Allow(key) {
+			t.Fatalf("first event for %s refused", key)
+		}
+	}
+	if l.Allow("a") {
+		t.Error("second event within the window allowed")
+	}
+	time.Sleep(30 * time.Millisecond)
+	if !l.Allow("d") {
+		t.Fatal("event for a new key refused")
+	}
+	if n := l.Len(); n != 1 {
+		t.Errorf("tracking %d keys after their windows ended, want 1", n)
+	}
+}
+
+// addConfirmingPeers connects node to n in-memory peers, each proving its own
+// identity key, and returns them.
+func addConfirmingPeers(t *testing.T, node *P2PNode, n int) []*P2PNode {
+	t.Helper()
+	network := NewMemoryNetwork()
+	var peers []*P2PNode
+	for i := 0; i < n; i++ {
+		addr := fmt.Sprintf("peer-%d:9000", i)
+		peer := NewP2PNode(addr)
+		network.servers[addr] = peer
+		node.peers[addr] = &peerState{state: PeerConnected, client: &memoryClient{network: network, addr: addr}, identityKey: peer.PublicKey()}
+		peers = append(peers, peer)
+	}
+	return peers
+}
+
+func TestConfirmTransactionCountsDistinctIdentities(t *testing.T) {
+	node := newTestNode(t)
+	peers := addConfirmingPeers(t, node, 3)
+	tx := testVote("e", "a", 1)
+	tx.Timestamp = uint64(time.Now().Unix())
+	signTestTx(tx, testVoterKey(1))
+
+	confirmed, err := node.ConfirmTransaction(context.Background(), tx, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(confirmed) != 2 || bytes.Equal(confirmed[0].Receipt.NodePubKey, confirmed[1].Receipt.NodePubKey) {
+		t.Fatalf("got %d confirmations, want 2 from distinct keys", len(confirmed))
+	}
+
+	// A second address for peer 0 is the same node and cannot make up a quorum of 4
+	node.peers["peer-0-alias:9000"] = &peerState{state: PeerConnected, client: node.peers["peer-0:9000"].client, identityKey: peers[0].PublicKey()}
+	if _, err := node.ConfirmTransaction(context.Background(), tx, 4); !errors.Is(err, ErrQuorumUnreachable) {
+		t.Fatalf("quorum of 4 from 3 identities: got %v, want ErrQuorumUnreachable", err)
+	}
+}
+
+func TestConfirmTransactionBindsReceiptsToHandshakeKey(t *testing.T) {
+	node := newTestNode(t)
+	peers := addConfirmingPeers(t, node, 2)
+	// Peer 1 claims peer 0's identity but signs receipts with its own key
+	node.peers["peer-1:9000"].identityKey = peers[0].PublicKey()
+	node.peers["peer-0:9000"].state = PeerFailed
+	tx := testVote("e", "a", 1)
+	tx.Timestamp = uint64(time.Now().Unix())
+	signTestTx(tx, testVoterKey(1))
+
+	if _, err := node.ConfirmTransaction(context.Background(), tx, 1); !errors.Is(err, ErrQuorumUnreachable) {
+		t.Fatalf("receipt signed by another key: got %v, want ErrQuorumUnreachable", err)
+	}
+}
+
+func TestSubmitVoteQuorumFailureKeepsVotePending(t *testing.T) {
+	node := newTestNode(t)
+	voter := testVoterID(t)
+	vote := map[string]string{"voter_id": voter, "election_id": "e1", "candidate": "candidate-a"}
+	submit := func(w http.ResponseWriter, r *http.Request) { SubmitVote(node, w, r) }
+	if rr := postJSON(t, submit, "/vote?quorum=1", signBallot(t, node, vote)); rr.Code == http.StatusOK || errorCode(t, rr) != ErrCodeQuorumNotReached {
+		t.Fatalf("vote with no peers: status %d, body %s", rr.Code, rr.Body)
+	}
+	if node.Mempool.Len() != 1 {
+		t.Fatalf("%d transactions pending after the quorum failed, want the vote", node.Mempool.Len())
+	}
+	vote["candidate"] = "candidate-b"
+	if rr := postJSON(t, submit, "/vote", signBallot(t, node, vote)); errorCode(t, rr) != ErrCodeAlreadyVoted {
+		t.Errorf("second vote after the quorum failed: status %d, body %s", rr.Code, rr.Body)
+	}
+}
+
+func TestSubmitVoteRefusesVoterCountedOnChain(t *testing.T) {
+	node := newTestNode(t)
+	voter := testVoterID(t)
+	v, _ := testVoters.Load(voter)
+	counted := testVote("e1", "candidate-a", 1)
+	counted.Sender, _ = hex.DecodeString(voter)
+	signTestTx(counted, v.(testVoter).priv)
+	if err := node.Chain.AppendBlock(testBlock(node.Chain.Tip(), counted)); err != nil {
+		t.Fatal(err)
+	}
+
+	// This node's double-vote set never saw the vote, but the chain counted it
+	vote := map[string]string{"voter_id": voter, "election_id": "e1", "candidate": "candidate-b"}
+	submit := func(w http.ResponseWriter, r *http.Request) { SubmitVote(node, w, r) }
+	if rr := postJSON(t, submit, "/vote", signBallot(t, node, vote)); rr.Code != http.StatusConflict || errorCode(t, rr) != ErrCodeAlreadyVoted {
+		t.Errorf("vote from a voter already on chain: status %d, body %s", rr.Code, rr.Body)
+	}
+	if node.Mempool.Len() != 0 {
+		t.Errorf("%d transactions pending, want none", node.Mempool.Len())
+	}
+}
+
+// signedVote returns a vote for candidate "a" in election "e" signed by a
+// fresh key.
+func signedVote(tb testing.TB) *Transaction {
+	tb.Helper()
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tx := &Transaction{
+		Sender:    pub,
+		Recipient: []byte("a"),
+		Amount:    VoteAmount,
+		Timestamp: uint64(time.Now().Unix()),
+		ChainId:   DefaultChainID,
+		Payload:   []byte("e"),
+	}
+	tx.Hash = testHash(tx.SigningBytes())
+	tx.Signature = ed25519.Sign(priv, tx.SigningBytes())
+	return tx
+}
+
+// secp256k1Vote returns a vote signed the way a hardware wallet would.
+func secp256k1Vote(t *testing.T) *Transaction {
+	t.Helper()
+	priv, err := secp256k1.GeneratePrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx := &Transaction{
+		Sender:    priv.PubKey().SerializeCompressed(),
+		Recipient: []byte("a"),
+		Amount:    VoteAmount,
+		Timestamp: uint64(time.Now().Unix()),
+		ChainId:   DefaultChainID,
+		Payload:   []byte("e"),
+		SigScheme: SigSchemeSecp256k1,
+	}
+	tx.Hash = testHash(tx.SigningBytes())
+	digest := sha256.Sum256(tx.SigningBytes())
+	sig := ecdsa.Sign(priv, digest[:])
+	r, s := sig.R(), sig.S()
+	rb, sb := r.Bytes(), s.Bytes()
+	tx.Signature = append(rb[:], sb[:]...)
+	return tx
+}
+
+// signedVotes returns n signed votes, every third with a corrupted signature.
+func signedVotes(tb testing.TB, n int) []*Transaction {
+	txs := make([]*Transaction, n)
+	for i := range txs {
+		txs[i] = signedVote(tb)
+		if i%3 == 2 {
+			txs[i].Signature[0] ^= 0xff
+		}
+	}
+	return txs
+}
+
+func TestVerifyTransactionsMatchesSerial(t *testing.T) {
+	txs := signedVotes(t, 64)
+	serial := VerifyTransactions(SHA3Hasher{}, txs, 1)
+	parallel := VerifyTransactions(SHA3Hasher{}, txs, 8)
+	for i := range txs {
+		if !errors.Is(parallel[i], serial[i]) {
+			t.Errorf("transaction %d: parallel got %v, serial got %v", i, parallel[i], serial[i])
+		}
+		if want := i%3 == 2; (serial[i] != nil) != want {
+			t.Errorf("transaction %d: got %v, want failure %v", i, serial[i], want)
+		}
+	}
+}
+
+func TestVerifyTransactionBySigScheme(t *testing.T) {
+	relabel := func(tx *Transaction, scheme SigScheme) *Transaction {
+		tx.SigScheme = scheme
+		tx.Hash = testHash(tx.SigningBytes())
+		return tx
+	}
+	forged := secp256k1Vote(t)
+	forged.Signature[40] ^= 0xff
+	for name, tc := range map[string]struct {
+		tx   *Transaction
+		want error
+	}{
+		"ed25519":                  {signedVote(t), nil},
+		"secp256k1":                {secp256k1Vote(t), nil},
+		"secp256k1 bad signature":  {forged, ErrBadSignature},
+		"ed25519 key as secp256k1": {relabel(signedVote(t), SigSchemeSecp256k1), ErrMalformedTx},
+		"secp256k1 key as ed25519": {relabel(secp256k1Vote(t), SigSchemeEd25519), ErrMalformedTx},
+		"unknown scheme":           {relabel(signedVote(t), 7), ErrMalformedTx},
+	} {
+		if err := VerifyTransaction(SHA3Hasher{}, tc.tx); !errors.Is(err, tc.want) {
+			t.Errorf("%s: got %v, want %v", name, err, tc.want)
+		}
+	}
+}
+
+func BenchmarkVerifyTransactions(b *testing.B) {
+	txs := signedVotes(b, MaxTxBatch)
+	workers := []int{1}
+	if n := runtime.NumCPU(); n > 1 {
+		workers = append(workers, n)
+	}
+	for _, workers := range workers {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			for b.Loop() {
+				VerifyTransactions(SHA3Hasher{}, txs, workers)
+			}
+		})
+	}
+}
+
+// postBatch submits txs to /tx/batch on node.
+func postBatch(t *testing.T, node *P2PNode, txs []*Transaction) *httptest.ResponseRecorder {
+	t.Helper()
+	var encoded []string
+	for _, tx := range txs {
+		encoded = append(encoded, hex.EncodeToString(tx.MarshalProto()))
+	}
+	return postJSON(t, func(w http.ResponseWriter, r *http.Request) { SubmitRawTransactions(node, w, r) }, "/tx/batch", map[string]interface{}{"txs": encoded, "encoding": TxEncodingHex})
+}
+
+// postRawTx submits the encoded transaction to /tx on node.
+func postRawTx(t *testing.T, node *P2PNode, encoded, encoding string) *httptest.ResponseRecorder {
+	t.Helper()
+	return postJSON(t, func(w http.ResponseWriter, r *http.Request) { SubmitRawTransaction(node, w, r) }, "/tx", map[string]string{"tx": encoded, "encoding": encoding})
+}
+
+func TestSubmitRawTransaction(t *testing.T) {
+	node := newTestNode(t)
+	hexTx, b64Tx := signedVote(t), signedVote(t)
+	if rr := postRawTx(t, node, hex.EncodeToString(hexTx.MarshalProto()), TxEncodingHex); rr.Code != http.StatusAccepted {
+		t.Fatalf("hex transaction: status %d, body %s", rr.Code, rr.Body)
+	}
+	if rr := postRawTx(t, node, base64.StdEncoding.EncodeToString(b64Tx.MarshalProto()), TxEncodingBase64); rr.Code != http.StatusAccepted {
+		t.Fatalf("base64 transaction: status %d, body %s", rr.Code, rr.Body)
+	}
+	if node.Mempool.Len() != 2 {
+		t.Errorf("mempool holds %d transactions, want 2", node.Mempool.Len())
+	}
+
+	forged := signedVote(t)
+	forged.Signature[0] ^= 0xff
+	raw := hex.EncodeToString(signedVote(t).MarshalProto())
+	for _, tc := range []struct {
+		name, tx, encoding, code string
+	}{
+		{"bad signature", hex.EncodeToString(forged.MarshalProto()), TxEncodingHex, ErrCodeInvalidSignature},
+		{"malformed", hex.EncodeToString([]byte{0xff, 0xff}), TxEncodingHex, ErrCodeInvalidRequest},
+		{"no encoding", raw, "", ErrCodeInvalidRequest},
+		{"wrong encoding", raw, TxEncodingBase64, ErrCodeInvalidRequest},
+	} {
+		if rr := postRawTx(t, node, tc.tx, tc.encoding); rr.Code != http.StatusBadRequest || errorCode(t, rr) != tc.code {
+			t.Errorf("%s: status %d, body %s; want 400 %s", tc.name, rr.Code, rr.Body, tc.code)
+		}
+	}
+	if node.Mempool.Len() != 2 {
+		t.Errorf("mempool holds %d transactions after refused ones, want 2", node.Mempool.Len())
+	}
+}
+
+func TestVotesMustHaveUnitAmount(t *testing.T) {
+	node := newTestNode(t)
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx := signedVote(t)
+	tx.Sender, tx.Amount = pub, 1000000
+	tx.Hash = testHash(tx.SigningBytes())
+	tx.Signature = ed25519.Sign(priv, tx.SigningBytes())
+
+	if err := VerifyTransaction(SHA3Hasher{}, tx); !errors.Is(err, ErrBadVoteAmount) {
+		t.Errorf("VerifyTransaction: got %v, want ErrBadVoteAmount", err)
+	}
+	if rr := postRawTx(t, node, hex.EncodeToString(tx.MarshalProto()), TxEncodingHex); rr.Code != http.StatusBadRequest {
+		t.Errorf("/tx: status %d, body %s; want 400", rr.Code, rr.Body)
+	}
+	if _, err := node.SendTransaction(inboundCtx("10.0.0.1:5000", nil), &SendTransactionRequest{Transaction: tx}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("SendTransaction: got %v, want InvalidArgument", err)
+	}
+	if node.Mempool.Len() != 0 {
+		t.Errorf("mempool holds %d transactions, want none", node.Mempool.Len())
+	}
+}
+
+func TestSubmitRawTransactionBoundsBody(t *testing.T) {
+	node := newTestNode(t)
+	body := `{"tx": "` + strings.Repeat("0", maxRawTxBodyBytes) + `", "encoding": "hex"}`
+	rr := httptest.NewRecorder()
+	SubmitRawTransaction(node, rr, httptest.NewRequest(http.MethodPost, "/tx", strings.NewReader(body)))
+	if rr.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("oversized body: status %d, want 413", rr.Code)
+	}
+}
+
+func TestSubmitRawTransactionsIsAllOrNothing(t *testing.T) {
+	node := newTestNode(t)
+	node.Mempool = NewMempool(3)
+	first := []*Transaction{signedVote(t), signedVote(t)}
+	if rr := postBatch(t, node, first); rr.Code != http.StatusAccepted {
+		t.Fatalf("first batch: status %d, body %s", rr.Code, rr.Body)
+	}
+
+	// Two new transactions do not fit in the one free slot, and the known one
+	// does not count against it
+	second := []*Transaction{first[0], signedVote(t), signedVote(t)}
+	if rr := postBatch(t, node, second); rr.Code != http.StatusServiceUnavailable || errorCode(t, rr) != ErrCodeMempoolFull {
+		t.Fatalf("overfull batch: status %d, body %s", rr.Code, rr.Body)
+	}
+	if n := node.Mempool.Len(); n != 2 {
+		t.Errorf("mempool holds %d transactions after a refused batch, want 2", n)
+	}
+	if rr := postBatch(t, node, second[:2]); rr.Code != http.StatusAccepted {
+		t.Fatalf("batch that fits: status %d, body %s", rr.Code, rr.Body)
+	}
+
+	bad := []*Transaction{signedVote(t), signedVotes(t, 3)[2]}
+	node.Mempool = NewMempool(10)
+	if rr := postBatch(t, node, bad); rr.Code != http.StatusBadRequest || node.Mempool.Len() != 0 {
+		t.Errorf("batch with a bad signature: status %d, %d accepted", rr.Code, node.Mempool.Len())
+	}
+}
+
+func TestSubmitRawTransactionsBoundsBody(t *testing.T) {
+	node := newTestNode(t)
+	body := `{"txs": ["` + strings.Repeat("0", maxTxBatchBodyBytes) + `"]}`
+	rr := httptest.NewRecorder()
+	SubmitRawTransactions(node, rr, httptest.NewRequest(http.MethodPost, "/tx/batch", strings.NewReader(body)))
+	if rr.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("oversized body: status %d, want 413", rr.Code)
+	}
+}
+
+// inboundCtx returns the context of an RPC from addr, proving the identity
+// key of cert if it is not nil.
+func inboundCtx(addr string, cert *x509.Certificate) context.Context {
+	tcp, _ := net.ResolveTCPAddr("tcp", addr)
+	p := &peer.Peer{Addr: tcp}
+	if cert != nil {
+		p.AuthInfo = credentials.TLSInfo{State: tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}}
+	}
+	return peer.NewContext(context.Background(), p)
+}
+
+func TestRateLimitPenalizesTheSendingHost(t *testing.T) {
+	node := newTestNode(t)
+	node.PeerLimiter = NewRateLimiter(1, time.Hour)
+	node.peers["10.0.0.1:9000"] = &peerState{state: PeerConnected, client: &mockNodeServiceClient{}}
+	node.peers["10.0.0.3:9000"] = &peerState{state: PeerConnected, client: &mockNodeServiceClient{}}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) { return "ok", nil }
+	info := &grpc.UnaryServerInfo{FullMethod: "/NodeService/SendTransaction"}
+
+	flooder := inboundCtx("10.0.0.1:51000", nil)
+	if _, err := node.rateLimitInterceptor(flooder, nil, info, handler); err != nil {
+		t.Fatalf("first request: %v", err)
+	}
+	if _, err := node.rateLimitInterceptor(flooder, nil, info, handler); status.Code(err) != codes.ResourceExhausted {
+		t.Fatalf("request over the limit: got %v, want ResourceExhausted", err)
+	}
+	if got := node.PeerScore("10.0.0.1"); got != -RateLimitPenalty {
+		t.Errorf("flooder's host scored %d, want %d", got, -RateLimitPenalty)
+	}
+	if got := node.PeerScore("10.0.0.3:9000"); got != 0 {
+		t.Errorf("peer on another host scored %d, want 0", got)
+	}
+
+	for node.PeerScore("10.0.0.1") > BanScore+RateLimitPenalty {
+		node.PenalizePeer("10.0.0.1", RateLimitPenalty)
+	}
+	node.PenalizePeer("10.0.0.1", RateLimitPenalty)
+	if _, err := node.rateLimitInterceptor(flooder, nil, info, handler); status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("banned peer: got %v, want PermissionDenied", err)
+	}
+	// Reconnecting from a new source port does not shed the ban
+	if _, err := node.rateLimitInterceptor(inboundCtx("10.0.0.1:51001", nil), nil, info, handler); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("banned peer on a new port: got %v, want PermissionDenied", err)
+	}
+	if _, err := node.rateLimitInterceptor(inboundCtx("10.0.0.3:51000", nil), nil, info, handler); err != nil {
+		t.Errorf("peer on another host refused: %v", err)
+	}
+	if node.PeerStates()["10.0.0.3:9000"] != PeerConnected {
+		t.Error("banning the flooder banned a peer on another host")
+	}
+	if err := node.UnbanPeer("10.0.0.1"); err != nil || node.peerBanned("10.0.0.1", time.Now()) {
+		t.Errorf("unbanning the flooder: %v", err)
+	}
+}
+
+func TestPenaltiesFollowTLSIdentity(t *testing.T) {
+	node := newTestNode(t)
+	pub, priv, _ := ed25519.GenerateKey(nil)
+	cert, err := NewValidatorCertificate(priv, "127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	node.peers["10.0.0.2:9000"] = &peerState{state: PeerConnected, client: &mockNodeServiceClient{}, identityKey: pub}
+
+	// The validator's inbound connection comes from an ephemeral port, but its
+	// certificate identifies it as the known peer
+	key, ok := remotePeer(inboundCtx("10.0.0.2:52000", leaf))
+	if !ok || key != identityPeerKey(pub) {
+		t.Fatalf("remote peer key %q, want the certificate identity", key)
+	}
+	node.PenalizePeer(key, -BanScore)
+	if got := node.PeerStates()["10.0.0.2:9000"]; got != PeerBanned {
+		t.Errorf("known peer with the penalized identity is %s, want banned", got)
+	}
+	if !node.peerBanned(key, time.Now()) {
+		t.Error("identity not banned after reaching BanScore")
+	}
+}
+
+// spoofingClient is a peer that claims a node ID without proving a key.
+type spoofingClient struct{ mockNodeServiceClient }
+
+func (c *spoofingClient) Handshake(ctx context.Context, in *HandshakeRequest, opts ...grpc.CallOption) (*HandshakeResponse, error) {
+	resp, err := c.mockNodeServiceClient.Handshake(ctx, in, opts...)
+	resp.NodeId = "spoofed"
+	return resp, err
+}
+
+// fixedTransport dials every address to the same client.
+type fixedTransport struct {
+	Transport
+	client NodeServiceClient
+}
+
+func (t fixedTransport) Dial(ctx context.Context, addr string) (NodeServiceClient, error) {
+	return t.client, nil
+}
+
+// servePeer starts node serving addr on network and returns once it is reachable.
+func servePeer(t *testing.T, network *MemoryNetwork, node *P2PNode, addr string) {
+	t.Helper()
+	node.Transport = network.NewTransport()
+	go node.Transport.Serve(node, addr)
+	t.Cleanup(node.Transport.Stop)
+	for {
+		if _, err := network.server(addr); err == nil {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestGetPeerInfoReflectsPeerState(t *testing.T) {
+	network := NewMemoryNetwork()
+	node, remote := newTestNode(t), NewP2PNode("remote:9000")
+	node.Transport = network.NewTransport()
+	servePeer(t, network, remote, "remote:9000")
+	extendChain(t, remote.Chain, 2)
+
+	ctx := context.Background()
+	if err := node.ConnectToPeer(ctx, "remote:9000"); err != nil {
+		t.Fatal(err)
+	}
+	info, _ := node.GetPeerInfo(ctx, &GetPeerInfoRequest{})
+	if len(info.Peers) != 1 {
+		t.Fatalf("got %d peers, want 1", len(info.Peers))
+	}
+	p := info.Peers[0]
+	if p.NodeId != remote.NodeID() || !bytes.Equal(p.IdentityKey, remote.PublicKey()) || p.TipHeight != 2 || p.ProtocolVersion != ProtocolVersion {
+		t.Errorf("peer info %+v does not match the remote node", p)
+	}
+
+	// Sync refreshes the tip as the remote grows while we fetch from it
+	extendChain(t, remote.Chain, 3)
+	if err := node.SyncWithPeer(ctx, "remote:9000"); err != nil {
+		t.Fatal(err)
+	}
+	info, _ = node.GetPeerInfo(ctx, &GetPeerInfoRequest{})
+	if got := info.Peers[0].TipHeight; got != 5 {
+		t.Errorf("peer tip %d after sync, want 5", got)
+	}
+}
+
+func TestUnprovenNodeIDIsNotRecorded(t *testing.T) {
+	node := newTestNode(t)
+	node.Transport = fixedTransport{client: &spoofingClient{}}
+	if err := node.ConnectToPeer(context.Background(), "spoofer:9000"); err != nil {
+		t.Fatal(err)
+	}
+	info, _ := node.GetPeerInfo(context.Background(), &GetPeerInfoRequest{})
+	if len(info.Peers) != 1 || info.Peers[0].NodeId != "" || info.Peers[0].IdentityKey != nil {
+		t.Errorf("peer without an identity key reported as %+v, want no node ID", info.Peers[0])
+	}
+}
+
+// banningClient is a peer that gets banned while its handshake is in flight.
+type banningClient struct {
+	mockNodeServiceClient
+	node   *P2PNode
+	addr   string
+	closed bool
+}
+
+func (c *banningClient) Handshake(ctx context.Context, in *HandshakeRequest, opts ...grpc.CallOption) (*HandshakeResponse, error) {
+	if err := c.node.BanPeer(c.addr); err != nil {
+		return nil, err
+	}
+	return c.mockNodeServiceClient.Handshake(ctx, in, opts...)
+}
+
+func (c *banningClient) Close() error {
+	c.closed = true
+	return nil
+}
+
+func TestPeerBannedDuringHandshakeIsClosed(t *testing.T) {
+	node := newTestNode(t)
+	client := &banningClient{node: node, addr: "banned:9000"}
+	node.Transport = fixedTransport{client: client}
+	if err := node.ConnectToPeer(context.Background(), "banned:9000"); err == nil {
+		t.Fatal("connected to a peer banned during its handshake")
+	}
+	if !client.closed {
+		t.Error("client of a peer banned during its handshake was not closed")
+	}
+}
+
+// skewedClient is a peer whose clock runs skew ahead of ours.
+type skewedClient struct {
+	mockNodeServiceClient
+	skew time.Duration
+}
+
+func (c *skewedClient) Handshake(ctx context.Context, in *HandshakeRequest, opts ...grpc.CallOption) (*HandshakeResponse, error) {
+	resp, err := c.mockNodeServiceClient.Handshake(ctx, in, opts...)
+	resp.Timestamp = time.Now().Add(c.skew).UnixMilli()
+	return resp, err
+}
+
+func TestSkewedPeerIsReportedOrRefused(t *testing.T) {
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	node := newTestNode(t)
+	node.Transport = fixedTransport{client: &skewedClient{skew: time.Minute}}
+	if err := node.ConnectToPeer(context.Background(), "skewed:9000"); err != nil {
+		t.Fatalf("skewed peer refused without RefuseSkewedPeers: %v", err)
+	}
+	if !strings.Contains(logs.String(), "skewed:9000 clock is") {
+		t.Errorf("no warning about the skewed peer in the log:\n%s", logs.String())
+	}
+	if skew := node.PeerClockSkews()["skewed:9000"]; (skew - time.Minute).Abs() > time.Second {
+		t.Errorf("measured skew %s, want about a minute", skew)
+	}
+	rr := httptest.NewRecorder()
+	NewAPIHandler(node).ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/status?election_id=e", nil))
+	var resp ElectionStatus
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if ms := resp.PeerClockSkewMs["skewed:9000"]; (time.Duration(ms)*time.Millisecond - time.Minute).Abs() > time.Second {
+		t.Errorf("/status reports a skew of %dms, want about 60000", ms)
+	}
+
+	node = newTestNode(t)
+	node.RefuseSkewedPeers = true
+	node.Transport = fixedTransport{client: &skewedClient{skew: -time.Minute}}
+	if err := node.ConnectToPeer(context.Background(), "skewed:9000"); err == nil {
+		t.Error("skewed peer accepted with RefuseSkewedPeers")
+	}
+	node.Transport = fixedTransport{client: &skewedClient{skew: time.Second}}
+	if err := node.ConnectToPeer(context.Background(), "close:9000"); err != nil {
+		t.Errorf("peer within MaxPeerClockSkew refused: %v", err)
+	}
+}
+
+// versionedClient answers handshakes as a peer speaking protocol version.
+type versionedClient struct {
+	mockNodeServiceClient
+	version string
+}
+
+func (c *versionedClient) Handshake(ctx context.Context, in *HandshakeRequest, opts ...grpc.CallOption) (*HandshakeResponse, error) {
+	resp, err := c.mockNodeServiceClient.Handshake(ctx, in, opts...)
+	resp.ProtocolVersion = c.version
+	return resp, err
+}
+
+func TestIncompatibleProtocolVersionsRefuseToPeer(t *testing.T) {
+	for _, tc := range []struct {
+		version string
+		ok      bool
+	}{
+		{ProtocolVersion, true},
+		{"1.7", true}, // Minor versions only add fields
+		{"", true},    // Predates versioning, so 1.0
+		{"2.0", false},
+		{"0.9", false},
+		{"one", false},
+	} {
+		node := newTestNode(t)
+		node.Transport = fixedTransport{client: &versionedClient{version: tc.version}}
+		if err := node.ConnectToPeer(context.Background(), "peer:9000"); (err == nil) != tc.ok {
+			t.Errorf("connecting to a %q peer: got %v, want ok %v", tc.version, err, tc.ok)
+		}
+
+		_, err := node.Handshake(inboundCtx("10.0.0.1:5000", nil), &HandshakeRequest{
+			ChainId:         node.ChainID,
+			GenesisHash:     node.Chain.Genesis().Header.Hash,
+			Timestamp:       time.Now().UnixMilli(),
+			ProtocolVersion: tc.version,
+		})
+		if tc.ok && err != nil {
+			t.Errorf("handshake from a %q peer: %v", tc.version, err)
+		}
+		if !tc.ok && status.Code(err) != codes.FailedPrecondition {
+			t.Errorf("handshake from a %q peer: got %v, want FailedPrecondition", tc.version, err)
+		}
+	}
+
+	rr := httptest.NewRecorder()
+	NewAPIHandler(newTestNode(t)).ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/version", nil))
+	var info VersionInfo
+	if err := json.Unmarshal(rr.Body.Bytes(), &info); err != nil || info.ProtocolVersion != ProtocolVersion || info.Version != NodeVersion {
+		t.Errorf("/version: %s", rr.Body)
+	}
+}
+
+// handshakeRecorder records the address each handshake announces.
+type handshakeRecorder struct {
+	mockNodeServiceClient
+	addrs []string
+}
+
+func (c *handshakeRecorder) Handshake(ctx context.Context, in *HandshakeRequest, opts ...grpc.CallOption) (*HandshakeResponse, error) {
+	c.addrs = append(c.addrs, in.GetAddr())
+	return c.mockNodeServiceClient.Handshake(ctx, in, opts...)
+}
+
+func TestPeersLearnTheAdvertisedAddress(t *testing.T) {
+	node := newTestNode(t)
+	node.Addr, node.AdvertiseAddr = "0.0.0.0:50051", "node.example:50051"
+	peer := &handshakeRecorder{}
+	node.Transport = fixedTransport{client: peer}
+	if err := node.ConnectToPeer(context.Background(), "peer:9000"); err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(peer.addrs, []string{"node.example:50051"}) {
+		t.Errorf("handshake announced %q, want the advertised address", peer.addrs)
+	}
+	resp, err := node.GetKnownPeers(context.Background(), &GetKnownPeersRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.PeerAddresses) == 0 || resp.PeerAddresses[0] != "node.example:50051" || slices.Contains(resp.PeerAddresses, node.Addr) {
+		t.Errorf("GetKnownPeers shares %q, want the advertised address first and never the bind address", resp.PeerAddresses)
+	}
+	if !node.isSelf("node.example:50051") || !node.isSelf("0.0.0.0:50051") {
+		t.Error("node does not recognise its own addresses")
+	}
+
+	for addr, ok := range map[string]bool{
+		"node.example:50051": true,
+		"10.0.0.1:50051":     true,
+		"0.0.0.0:50051":      false,
+		"[::]:50051":         false,
+		":50051":             false,
+		"node.example:0":     false,
+		"node.example":       false,
+	} {
+		if err := validateAdvertiseAddr(addr); (err == nil) != ok {
+			t.Errorf("validateAdvertiseAddr(%q) = %v, want valid %v", addr, err, ok)
+		}
+	}
+	node.AdvertiseAddr = ""
+	if err := node.StartGRPCServer(); err == nil {
+		t.Error("started advertising the wildcard bind address")
+	}
+}
+
+// knownPeers adds each address to node's address book with the given number
+// of past successful connections.
+func knownPeers(node *P2PNode, successes map[string]int) {
+	node.mu.Lock()
+	defer node.mu.Unlock()
+	for addr, n := range successes {
+		node.addKnownNode(addr)
+		node.peers[addr].successes = n
+	}
+}
+
+func TestReloadedPeersReconnectBestFirst(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "peers.json")
+	old := newTestNode(t)
+	knownPeers(old, map[string]int{"good:9000": 5, "unknown:9000": 0})
+	if err := old.SavePeers(path); err != nil {
+		t.Fatal(err)
+	}
+
+	network := NewMemoryNetwork()
+	node := newTestNode(t)
+	node.Transport = network.NewTransport()
+	servePeer(t, network, NewP2PNode("good:9000"), "good:9000")
+	servePeer(t, network, NewP2PNode("unknown:9000"), "unknown:9000")
+	if err := node.LoadPeers(path); err != nil {
+		t.Fatal(err)
+	}
+	node.mu.RLock()
+	order := node.reconnectOrder(false)
+	node.mu.RUnlock()
+	if want := []string{"good:9000", "unknown:9000"}; !slices.Equal(order, want) {
+		t.Errorf("reconnect order %q after reload, want %q", order, want)
+	}
+	node.connectKnownPeers()
+	if _, ok := node.Peer("good:9000"); !ok || node.PeerCount() != 1 {
+		t.Errorf("connected to %d peers, want only the peer with a good history", node.PeerCount())
+	}
+}
+
+func TestReconnectStopsAtTargetPeers(t *testing.T) {
+	network := NewMemoryNetwork()
+	node := newTestNode(t)
+	node.Transport = network.NewTransport()
+	node.TargetPeers, node.ReconnectWorkers = 2, 1
+	successes := make(map[string]int)
+	for i := 1; i <= 4; i++ {
+		addr := fmt.Sprintf("saved-%d:9000", i)
+		servePeer(t, network, NewP2PNode(addr), addr)
+		successes[addr] = 10 - i
+	}
+	knownPeers(node, successes)
+
+	node.connectKnownPeers()
+	if got := node.PeerCount(); got != node.TargetPeers {
+		t.Fatalf("connected to %d saved peers, want the target of %d", got, node.TargetPeers)
+	}
+	for _, addr := range []string{"saved-1:9000", "saved-2:9000"} {
+		if _, ok := node.Peer(addr); !ok {
+			t.Errorf("best saved peer %s not connected", addr)
+		}
+	}
+}
+
+func TestGetKnownPeersSharesOnlyHealthyPeers(t *testing.T) {
+	node := newTestNode(t)
+	knownPeers(node, map[string]int{"live:9000": 1, "silent:9000": 1, "dropped:9000": 1, "never:9000": 0})
+	node.mu.Lock()
+	for addr, seen := range map[string]time.Time{
+		"live:9000":    time.Now(),
+		"silent:9000":  time.Now().Add(-2 * PeerHealthyWindow),
+		"dropped:9000": time.Now(),
+	} {
+		node.peers[addr].state, node.peers[addr].lastContact = PeerConnected, seen
+	}
+	node.mu.Unlock()
+	node.removePeer("dropped:9000")
+
+	resp, err := node.GetKnownPeers(context.Background(), &GetKnownPeersRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{node.AdvertisedAddr(), "live:9000"}; !slices.Equal(resp.PeerAddresses, want) {
+		t.Errorf("shared %q, want only ourselves and the live peer %q", resp.PeerAddresses, want)
+	}
+}
+
+func TestFreshPeerAddressesClampsFutureContact(t *testing.T) {
+	now := time.Now()
+	resp := &GetKnownPeersResponse{Peers: []*PeerAddress{
+		{Addr: "honest:9000", LastSeen: uint64(now.Unix())},
+		{Addr: "boastful:9000", LastSeen: uint64(now.Add(time.Hour).Unix())},
+		{Addr: "stale:9000", LastSeen: uint64(now.Add(-2 * PeerHealthyWindow).Unix())},
+	}}
+	if got, want := freshPeerAddresses(resp, now), []string{"honest:9000", "boastful:9000"}; !slices.Equal(got, want) {
+		t.Errorf("addresses %q, want %q with future contact counted as now", got, want)
+	}
+}
+
+func TestSeedReachableSoonAfterStartup(t *testing.T) {
+	network := NewMemoryNetwork()
+	node, seed := newTestNode(t), NewP2PNode("seed:9000")
+	node.Transport, seed.Transport = network.NewTransport(), network.NewTransport()
+	t.Cleanup(seed.Transport.Stop)
+	time.AfterFunc(100*time.Millisecond, func() { seed.Transport.Serve(seed, "seed:9000") })
+
+	start := time.Now()
+	node.connectToSeeds([]string{"seed:9000"})
+	if elapsed := time.Since(start); elapsed > 4*BootstrapInitialBackoff {
+		t.Errorf("connected after %s, want within the first retries", elapsed)
+	}
+	if _, ok := node.Peer("seed:9000"); !ok {
+		t.Error("not connected to the seed")
+	}
+}
+
+func TestConnectToSeedsStops(t *testing.T) {
+	node := newTestNode(t)
+	node.Transport = NewMemoryNetwork().NewTransport()
+	done := make(chan struct{})
+	go func() {
+		node.connectToSeeds([]string{"unreachable:9000"})
+		close(done)
+	}()
+	time.Sleep(BootstrapInitialBackoff / 2)
+	if err := node.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("seed retries continued after Close")
+	}
+
+	// A node whose only seed is itself has nothing to wait for
+	node = newTestNode(t)
+	node.connectToSeeds([]string{node.Addr})
+}
+
+func TestLoadIdentityKeyGeneratesOnceAndReuses(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys", "identity.key")
+	first, err := LoadIdentityKey(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0600 {
+		t.Errorf("key file mode %v, want 0600", perm)
+	}
+	if _, err := os.Stat(path + ".tmp"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("temporary file left behind: %v", err)
+	}
+
+	second, err := LoadIdentityKey(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !first.Equal(second) {
+		t.Error("second start generated a new key instead of loading the saved one")
+	}
+}
+
+func TestNodeIDFollowsIdentityKey(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "identity.key")
+	var ids []string
+	for restart := 0; restart < 2; restart++ {
+		key, err := LoadIdentityKey(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		node := NewP2PNode("127.0.0.1:0")
+		node.UseIdentityKey(key)
+		ids = append(ids, node.NodeID())
+	}
+	if ids[0] != ids[1] {
+		t.Errorf("node ID changed across restarts: %s, then %s", ids[0], ids[1])
+	}
+	if len(ids[0]) != 2*NodeIDLen {
+		t.Errorf("node ID %q is %d characters, want %d", ids[0], len(ids[0]), 2*NodeIDLen)
+	}
+	if other := newTestNode(t).NodeID(); other == ids[0] {
+		t.Errorf("a different identity key gave the same node ID %s", other)
+	}
+}
+
+func TestSeededNodesGossipToTheSamePeers(t *testing.T) {
+	rounds := func(seed int64) [][]string {
+		node := newTestNode(t)
+		node.UseRandSource(mrand.NewSource(seed))
+		for i := 0; i < 20; i++ {
+			node.peers[fmt.Sprintf("10.0.0.%d:9000", i)] = &peerState{state: PeerConnected, client: &slowPeer{}}
+		}
+		var out [][]string
+		node.mu.Lock()
+		defer node.mu.Unlock()
+		for range 5 {
+			targets := slices.Sorted(maps.Keys(node.gossipTargets()))
+			out = append(out, targets)
+		}
+		return out
+	}
+
+	first, second := rounds(42), rounds(42)
+	if !slices.EqualFunc(first, second, slices.Equal) {
+		t.Errorf("nodes with the same seed gossiped to %v and %v", first, second)
+	}
+	if other := rounds(43); slices.EqualFunc(first, other, slices.Equal) {
+		t.Error("nodes with different seeds made the same choices in every round")
+	}
+}
+
+func TestSlowPeerDropsAreCountedPerPeerAndForgotten(t *testing.T) {
+	node := newTestNode(t)
+	node.BroadcastQueueLen = 2
+	const slow, fast = "drops-slow:9000", "drops-fast:9000"
+	total := broadcastDropsTotal.Value()
+	started, release := make(chan struct{}), make(chan struct{})
+	node.enqueueSend(slow, false, func() {
+		close(started)
+		<-release
+	})
+	<-started
+	for i := 0; i < 4; i++ {
+		node.enqueueSend(slow, false, func() {})
+		sent := make(chan struct{})
+		node.enqueueSend(fast, false, func() { close(sent) })
+		<-sent
+	}
+	if v, ok := broadcastDrops.Get(slow).(*expvar.Int); !ok || v.Value() != 2 {
+		t.Errorf("slow peer drops %v, want 2", broadcastDrops.Get(slow))
+	}
+	if v := broadcastDrops.Get(fast); v != nil {
+		t.Errorf("fast peer drops %v, want none", v)
+	}
+	if got := broadcastDropsTotal.Value() - total; got != 2 {
+		t.Errorf("p2p_broadcast_drops_total rose by %d, want 2", got)
+	}
+	close(release)
+	node.broadcasts.Wait()
+
+	node.mu.Lock()
+	node.addKnownNode(slow)
+	node.evictKnownNodes(1)
+	node.mu.Unlock()
+	if v := broadcastDrops.Get(slow); v != nil {
+		t.Errorf("forgotten peer still has a drop count of %v", v)
+	}
+}
+
+func TestSendTransactionEnforcesTimestampWindow(t *testing.T) {
+	node := newTestNode(t)
+	now := time.Unix(1_700_000_000, 0)
+	node.Chain.Clock = func() time.Time { return now }
+	ctx := inboundCtx("10.0.0.1:5000", nil)
+
+	for i, tc := range []struct {
+		name string
+		ts   time.Time
+		ok   bool
+	}{
+		{"too old", now.Add(-node.MaxTxAge - time.Second), false},
+		{"too far ahead", now.Add(node.MaxTxSkew + time.Second), false},
+		{"slightly old", now.Add(-node.MaxTxAge + time.Second), true},
+		{"within the skew", now.Add(node.MaxTxSkew - time.Second), true},
+	} {
+		tx := testVote("e", "candidate-a", i+1)
+		tx.Timestamp = uint64(tc.ts.Unix())
+		signTestTx(tx, testVoterKey(i+1))
+		_, err := node.SendTransaction(ctx, &SendTransactionRequest{Transaction: tx})
+		if tc.ok && err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+		}
+		if !tc.ok && (status.Code(err) != codes.InvalidArgument || !strings.Contains(err.Error(), ErrStaleTx.Error())) {
+			t.Errorf("%s: got %v, want InvalidArgument for a stale transaction", tc.name, err)
+		}
+		if _, got := node.Mempool.Get(tx.Hash); got != tc.ok {
+			t.Errorf("%s: in mempool %v, want %v", tc.name, got, tc.ok)
+		}
+	}
+}
+
+func TestForgedPeerTransactionsAreRefused(t *testing.T) {
+	node := newTestNode(t)
+	ctx := inboundCtx("10.0.0.1:5000", nil)
+	forge := map[string]func(*Transaction) error{
+		"other signer": func(tx *Transaction) error {
+			tx.Signature = ed25519.Sign(testVoterKey(9), tx.SigningBytes())
+			return ErrBadSignature
+		},
+		"swapped candidate": func(tx *Transaction) error {
+			tx.Recipient = []byte("candidate-b") // Hash and signature still cover candidate-a
+			return ErrBadTxHash
+		},
+	}
+	for name, tamper := range forge {
+		tx := testVote("e", "candidate-a", 1)
+		tx.Timestamp = uint64(time.Now().Unix())
+		signTestTx(tx, testVoterKey(1))
+		want := tamper(tx)
+
+		_, err := node.SendTransaction(ctx, &SendTransactionRequest{Transaction: tx})
+		if status.Code(err) != codes.InvalidArgument || !strings.Contains(err.Error(), want.Error()) {
+			t.Errorf("gossiped %s: got %v, want InvalidArgument for %v", name, err, want)
+		}
+		if _, ok := node.Mempool.Get(tx.Hash); ok {
+			t.Errorf("gossiped %s reached the mempool", name)
+		}
+		if err := node.Chain.AppendBlock(testBlock(node.Chain.Tip(), tx)); !errors.Is(err, want) {
+			t.Errorf("block with %s: got %v, want %v", name, err, want)
+		}
+	}
+}
+
+func TestGossipTargetsSampleSqrtPeers(t *testing.T) {
+	node := newTestNode(t)
+	const peers = 16
+	for i := 0; i < peers; i++ {
+		node.peers[fmt.Sprintf("10.0.0.%d:9000", i)] = &peerState{state: PeerConnected, client: &mockNodeServiceClient{}}
+	}
+
+	reached := make(map[string]bool)
+	for round := 0; round < 50; round++ {
+		node.mu.Lock()
+		targets := node.gossipTargets()
+		node.mu.Unlock()
+		if len(targets) != 4 {
+			t.Fatalf("round %d: %d targets of %d peers, want sqrt(peers) = 4", round, len(targets), peers)
+		}
+		for addr := range targets {
+			reached[addr] = true
+		}
+	}
+	if len(reached) != peers {
+		t.Errorf("%d of %d peers reached over 50 rounds, want all", len(reached), peers)
+	}
+
+	node.GossipFanout = 2
+	node.mu.Lock()
+	targets := node.gossipTargets()
+	node.mu.Unlock()
+	if len(targets) != 8 {
+		t.Errorf("fan-out 2: %d targets, want 8", len(targets))
+	}
+}
+
+func TestSendTransactionReceiptVerifies(t *testing.T) {
+	node := newTestNode(t)
+	tx := testVote("e", "candidate-a", 1)
+	tx.Timestamp = uint64(time.Now().Unix())
+	signTestTx(tx, testVoterKey(1))
+	resp, err := node.SendTransaction(inboundCtx("10.0.0.1:5000", nil), &SendTransactionRequest{Transaction: tx})
+	if err != nil || !resp.Success {
+		t.Fatalf("SendTransaction: %v, %+v", err, resp)
+	}
+	receipt := resp.Receipt
+	if err := VerifyReceipt(receipt, node.PublicKey()); err != nil {
+		t.Fatalf("receipt does not verify: %v", err)
+	}
+	if !bytes.Equal(receipt.TxHash, tx.Hash) {
+		t.Errorf("receipt for %x, want %x", receipt.TxHash, tx.Hash)
+	}
+
+	other := newTestNode(t)
+	if err := VerifyReceipt(receipt, other.PublicKey()); err == nil {
+		t.Error("receipt verified against another node's key")
+	}
+	for name, tamper := range map[string]func(r *TxReceipt){
+		"tx hash":   func(r *TxReceipt) { r.TxHash = bytes.Repeat([]byte{1}, 32) },
+		"timestamp": func(r *TxReceipt) { r.Timestamp++ },
+		"signature": func(r *TxReceipt) { r.Signature = slices.Clone(r.Signature); r.Signature[0] ^= 1 },
+	} {
+		tampered := *receipt
+		tamper(&tampered)
+		if err := VerifyReceipt(&tampered, node.PublicKey()); err == nil {
+			t.Errorf("receipt with a tampered %s verified", name)
+		}
+	}
+	if err := VerifyReceipt(nil, node.PublicKey()); err == nil {
+		t.Error("missing receipt verified")
+	}
+}
+
+func TestRecoveryInterceptorSurvivesPanickingHandler(t *testing.T) {
+	info := &grpc.UnaryServerInfo{FullMethod: "/p2p.P2P/SendBlock"}
+	// The unchecked dereference SendBlock used to do on a headerless block
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return req.(*SendBlockRequest).Block.Header.Height, nil
+	}
+	errorsBefore := func() int64 {
+		if v, ok := grpcErrors.Get(info.FullMethod).(*expvar.Int); ok {
+			return v.Value()
+		}
+		return 0
+	}()
+	panics := grpcPanics.Value()
+
+	_, err := metricsInterceptor(context.Background(), &SendBlockRequest{}, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+		return recoveryInterceptor(ctx, req, info, handler)
+	})
+	if status.Code(err) != codes.Internal {
+		t.Fatalf("got %v, want Internal", err)
+	}
+	if got := grpcPanics.Value() - panics; got != 1 {
+		t.Errorf("grpc_panics rose by %d, want 1", got)
+	}
+	if got := grpcErrors.Get(info.FullMethod).(*expvar.Int).Value() - errorsBefore; got != 1 {
+		t.Errorf("grpc_errors for %s rose by %d, want 1", info.FullMethod, got)
+	}
+}
+
+func TestEmptyPeerRequestsAreRefused(t *testing.T) {
+	node := newTestNode(t)
+	ctx := inboundCtx("10.0.0.1:5000", nil)
+	for name, send := range map[string]func() error{
+		"nil block": func() error {
+			_, err := node.SendBlock(ctx, &SendBlockRequest{})
+			return err
+		},
+		"nil header": func() error {
+			_, err := node.SendBlock(ctx, &SendBlockRequest{Block: &Block{}})
+			return err
+		},
+		"nil transaction": func() error {
+			_, err := node.SendTransaction(ctx, &SendTransactionRequest{})
+			return err
+		},
+	} {
+		if err := send(); status.Code(err) != codes.InvalidArgument {
+			t.Errorf("%s: got %v, want InvalidArgument", name, err)
+		}
+	}
+}
+
+func TestKnownNodesCapEvictsLeastUseful(t *testing.T) {
+	node := newTestNode(t)
+	node.MaxKnownNodes = 4
+	now := time.Now()
+	contacts := map[string]time.Time{
+		"connected:9000": now.Add(-48 * time.Hour), // Oldest contact, but connected now
+		"healthy:9000":   now.Add(-time.Minute),
+		"stale:9000":     now.Add(-24 * time.Hour),
+		"dead:9000":      {},
+	}
+	node.mu.Lock()
+	for addr, seen := range contacts {
+		node.addKnownNode(addr)
+		node.peers[addr].lastContact = seen
+	}
+	node.peers["connected:9000"].state = PeerConnected
+	node.mu.Unlock()
+
+	known := func() []string {
+		node.mu.Lock()
+		defer node.mu.Unlock()
+		return slices.Sorted(maps.Keys(node.peers))
+	}
+	node.mu.Lock()
+	node.addKnownNode("fresh:9000")
+	node.peers["fresh:9000"].lastContact = now
+	node.mu.Unlock()
+	if got, want := known(), []string{"connected:9000", "fresh:9000", "healthy:9000", "stale:9000"}; !slices.Equal(got, want) {
+		t.Fatalf("known %v, want the never-reached address evicted first: %v", got, want)
+	}
+
+	node.mu.Lock()
+	node.addKnownNode("another:9000")
+	node.mu.Unlock()
+	if got, want := known(), []string{"another:9000", "connected:9000", "fresh:9000", "healthy:9000"}; !slices.Equal(got, want) {
+		t.Errorf("known %v, want the longest-unreached address evicted next: %v", got, want)
+	}
+}
+
+func TestPeerStateTransitions(t *testing.T) {
+	node := newTestNode(t)
+	const addr = "transitions:9000"
+	node.mu.Lock()
+	defer node.mu.Unlock()
+	if err := node.transition(addr, PeerConnecting); err == nil {
+		t.Error("transition of an unknown peer succeeded")
+	}
+	node.addKnownNode(addr)
+	ps := node.peers[addr]
+
+	for _, step := range []struct {
+		to PeerState
+		ok bool
+	}{
+		{PeerConnected, false}, // Must connect and handshake first
+		{PeerConnecting, true},
+		{PeerHandshaking, true},
+		{PeerConnecting, false},
+		{PeerFailed, true},
+		{PeerConnecting, true},
+		{PeerHandshaking, true},
+		{PeerConnected, true},
+		{PeerDiscovered, false},
+		{PeerBanned, true},
+		{PeerConnecting, false},
+		{PeerDiscovered, true},
+	} {
+		from := ps.state
+		err := node.transition(addr, step.to)
+		if step.ok && (err != nil || ps.state != step.to) {
+			t.Fatalf("%s to %s: %v, now %s", from, step.to, err, ps.state)
+		}
+		if !step.ok && (err == nil || ps.state != from) {
+			t.Fatalf("illegal %s to %s allowed, now %s", from, step.to, ps.state)
+		}
+	}
+	if ps.failures != 0 || ps.successes != 1 || ps.lastContact.IsZero() {
+		t.Errorf("after one failure then a connection: failures %d, successes %d, last contact %v", ps.failures, ps.successes, ps.lastContact)
+	}
+}
+
+func TestGetBlockByHash(t *testing.T) {
+	node := newTestNode(t)
+	c := newTestChain(t, 0)
+	node.UseChain(c)
+	blocks := extendChain(t, c, 3)
+	api := NewAPIHandler(node)
+	get := func(hash string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		api.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/block/hash/"+hash, nil))
+		return rr
+	}
+
+	want := blocks[1]
+	for _, hash := range []string{hex.EncodeToString(want.Header.Hash), "0x" + hex.EncodeToString(want.Header.Hash)} {
+		rr := get(hash)
+		var info BlockInfo
+		if err := json.Unmarshal(rr.Body.Bytes(), &info); rr.Code != http.StatusOK || err != nil {
+			t.Fatalf("%s: status %d, body %s", hash, rr.Code, rr.Body)
+		}
+		if info.Height != 2 || info.Hash != hex.EncodeToString(want.Header.Hash) ||
+			len(info.Transactions) != 1 || info.Transactions[0].Hash != hex.EncodeToString(want.Transactions[0].Hash) {
+			t.Errorf("%s: got block %+v, want height 2 with its vote", hash, info)
+		}
+	}
+	if rr := get(strings.Repeat("ab", 32)); rr.Code != http.StatusNotFound || errorCode(t, rr) != ErrCodeNotFound {
+		t.Errorf("unknown hash: status %d, body %s", rr.Code, rr.Body)
+	}
+	if rr := get("not-hex"); rr.Code != http.StatusBadRequest || errorCode(t, rr) != ErrCodeInvalidRequest {
+		t.Errorf("malformed hash: status %d, body %s", rr.Code, rr.Body)
+	}
+}
+
+func TestRecoverHTTPKeepsServing(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /boom", func(w http.ResponseWriter, r *http.Request) {
+		var node *P2PNode
+		fmt.Fprint(w, node.Addr)
+	})
+	mux.HandleFunc("GET /ok", func(w http.ResponseWriter, r *http.Request) {})
+	srv := httptest.NewServer(recoverHTTP(mux))
+	defer srv.Close()
+	panics := httpPanics.Value()
+
+	resp, err := http.Get(srv.URL + "/boom")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var body errorResponse
+	err = json.NewDecoder(resp.Body).Decode(&body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusInternalServerError || err != nil || body.Error.Code != ErrCodeInternal {
+		t.Errorf("panicking handler: status %d, error %+v (%v), want 500 %s", resp.StatusCode, body.Error, err, ErrCodeInternal)
+	}
+	if got := httpPanics.Value() - panics; got != 1 {
+		t.Errorf("http_panics rose by %d, want 1", got)
+	}
+
+	resp, err = http.Get(srv.URL + "/ok")
+	if err != nil {
+		t.Fatalf("server down after a panic: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("request after the panic: status %d, want 200", resp.StatusCode)
+	}
+}
+
+func TestTiedLeadersAreReportedIdentically(t *testing.T) {
+	status := func(candidates ...string) ElectionStatus {
+		t.Helper()
+		node := newTestNode(t)
+		c := newTestChain(t, 0)
+		node.UseChain(c)
+		for i, candidate := range candidates {
+			if err := c.AppendBlock(testBlock(c.Tip(), testVote("e", candidate, i+1))); err != nil {
+				t.Fatal(err)
+			}
+		}
+		rr := httptest.NewRecorder()
+		GetElectionStatus(node, rr, httptest.NewRequest(http.MethodGet, "/status?election_id=e", nil))
+		var resp ElectionStatus
+		if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	// Two nodes see the same votes in different orders
+	first, second := status("b", "c", "a", "b", "a"), status("a", "a", "b", "c", "b")
+	for _, resp := range []ElectionStatus{first, second} {
+		if !resp.Tie || !slices.Equal(resp.Leaders, []string{"a", "b"}) {
+			t.Errorf("leaders %v, tie %v; want a and b tied", resp.Leaders, resp.Tie)
+		}
+	}
+	if !slices.Equal(first.Candidates, second.Candidates) {
+		t.Errorf("nodes disagree on the tally: %v and %v", first.Candidates, second.Candidates)
+	}
+
+	if resp := status("b", "a", "b"); resp.Tie || !slices.Equal(resp.Leaders, []string{"b"}) {
+		t.Errorf("leaders %v, tie %v; want b alone", resp.Leaders, resp.Tie)
+	}
+}
+
+func TestPercentagesUseLargestRemainder(t *testing.T) {
+	for _, tc := range []struct {
+		votes     []uint64
+		precision int
+		want      []string
+	}{
+		{[]uint64{1, 1, 1}, 2, []string{"33.34", "33.33", "33.33"}},
+		{[]uint64{1, 1, 1}, 0, []string{"34", "33", "33"}}, // Rounding each would sum to 99
+		{[]uint64{7, 6, 4}, 1, []string{"41.2", "35.3", "23.5"}},
+		{[]uint64{7, 6, 4}, 0, []string{"41", "35", "24"}},      // 23.53 has the largest remainder
+		{[]uint64{2, 1}, 9, []string{"66.666667", "33.333333"}}, // Capped at MaxResultPrecision
+		{[]uint64{0, 0}, 2, []string{"0.00", "0.00"}},
+	} {
+		tallies := make([]CandidateTally, len(tc.votes))
+		for i, votes := range tc.votes {
+			tallies[i] = CandidateTally{Candidate: string(rune('a' + i)), Votes: votes}
+		}
+		setPercentages(tallies, tc.precision)
+		var got []string
+		for _, tally := range tallies {
+			got = append(got, tally.Percent)
+		}
+		if !slices.Equal(got, tc.want) {
+			t.Errorf("votes %v at precision %d: got %v, want %v", tc.votes, tc.precision, got, tc.want)
+		}
+	}
+}
+
+func TestStatusPercentagesExcludeAbstentions(t *testing.T) {
+	node := newTestNode(t)
+	c := newTestChain(t, 0)
+	node.UseChain(c)
+	votes := []*Transaction{testVote("e", "a", 1), testVote("e", "a", 2), testVote("e", "b", 3), testVote("e", "", 4)}
+	votes[3].Recipient, votes[3].BallotType = nil, BallotAbstain
+	signTestTx(votes[3], testVoterKey(4))
+	if err := c.AppendBlock(testBlock(c.Tip(), votes...)); err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	GetElectionStatus(node, rr, httptest.NewRequest(http.MethodGet, "/status?election_id=e", nil))
+	var status ElectionStatus
+	if err := json.Unmarshal(rr.Body.Bytes(), &status); err != nil {
+		t.Fatal(err)
+	}
+	want := []CandidateTally{{Candidate: "a", Votes: 2, Percent: "66.67"}, {Candidate: "b", Votes: 1, Percent: "33.33"}}
+	if !slices.Equal(status.Candidates, want) {
+		t.Errorf("candidates %+v, want %+v", status.Candidates, want)
+	}
+}
+
+func TestReadOnlyHTTPServesOnlyQueries(t *testing.T) {
+	node := newTestNode(t)
+	node.ReadOnlyHTTP = true
+	node.AdminToken = "secret"
+	if err := node.Chain.AppendBlock(testBlock(node.Chain.Tip(), testVote("e", "a", 1))); err != nil {
+		t.Fatal(err)
+	}
+	api := NewAPIHandler(node)
+	vote := `{"voter_id": "` + testVoterID(t) + `", "election_id": "e", "candidate": "a"}`
+
+	for _, tc := range []struct {
+		method, path string
+		status       int
+	}{
+		{http.MethodPost, "/vote", http.StatusNotFound},
+		{http.MethodPost, "/register", http.StatusNotFound},
+		{http.MethodPost, "/tx", http.StatusNotFound},
+		{http.MethodGet, "/admin/mempool", http.StatusNotFound},
+		{http.MethodGet, "/elections", http.StatusNotFound},
+		{http.MethodGet, "/validators", http.StatusNotFound},
+		{http.MethodGet, "/status?election_id=e", http.StatusOK},
+		{http.MethodGet, "/results?election_id=e", http.StatusOK},
+		{http.MethodGet, "/blocks", http.StatusOK},
+		{http.MethodGet, "/version", http.StatusOK},
+		{http.MethodGet, "/health", http.StatusOK},
+	} {
+		req := httptest.NewRequest(tc.method, tc.path, strings.NewReader(vote))
+		req.Header.Set("Authorization", "Bearer secret")
+		rr := httptest.NewRecorder()
+		api.ServeHTTP(rr, req)
+		if rr.Code != tc.status {
+			t.Errorf("%s %s: status %d, want %d", tc.method, tc.path, rr.Code, tc.status)
+		}
+	}
+	if node.Mempool.Len() != 0 {
+		t.Errorf("read-only node accepted %d transactions", node.Mempool.Len())
+	}
+}
+
+func TestBlocksPagesThroughTheChain(t *testing.T) {
+	node := newTestNode(t)
+	for i := 1; i <= 5; i++ {
+		if err := node.Chain.AppendBlock(testBlock(node.Chain.Tip(), testVote("e", "a", i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	api := NewAPIHandler(node)
+	heights := func(path string) []uint64 {
+		rr := httptest.NewRecorder()
+		api.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, path, nil))
+		var resp struct {
+			Height uint64      `json:"height"`
+			Blocks []BlockInfo `json:"blocks"`
+		}
+		if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil || resp.Height != 5 {
+			t.Fatalf("%s: status %d, body %s", path, rr.Code, rr.Body)
+		}
+		var got []uint64
+		for _, blk := range resp.Blocks {
+			got = append(got, blk.Height)
+		}
+		return got
+	}
+
+	for path, want := range map[string][]uint64{
+		"/blocks?limit=2":        {4, 5},
+		"/blocks?from=1&limit=3": {1, 2, 3},
+		"/blocks?from=4":         {4, 5},
+		"/blocks":                {0, 1, 2, 3, 4, 5},
+		"/blocks?from=9":         nil,
+	} {
+		if got := heights(path); !slices.Equal(got, want) {
+			t.Errorf("%s: heights %v, want %v", path, got, want)
+		}
+	}
+	rr := httptest.NewRecorder()
+	api.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/blocks?limit=0", nil))
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("limit=0: status %d, want 400", rr.Code)
+	}
+}
+
+func TestHealthReportsOverload(t *testing.T) {
+	node := newTestNode(t)
+	api := NewAPIHandler(node)
+	health := func() int {
+		rr := httptest.NewRecorder()
+		api.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/health", nil))
+		return rr.Code
+	}
+	if code := health(); code != http.StatusOK {
+		t.Errorf("idle node: status %d, want 200", code)
+	}
+	node.MempoolHighWater = 0 // Any mempool is full
+	if code := health(); code != http.StatusServiceUnavailable {
+		t.Errorf("overloaded node: status %d, want 503", code)
+	}
+}
+
+func TestAdminEndpointsServeOnlyOnAdminListener(t *testing.T) {
+	node := newTestNode(t)
+	node.AdminToken = "secret"
+	node.AdminHTTPAddr = "127.0.0.1:0"
+	public, admin := NewAPIHandler(node), NewAdminHandler(node)
+	get := func(h http.Handler, path string) int {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("Authorization", "Bearer secret")
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	for _, path := range []string{"/admin/mempool", "/debug/tally-digest?election_id=e"} {
+		if code := get(public, path); code != http.StatusNotFound {
+			t.Errorf("public %s: status %d, want 404", path, code)
+		}
+		if code := get(admin, path); code != http.StatusOK {
+			t.Errorf("admin %s: status %d, want 200", path, code)
+		}
+	}
+	if code := get(admin, "/status?election_id=e"); code != http.StatusNotFound {
+		t.Errorf("admin /status: status %d, want 404", code)
+	}
+	if code := get(public, "/status?election_id=e"); code != http.StatusOK {
+		t.Errorf("public /status: status %d, want 200", code)
+	}
+}
+
+func TestOversizedBlocksAreRefusedEarly(t *testing.T) {
+	node := newTestNode(t)
+	node.Chain.MaxTxPerBlock = 2
+	const sender = "10.0.0.9:5000"
+	maxBytes := node.Chain.MaxBlockBytes
+	node.Chain.MaxBlockBytes = testBlock(node.Chain.Tip(), testVote("e", "a", 1)).Size()
+	rejected := grpcOversized.Value()
+
+	for i, blk := range []*Block{
+		testBlock(node.Chain.Tip(), testVote("e", "a", 1), testVote("e", "a", 2), testVote("e", "a", 3)),
+		testBlock(node.Chain.Tip(), testVote("e", "a", 4), testVote("e", "a", 5)), // Under the count, over the bytes
+	} {
+		_, err := node.SendBlock(inboundCtx(sender, nil), &SendBlockRequest{Block: blk})
+		if status.Code(err) != codes.ResourceExhausted {
+			t.Fatalf("block %d: got %v, want ResourceExhausted", i, err)
+		}
+		if !node.seenBlocks.Add(blk.Header.Hash) {
+			t.Errorf("block %d was marked seen before being refused", i)
+		}
+	}
+	if got := grpcOversized.Value() - rejected; got != 2 {
+		t.Errorf("grpc_oversized_blocks rose by %d, want 2", got)
+	}
+	if got, want := node.PeerScore(addrHost(sender)), -2*OversizedBlockPenalty; got != want {
+		t.Errorf("sender score %d, want %d", got, want)
+	}
+
+	node.Chain.MaxBlockBytes = maxBytes
+	if _, err := node.SendBlock(inboundCtx(sender, nil), &SendBlockRequest{Block: testBlock(node.Chain.Tip(), testVote("e", "a", 6))}); err != nil {
+		t.Errorf("block within the limits: %v", err)
+	}
+}
@@ -0,0 +1,168 @@
+// go_backend_audit_snippet.go
+
+package main
+
+import (
+	"bufio"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// --- Audit Log ---
+
+// AuditEntry is one accepted transaction in the audit log. Each entry commits to
+// the previous entry's hash, so editing, removing or reordering any entry breaks
+// every hash after it.
+type AuditEntry struct {
+	Seq        uint64 `json:"seq"`
+	TxHash     string `json:"tx_hash"`
+	Sender     string `json:"sender"`
+	ElectionID string `json:"election_id,omitempty"` // Empty when the transaction did not name an election
+	Timestamp  uint64 `json:"timestamp"`             // Unix seconds when the node accepted the transaction
+	Node       string `json:"node"`                  // Address of the accepting node
+	PrevHash   string `json:"prev_hash"`             // Hash of the previous entry; empty for the first
+	Hash       string `json:"hash"`
+}
+
+// ComputeHash returns the hash over every field except Hash itself. The log is
+// this node's own record rather than chain data, so it is always SHA3-256,
+// whatever the chain's Hasher.
+func (e *AuditEntry) ComputeHash() string {
+	return hex.EncodeToString(SHA3Hasher{}.Sum([]byte(fmt.Sprintf("%d|%s|%s|%s|%d|%s|%s",
+		e.Seq, e.TxHash, e.Sender, e.ElectionID, e.Timestamp, e.Node, e.PrevHash))))
+}
+
+// AuditLog is an append-only, hash-chained record of every transaction this node
+// accepted, kept as one JSON entry per line. It is independent of the blockchain
+// and serves as a secondary record for election audits.
+type AuditLog struct {
+	mu       sync.Mutex
+	f        *os.File
+	seq      uint64 // Seq of the last entry written
+	lastHash string
+}
+
+// OpenAuditLog opens (or creates) the audit log at path for appending. An existing
+// log is verified first so new entries never extend a tampered chain.
+func OpenAuditLog(path string) (*AuditLog, error) {
+	seq, lastHash, err := readAuditLog(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit log: %w", err)
+	}
+	return &AuditLog{f: f, seq: seq, lastHash: lastHash}, nil
+}
+
+// Record appends an entry for tx, accepted by node, and syncs it to disk.
+func (a *AuditLog) Record(tx *Transaction, electionID, node string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	entry := &AuditEntry{
+		Seq:        a.seq + 1,
+		TxHash:     hex.EncodeToString(tx.GetHash()),
+		Sender:     hex.EncodeToString(tx.GetSender()),
+		ElectionID: electionID,
+		Timestamp:  uint64(time.Now().Unix()),
+		Node:       node,
+		PrevHash:   a.lastHash,
+	}
+	entry.Hash = entry.ComputeHash()
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	if _, err := a.f.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("failed to write audit entry %d: %w", entry.Seq, err)
+	}
+	if err := a.f.Sync(); err != nil {
+		return fmt.Errorf("failed to sync audit entry %d: %w", entry.Seq, err)
+	}
+	a.seq = entry.Seq
+	a.lastHash = entry.Hash
+	return nil
+}
+
+// Close closes the underlying file.
+func (a *AuditLog) Close() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.f.Close()
+}
+
+// VerifyAuditLog checks that every entry in the log at path is intact and
+// correctly chained to the one before it.
+func VerifyAuditLog(path string) error {
+	_, _, err := readAuditLog(path)
+	return err
+}
+
+// readAuditLog verifies the log at path and returns the seq and hash of its last entry.
+func readAuditLog(path string) (uint64, string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, "", err
+	}
+	defer f.Close()
+
+	var seq uint64
+	var prevHash string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var entry AuditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return 0, "", fmt.Errorf("audit entry %d is malformed: %v", seq+1, err)
+		}
+		if entry.Seq != seq+1 {
+			return 0, "", fmt.Errorf("audit entry %d has seq %d", seq+1, entry.Seq)
+		}
+		if entry.PrevHash != prevHash {
+			return 0, "", fmt.Errorf("audit entry %d does not link to the previous entry", entry.Seq)
+		}
+		if entry.Hash != entry.ComputeHash() {
+			return 0, "", fmt.Errorf("audit entry %d hash does not match its contents", entry.Seq)
+		}
+		seq, prevHash = entry.Seq, entry.Hash
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, "", fmt.Errorf("failed to read audit log: %w", err)
+	}
+	return seq, prevHash, nil
+}
+
+// recordAccepted writes tx to the node's audit log, if one is configured,
+// under the election it belongs to (see auditElection). Failures are logged
+// rather than returned: the transaction is already accepted.
+func (n *P2PNode) recordAccepted(tx *Transaction) {
+	if n.Audit == nil {
+		return
+	}
+	if err := n.Audit.Record(tx, auditElection(tx), n.AdvertisedAddr()); err != nil {
+		log.Printf("ERROR: failed to audit transaction %x: %v", tx.GetHash(), err)
+	}
+}
+
+// auditElection returns the election tx belongs to, whichever path it arrived
+// by, or "" for a transaction outside any election, such as a validator
+// change.
+func auditElection(tx *Transaction) string {
+	switch tx.GetKind() {
+	case TxKindVote, TxKindVoteCommit:
+		return string(tx.GetPayload())
+	case TxKindRegisterCandidate:
+		reg := &CandidateRegistration{}
+		if reg.UnmarshalProto(tx.GetPayload()) == nil {
+			return reg.ElectionID
+		}
+	}
+	return ""
+}
@@ -0,0 +1,137 @@
+// go_backend_audit_snippet_test.go
+
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeAuditLog records n votes to a new audit log and returns its path.
+func writeAuditLog(t *testing.T, n int) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "audit.log")
+	audit, err := OpenAuditLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= n; i++ {
+		if err := audit.Record(testVote("e", "a", i), "e", "127.0.0.1:9000"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := audit.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// editAuditEntry rewrites line i (from 0) of the audit log at path with edit,
+// rehashing the entry if rehash is set.
+func editAuditEntry(t *testing.T, path string, i int, rehash bool, edit func(e *AuditEntry)) {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := bytes.Split(bytes.TrimSuffix(data, []byte("\n")), []byte("\n"))
+	var entry AuditEntry
+	if err := json.Unmarshal(lines[i], &entry); err != nil {
+		t.Fatal(err)
+	}
+	edit(&entry)
+	if rehash {
+		entry.Hash = entry.ComputeHash()
+	}
+	if lines[i], err = json.Marshal(&entry); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, append(bytes.Join(lines, []byte("\n")), '\n'), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAuditLogChainsAndReopens(t *testing.T) {
+	path := writeAuditLog(t, 3)
+	if err := VerifyAuditLog(path); err != nil {
+		t.Fatal(err)
+	}
+	audit, err := OpenAuditLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := audit.Record(testVote("e", "a", 4), "e", "127.0.0.1:9000"); err != nil {
+		t.Fatal(err)
+	}
+	audit.Close()
+	if seq, _, err := readAuditLog(path); err != nil || seq != 4 {
+		t.Errorf("reopened log ends at entry %d (%v), want 4", seq, err)
+	}
+}
+
+func TestVerifyAuditLogDetectsTamperedEntry(t *testing.T) {
+	for name, tc := range map[string]struct {
+		rehash bool
+		want   string // Entry the verifier should blame
+	}{
+		"edited":           {false, "audit entry 2 hash"},
+		"edited, rehashed": {true, "audit entry 3 does not link"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			path := writeAuditLog(t, 3)
+			editAuditEntry(t, path, 1, tc.rehash, func(e *AuditEntry) { e.ElectionID = "other" })
+			err := VerifyAuditLog(path)
+			if err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Errorf("got %v, want an error about %q", err, tc.want)
+			}
+			if _, err := OpenAuditLog(path); err == nil {
+				t.Error("OpenAuditLog appended to a tampered log")
+			}
+		})
+	}
+}
+
+func TestAcceptedTransactionsRecordTheirElection(t *testing.T) {
+	node := newTestNode(t)
+	path := filepath.Join(t.TempDir(), "audit.log")
+	audit, err := OpenAuditLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer audit.Close()
+	node.Audit = audit
+
+	if _, err := node.SendTransaction(inboundCtx("10.0.0.1:5000", nil), &SendTransactionRequest{Transaction: signedVote(t)}); err != nil {
+		t.Fatal(err)
+	}
+	if rr := postRawTx(t, node, hex.EncodeToString(signedVote(t).MarshalProto()), TxEncodingHex); rr.Code != http.StatusAccepted {
+		t.Fatalf("/tx: status %d, body %s", rr.Code, rr.Body)
+	}
+	if rr := postBatch(t, node, []*Transaction{signedVote(t)}); rr.Code != http.StatusAccepted {
+		t.Fatalf("/tx/batch: status %d, body %s", rr.Code, rr.Body)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := bytes.Split(bytes.TrimSuffix(data, []byte("\n")), []byte("\n"))
+	if len(lines) != 3 {
+		t.Fatalf("%d audit entries, want 3", len(lines))
+	}
+	for i, line := range lines {
+		var entry AuditEntry
+		if err := json.Unmarshal(line, &entry); err != nil {
+			t.Fatal(err)
+		}
+		if entry.ElectionID != "e" {
+			t.Errorf("entry %d: election %q, want %q", i, entry.ElectionID, "e")
+		}
+	}
+}
@@ -0,0 +1,2017 @@
+// go_backend_chain_snippet.go
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/sha3"
+	"errors"
+	"expvar"
+	"fmt"
+	"log"
+	"math"
+	"math/bits"
+	"runtime"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/decred/dcrd/dcrec/secp256k1/v4"
+	"github.com/decred/dcrd/dcrec/secp256k1/v4/ecdsa"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// --- Hashing ---
+
+// Hasher is the hash function a chain uses for transactions, blocks and
+// everything else its nodes must agree on. Every node on a network must use
+// the same one, so it is named in the genesis block rather than set per node,
+// and each Chain hashes with its own; see Chain.Hasher.
+type Hasher interface {
+	Name() string
+	Sum(data []byte) []byte
+}
+
+// SHA3Hasher is the default Hasher (SHA3-256).
+type SHA3Hasher struct{}
+
+func (SHA3Hasher) Name() string { return "sha3-256" }
+
+func (SHA3Hasher) Sum(data []byte) []byte {
+	sum := sha3.Sum256(data)
+	return sum[:]
+}
+
+// hashers lists the algorithms a genesis config may name.
+var hashers = map[string]Hasher{
+	SHA3Hasher{}.Name(): SHA3Hasher{},
+}
+
+// HasherNamed returns the Hasher a genesis config names; the empty name, as
+// in genesis blocks from before hashers were named, is SHA3Hasher.
+func HasherNamed(name string) (Hasher, error) {
+	if name == "" {
+		return SHA3Hasher{}, nil
+	}
+	h, ok := hashers[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown hash algorithm %q", name)
+	}
+	return h, nil
+}
+
+// --- Signature Schemes ---
+
+// Verifier checks transaction signatures under one SigScheme.
+type Verifier interface {
+	Name() string
+	PublicKeySize() int
+	SignatureSize() int
+	Verify(pubKey, msg, sig []byte) bool
+}
+
+// Ed25519Verifier is the default Verifier.
+type Ed25519Verifier struct{}
+
+func (Ed25519Verifier) Name() string       { return "ed25519" }
+func (Ed25519Verifier) PublicKeySize() int { return ed25519.PublicKeySize }
+func (Ed25519Verifier) SignatureSize() int { return ed25519.SignatureSize }
+
+func (Ed25519Verifier) Verify(pubKey, msg, sig []byte) bool {
+	return ed25519.Verify(ed25519.PublicKey(pubKey), msg, sig)
+}
+
+// Secp256k1Verifier checks ECDSA signatures as produced by hardware wallets:
+// a compressed public key, and a 64-byte r||s signature over SHA-256(msg).
+type Secp256k1Verifier struct{}
+
+func (Secp256k1Verifier) Name() string       { return "secp256k1" }
+func (Secp256k1Verifier) PublicKeySize() int { return secp256k1.PubKeyBytesLenCompressed }
+func (Secp256k1Verifier) SignatureSize() int { return 64 }
+
+func (Secp256k1Verifier) Verify(pubKey, msg, sig []byte) bool {
+	key, err := secp256k1.ParsePubKey(pubKey)
+	if err != nil || len(sig) != 64 {
+		return false
+	}
+	var r, s secp256k1.ModNScalar
+	if r.SetByteSlice(sig[:32]) || s.SetByteSlice(sig[32:]) {
+		return false // r or s not below the group order
+	}
+	digest := sha256.Sum256(msg)
+	return ecdsa.NewSignature(&r, &s).Verify(digest[:], key)
+}
+
+// verifiers maps each supported SigScheme to its Verifier.
+var verifiers = map[SigScheme]Verifier{
+	SigSchemeEd25519:   Ed25519Verifier{},
+	SigSchemeSecp256k1: Secp256k1Verifier{},
+}
+
+// --- Block Hashing and Encoding ---
+
+// ComputeHash returns the chain hash over every header field except Hash itself.
+// Proposer and Fees are appended only when set, so the hashes of blocks without
+// them (including every genesis block) are unchanged.
+func (h *BlockHeader) ComputeHash(hasher Hasher) []byte {
+	msg := fmt.Sprintf("%d|%x|%x|%d|%d|%s",
+		h.Version, h.PrevBlockHash, h.MerkleRoot, h.Timestamp, h.Height, h.ChainId)
+	if len(h.Proposer) > 0 || h.Fees != 0 {
+		msg += fmt.Sprintf("|%x|%d", h.Proposer, h.Fees)
+	}
+	return hasher.Sum([]byte(msg))
+}
+
+// ComputeTxHash returns the hash identifying tx: that of its signing bytes.
+func ComputeTxHash(hasher Hasher, tx *Transaction) []byte {
+	return hasher.Sum(tx.SigningBytes())
+}
+
+// ComputeMerkleRoot hashes the transaction hashes pairwise up to a single root.
+// An odd node at any level is paired with itself, as in Bitcoin.
+func ComputeMerkleRoot(hasher Hasher, txs []*Transaction) []byte {
+	if len(txs) == 0 {
+		return hasher.Sum(nil)
+	}
+	level := make([][]byte, len(txs))
+	for i, tx := range txs {
+		level[i] = tx.Hash
+	}
+	for len(level) > 1 {
+		next := make([][]byte, 0, (len(level)+1)/2)
+		for i := 0; i < len(level); i += 2 {
+			right := level[i]
+			if i+1 < len(level) {
+				right = level[i+1]
+			}
+			next = append(next, hasher.Sum(append(append([]byte(nil), level[i]...), right...)))
+		}
+		level = next
+	}
+	return level[0]
+}
+
+// MarshalProto encodes the header in protobuf wire format.
+func (h *BlockHeader) MarshalProto() []byte {
+	var b []byte
+	b = protowire.AppendTag(b, 1, protowire.VarintType)
+	b = protowire.AppendVarint(b, uint64(h.Version))
+	b = protowire.AppendTag(b, 2, protowire.BytesType)
+	b = protowire.AppendBytes(b, h.PrevBlockHash)
+	b = protowire.AppendTag(b, 3, protowire.BytesType)
+	b = protowire.AppendBytes(b, h.MerkleRoot)
+	b = protowire.AppendTag(b, 4, protowire.VarintType)
+	b = protowire.AppendVarint(b, h.Timestamp)
+	b = protowire.AppendTag(b, 5, protowire.VarintType)
+	b = protowire.AppendVarint(b, h.Height)
+	b = protowire.AppendTag(b, 6, protowire.BytesType)
+	b = protowire.AppendString(b, h.ChainId)
+	b = protowire.AppendTag(b, 7, protowire.BytesType)
+	b = protowire.AppendBytes(b, h.Hash)
+	if len(h.Proposer) > 0 {
+		b = protowire.AppendTag(b, 8, protowire.BytesType)
+		b = protowire.AppendBytes(b, h.Proposer)
+	}
+	if h.Fees != 0 {
+		b = protowire.AppendTag(b, 9, protowire.VarintType)
+		b = protowire.AppendVarint(b, h.Fees)
+	}
+	return b
+}
+
+// UnmarshalProto decodes a header from protobuf wire format, dispatching on
+// its version. A version newer than BlockVersion fails with ErrUnknownVersion.
+func (h *BlockHeader) UnmarshalProto(b []byte) error {
+	version, err := peekVersion(b, 1)
+	if err != nil {
+		return err
+	}
+	switch version {
+	case 0, 1:
+		return h.unmarshalV1(b)
+	default:
+		return fmt.Errorf("%w: block version %d, newest known is %d", ErrUnknownVersion, version, BlockVersion)
+	}
+}
+
+// unmarshalV1 decodes a version 1 header. Unknown fields are skipped.
+func (h *BlockHeader) unmarshalV1(b []byte) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+		switch {
+		case typ == protowire.BytesType && (num == 2 || num == 3 || num == 6 || num == 7 || num == 8):
+			v, n := protowire.ConsumeBytes(b)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			b = b[n:]
+			switch num {
+			case 2:
+				h.PrevBlockHash = append([]byte(nil), v...)
+			case 3:
+				h.MerkleRoot = append([]byte(nil), v...)
+			case 6:
+				h.ChainId = string(v)
+			case 7:
+				h.Hash = append([]byte(nil), v...)
+			case 8:
+				h.Proposer = append([]byte(nil), v...)
+			}
+		case typ == protowire.VarintType && (num == 1 || num == 4 || num == 5 || num == 9):
+			v, n := protowire.ConsumeVarint(b)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			b = b[n:]
+			switch num {
+			case 1:
+				h.Version = uint32(v)
+			case 4:
+				h.Timestamp = v
+			case 5:
+				h.Height = v
+			case 9:
+				h.Fees = v
+			}
+		default:
+			n := protowire.ConsumeFieldValue(num, typ, b)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			b = b[n:]
+		}
+	}
+	return nil
+}
+
+// MarshalProto encodes the block (header, then transactions) in protobuf wire format.
+func (blk *Block) MarshalProto() []byte {
+	var b []byte
+	b = protowire.AppendTag(b, 1, protowire.BytesType)
+	b = protowire.AppendBytes(b, blk.Header.MarshalProto())
+	for _, tx := range blk.Transactions {
+		b = protowire.AppendTag(b, 2, protowire.BytesType)
+		b = protowire.AppendBytes(b, tx.MarshalProto())
+	}
+	return b
+}
+
+// UnmarshalProto decodes a block from protobuf wire format. The header and
+// each transaction are decoded by version, so a block in or carrying a format
+// newer than this node knows fails with ErrUnknownVersion.
+func (blk *Block) UnmarshalProto(b []byte) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+		if typ != protowire.BytesType || (num != 1 && num != 2) {
+			n := protowire.ConsumeFieldValue(num, typ, b)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			b = b[n:]
+			continue
+		}
+		v, n := protowire.ConsumeBytes(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+		if num == 1 {
+			blk.Header = &BlockHeader{}
+			if err := blk.Header.UnmarshalProto(v); err != nil {
+				return err
+			}
+			continue
+		}
+		tx := &Transaction{}
+		if err := tx.UnmarshalProto(v); err != nil {
+			return fmt.Errorf("transaction %d: %w", len(blk.Transactions), err)
+		}
+		blk.Transactions = append(blk.Transactions, tx)
+	}
+	return nil
+}
+
+// Size returns the serialized size of the block in bytes.
+func (blk *Block) Size() int {
+	return len(blk.MarshalProto())
+}
+
+// --- Local Chain ---
+
+// GenesisConfig holds the network-wide parameters fixed at genesis.
+// Every node on a network must use identical values.
+type GenesisConfig struct {
+	ChainID           string
+	HashAlgorithm     string    // Name of the Hasher the chain uses, e.g. "sha3-256"; see HasherNamed
+	InitialValidators [][]byte  // Ed25519 keys of the validators active from height 0
+	InitialStakes     []uint64  // Stake of each initial validator, by index; missing or zero means DefaultValidatorStake
+	AuthorityKey      []byte    // Ed25519 key of the election authority; nil disables candidate registration
+	LaunchTime        time.Time // Genesis timestamp; no block may be proposed before it. Zero launches immediately
+	BurnFees          bool      // Burn transaction fees instead of crediting them to block proposers
+
+	// AllowAnyCandidate turns off the candidate whitelist, which otherwise
+	// rejects votes for candidates not registered in their election once an
+	// AuthorityKey can register them. Set it only on test networks; a config
+	// that leaves it unset enforces the whitelist.
+	AllowAnyCandidate bool
+
+	// RevealStart enables commit-reveal voting: below it voters may only
+	// submit commitments, and from it until RevealEnd (exclusive, 0 for no
+	// end) only votes revealing them are accepted and tallied. Commitments
+	// not revealed by then are never counted. 0 disables commit-reveal, so
+	// votes are public.
+	RevealStart uint64
+	RevealEnd   uint64
+
+	// Elections schedules elections, each closing at its EndTime and
+	// optionally requiring a minimum turnout. Elections not listed here run
+	// without an end or a turnout quorum.
+	Elections []Election
+}
+
+// DefaultGenesisConfig returns the mainnet genesis config.
+func DefaultGenesisConfig() GenesisConfig {
+	return GenesisConfig{
+		ChainID:       DefaultChainID,
+		HashAlgorithm: SHA3Hasher{}.Name(),
+	}
+}
+
+// GenesisState is the starting authority set, carried as the payload of the
+// genesis block's single TxKindGenesis transaction (see proto/transaction.proto).
+// Because the merkle root commits to it, nodes that disagree on it derive
+// different genesis hashes and refuse each other at handshake.
+type GenesisState struct {
+	InitialValidators [][]byte
+	AuthorityKey      []byte
+	InitialStakes     []uint64 // Parallel to InitialValidators
+	BurnFees          bool
+	AllowAnyCandidate bool // As in GenesisConfig; false, the encoded default, enforces the whitelist
+	RevealStart       uint64
+	RevealEnd         uint64
+	HashAlgorithm     string // As in GenesisConfig; only encoded when not SHA3Hasher, so older genesis hashes are unchanged
+	Elections         []Election
+}
+
+// MarshalProto encodes the state in protobuf wire format.
+func (g *GenesisState) MarshalProto() []byte {
+	var b []byte
+	for _, key := range g.InitialValidators {
+		b = protowire.AppendTag(b, 1, protowire.BytesType)
+		b = protowire.AppendBytes(b, key)
+	}
+	if len(g.AuthorityKey) > 0 {
+		b = protowire.AppendTag(b, 2, protowire.BytesType)
+		b = protowire.AppendBytes(b, g.AuthorityKey)
+	}
+	if len(g.InitialStakes) > 0 {
+		var packed []byte
+		for _, stake := range g.InitialStakes {
+			packed = protowire.AppendVarint(packed, stake)
+		}
+		b = protowire.AppendTag(b, 3, protowire.BytesType)
+		b = protowire.AppendBytes(b, packed)
+	}
+	if g.BurnFees {
+		b = protowire.AppendTag(b, 4, protowire.VarintType)
+		b = protowire.AppendVarint(b, 1)
+	}
+	if g.AllowAnyCandidate {
+		b = protowire.AppendTag(b, 5, protowire.VarintType)
+		b = protowire.AppendVarint(b, 1)
+	}
+	if g.RevealStart > 0 {
+		b = protowire.AppendTag(b, 6, protowire.VarintType)
+		b = protowire.AppendVarint(b, g.RevealStart)
+	}
+	if g.RevealEnd > 0 {
+		b = protowire.AppendTag(b, 7, protowire.VarintType)
+		b = protowire.AppendVarint(b, g.RevealEnd)
+	}
+	if g.HashAlgorithm != "" && g.HashAlgorithm != (SHA3Hasher{}).Name() {
+		b = protowire.AppendTag(b, 8, protowire.BytesType)
+		b = protowire.AppendString(b, g.HashAlgorithm)
+	}
+	for _, e := range g.Elections {
+		b = protowire.AppendTag(b, 9, protowire.BytesType)
+		b = protowire.AppendBytes(b, e.MarshalProto())
+	}
+	return b
+}
+
+// UnmarshalProto decodes a state from protobuf wire format.
+func (g *GenesisState) UnmarshalProto(b []byte) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+		if num >= 3 && num <= 7 && typ == protowire.VarintType { // num 3 is the unpacked encoding of initial_stakes
+			v, n := protowire.ConsumeVarint(b)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			b = b[n:]
+			switch num {
+			case 3:
+				g.InitialStakes = append(g.InitialStakes, v)
+			case 4:
+				g.BurnFees = v != 0
+			case 5:
+				g.AllowAnyCandidate = v != 0
+			case 6:
+				g.RevealStart = v
+			case 7:
+				g.RevealEnd = v
+			}
+			continue
+		}
+		if typ != protowire.BytesType || num < 1 || (num > 3 && num != 8 && num != 9) {
+			n := protowire.ConsumeFieldValue(num, typ, b)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			b = b[n:]
+			continue
+		}
+		v, n := protowire.ConsumeBytes(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+		switch num {
+		case 1:
+			g.InitialValidators = append(g.InitialValidators, append([]byte(nil), v...))
+		case 2:
+			g.AuthorityKey = append([]byte(nil), v...)
+		case 3:
+			for len(v) > 0 {
+				stake, n := protowire.ConsumeVarint(v)
+				if n < 0 {
+					return protowire.ParseError(n)
+				}
+				v = v[n:]
+				g.InitialStakes = append(g.InitialStakes, stake)
+			}
+		case 8:
+			g.HashAlgorithm = string(v)
+		case 9:
+			var e Election
+			if err := e.UnmarshalProto(v); err != nil {
+				return err
+			}
+			g.Elections = append(g.Elections, e)
+		}
+	}
+	return nil
+}
+
+// validators pairs each initial validator with its stake.
+func (g *GenesisState) validators() []Validator {
+	vals := make([]Validator, len(g.InitialValidators))
+	for i, key := range g.InitialValidators {
+		vals[i] = Validator{PubKey: key, Stake: DefaultValidatorStake}
+		if i < len(g.InitialStakes) && g.InitialStakes[i] > 0 {
+			vals[i].Stake = g.InitialStakes[i]
+		}
+	}
+	return vals
+}
+
+// GenesisBlock returns the deterministic height-0 block for a chain.
+// Every node with the same genesis config derives the same genesis hash.
+func GenesisBlock(cfg GenesisConfig) *Block {
+	state := &GenesisState{InitialValidators: cfg.InitialValidators, InitialStakes: cfg.InitialStakes, AuthorityKey: cfg.AuthorityKey, BurnFees: cfg.BurnFees, AllowAnyCandidate: cfg.AllowAnyCandidate, RevealStart: cfg.RevealStart, RevealEnd: cfg.RevealEnd, HashAlgorithm: cfg.HashAlgorithm, Elections: cfg.Elections}
+	hasher, err := HasherNamed(cfg.HashAlgorithm)
+	if err != nil {
+		hasher = SHA3Hasher{} // NewChain refuses the block, as its state names an unknown Hasher
+	}
+	tx := &Transaction{
+		ChainId: cfg.ChainID,
+		Kind:    TxKindGenesis,
+		Payload: state.MarshalProto(),
+	}
+	tx.Hash = ComputeTxHash(hasher, tx)
+	txs := []*Transaction{tx}
+
+	header := &BlockHeader{
+		Version:    1,
+		MerkleRoot: ComputeMerkleRoot(hasher, txs),
+		Height:     0,
+		ChainId:    cfg.ChainID,
+	}
+	if !cfg.LaunchTime.IsZero() {
+		// Every block must be timestamped after its parent, so this also stops
+		// nodes from accepting blocks produced before launch
+		header.Timestamp = uint64(cfg.LaunchTime.Unix())
+	}
+	header.Hash = header.ComputeHash(hasher)
+	return &Block{Header: header, Transactions: txs}
+}
+
+// ParseGenesisState verifies a genesis block's hashes and returns the state it carries.
+func ParseGenesisState(genesis *Block) (*GenesisState, error) {
+	if genesis == nil || genesis.Header == nil || genesis.Header.Height != 0 {
+		return nil, fmt.Errorf("%w: not a genesis block", ErrMalformedBlock)
+	}
+	if len(genesis.Transactions) != 1 || genesis.Transactions[0].GetKind() != TxKindGenesis {
+		return nil, fmt.Errorf("%w: genesis must carry exactly one genesis transaction", ErrMalformedBlock)
+	}
+	// The state names the Hasher the hashes below are checked with
+	tx := genesis.Transactions[0]
+	state := &GenesisState{}
+	if err := state.UnmarshalProto(tx.GetPayload()); err != nil {
+		return nil, fmt.Errorf("%w: genesis state: %v", ErrMalformedTx, err)
+	}
+	hasher, err := HasherNamed(state.HashAlgorithm)
+	if err != nil {
+		return nil, fmt.Errorf("%w: genesis: %v", ErrMalformedTx, err)
+	}
+	if !bytes.Equal(tx.GetHash(), ComputeTxHash(hasher, tx)) {
+		return nil, fmt.Errorf("genesis: %w", ErrBadTxHash)
+	}
+	if !bytes.Equal(genesis.Header.MerkleRoot, ComputeMerkleRoot(hasher, genesis.Transactions)) {
+		return nil, fmt.Errorf("genesis: %w", ErrBadMerkleRoot)
+	}
+	if !bytes.Equal(genesis.Header.Hash, genesis.Header.ComputeHash(hasher)) {
+		return nil, fmt.Errorf("genesis: %w", ErrBadBlockHash)
+	}
+	for _, key := range state.InitialValidators {
+		if len(key) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("%w: genesis validator key must be %d bytes, got %d", ErrMalformedTx, ed25519.PublicKeySize, len(key))
+		}
+	}
+	if len(state.InitialStakes) > len(state.InitialValidators) {
+		return nil, fmt.Errorf("%w: genesis has %d stakes for %d validators", ErrMalformedTx, len(state.InitialStakes), len(state.InitialValidators))
+	}
+	if _, ok := totalStake(state.validators()); !ok {
+		return nil, fmt.Errorf("%w: genesis stakes overflow the total stake", ErrMalformedTx)
+	}
+	if len(state.AuthorityKey) != 0 && len(state.AuthorityKey) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("%w: genesis authority key must be %d bytes, got %d", ErrMalformedTx, ed25519.PublicKeySize, len(state.AuthorityKey))
+	}
+	if state.RevealEnd != 0 && state.RevealEnd <= state.RevealStart {
+		return nil, fmt.Errorf("%w: genesis reveal window [%d, %d) is empty", ErrMalformedTx, state.RevealStart, state.RevealEnd)
+	}
+	scheduled := make(map[string]bool)
+	for _, e := range state.Elections {
+		if e.ID == "" || scheduled[e.ID] {
+			return nil, fmt.Errorf("%w: genesis election ID %q is empty or repeated", ErrMalformedTx, e.ID)
+		}
+		scheduled[e.ID] = true
+		if e.EndTime.Unix() <= 0 {
+			return nil, fmt.Errorf("%w: genesis election %q has no end time", ErrMalformedTx, e.ID)
+		}
+		if !e.StartTime.Before(e.EndTime) {
+			return nil, fmt.Errorf("%w: genesis election %q starts at or after its end", ErrMalformedTx, e.ID)
+		}
+		if e.MinTurnoutPercent > 100 || (e.MinTurnoutPercent > 0 && e.EligibleVoters == 0) {
+			return nil, fmt.Errorf("%w: genesis election %q needs a turnout percentage of at most 100 and its eligible voters", ErrMalformedTx, e.ID)
+		}
+	}
+	return state, nil
+}
+
+// Default per-block limits. Both bound what ProposeBlock produces and what
+// AppendBlock accepts from peers.
+const (
+	DefaultMaxTxPerBlock = 1000
+	DefaultMaxBlockBytes = 1 << 20 // 1 MiB
+)
+
+// DefaultMaxBlockTimeDrift is how far ahead of local time a block timestamp may
+// be, allowing for modest clock differences between proposer and validator.
+const DefaultMaxBlockTimeDrift = 15 * time.Second
+
+// Chain is the node's local copy of the blockchain, indexed by height and hash.
+type Chain struct {
+	MaxTxPerBlock int              // Most transactions a block may carry
+	MaxBlockBytes int              // Largest serialized block size (see Block.Size)
+	MaxBlockDrift time.Duration    // How far past local time a block timestamp may be
+	KeepBlocks    uint64           // Block bodies kept below the tip on a pruned node; 0 keeps every block, as an archive node
+	VerifyWorkers int              // Goroutines verifying a block's transaction signatures, capped at runtime.NumCPU(); 1 verifies serially
+	Clock         func() time.Time // Current time for block timestamps and checks; time.Now if nil, e.g. a ManualClock in tests
+	Tally         *Tally           // Vote counts over the current best chain
+	Validators    *ValidatorSet    // Validator set per height, including on-chain changes
+	Candidates    *CandidateRegistry
+	Commitments   *CommitmentRegistry
+	Events        *EventBus  // Notified of every block added to the best chain
+	Fees          *FeeLedger // Fees credited or burned over the current best chain
+	mu            sync.RWMutex
+	hasher        Hasher              // Named by the genesis block
+	authorityKey  ed25519.PublicKey   // Election authority from the genesis block
+	anyCandidate  bool                // Genesis turned off the candidate whitelist
+	revealStart   uint64              // Genesis RevealStart; 0 if commit-reveal voting is off
+	revealEnd     uint64              // Genesis RevealEnd; 0 if reveals never close
+	elections     map[string]Election // Scheduled by the genesis block, by ID
+	blocks        []*Block            // blocks[h] is the block at height h
+	byHash        map[string]*Block   // hex(block hash) -> block
+	txHeight      map[string]uint64   // hex(tx hash) -> height of the block including it
+	prunedTo      uint64              // Highest height whose block body has been pruned; 0 if none has
+	prunedTally   *Tally              // Votes in the pruned block bodies, so TallyDigest can still recount
+
+	// Mempool, if set, loses each block's transactions under the chain lock as
+	// the block is applied, so no reader of FindTx sees a transaction both
+	// pending and included. OnInclude, if set, is then called, still under the
+	// lock, with the mempool entries the block included.
+	Mempool   *Mempool
+	OnInclude func(included []*MempoolEntry)
+}
+
+// NewChain creates a chain holding only the genesis block. The initial validators
+// and election authority are read from the verified genesis block itself, so they
+// cannot drift from what the genesis hash commits to.
+func NewChain(genesis *Block) (*Chain, error) {
+	state, err := ParseGenesisState(genesis)
+	if err != nil {
+		return nil, err
+	}
+	var authorityKey ed25519.PublicKey
+	if len(state.AuthorityKey) > 0 {
+		authorityKey = state.AuthorityKey
+	}
+	hasher, _ := HasherNamed(state.HashAlgorithm) // Checked by ParseGenesisState
+	validators := NewValidatorSet(state.validators())
+	validators.hasher = hasher
+	elections := make(map[string]Election, len(state.Elections))
+	for _, e := range state.Elections {
+		elections[e.ID] = e
+	}
+	return &Chain{
+		MaxTxPerBlock: DefaultMaxTxPerBlock,
+		MaxBlockBytes: DefaultMaxBlockBytes,
+		MaxBlockDrift: DefaultMaxBlockTimeDrift,
+		VerifyWorkers: runtime.NumCPU(),
+		Tally:         NewTally(),
+		Validators:    validators,
+		Candidates:    NewCandidateRegistry(),
+		Commitments:   NewCommitmentRegistry(),
+		Events:        NewEventBus(),
+		Fees:          NewFeeLedger(state.BurnFees),
+		hasher:        hasher,
+		authorityKey:  authorityKey,
+		anyCandidate:  state.AllowAnyCandidate,
+		revealStart:   state.RevealStart,
+		revealEnd:     state.RevealEnd,
+		elections:     elections,
+		blocks:        []*Block{genesis},
+		byHash:        map[string]*Block{fmt.Sprintf("%x", genesis.Header.Hash): genesis},
+		txHeight:      make(map[string]uint64),
+		prunedTally:   NewTally(),
+	}, nil
+}
+
+// Genesis returns the height-0 block.
+func (c *Chain) Genesis() *Block {
+	return c.blocks[0] // Never changes, so no lock is needed
+}
+
+// now returns the current time from c.Clock, or the system clock if it is nil.
+// The node owning the chain reads the same clock.
+func (c *Chain) now() time.Time {
+	if c.Clock != nil {
+		return c.Clock()
+	}
+	return time.Now()
+}
+
+// LaunchTime returns when the network opens for block production, as fixed by
+// the genesis timestamp.
+func (c *Chain) LaunchTime() time.Time {
+	return time.Unix(int64(c.Genesis().Header.Timestamp), 0)
+}
+
+// Hasher returns the hash function the chain uses for transactions, blocks and
+// everything else nodes must agree on, as named by the genesis block. Chains
+// in one process may use different ones.
+func (c *Chain) Hasher() Hasher {
+	return c.hasher
+}
+
+// AuthorityKey returns the election authority key fixed at genesis, or nil if
+// the network has none.
+func (c *Chain) AuthorityKey() ed25519.PublicKey {
+	return c.authorityKey
+}
+
+// EnforcesCandidateWhitelist reports whether votes must be for a registered
+// candidate, as fixed by the genesis config. Without an election authority no
+// candidate can be registered, so the whitelist is never enforced.
+func (c *Chain) EnforcesCandidateWhitelist() bool {
+	return c.authorityKey != nil && !c.anyCandidate
+}
+
+// Height returns the height of the tip block.
+func (c *Chain) Height() uint64 {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return uint64(len(c.blocks) - 1)
+}
+
+// Tip returns the highest block.
+func (c *Chain) Tip() *Block {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.blocks[len(c.blocks)-1]
+}
+
+// GetBlock returns the block at height, if the chain has it and its body has
+// not been pruned.
+func (c *Chain) GetBlock(height uint64) (*Block, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if height >= uint64(len(c.blocks)) || c.pruned(height) {
+		return nil, false
+	}
+	return c.blocks[height], true
+}
+
+// GetByHash returns the block with the given header hash, if the chain has it
+// and its body has not been pruned.
+func (c *Chain) GetByHash(hash []byte) (*Block, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	blk, ok := c.byHash[fmt.Sprintf("%x", hash)]
+	if !ok || c.pruned(blk.Header.Height) {
+		return nil, false
+	}
+	return blk, true
+}
+
+// Header returns the header of the block at height. Headers are kept when
+// block bodies are pruned, so this succeeds for every height up to the tip.
+func (c *Chain) Header(height uint64) (*BlockHeader, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if height >= uint64(len(c.blocks)) {
+		return nil, false
+	}
+	return c.blocks[height].Header, true
+}
+
+// TxHeight returns the height of the block that includes the transaction, if any.
+func (c *Chain) TxHeight(txHash []byte) (uint64, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	h, ok := c.txHeight[fmt.Sprintf("%x", txHash)]
+	return h, ok
+}
+
+// FindTx returns the transaction with the given hash and the height of the
+// block including it, or height 0 if it is pending in the chain's Mempool.
+// Transactions in pruned blocks are not found, though TxHeight still knows
+// their height.
+// Both are checked under the chain lock, so a transaction whose block is being
+// applied is seen either pending or included, never both or neither.
+func (c *Chain) FindTx(txHash []byte) (*Transaction, uint64, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if height, ok := c.txHeight[fmt.Sprintf("%x", txHash)]; ok {
+		for _, tx := range c.blocks[height].Transactions {
+			if bytes.Equal(tx.GetHash(), txHash) {
+				return tx, height, true
+			}
+		}
+	}
+	if c.Mempool != nil {
+		if tx, ok := c.Mempool.Get(txHash); ok {
+			return tx, 0, true
+		}
+	}
+	return nil, 0, false
+}
+
+// --- Block Pruning ---
+
+// ErrPruned is returned for operations that need block bodies a pruned chain
+// no longer has.
+var ErrPruned = errors.New("block bodies pruned")
+
+// pruned reports whether the body of the block at height has been pruned.
+// Callers hold c.mu.
+func (c *Chain) pruned(height uint64) bool {
+	return height > 0 && height <= c.prunedTo
+}
+
+// PrunedHeight returns the highest height whose block body has been pruned, or
+// 0 if the chain still has every block.
+func (c *Chain) PrunedHeight() uint64 {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.prunedTo
+}
+
+// prune drops the bodies of blocks more than KeepBlocks below the tip, keeping
+// their headers for verification and folding their votes into prunedTally.
+// The derived state (tally, registries, fee ledger) is already the latest
+// snapshot, so it is unaffected. Genesis is never pruned. Callers hold c.mu.
+func (c *Chain) prune() {
+	if c.KeepBlocks == 0 {
+		return
+	}
+	tip := uint64(len(c.blocks) - 1)
+	for c.prunedTo+c.KeepBlocks < tip {
+		height := c.prunedTo + 1
+		blk := c.blocks[height]
+		c.prunedTally.applyBlock(blk)
+		header := &Block{Header: blk.Header}
+		c.blocks[height] = header
+		c.byHash[fmt.Sprintf("%x", blk.Header.Hash)] = header
+		c.prunedTo = height
+	}
+}
+
+// --- Validation Errors ---
+
+// Validation errors, wrapped with detail by the functions that return them, so the
+// network and HTTP layers can tell failures apart with errors.Is.
+var (
+	ErrMalformedTx        = errors.New("malformed transaction")
+	ErrBadTxHash          = errors.New("transaction hash does not match its contents")
+	ErrBadSignature       = errors.New("invalid transaction signature")
+	ErrStaleTx            = errors.New("transaction timestamp outside the replay window")
+	ErrUnknownTxKind      = errors.New("unknown transaction kind")
+	ErrBadVoteAmount      = errors.New("invalid vote amount")
+	ErrDuplicateVote      = errors.New("voter has already voted in this election")
+	ErrElectionClosed     = errors.New("election is closed")
+	ErrUnknownCandidate   = errors.New("unknown candidate")
+	ErrDuplicateCandidate = errors.New("candidate is already registered")
+	ErrNotAuthorized      = errors.New("sender is not authorized")
+	ErrMalformedBlock     = errors.New("malformed block")
+	ErrOrphanBlock        = errors.New("block does not extend its parent")
+	ErrWrongChain         = errors.New("wrong chain")
+	ErrBadMerkleRoot      = errors.New("merkle root does not match transactions")
+	ErrBadBlockHash       = errors.New("block hash does not match header")
+	ErrBlockTooLarge      = errors.New("block exceeds size limits")
+	ErrBadBlockTime       = errors.New("block timestamp out of range")
+	ErrUnknownVersion     = errors.New("unknown wire format version")
+)
+
+// checkTxVersion rejects a transaction in a format newer than TxVersion.
+func checkTxVersion(tx *Transaction) error {
+	if tx.GetVersion() > TxVersion {
+		return fmt.Errorf("%w: transaction %x has version %d, newest known is %d", ErrUnknownVersion, tx.GetHash(), tx.GetVersion(), TxVersion)
+	}
+	return nil
+}
+
+// VoteAmount is the Amount every vote transaction must carry. Tallies count
+// transactions rather than summing amounts, but enforcing this stops an inflated
+// amount from skewing results if amounts are ever summed.
+const VoteAmount = 1
+
+// checkVoteAmount rejects a vote whose Amount is not exactly VoteAmount.
+func checkVoteAmount(tx *Transaction) error {
+	if tx.GetKind() != TxKindVote {
+		return nil
+	}
+	if tx.GetAmount() != VoteAmount {
+		return fmt.Errorf("%w: vote %x has amount %d, must be %d", ErrBadVoteAmount, tx.GetHash(), tx.GetAmount(), VoteAmount)
+	}
+	return nil
+}
+
+// checkBallot rejects a vote with an unknown BallotType, a valid ballot with no
+// candidate, or an abstention or spoiled ballot that names one.
+func checkBallot(tx *Transaction) error {
+	if tx.GetKind() != TxKindVote {
+		return nil
+	}
+	switch tx.GetBallotType() {
+	case BallotValid:
+		if len(tx.GetRecipient()) == 0 {
+			return fmt.Errorf("%w: vote %x has no candidate", ErrMalformedTx, tx.GetHash())
+		}
+	case BallotAbstain, BallotSpoiled:
+		if len(tx.GetRecipient()) != 0 {
+			return fmt.Errorf("%w: %s ballot %x names a candidate", ErrMalformedTx, tx.GetBallotType(), tx.GetHash())
+		}
+	default:
+		return fmt.Errorf("%w: vote %x has ballot type %d", ErrMalformedTx, tx.GetHash(), tx.GetBallotType())
+	}
+	return nil
+}
+
+// ValidateBlock checks that blk is well-formed and extends parent.
+// In a real system the PoS/PBFT commit signatures would also be verified here.
+func (c *Chain) ValidateBlock(blk, parent *Block) error {
+	if err := c.ValidateBlockContents(blk); err != nil {
+		return err
+	}
+	return validateBlockLink(blk, parent)
+}
+
+// ValidateBlockContents checks everything about blk that does not depend on
+// its parent: transaction kinds, each transaction's format, hash and signature
+// (see VerifyTransaction, run on VerifyWorkers goroutines), the fee total, the
+// merkle root and the header hash, with the chain's Hasher. Of several bad
+// transactions the first in the block is reported, however the verification
+// was scheduled. It takes no lock, so it is safe to run concurrently for many
+// blocks.
+func (c *Chain) ValidateBlockContents(blk *Block) error {
+	if blk == nil || blk.Header == nil {
+		return fmt.Errorf("%w: block or header is missing", ErrMalformedBlock)
+	}
+	h := blk.Header
+	if h.Version > BlockVersion {
+		return fmt.Errorf("%w: block %d has version %d, newest known is %d", ErrUnknownVersion, h.Height, h.Version, BlockVersion)
+	}
+	for _, tx := range blk.Transactions {
+		if kind := tx.GetKind(); kind == TxKindGenesis || kind > TxKindVoteCommit {
+			return fmt.Errorf("%w: block %d: transaction %x has kind %d", ErrUnknownTxKind, h.Height, tx.GetHash(), tx.GetKind())
+		}
+	}
+	for i, err := range VerifyTransactions(c.hasher, blk.Transactions, c.VerifyWorkers) {
+		if err != nil {
+			return fmt.Errorf("block %d: transaction %x: %w", h.Height, blk.Transactions[i].GetHash(), err)
+		}
+	}
+	if err := checkBlockFees(blk); err != nil {
+		return err
+	}
+	if !bytes.Equal(h.MerkleRoot, ComputeMerkleRoot(c.hasher, blk.Transactions)) {
+		return fmt.Errorf("block %d: %w", h.Height, ErrBadMerkleRoot)
+	}
+	if !bytes.Equal(h.Hash, h.ComputeHash(c.hasher)) {
+		return fmt.Errorf("block %d: %w", h.Height, ErrBadBlockHash)
+	}
+	return nil
+}
+
+// validateBlockLink checks that a blk with valid contents extends parent.
+func validateBlockLink(blk, parent *Block) error {
+	h := blk.Header
+	if h.Height != parent.Header.Height+1 {
+		return fmt.Errorf("%w: block height %d does not follow parent height %d", ErrOrphanBlock, h.Height, parent.Header.Height)
+	}
+	if !bytes.Equal(h.PrevBlockHash, parent.Header.Hash) {
+		return fmt.Errorf("%w: block %d does not link to parent hash %x", ErrOrphanBlock, h.Height, parent.Header.Hash)
+	}
+	if h.ChainId != parent.Header.ChainId {
+		return fmt.Errorf("%w: block %d is for chain %q, expected %q", ErrWrongChain, h.Height, h.ChainId, parent.Header.ChainId)
+	}
+	if h.Timestamp <= parent.Header.Timestamp {
+		return fmt.Errorf("%w: block %d timestamp %d is not after parent timestamp %d", ErrBadBlockTime, h.Height, h.Timestamp, parent.Header.Timestamp)
+	}
+	return nil
+}
+
+// checkBlockLimits rejects blocks over MaxTxPerBlock transactions or MaxBlockBytes.
+func (c *Chain) checkBlockLimits(blk *Block) error {
+	if len(blk.Transactions) > c.MaxTxPerBlock {
+		return fmt.Errorf("%w: block %d has %d transactions, limit is %d", ErrBlockTooLarge, blk.Header.Height, len(blk.Transactions), c.MaxTxPerBlock)
+	}
+	if size := blk.Size(); size > c.MaxBlockBytes {
+		return fmt.Errorf("%w: block %d is %d bytes, limit is %d", ErrBlockTooLarge, blk.Header.Height, size, c.MaxBlockBytes)
+	}
+	return nil
+}
+
+// checkBlockTime rejects blocks timestamped more than MaxBlockDrift after now.
+// Unlike the checks in ValidateBlock this depends on the local clock, so a
+// block refused now may be accepted once time catches up.
+func (c *Chain) checkBlockTime(blk *Block, now time.Time) error {
+	if ts := time.Unix(int64(blk.Header.Timestamp), 0); ts.After(now.Add(c.MaxBlockDrift)) {
+		return fmt.Errorf("%w: block %d is timestamped %s, more than %s ahead of local time", ErrBadBlockTime, blk.Header.Height, ts.UTC().Format(time.RFC3339), c.MaxBlockDrift)
+	}
+	return nil
+}
+
+// ProposeBlock builds the next block on the tip from txs, taken in order until
+// adding another would exceed MaxTxPerBlock or MaxBlockBytes. Transactions that
+// do not fit are left for a later block; those appendBlock would reject, such
+// as a second vote from one voter or a vote for an unknown candidate, are
+// skipped. The block's fees are credited to
+// proposer unless the chain burns them.
+func (c *Chain) ProposeBlock(proposer []byte, txs []*Transaction) *Block {
+	tip := c.Tip()
+	header := &BlockHeader{
+		Version:       1,
+		PrevBlockHash: tip.Header.Hash,
+		Timestamp:     max(uint64(c.now().Unix()), tip.Header.Timestamp+1), // Strictly after the parent even within one second
+		Height:        tip.Header.Height + 1,
+		ChainId:       tip.Header.ChainId,
+		Proposer:      proposer,
+	}
+
+	// The merkle root and hash are fixed-length, so placeholders give the exact
+	// header size; the largest fee total bounds it from above
+	placeholder := make([]byte, len(c.hasher.Sum(nil)))
+	sized := *header
+	sized.MerkleRoot, sized.Hash, sized.Fees = placeholder, placeholder, math.MaxUint64
+	size := protowire.SizeTag(1) + protowire.SizeBytes(len(sized.MarshalProto()))
+
+	var included []*Transaction
+	voters := make(map[voterKey]bool)
+	registered := make(map[string]bool)
+	committed := make(map[voterKey]bool)
+	for _, tx := range txs {
+		if len(included) == c.MaxTxPerBlock {
+			break
+		}
+		txSize := protowire.SizeTag(2) + protowire.SizeBytes(len(tx.MarshalProto()))
+		if size+txSize > c.MaxBlockBytes {
+			continue // A smaller transaction later in the list may still fit
+		}
+		fees, carry := bits.Add64(header.Fees, tx.GetFee(), 0)
+		if carry != 0 {
+			continue
+		}
+		if tx.GetKind() == TxKindValidatorChange {
+			if _, err := c.Validators.validateChange(tx, header.Height); err != nil {
+				continue
+			}
+		}
+		if err := c.checkBlockTx(tx, header, voters, registered, committed); err != nil {
+			continue // E.g. a voter who has voted on chain since admission
+		}
+		size += txSize
+		header.Fees = fees
+		included = append(included, tx)
+	}
+
+	header.MerkleRoot = ComputeMerkleRoot(c.hasher, included)
+	header.Hash = header.ComputeHash(c.hasher)
+	return &Block{Header: header, Transactions: included}
+}
+
+// AppendBlock validates blk against the current tip and appends it.
+func (c *Chain) AppendBlock(blk *Block) error {
+	return c.appendBlock(blk, false)
+}
+
+// appendBlock appends blk, skipping ValidateBlockContents if the caller has
+// already run it, e.g. concurrently over a batch of synced blocks.
+func (c *Chain) appendBlock(blk *Block, contentsValid bool) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !contentsValid {
+		if err := c.ValidateBlockContents(blk); err != nil {
+			return err
+		}
+	}
+	if err := validateBlockLink(blk, c.blocks[len(c.blocks)-1]); err != nil {
+		return err
+	}
+	if err := c.checkBlockLimits(blk); err != nil {
+		return err
+	}
+	if err := c.checkBlockTime(blk, c.now()); err != nil {
+		return err
+	}
+	if err := c.validateState(blk); err != nil {
+		return err
+	}
+	c.addBlock(blk)
+	c.prune()
+	return nil
+}
+
+// validateState checks blk against state derived from the chain so far: the
+// validator set, the fee recipient and the candidate registry. Callers hold c.mu.
+func (c *Chain) validateState(blk *Block) error {
+	if err := c.Validators.validateBlock(blk); err != nil {
+		return err
+	}
+	if err := c.Fees.checkProposer(blk, c.Validators); err != nil {
+		return err
+	}
+	return c.checkElectionTxs(blk)
+}
+
+// addBlock appends a validated blk to the best chain, updates every index
+// derived from it and publishes it. Callers hold c.mu.
+func (c *Chain) addBlock(blk *Block) {
+	c.applyBlock(blk)
+	c.Events.PublishBlock(blk)
+}
+
+// applyBlock appends a validated blk to the best chain and updates every index
+// derived from it. Callers hold c.mu.
+func (c *Chain) applyBlock(blk *Block) {
+	c.blocks = append(c.blocks, blk)
+	c.byHash[fmt.Sprintf("%x", blk.Header.Hash)] = blk
+	for _, tx := range blk.Transactions {
+		c.txHeight[fmt.Sprintf("%x", tx.GetHash())] = blk.Header.Height
+	}
+	c.Tally.applyBlock(blk)
+	c.Validators.applyBlock(blk)
+	c.Candidates.applyBlock(blk)
+	c.Commitments.applyBlock(blk)
+	c.Fees.applyBlock(blk)
+	if c.Mempool != nil {
+		if included := c.Mempool.Remove(blk.Transactions); len(included) > 0 && c.OnInclude != nil {
+			c.OnInclude(included)
+		}
+	}
+}
+
+// removeTip drops the tip block from the best chain and its derived indexes.
+// A pruned block's votes have been folded into prunedTally and cannot be
+// reverted, so removing one fails with ErrPruned. Callers hold c.mu.
+func (c *Chain) removeTip() error {
+	orphan := c.blocks[len(c.blocks)-1]
+	if c.pruned(orphan.Header.Height) {
+		return fmt.Errorf("%w: cannot remove block %d", ErrPruned, orphan.Header.Height)
+	}
+	c.blocks = c.blocks[:len(c.blocks)-1]
+	delete(c.byHash, fmt.Sprintf("%x", orphan.Header.Hash))
+	for _, tx := range orphan.Transactions {
+		delete(c.txHeight, fmt.Sprintf("%x", tx.GetHash()))
+	}
+	c.Tally.revertBlock(orphan)
+	c.Validators.revertBlock(orphan)
+	c.Candidates.revertBlock(orphan)
+	c.Commitments.revertBlock(orphan)
+	c.Fees.revertBlock(orphan)
+	return nil
+}
+
+// Reorg replaces the chain above branch[0]'s parent with branch. Every block in
+// the new branch is validated before anything changes; then orphaned blocks are
+// reverted from the tally, tip first, and the new branch is applied in order.
+// Validator changes and votes depend on state as of the fork, so they are checked
+// as the branch is applied, restoring the old chain if one is invalid.
+// Blocks are pruned only once the reorg has committed or been rolled back, so
+// a rollback never meets a pruned block.
+// Fork choice (deciding which branch should win) is up to the caller.
+func (c *Chain) Reorg(branch []*Block) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(branch) == 0 || branch[0].Header == nil {
+		return fmt.Errorf("%w: reorg branch is empty", ErrMalformedBlock)
+	}
+	forkHeight := branch[0].Header.Height
+	if forkHeight == 0 || forkHeight > uint64(len(c.blocks)) {
+		return fmt.Errorf("reorg branch starts at height %d, chain height is %d", forkHeight, len(c.blocks)-1)
+	}
+	if c.pruned(forkHeight) {
+		return fmt.Errorf("%w: reorg at height %d reaches below the kept blocks", ErrPruned, forkHeight)
+	}
+	parent := c.blocks[forkHeight-1]
+	now := c.now()
+	for _, blk := range branch {
+		if err := c.ValidateBlock(blk, parent); err != nil {
+			return err
+		}
+		if err := c.checkBlockLimits(blk); err != nil {
+			return err
+		}
+		if err := c.checkBlockTime(blk, now); err != nil {
+			return err
+		}
+		parent = blk
+	}
+
+	orphans := append([]*Block(nil), c.blocks[forkHeight:]...)
+	defer c.prune()
+	for uint64(len(c.blocks)) > forkHeight {
+		if err := c.removeTip(); err != nil {
+			return err // Unreachable: forkHeight is above the pruned blocks
+		}
+	}
+	for _, blk := range branch {
+		if err := c.validateState(blk); err != nil {
+			for uint64(len(c.blocks)) > forkHeight {
+				if err := c.removeTip(); err != nil {
+					return err
+				}
+			}
+			for _, orphan := range orphans {
+				c.addBlock(orphan)
+			}
+			return err
+		}
+		c.addBlock(blk)
+	}
+	log.Printf("Reorg at height %d: new tip %d (%x)", forkHeight, parent.Header.Height, parent.Header.Hash)
+	return nil
+}
+
+// Replay discards every index and tally derived from the blocks and rebuilds
+// them by re-applying the chain from genesis through the tip, for recovery when
+// derived state is found to be wrong. The blocks themselves are kept and no
+// events are published. Every block's state is first re-validated on a scratch
+// chain; if one fails, the error is returned and the chain and its derived
+// state are left as they were. A pruned chain cannot be replayed.
+func (c *Chain) Replay() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.prunedTo > 0 {
+		return fmt.Errorf("%w: replay needs every block body, but blocks 1-%d are pruned", ErrPruned, c.prunedTo)
+	}
+
+	scratch, err := NewChain(c.blocks[0])
+	if err != nil {
+		return err
+	}
+	for _, blk := range c.blocks[1:] {
+		if err := scratch.validateState(blk); err != nil {
+			return fmt.Errorf("replay stopped at block %d: %w", blk.Header.Height, err)
+		}
+		scratch.applyBlock(blk)
+	}
+
+	// Every block validated, so re-applying them to the chain itself cannot fail
+	blocks := c.blocks
+	genesis := blocks[0]
+	c.blocks = []*Block{genesis}
+	c.byHash = map[string]*Block{fmt.Sprintf("%x", genesis.Header.Hash): genesis}
+	c.txHeight = make(map[string]uint64)
+	c.Tally.reset()
+	c.Validators.reset()
+	c.Candidates.reset()
+	c.Commitments.reset()
+	c.Fees.reset()
+	for _, blk := range blocks[1:] {
+		c.applyBlock(blk)
+	}
+	c.prune()
+	return nil
+}
+
+// Replay rebuilds the chain's derived state (see Chain.Replay). Mempool
+// transactions the rebuilt chain includes are dropped as their blocks are
+// re-applied.
+func (n *P2PNode) Replay() error {
+	start := time.Now()
+	if err := n.Chain.Replay(); err != nil {
+		log.Printf("Replay failed after %s: %v", time.Since(start), err)
+		return err
+	}
+	log.Printf("Replayed %d blocks in %s", n.Chain.Height(), time.Since(start))
+	return nil
+}
+
+// tallyResyncs counts reorgs onto a peer's branch after the chains, and with
+// them the tallies, were found to have diverged at the same height.
+var tallyResyncs = expvar.NewInt("tally_divergence_resyncs")
+
+// BranchWeight is the fork-choice weight of a branch: the summed stake, as of
+// each block's height, of the validators that proposed its blocks, saturating
+// at math.MaxUint64. Of two branches from the same fork the heavier one wins,
+// so a branch built by the expected leaders outweighs one a lone or late
+// proposer forked off.
+func (c *Chain) BranchWeight(branch []*Block) uint64 {
+	var weight uint64
+	for _, blk := range branch {
+		for _, v := range c.Validators.ValidatorsAt(blk.Header.Height) {
+			if !bytes.Equal(v.PubKey, blk.Header.Proposer) {
+				continue
+			}
+			sum, carry := bits.Add64(weight, v.Stake, 0)
+			if carry != 0 {
+				return math.MaxUint64
+			}
+			weight = sum
+		}
+	}
+	return weight
+}
+
+// prefersBranch reports whether theirs should replace ours, two branches from
+// the same fork: the heavier wins (see BranchWeight), and of equal weights the
+// one whose tip hash sorts lower, so both nodes agree which of them resyncs.
+func (c *Chain) prefersBranch(theirs, ours []*Block) bool {
+	tw, ow := c.BranchWeight(theirs), c.BranchWeight(ours)
+	if tw != ow {
+		return tw > ow
+	}
+	return bytes.Compare(theirs[len(theirs)-1].Header.Hash, ours[len(ours)-1].Header.Hash) < 0
+}
+
+// resyncIfDiverged checks whether the peer's block at our tip height differs
+// from ours, meaning our tallies differ too, e.g. because one side missed a
+// block and built on another. If so we locate the fork point, fetch the peer's
+// branch and reorg onto it if the fork-choice rule prefers it (see
+// prefersBranch); otherwise the peer resyncs onto ours.
+func (n *P2PNode) resyncIfDiverged(ctx context.Context, client NodeServiceClient, peerAddr string) error {
+	height := n.Chain.Height()
+	if height == 0 {
+		return nil
+	}
+	theirs, err := n.peerBlock(ctx, client, height)
+	if status.Code(err) == codes.NotFound {
+		return nil // Peer is behind us and will sync from us
+	}
+	if err != nil {
+		return fmt.Errorf("failed to fetch block %d from %s: %v", height, peerAddr, err)
+	}
+	ours, ok := n.Chain.Header(height)
+	if !ok || bytes.Equal(theirs.GetHeader().GetHash(), ours.Hash) {
+		return nil // Same chain, or our tip moved on
+	}
+
+	fork := height
+	for ; fork > 1; fork-- {
+		theirs, err := n.peerBlock(ctx, client, fork-1)
+		if err != nil {
+			return fmt.Errorf("failed to fetch block %d from %s: %v", fork-1, peerAddr, err)
+		}
+		if ours, ok := n.Chain.Header(fork - 1); ok && bytes.Equal(theirs.GetHeader().GetHash(), ours.Hash) {
+			break
+		}
+	}
+	var branch []*Block
+	for from := fork; from <= height; from = fork + uint64(len(branch)) {
+		reqCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+		resp, err := client.GetBlocks(reqCtx, &GetBlocksRequest{FromHeight: from, Count: uint64(height - from + 1)})
+		cancel()
+		if err != nil || len(resp.GetBlocks()) == 0 {
+			return fmt.Errorf("failed to fetch blocks %d+ from %s: %v", from, peerAddr, err)
+		}
+		n.notePeerTip(peerAddr, resp.GetTipHeight())
+		branch = append(branch, resp.GetBlocks()...)
+	}
+	var ourBranch []*Block
+	for h := fork; h <= height; h++ {
+		blk, ok := n.Chain.GetBlock(h)
+		if !ok {
+			return fmt.Errorf("%w: cannot weigh our branch from height %d", ErrPruned, fork)
+		}
+		ourBranch = append(ourBranch, blk)
+	}
+	if !n.Chain.prefersBranch(branch, ourBranch) {
+		return nil // Our branch wins; the peer resyncs onto it
+	}
+	if err := n.Chain.Reorg(branch); err != nil {
+		return fmt.Errorf("invalid branch from %s: %w", peerAddr, err)
+	}
+	tallyResyncs.Add(1)
+	log.Printf("Chain diverged from %s at height %d; resynced onto its branch", peerAddr, fork)
+	return nil
+}
+
+// peerBlock fetches the peer's block at height.
+func (n *P2PNode) peerBlock(ctx context.Context, client NodeServiceClient, height uint64) (*Block, error) {
+	reqCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	resp, err := client.GetBlock(reqCtx, &GetBlockRequest{Height: height})
+	if err != nil {
+		return nil, err
+	}
+	return resp.GetBlock(), nil
+}
+
+// --- Events ---
+
+// EventBus fans chain events out to subscribers. Sends never block the chain:
+// a subscriber whose buffer is full misses that event, so subscribers should
+// treat an event as a prompt to re-check chain state rather than as the state itself.
+type EventBus struct {
+	mu   sync.Mutex
+	subs map[int]chan *Block
+	next int
+}
+
+// NewEventBus creates an event bus with no subscribers.
+func NewEventBus() *EventBus {
+	return &EventBus{subs: make(map[int]chan *Block)}
+}
+
+// SubscribeBlocks returns a channel receiving each block added to the best
+// chain, and a function that unsubscribes and closes it.
+func (b *EventBus) SubscribeBlocks(buffer int) (<-chan *Block, func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	id := b.next
+	b.next++
+	ch := make(chan *Block, buffer)
+	b.subs[id] = ch
+	return ch, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		if _, ok := b.subs[id]; ok {
+			delete(b.subs, id)
+			close(ch)
+		}
+	}
+}
+
+// PublishBlock notifies every subscriber of blk.
+func (b *EventBus) PublishBlock(blk *Block) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, ch := range b.subs {
+		select {
+		case ch <- blk:
+		default:
+		}
+	}
+}
+
+// WaitForInclusion blocks until the transaction is in a block on the best chain
+// and returns that block's height, or returns ctx's error once it is done.
+func (n *P2PNode) WaitForInclusion(ctx context.Context, txHash []byte) (uint64, error) {
+	// Subscribe before checking so a block appended in between is not missed
+	blocks, unsubscribe := n.Chain.Events.SubscribeBlocks(16)
+	defer unsubscribe()
+
+	for {
+		if height, ok := n.Chain.TxHeight(txHash); ok {
+			return height, nil
+		}
+		select {
+		case <-blocks:
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		}
+	}
+}
+
+// --- Vote Tally ---
+
+// Tally counts votes per election and candidate over the blocks applied to it.
+// Elections are identified by the vote payload and counted independently, so
+// the same candidate ID in two elections never shares votes. Each vote
+// transaction counts once regardless of Amount; abstentions and spoiled
+// ballots are counted by ballot type only. Blocks are applied as they are
+// appended and reverted when a reorg orphans them, so the counts always match
+// the current best chain.
+type Tally struct {
+	mu        sync.RWMutex
+	elections map[string]*electionTally // election ID -> its counts
+	total     uint64
+}
+
+// electionTally holds the counts of one election.
+type electionTally struct {
+	votes   map[string]uint64     // candidate (tx recipient) -> valid votes
+	ballots map[BallotType]uint64 // ballot type -> votes, valid ones included
+	voters  map[string]uint64     // sender -> votes, so each voter counts once toward turnout
+	total   uint64
+}
+
+// NewTally creates an empty tally.
+func NewTally() *Tally {
+	return &Tally{elections: make(map[string]*electionTally)}
+}
+
+// applyBlock adds blk's votes.
+func (t *Tally) applyBlock(blk *Block) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, tx := range blk.Transactions {
+		if tx.GetKind() != TxKindVote {
+			continue
+		}
+		e := t.elections[string(tx.GetPayload())]
+		if e == nil {
+			e = &electionTally{votes: make(map[string]uint64), ballots: make(map[BallotType]uint64), voters: make(map[string]uint64)}
+			t.elections[string(tx.GetPayload())] = e
+		}
+		if tx.GetBallotType() == BallotValid {
+			e.votes[string(tx.GetRecipient())]++
+		}
+		e.ballots[tx.GetBallotType()]++
+		e.voters[string(tx.GetSender())]++
+		e.total++
+		t.total++
+	}
+}
+
+// reset empties the tally, e.g. before a replay.
+func (t *Tally) reset() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.elections = make(map[string]*electionTally)
+	t.total = 0
+}
+
+// revertBlock subtracts the votes of a previously applied blk.
+func (t *Tally) revertBlock(blk *Block) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, tx := range blk.Transactions {
+		if tx.GetKind() != TxKindVote {
+			continue
+		}
+		electionID := string(tx.GetPayload())
+		e := t.elections[electionID]
+		if tx.GetBallotType() == BallotValid {
+			candidate := string(tx.GetRecipient())
+			e.votes[candidate]--
+			if e.votes[candidate] == 0 {
+				delete(e.votes, candidate)
+			}
+		}
+		e.ballots[tx.GetBallotType()]--
+		if e.ballots[tx.GetBallotType()] == 0 {
+			delete(e.ballots, tx.GetBallotType())
+		}
+		voter := string(tx.GetSender())
+		e.voters[voter]--
+		if e.voters[voter] == 0 {
+			delete(e.voters, voter)
+		}
+		e.total--
+		if e.total == 0 {
+			delete(t.elections, electionID)
+		}
+		t.total--
+	}
+}
+
+// Elections returns the IDs of every election with at least one vote, sorted.
+func (t *Tally) Elections() []string {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	ids := make([]string, 0, len(t.elections))
+	for id := range t.elections {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// Counts returns the per-candidate vote counts summed over every election.
+// Use ElectionCounts for the results of one election.
+func (t *Tally) Counts() map[string]uint64 {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	counts := make(map[string]uint64)
+	for _, e := range t.elections {
+		for candidate, votes := range e.votes {
+			counts[candidate] += votes
+		}
+	}
+	return counts
+}
+
+// ElectionCounts returns a copy of the per-candidate vote counts of one election.
+func (t *Tally) ElectionCounts(electionID string) map[string]uint64 {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	counts := make(map[string]uint64)
+	if e := t.elections[electionID]; e != nil {
+		for candidate, votes := range e.votes {
+			counts[candidate] = votes
+		}
+	}
+	return counts
+}
+
+// Ballots returns the number of votes of each ballot type over every election.
+// Valid votes are the sum of Counts; the rest count towards turnout only.
+func (t *Tally) Ballots() map[BallotType]uint64 {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	counts := make(map[BallotType]uint64)
+	for _, e := range t.elections {
+		for ballot, votes := range e.ballots {
+			counts[ballot] += votes
+		}
+	}
+	return counts
+}
+
+// ElectionBallots returns the number of votes of each ballot type in one election.
+func (t *Tally) ElectionBallots(electionID string) map[BallotType]uint64 {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	counts := make(map[BallotType]uint64)
+	if e := t.elections[electionID]; e != nil {
+		for ballot, votes := range e.ballots {
+			counts[ballot] = votes
+		}
+	}
+	return counts
+}
+
+// Total returns the number of votes counted, of every ballot type, over every
+// election.
+func (t *Tally) Total() uint64 {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.total
+}
+
+// ElectionTotal returns the number of votes counted in one election, of every
+// ballot type.
+func (t *Tally) ElectionTotal(electionID string) uint64 {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if e := t.elections[electionID]; e != nil {
+		return e.total
+	}
+	return 0
+}
+
+// ElectionTurnout returns how many distinct voters have a vote in the election
+// on the best chain, whatever their ballot type.
+func (t *Tally) ElectionTurnout(electionID string) uint64 {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if e := t.elections[electionID]; e != nil {
+		return uint64(len(e.voters))
+	}
+	return 0
+}
+
+// HasVoted reports whether sender has a vote in electionID on the best chain.
+func (t *Tally) HasVoted(electionID string, sender []byte) bool {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	e := t.elections[electionID]
+	return e != nil && e.voters[string(sender)] > 0
+}
+
+// Elections returns the IDs of every election scheduled by the genesis block or
+// with a registered candidate, a vote commitment or a vote on the best chain,
+// sorted.
+func (c *Chain) Elections() []string {
+	ids := c.Tally.Elections()
+	for _, e := range c.ScheduledElections() {
+		if i, found := slices.BinarySearch(ids, e.ID); !found {
+			ids = slices.Insert(ids, i, e.ID)
+		}
+	}
+	for _, id := range slices.Concat(c.Candidates.Elections(), c.Commitments.Elections()) {
+		if i, found := slices.BinarySearch(ids, id); !found {
+			ids = slices.Insert(ids, i, id)
+		}
+	}
+	return ids
+}
+
+// TallyDigest recounts the votes on the best chain, restricted to electionID
+// unless it is empty, and returns a hash of the per-candidate counts with the
+// height counted to. Nodes at the same height with different digests have
+// diverged, so monitoring can compare digests across nodes.
+func (c *Chain) TallyDigest(electionID string) (uint64, []byte) {
+	c.mu.RLock()
+	// Pruned blocks have no transactions left to recount, so start from their votes
+	counts, ballots := c.prunedTally.Counts(), c.prunedTally.Ballots()
+	if electionID != "" {
+		counts, ballots = c.prunedTally.ElectionCounts(electionID), c.prunedTally.ElectionBallots(electionID)
+	}
+	for _, blk := range c.blocks {
+		for _, tx := range blk.Transactions {
+			if tx.GetKind() != TxKindVote || (electionID != "" && string(tx.GetPayload()) != electionID) {
+				continue
+			}
+			if tx.GetBallotType() == BallotValid {
+				counts[string(tx.GetRecipient())]++
+			}
+			ballots[tx.GetBallotType()]++
+		}
+	}
+	height := uint64(len(c.blocks) - 1)
+	c.mu.RUnlock()
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "tally|%q|%d\n", electionID, height)
+	for _, tally := range sortedTallies(counts) {
+		fmt.Fprintf(&b, "%q=%d\n", tally.Candidate, tally.Votes)
+	}
+	fmt.Fprintf(&b, "abstain=%d\nspoiled=%d\n", ballots[BallotAbstain], ballots[BallotSpoiled])
+	return height, c.hasher.Sum(b.Bytes())
+}
+
+// --- Block Import ---
+
+// SeenBlocksCapacity bounds how many recent block hashes a node remembers to
+// suppress duplicate deliveries. It only needs to outlast gossip of one block.
+const SeenBlocksCapacity = 1024
+
+// seenSet remembers up to capacity recent hashes, forgetting the oldest first.
+type seenSet struct {
+	mu       sync.Mutex
+	capacity int
+	hashes   map[string]struct{}
+	order    []string // Insertion order, oldest first
+}
+
+func newSeenSet(capacity int) *seenSet {
+	return &seenSet{capacity: capacity, hashes: make(map[string]struct{})}
+}
+
+// Add records hash and reports whether it was new.
+func (s *seenSet) Add(hash []byte) bool {
+	key := fmt.Sprintf("%x", hash)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.hashes[key]; ok {
+		return false
+	}
+	if len(s.order) >= s.capacity {
+		delete(s.hashes, s.order[0])
+		s.order = s.order[1:]
+	}
+	s.hashes[key] = struct{}{}
+	s.order = append(s.order, key)
+	return true
+}
+
+// Forget removes hash so a later delivery is treated as new.
+func (s *seenSet) Forget(hash []byte) {
+	key := fmt.Sprintf("%x", hash)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.hashes[key]; !ok {
+		return
+	}
+	delete(s.hashes, key)
+	for i, k := range s.order {
+		if k == key {
+			s.order = append(s.order[:i], s.order[i+1:]...)
+			break
+		}
+	}
+}
+
+// ImportBlocks appends blocks received from peers via SendBlock to the local
+// chain, drops their transactions from the mempool and re-broadcasts them,
+// standing in for the consensus engine on nodes run without one. SendBlock
+// admits each block hash once, so every block is relayed at most once per node.
+// Blocks ahead of the tip wait in the Orphans pool and are imported once their
+// parent is. Other blocks that do not extend the tip are dropped and
+// forgotten, so a copy delivered again once SyncWithPeer has recovered the gap
+// is still imported.
+// This method should be run in a goroutine and returns once Close closes BlockChan.
+func (n *P2PNode) ImportBlocks() {
+	for next := range n.BlockChan {
+		n.importBlocks([]*Block{next})
+	}
+}
+
+// importBlocks appends pending in order and relays each block imported.
+// Importing a block may resolve orphans waiting for it, which are imported in
+// turn.
+func (n *P2PNode) importBlocks(pending []*Block) {
+	for len(pending) > 0 {
+		blk := pending[0]
+		pending = pending[1:]
+		if err := n.Chain.AppendBlock(blk); err != nil {
+			log.Printf("Dropping block %x: %v", blk.GetHeader().GetHash(), err)
+			if errors.Is(err, ErrOrphanBlock) {
+				n.seenBlocks.Forget(blk.GetHeader().GetHash())
+			}
+			continue
+		}
+		if n.closing.Err() == nil {
+			n.BroadcastBlock(blk)
+		}
+		pending = append(pending, n.Orphans.Children(blk.GetHeader().GetHash())...)
+	}
+}
+
+// --- Block Sync ---
+
+// Limits on a single GetBlocks response. Whichever is hit first ends the batch.
+const (
+	MaxBlocksPerBatch = 100
+	MaxBatchBytes     = 4 << 20 // 4 MiB
+)
+
+// syncBatchSize is how many blocks SyncWithPeer requests per GetBlocks call.
+const syncBatchSize = 10
+
+// DefaultSyncStallTimeout is how long sync waits for a batch of blocks (or a
+// peer's status) before treating the peer as stalled.
+const DefaultSyncStallTimeout = 10 * time.Second
+
+// syncTipSlack is how far below the highest reported tip a peer may be and
+// still be preferred as a sync source for its lower latency. A few blocks
+// short are quickly fetched from the next source, while a slow link costs on
+// every batch.
+const syncTipSlack = syncBatchSize
+
+// syncFailovers counts sync sources abandoned for another peer after failing
+// or stalling.
+var syncFailovers = expvar.NewInt("sync_source_failovers")
+
+// GetBlock is a gRPC method that returns the block at the requested height.
+func (n *P2PNode) GetBlock(ctx context.Context, req *GetBlockRequest) (*GetBlockResponse, error) {
+	blk, ok := n.Chain.GetBlock(req.GetHeight())
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "no block at height %d", req.GetHeight())
+	}
+	return &GetBlockResponse{Block: blk}, nil
+}
+
+// GetBlocks is a gRPC method that returns up to Count consecutive blocks starting
+// at FromHeight, capped by MaxBlocksPerBatch and MaxBatchBytes. The response is
+// short (possibly empty) when the node's chain ends before the requested range
+// does, and always reports the node's tip so the caller knows where it ends.
+func (n *P2PNode) GetBlocks(ctx context.Context, req *GetBlocksRequest) (*GetBlocksResponse, error) {
+	count := req.GetCount()
+	if count == 0 || count > MaxBlocksPerBatch {
+		count = MaxBlocksPerBatch
+	}
+
+	resp := &GetBlocksResponse{TipHeight: n.Chain.Height()}
+	size := 0
+	for h := req.GetFromHeight(); h < req.GetFromHeight()+count; h++ {
+		blk, ok := n.Chain.GetBlock(h)
+		if !ok {
+			break // Peer asked past our tip
+		}
+		size += blk.Size()
+		if size > MaxBatchBytes && len(resp.Blocks) > 0 {
+			break // Always return at least one block so sync makes progress
+		}
+		resp.Blocks = append(resp.Blocks, blk)
+	}
+	return resp, nil
+}
+
+// GetStatus is a gRPC method that reports the node's tip, so that syncing peers
+// can choose where to download blocks from.
+func (n *P2PNode) GetStatus(ctx context.Context, req *GetStatusRequest) (*GetStatusResponse, error) {
+	tip := n.Chain.Tip()
+	return &GetStatusResponse{TipHeight: tip.Header.Height, TipHash: tip.Header.Hash, PrunedHeight: n.Chain.PrunedHeight()}, nil
+}
+
+// validateBlocksConcurrently runs ValidateBlockContents over blocks on up to
+// workers goroutines, returning each block's result by index.
+func (c *Chain) validateBlocksConcurrently(blocks []*Block, workers int) []error {
+	errs := make([]error, len(blocks))
+	sem := make(chan struct{}, max(workers, 1))
+	var wg sync.WaitGroup
+	for i, blk := range blocks {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, blk *Block) {
+			defer wg.Done()
+			errs[i] = c.ValidateBlockContents(blk)
+			<-sem
+		}(i, blk)
+	}
+	wg.Wait()
+	return errs
+}
+
+// SyncWithPeer downloads blocks above our tip from peerAddr in batches of
+// syncBatchSize. The contents of each batch are validated concurrently on up
+// to SyncWorkers goroutines, then the blocks are linked and appended strictly
+// in order, stopping at the first invalid one so the chain ends at the last
+// good block. A batch may be short (the peer has fewer blocks, or hit its size
+// cap), so it keeps requesting until it reaches the tip the peer reports with
+// each batch, or the peer returns no blocks at all.
+func (n *P2PNode) SyncWithPeer(ctx context.Context, peerAddr string) error {
+	client, ok := n.Peer(peerAddr)
+	if !ok {
+		return fmt.Errorf("not connected to peer %s", peerAddr)
+	}
+
+	for {
+		from := n.Chain.Height() + 1
+		reqCtx, cancel := context.WithTimeout(ctx, n.SyncStallTimeout)
+		resp, err := client.GetBlocks(reqCtx, &GetBlocksRequest{FromHeight: from, Count: syncBatchSize})
+		cancel()
+		if err != nil {
+			return fmt.Errorf("failed to fetch blocks %d+ from %s: %v", from, peerAddr, err)
+		}
+		n.notePeerTip(peerAddr, resp.GetTipHeight())
+
+		// Orphans waiting for a synced block are imported after the batch, so
+		// one that duplicates a later block in it does not break the sync;
+		// those that no longer extend the tip are dropped and forgotten
+		errs := n.Chain.validateBlocksConcurrently(resp.GetBlocks(), n.SyncWorkers)
+		var released []*Block
+		for i, blk := range resp.GetBlocks() {
+			err := errs[i]
+			if err == nil {
+				err = n.Chain.appendBlock(blk, true)
+			}
+			if err != nil {
+				n.importBlocks(released)
+				return fmt.Errorf("invalid block from %s: %w", peerAddr, err)
+			}
+			released = append(released, n.Orphans.Children(blk.GetHeader().GetHash())...)
+		}
+		n.importBlocks(released)
+		if len(resp.GetBlocks()) > 0 {
+			log.Printf("Synced blocks %d-%d from %s", from, n.Chain.Height(), peerAddr)
+		}
+		if len(resp.GetBlocks()) == 0 || n.Chain.Height() >= resp.GetTipHeight() {
+			if n.ResyncOnDivergence {
+				if err := n.resyncIfDiverged(ctx, client, peerAddr); err != nil {
+					return err
+				}
+			}
+			n.mu.Lock()
+			n.synced = true // Caught up with the peer's tip
+			n.mu.Unlock()
+			return nil
+		}
+	}
+}
+
+// SyncSource is a connected peer ahead of our tip, as measured by RankSyncSources.
+type SyncSource struct {
+	Addr      string
+	TipHeight uint64
+	Latency   time.Duration // Round trip of the GetStatus call
+}
+
+// RankSyncSources asks every connected peer for its tip and returns those
+// ahead of ours, best sync source first. Peers within syncTipSlack of the
+// highest tip come first, fastest first; the rest follow by tip height, then
+// latency. Peers that fail or do not answer within SyncStallTimeout are left out.
+func (n *P2PNode) RankSyncSources(ctx context.Context) []SyncSource {
+	n.mu.RLock()
+	peers := n.connectedPeers()
+	n.mu.RUnlock()
+
+	var (
+		mu      sync.Mutex
+		wg      sync.WaitGroup
+		sources []SyncSource
+	)
+	for addr, client := range peers {
+		wg.Add(1)
+		go func(addr string, client NodeServiceClient) {
+			defer wg.Done()
+			reqCtx, cancel := context.WithTimeout(ctx, n.SyncStallTimeout)
+			defer cancel()
+			start := time.Now()
+			resp, err := client.GetStatus(reqCtx, &GetStatusRequest{})
+			if err != nil {
+				log.Printf("Failed to get status from %s: %v", addr, err)
+				return
+			}
+			n.notePeerTip(addr, resp.GetTipHeight())
+			if resp.GetPrunedHeight() > n.Chain.Height() {
+				return // Pruned the blocks we are missing, so it cannot serve them
+			}
+			mu.Lock()
+			sources = append(sources, SyncSource{Addr: addr, TipHeight: resp.GetTipHeight(), Latency: time.Since(start)})
+			mu.Unlock()
+		}(addr, client)
+	}
+	wg.Wait()
+
+	height := n.Chain.Height()
+	sources = slices.DeleteFunc(sources, func(src SyncSource) bool { return src.TipHeight <= height })
+	var best uint64
+	for _, src := range sources {
+		best = max(best, src.TipHeight)
+	}
+	sort.Slice(sources, func(i, j int) bool {
+		a, b := sources[i], sources[j]
+		// Subtract rather than add, so a tip near math.MaxUint64 cannot wrap
+		aNear := a.TipHeight <= best && best-a.TipHeight <= syncTipSlack
+		bNear := b.TipHeight <= best && best-b.TipHeight <= syncTipSlack
+		switch {
+		case aNear != bNear:
+			return aNear
+		case aNear && a.Latency != b.Latency:
+			return a.Latency < b.Latency
+		case a.TipHeight != b.TipHeight:
+			return a.TipHeight > b.TipHeight
+		}
+		return a.Latency < b.Latency
+	})
+	return sources
+}
+
+// SyncFromBestPeer catches up from the peers ranked by RankSyncSources,
+// starting with the best. If a source fails or stalls, sync falls back to the
+// next one, and sources whose tip is still above ours after the first finishes
+// are used to fetch the remainder. It returns an error if no source could
+// bring us up to the highest tip reported.
+func (n *P2PNode) SyncFromBestPeer(ctx context.Context) error {
+	sources := n.RankSyncSources(ctx)
+	var best uint64
+	var errs []error
+	for _, src := range sources {
+		best = max(best, src.TipHeight)
+		if n.Chain.Height() >= src.TipHeight {
+			continue // Already caught up past this peer
+		}
+		err := n.SyncWithPeer(ctx, src.Addr)
+		if err == nil {
+			continue
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		syncFailovers.Add(1)
+		log.Printf("Sync from %s failed, falling back to another peer: %v", src.Addr, err)
+		errs = append(errs, err)
+	}
+	if height := n.Chain.Height(); height < best {
+		return fmt.Errorf("synced to height %d of %d: %w", height, best, errors.Join(errs...))
+	}
+	return nil
+}
@@ -0,0 +1,1067 @@
+// go_backend_chain_snippet_test.go
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"maps"
+	"math"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// newTestChain returns a chain on the default genesis keeping keep block
+// bodies below the tip, or every block if keep is 0.
+func newTestChain(t *testing.T, keep uint64) *Chain {
+	t.Helper()
+	c, err := NewChain(GenesisBlock(DefaultGenesisConfig()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.KeepBlocks = keep
+	return c
+}
+
+// testHash hashes data as the default chains in these tests do.
+func testHash(data []byte) []byte {
+	return SHA3Hasher{}.Sum(data)
+}
+
+// testVoterKey returns the key of test voter n, the same on every run.
+func testVoterKey(n int) ed25519.PrivateKey {
+	return ed25519.NewKeyFromSeed(bytes.Repeat([]byte{byte(n)}, ed25519.SeedSize))
+}
+
+// testVote returns a valid ballot for candidate in election, signed by test
+// voter n. Tests that change it afterwards sign it again with signTestTx.
+func testVote(election, candidate string, n int) *Transaction {
+	priv := testVoterKey(n)
+	tx := &Transaction{
+		Sender:    priv.Public().(ed25519.PublicKey),
+		Recipient: []byte(candidate),
+		Amount:    VoteAmount,
+		ChainId:   DefaultChainID,
+		Payload:   []byte(election),
+	}
+	signTestTx(tx, priv)
+	return tx
+}
+
+// signTestTx sets tx's hash and signature for its current contents, signed by priv.
+func signTestTx(tx *Transaction, priv ed25519.PrivateKey) {
+	tx.Hash = testHash(tx.SigningBytes())
+	tx.Signature = ed25519.Sign(priv, tx.SigningBytes())
+}
+
+// testBlock returns a block of txs on parent, timestamped one second after it
+// so test chains never run ahead of the clock.
+func testBlock(parent *Block, txs ...*Transaction) *Block {
+	header := &BlockHeader{
+		Version:       BlockVersion,
+		PrevBlockHash: parent.Header.Hash,
+		Timestamp:     parent.Header.Timestamp + 1,
+		Height:        parent.Header.Height + 1,
+		ChainId:       parent.Header.ChainId,
+		MerkleRoot:    ComputeMerkleRoot(SHA3Hasher{}, txs),
+	}
+	header.Hash = header.ComputeHash(SHA3Hasher{})
+	return &Block{Header: header, Transactions: txs}
+}
+
+// extendChain appends n blocks to c, the i-th carrying a vote for candidate
+// "a" in election "e", and returns them.
+func extendChain(t *testing.T, c *Chain, n int) []*Block {
+	t.Helper()
+	var blocks []*Block
+	for i := 0; i < n; i++ {
+		blk := testBlock(c.Tip(), testVote("e", "a", int(c.Height())+1))
+		if err := c.AppendBlock(blk); err != nil {
+			t.Fatal(err)
+		}
+		blocks = append(blocks, blk)
+	}
+	return blocks
+}
+
+func TestPrunedChainServesRecentBlocks(t *testing.T) {
+	archive, pruned := newTestChain(t, 0), newTestChain(t, 3)
+	for _, blk := range extendChain(t, archive, 6) {
+		if err := pruned.AppendBlock(blk); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := pruned.PrunedHeight(); got != 3 {
+		t.Fatalf("pruned to %d, want 3", got)
+	}
+	for h := uint64(0); h <= 6; h++ {
+		if _, ok := archive.GetBlock(h); !ok {
+			t.Errorf("archive chain lost block %d", h)
+		}
+		_, ok := pruned.GetBlock(h)
+		if want := h == 0 || h > 3; ok != want {
+			t.Errorf("pruned chain: GetBlock(%d) found %v, want %v", h, ok, want)
+		}
+		if _, ok := pruned.Header(h); !ok {
+			t.Errorf("pruned chain lost header %d", h)
+		}
+	}
+	_, archiveDigest := archive.TallyDigest("")
+	if _, digest := pruned.TallyDigest(""); !bytes.Equal(digest, archiveDigest) {
+		t.Error("pruning changed the tally digest")
+	}
+
+	node := NewP2PNode("127.0.0.1:0")
+	node.UseChain(pruned)
+	if _, err := node.GetBlock(context.Background(), &GetBlockRequest{Height: 2}); status.Code(err) != codes.NotFound {
+		t.Errorf("GetBlock of a pruned block: got %v, want NotFound", err)
+	}
+	if _, err := node.GetBlock(context.Background(), &GetBlockRequest{Height: 5}); err != nil {
+		t.Errorf("GetBlock of a kept block: %v", err)
+	}
+}
+
+func TestPrunedChainRollsBackFailedReorg(t *testing.T) {
+	c := newTestChain(t, 2)
+	old := extendChain(t, c, 4) // Blocks 1-2 pruned, 3-4 kept
+	_, digest := c.TallyDigest("")
+
+	// The branch outgrows the old tip, which would prune its first block,
+	// before its last block fails state validation with a duplicate vote
+	fork, _ := c.Header(2)
+	parent := &Block{Header: fork}
+	var branch []*Block
+	for i := 0; i < 3; i++ {
+		parent = testBlock(parent, testVote("e", "b", 100+i))
+		branch = append(branch, parent)
+	}
+	dup := testVote("e", "b", 200)
+	branch = append(branch, testBlock(parent, dup, dup))
+	if err := c.Reorg(branch); !errors.Is(err, ErrDuplicateVote) {
+		t.Fatalf("reorg: got %v, want ErrDuplicateVote", err)
+	}
+
+	if got := c.PrunedHeight(); got != 2 {
+		t.Errorf("pruned to %d after rollback, want 2", got)
+	}
+	for _, blk := range old[2:] {
+		got, ok := c.GetBlock(blk.Header.Height)
+		if !ok || !bytes.Equal(got.Header.Hash, blk.Header.Hash) {
+			t.Errorf("block %d not restored after rollback", blk.Header.Height)
+		}
+	}
+	if _, got := c.TallyDigest(""); !bytes.Equal(got, digest) {
+		t.Error("rolled-back branch left votes in the tally digest")
+	}
+	if votes := c.Tally.Counts()["b"]; votes != 0 {
+		t.Errorf("rolled-back branch left %d votes in the tally", votes)
+	}
+
+	// A valid branch reorgs and is pruned once it has committed
+	branch = branch[:3]
+	if err := c.Reorg(branch); err != nil {
+		t.Fatal(err)
+	}
+	if got := c.PrunedHeight(); got != 3 {
+		t.Errorf("pruned to %d after reorg to height 5, want 3", got)
+	}
+	if _, ok := c.GetBlock(3); ok {
+		t.Error("reorged chain kept block 3 past KeepBlocks")
+	}
+}
+
+func TestRemoveTipRefusesPrunedBlock(t *testing.T) {
+	c := newTestChain(t, 1)
+	extendChain(t, c, 3)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.removeTip(); err != nil {
+		t.Fatalf("removing a kept block: %v", err)
+	}
+	if err := c.removeTip(); !errors.Is(err, ErrPruned) {
+		t.Fatalf("removing a pruned block: got %v, want ErrPruned", err)
+	}
+}
+
+func TestReplayMatchesIncrementalState(t *testing.T) {
+	c := newTestChain(t, 0)
+	blocks := extendChain(t, c, 5)
+	want := c.Tally.Counts()
+
+	c.Tally.applyBlock(blocks[0]) // Count block 1's vote twice
+	if got := c.Tally.Counts()["a"]; got == want["a"] {
+		t.Fatal("corrupting the tally had no effect")
+	}
+	if err := c.Replay(); err != nil {
+		t.Fatal(err)
+	}
+	if got := c.Tally.Counts(); !maps.Equal(got, want) {
+		t.Errorf("replayed tally %v, want %v", got, want)
+	}
+
+	fresh := newTestChain(t, 0)
+	for _, blk := range blocks {
+		if err := fresh.AppendBlock(blk); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got, want := c.Tally.Counts(), fresh.Tally.Counts(); !maps.Equal(got, want) {
+		t.Errorf("replayed tally %v, fresh chain has %v", got, want)
+	}
+	for _, blk := range blocks {
+		for _, tx := range blk.Transactions {
+			if h, ok := c.TxHeight(tx.GetHash()); !ok || h != blk.Header.Height {
+				t.Errorf("transaction %x indexed at %d, %v after replay; want %d", tx.GetHash(), h, ok, blk.Header.Height)
+			}
+		}
+	}
+}
+
+func TestFailedReplayKeepsState(t *testing.T) {
+	c := newTestChain(t, 0)
+	blocks := extendChain(t, c, 3)
+
+	// Smuggle in a block re-including block 1's vote, as a derived-state bug
+	// might have let through; replay must notice and change nothing
+	bad := testBlock(c.Tip(), blocks[0].Transactions[0])
+	c.mu.Lock()
+	c.applyBlock(bad)
+	c.mu.Unlock()
+	counts := c.Tally.Counts()
+
+	if err := c.Replay(); !errors.Is(err, ErrDuplicateVote) {
+		t.Fatalf("replay: got %v, want ErrDuplicateVote", err)
+	}
+	if got := c.Height(); got != 4 {
+		t.Errorf("failed replay left height %d, want 4", got)
+	}
+	if got := c.Tally.Counts(); !maps.Equal(got, counts) {
+		t.Errorf("failed replay changed the tally from %v to %v", counts, got)
+	}
+	if _, ok := c.GetByHash(bad.Header.Hash); !ok {
+		t.Error("failed replay dropped the hash index")
+	}
+}
+
+func TestTallyDigestDiffersAcrossForks(t *testing.T) {
+	a, b := newTestChain(t, 0), newTestChain(t, 0)
+	shared := extendChain(t, a, 2)
+	for _, blk := range shared {
+		if err := b.AppendBlock(blk); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, da := a.TallyDigest("e"); !bytes.Equal(da, mustDigest(b, "e")) {
+		t.Fatal("identical chains have different digests")
+	}
+
+	// Same height, but a's block 3 and b's block 3 carry different votes
+	if err := a.AppendBlock(testBlock(a.Tip(), testVote("e", "a", 3))); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.AppendBlock(testBlock(b.Tip(), testVote("e", "b", 3))); err != nil {
+		t.Fatal(err)
+	}
+	ha, da := a.TallyDigest("e")
+	hb, db := b.TallyDigest("e")
+	if ha != hb {
+		t.Fatalf("heights %d and %d differ", ha, hb)
+	}
+	if bytes.Equal(da, db) {
+		t.Error("diverged chains at the same height have the same digest")
+	}
+}
+
+// mustDigest returns c's tally digest for electionID.
+func mustDigest(c *Chain, electionID string) []byte {
+	_, digest := c.TallyDigest(electionID)
+	return digest
+}
+
+func TestForkChoicePrefersHeavierBranch(t *testing.T) {
+	light, heavy := bytes.Repeat([]byte{1}, 32), bytes.Repeat([]byte{2}, 32)
+	cfg := DefaultGenesisConfig()
+	cfg.InitialValidators = [][]byte{light, heavy}
+	cfg.InitialStakes = []uint64{1, 10}
+	c, err := NewChain(GenesisBlock(cfg))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	proposed := func(parent *Block, proposer []byte) *Block {
+		blk := testBlock(parent)
+		blk.Header.Proposer = proposer
+		blk.Header.Hash = blk.Header.ComputeHash(SHA3Hasher{})
+		return blk
+	}
+	lightBranch := []*Block{proposed(c.Genesis(), light)}
+	heavyBranch := []*Block{proposed(c.Genesis(), heavy)}
+	if w := c.BranchWeight(heavyBranch); w != 10 {
+		t.Errorf("heavy branch weighs %d, want 10", w)
+	}
+	if !c.prefersBranch(heavyBranch, lightBranch) || c.prefersBranch(lightBranch, heavyBranch) {
+		t.Error("fork choice did not prefer the heavier branch")
+	}
+
+	// Equal weights fall back to the lower tip hash, the same on both sides
+	other := []*Block{proposed(lightBranch[0], heavy)}
+	same := []*Block{proposed(heavyBranch[0], light)}
+	if c.prefersBranch(other, same) == c.prefersBranch(same, other) {
+		t.Error("equal-weight branches are not ordered consistently")
+	}
+}
+
+func TestBallotTypesRoundTripAndTallySeparately(t *testing.T) {
+	c := newTestChain(t, 0)
+	var txs []*Transaction
+	for i, ballot := range []BallotType{BallotValid, BallotAbstain, BallotSpoiled} {
+		tx := testVote("e", "a", i+1)
+		if ballot != BallotValid {
+			tx.Recipient = nil
+		}
+		tx.BallotType = ballot
+		signTestTx(tx, testVoterKey(i+1))
+		decoded := &Transaction{}
+		if err := decoded.UnmarshalProto(tx.MarshalProto()); err != nil {
+			t.Fatal(err)
+		}
+		if decoded.GetBallotType() != ballot {
+			t.Fatalf("%v ballot decoded as %v", ballot, decoded.GetBallotType())
+		}
+		txs = append(txs, decoded)
+	}
+	if err := c.AppendBlock(testBlock(c.Tip(), txs...)); err != nil {
+		t.Fatal(err)
+	}
+	if got := c.Tally.ElectionCounts("e"); !maps.Equal(got, map[string]uint64{"a": 1}) {
+		t.Errorf("candidate counts %v, want only a=1", got)
+	}
+	ballots := c.Tally.ElectionBallots("e")
+	if ballots[BallotAbstain] != 1 || ballots[BallotSpoiled] != 1 {
+		t.Errorf("ballot counts %v, want one abstain and one spoiled", ballots)
+	}
+	if got := c.Tally.ElectionTurnout("e"); got != 3 {
+		t.Errorf("turnout %d, want 3", got)
+	}
+}
+
+func TestTurnoutCountsUniqueVotersAcrossReorgs(t *testing.T) {
+	c := newTestChain(t, 0)
+	genesis := c.Tip()
+	if err := c.AppendBlock(testBlock(genesis, testVote("t", "a", 1), testVote("t", "b", 2))); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.AppendBlock(testBlock(c.Tip(), testVote("t", "b", 1))); !errors.Is(err, ErrDuplicateVote) {
+		t.Fatalf("second vote from voter 1: got %v, want ErrDuplicateVote", err)
+	}
+	if got := c.Tally.ElectionTurnout("t"); got != 2 {
+		t.Errorf("turnout %d, want 2 after a rejected duplicate", got)
+	}
+
+	fork := testBlock(genesis, testVote("t", "a", 3))
+	if err := c.Reorg([]*Block{fork, testBlock(fork)}); err != nil {
+		t.Fatal(err)
+	}
+	if got := c.Tally.ElectionTurnout("t"); got != 1 {
+		t.Errorf("turnout %d after reorg, want 1", got)
+	}
+}
+
+func TestReorgTalliesOnlyTheWinningBranch(t *testing.T) {
+	c := newTestChain(t, 0)
+	extendChain(t, c, 1)
+	base := c.Tip()
+	if err := c.AppendBlock(testBlock(base, testVote("e", "a", 2), testVote("e", "a", 3))); err != nil {
+		t.Fatal(err)
+	}
+	if got := c.Tally.ElectionCounts("e"); !maps.Equal(got, map[string]uint64{"a": 3}) {
+		t.Fatalf("tally %v before the reorg, want a=3", got)
+	}
+
+	// Replace the top block with one where voter 2 chose b and voter 3 did not vote
+	fork := testBlock(base, testVote("e", "b", 2))
+	if err := c.Reorg([]*Block{fork, testBlock(fork)}); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := c.Tally.ElectionCounts("e"), map[string]uint64{"a": 1, "b": 1}; !maps.Equal(got, want) {
+		t.Errorf("tally %v after the reorg, want %v", got, want)
+	}
+	if got := c.Tally.ElectionTotal("e"); got != 2 {
+		t.Errorf("total %d after the reorg, want 2", got)
+	}
+}
+
+func TestWireVersionsDecodeKnownAndRejectUnknown(t *testing.T) {
+	legacy := testVote("e", "a", 1)
+	versioned := testVote("e", "a", 2)
+	versioned.Version = TxVersion
+	for _, tx := range []*Transaction{legacy, versioned} {
+		var got Transaction
+		if err := got.UnmarshalProto(tx.MarshalProto()); err != nil {
+			t.Fatalf("version %d: %v", tx.Version, err)
+		}
+		if got.Version != tx.Version || !bytes.Equal(got.SigningBytes(), tx.SigningBytes()) {
+			t.Errorf("version %d decoded as version %d with different signing bytes", tx.Version, got.Version)
+		}
+	}
+	if bytes.Equal(legacy.SigningBytes(), (&Transaction{Sender: legacy.Sender, Recipient: legacy.Recipient, Amount: legacy.Amount,
+		ChainId: legacy.ChainId, Payload: legacy.Payload, Version: TxVersion}).SigningBytes()) {
+		t.Error("Version is not covered by the signature")
+	}
+
+	future := testVote("e", "a", 3)
+	future.Version = TxVersion + 1
+	var tx Transaction
+	if err := tx.UnmarshalProto(future.MarshalProto()); !errors.Is(err, ErrUnknownVersion) {
+		t.Errorf("transaction version %d: got %v, want ErrUnknownVersion", future.Version, err)
+	}
+	c := newTestChain(t, 0)
+	blk := testBlock(c.Tip(), versioned)
+	var decoded Block
+	if err := decoded.UnmarshalProto(blk.MarshalProto()); err != nil || !bytes.Equal(decoded.Header.Hash, blk.Header.Hash) {
+		t.Fatalf("block version %d: %v", blk.Header.Version, err)
+	}
+	if err := decoded.UnmarshalProto(testBlock(c.Tip(), future).MarshalProto()); !errors.Is(err, ErrUnknownVersion) {
+		t.Errorf("block carrying a future transaction: got %v, want ErrUnknownVersion", err)
+	}
+	blk.Header.Version = BlockVersion + 1
+	if err := decoded.UnmarshalProto(blk.MarshalProto()); !errors.Is(err, ErrUnknownVersion) {
+		t.Errorf("block version %d: got %v, want ErrUnknownVersion", blk.Header.Version, err)
+	}
+	if err := decoded.UnmarshalProto(blk.MarshalProto()[:10]); err == nil {
+		t.Error("truncated block decoded without error")
+	}
+}
+
+func TestValidationErrorsAreTyped(t *testing.T) {
+	c := newTestChain(t, 0)
+	extendChain(t, c, 1) // Voter 1 has voted in election "e"
+	tip := c.Tip()
+	relink := func(blk *Block) *Block {
+		blk.Header.MerkleRoot = ComputeMerkleRoot(SHA3Hasher{}, blk.Transactions)
+		blk.Header.Hash = blk.Header.ComputeHash(SHA3Hasher{})
+		return blk
+	}
+
+	badAmount := testVote("e", "a", 2)
+	badAmount.Amount = 2
+	unknownKind := testVote("e", "a", 2)
+	unknownKind.Kind = TxKindVoteCommit + 1
+	farBlock := testBlock(tip, testVote("e", "a", 2))
+	farBlock.Header.Height++
+	otherChain := testBlock(tip, testVote("e", "a", 2))
+	otherChain.Header.ChainId = "other"
+	badRoot := testBlock(tip, testVote("e", "a", 2))
+	badRoot.Header.MerkleRoot = bytes.Repeat([]byte{1}, 32)
+	badRoot.Header.Hash = badRoot.Header.ComputeHash(SHA3Hasher{})
+	badHash := testBlock(tip, testVote("e", "a", 2))
+	badHash.Header.Hash = bytes.Repeat([]byte{1}, 32)
+
+	// Voter 1 again, in a transaction with a different hash
+	revote := testVote("e", "b", 1)
+	twice := []*Transaction{testVote("e", "a", 3), testVote("e", "b", 3)}
+
+	for _, tt := range []struct {
+		name string
+		blk  *Block
+		want error
+	}{
+		{"missing header", &Block{}, ErrMalformedBlock},
+		{"vote amount", relink(testBlock(tip, badAmount)), ErrBadVoteAmount},
+		{"transaction kind", relink(testBlock(tip, unknownKind)), ErrUnknownTxKind},
+		{"height gap", relink(farBlock), ErrOrphanBlock},
+		{"chain ID", relink(otherChain), ErrWrongChain},
+		{"merkle root", badRoot, ErrBadMerkleRoot},
+		{"header hash", badHash, ErrBadBlockHash},
+		{"voter already on chain", testBlock(tip, revote), ErrDuplicateVote},
+		{"voter twice in block", testBlock(tip, twice...), ErrDuplicateVote},
+	} {
+		if err := c.AppendBlock(tt.blk); !errors.Is(err, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, err, tt.want)
+		}
+	}
+
+	c.MaxTxPerBlock = 1
+	if err := c.checkBlockLimits(testBlock(tip, twice...)); !errors.Is(err, ErrBlockTooLarge) {
+		t.Errorf("block limits: got %v, want ErrBlockTooLarge", err)
+	}
+	// The same voter may still vote in another election
+	if err := c.checkElectionTx(testVote("f", "a", 1), nil); err != nil {
+		t.Errorf("voter in a second election: %v", err)
+	}
+}
+
+func TestDuplicateCandidateAndClosedElectionErrors(t *testing.T) {
+	pub, priv, _ := ed25519.GenerateKey(nil)
+	cfg := DefaultGenesisConfig()
+	cfg.AuthorityKey = pub
+	cfg.RevealStart, cfg.RevealEnd = 1, 3
+	c, err := NewChain(GenesisBlock(cfg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	reg := &CandidateRegistration{ElectionID: "e", CandidateID: "a"}
+	tx := &Transaction{Kind: TxKindRegisterCandidate, Sender: pub, ChainId: DefaultChainID, Payload: reg.MarshalProto()}
+	tx.Hash = testHash(tx.SigningBytes())
+	tx.Signature = ed25519.Sign(priv, tx.SigningBytes())
+	if err := c.AppendBlock(testBlock(c.Tip(), tx)); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.checkElectionTx(tx, nil); !errors.Is(err, ErrDuplicateCandidate) || errors.Is(err, ErrMalformedTx) {
+		t.Errorf("second registration: got %v, want only ErrDuplicateCandidate", err)
+	}
+	if err := c.checkCommitReveal(testVote("e", "a", 1), 3, nil); !errors.Is(err, ErrElectionClosed) {
+		t.Errorf("reveal after RevealEnd: got %v, want ErrElectionClosed", err)
+	}
+	if err := c.checkCommitReveal(testVote("e", "a", 1), 2, nil); errors.Is(err, ErrElectionClosed) {
+		t.Errorf("reveal within the window reported a closed election: %v", err)
+	}
+}
+
+// syncPeer serves blocks from chain after delay, reporting tip as its height
+// if set, or stalls until the request is cancelled if stall is set. It sends
+// at most maxBatch blocks per GetBlocks call if set, and records each request.
+type syncPeer struct {
+	mockNodeServiceClient
+	chain    *Chain
+	tip      uint64
+	delay    time.Duration
+	stall    bool
+	maxBatch uint64
+	requests []*GetBlocksRequest
+}
+
+func (p *syncPeer) GetStatus(ctx context.Context, in *GetStatusRequest, opts ...grpc.CallOption) (*GetStatusResponse, error) {
+	time.Sleep(p.delay)
+	if p.tip != 0 {
+		return &GetStatusResponse{TipHeight: p.tip}, nil
+	}
+	return &GetStatusResponse{TipHeight: p.chain.Height()}, nil
+}
+
+func (p *syncPeer) GetBlocks(ctx context.Context, in *GetBlocksRequest, opts ...grpc.CallOption) (*GetBlocksResponse, error) {
+	if p.stall {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	p.requests = append(p.requests, in)
+	count := in.Count
+	if p.maxBatch != 0 {
+		count = min(count, p.maxBatch)
+	}
+	resp := &GetBlocksResponse{TipHeight: p.chain.Height()}
+	for h := in.FromHeight; h < in.FromHeight+count; h++ {
+		blk, ok := p.chain.GetBlock(h)
+		if !ok {
+			break
+		}
+		resp.Blocks = append(resp.Blocks, blk)
+	}
+	return resp, nil
+}
+
+// addSyncPeers connects node to each of peers, at "peer-<i>".
+func addSyncPeers(node *P2PNode, peers ...*syncPeer) {
+	for i, p := range peers {
+		node.peers[fmt.Sprintf("peer-%d", i)] = &peerState{state: PeerConnected, client: p}
+	}
+}
+
+func TestSyncFetchesInBatches(t *testing.T) {
+	source := newTestChain(t, 0)
+	extendChain(t, source, 50)
+	node := NewP2PNode("127.0.0.1:0")
+	peer := &syncPeer{chain: source}
+	addSyncPeers(node, peer)
+	if err := node.SyncWithPeer(context.Background(), "peer-0"); err != nil {
+		t.Fatal(err)
+	}
+	if got := node.Chain.Height(); got != 50 {
+		t.Fatalf("synced to height %d, want 50", got)
+	}
+	var from []uint64
+	for _, req := range peer.requests {
+		if req.Count != syncBatchSize {
+			t.Errorf("requested %d blocks, want batches of %d", req.Count, syncBatchSize)
+		}
+		from = append(from, req.FromHeight)
+	}
+	if want := []uint64{1, 11, 21, 31, 41}; !slices.Equal(from, want) {
+		t.Errorf("requested batches from %v, want %v", from, want)
+	}
+
+	// A peer that answers short is asked again from where its batch ended
+	node = NewP2PNode("127.0.0.1:0")
+	peer = &syncPeer{chain: source, maxBatch: 7}
+	addSyncPeers(node, peer)
+	if err := node.SyncWithPeer(context.Background(), "peer-0"); err != nil {
+		t.Fatal(err)
+	}
+	if got := node.Chain.Height(); got != 50 || len(peer.requests) != 8 {
+		t.Errorf("short batches: synced to %d in %d requests, want 50 in 8", got, len(peer.requests))
+	}
+}
+
+func TestGetBlocksPastTipReturnsShortBatch(t *testing.T) {
+	server := NewP2PNode("127.0.0.1:0")
+	extendChain(t, server.Chain, 5)
+	for _, from := range []uint64{3, 6, 100} {
+		resp, err := server.GetBlocks(context.Background(), &GetBlocksRequest{FromHeight: from, Count: syncBatchSize})
+		if err != nil {
+			t.Fatalf("from %d: %v", from, err)
+		}
+		want := 0
+		if from <= 5 {
+			want = int(5 - from + 1)
+		}
+		if len(resp.Blocks) != want || resp.TipHeight != 5 {
+			t.Errorf("from %d: %d blocks, tip %d; want %d blocks, tip 5", from, len(resp.Blocks), resp.TipHeight, want)
+		}
+	}
+
+	// The syncing side stops at that tip rather than waiting for a full batch
+	node := NewP2PNode("127.0.0.1:0")
+	peer := &syncPeer{chain: server.Chain}
+	addSyncPeers(node, peer)
+	if err := node.SyncWithPeer(context.Background(), "peer-0"); err != nil {
+		t.Fatal(err)
+	}
+	if node.Chain.Height() != 5 || len(peer.requests) != 1 {
+		t.Errorf("synced to %d in %d requests, want 5 in one", node.Chain.Height(), len(peer.requests))
+	}
+}
+
+func TestSyncValidatesInParallelButAppendsInOrder(t *testing.T) {
+	source := newTestChain(t, 0)
+	extendChain(t, source, 25)
+	sync := func(workers int) (*P2PNode, error) {
+		node := NewP2PNode("127.0.0.1:0")
+		node.SyncWorkers = workers
+		addSyncPeers(node, &syncPeer{chain: source})
+		return node, node.SyncWithPeer(context.Background(), "peer-0")
+	}
+
+	serial, err := sync(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parallel, err := sync(8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(serial.Chain.Tip().Header.Hash, source.Tip().Header.Hash) ||
+		!bytes.Equal(parallel.Chain.Tip().Header.Hash, source.Tip().Header.Hash) {
+		t.Fatalf("synced tips at %d and %d, want both at the source tip %d", serial.Chain.Height(), parallel.Chain.Height(), source.Height())
+	}
+	if got, want := parallel.Chain.Tally.Counts(), serial.Chain.Tally.Counts(); !maps.Equal(got, want) || want["a"] != 25 {
+		t.Errorf("tallies %v and %v, want 25 votes for a in both", want, got)
+	}
+
+	// A bad block mid-batch stops the append at the block before it
+	bad, _ := source.GetBlock(17)
+	bad.Transactions[0].Amount = 2 * VoteAmount
+	for _, workers := range []int{1, 8} {
+		node, err := sync(workers)
+		if err == nil {
+			t.Errorf("%d workers: synced past a tampered block", workers)
+		}
+		if got := node.Chain.Height(); got != 16 {
+			t.Errorf("%d workers: synced to %d, want 16", workers, got)
+		}
+	}
+}
+
+func TestRankSyncSourcesPrefersFastPeersNearTheTip(t *testing.T) {
+	const best = syncTipSlack + 10
+	node := NewP2PNode("127.0.0.1:0")
+	addSyncPeers(node,
+		&syncPeer{tip: best, delay: 30 * time.Millisecond}, // Best tip, slow
+		&syncPeer{tip: best - 1},                           // Near the tip, fast
+		&syncPeer{tip: best - syncTipSlack - 1},            // Fast but far behind
+	)
+	var order []string
+	for _, src := range node.RankSyncSources(context.Background()) {
+		order = append(order, src.Addr)
+	}
+	if want := []string{"peer-1", "peer-0", "peer-2"}; !slices.Equal(order, want) {
+		t.Errorf("ranked %v, want %v", order, want)
+	}
+}
+
+func TestRankSyncSourcesNearMaxTip(t *testing.T) {
+	node := NewP2PNode("127.0.0.1:0")
+	addSyncPeers(node,
+		&syncPeer{tip: math.MaxUint64, delay: 30 * time.Millisecond},
+		&syncPeer{tip: math.MaxUint64 - 1},
+	)
+	sources := node.RankSyncSources(context.Background())
+	if len(sources) != 2 || sources[0].Addr != "peer-1" {
+		t.Errorf("ranked %+v, want the fast peer one block behind first", sources)
+	}
+}
+
+func TestSyncFailsOverFromStallingSource(t *testing.T) {
+	source := newTestChain(t, 0)
+	extendChain(t, source, 5)
+	node := NewP2PNode("127.0.0.1:0")
+	node.SyncStallTimeout = 50 * time.Millisecond
+	addSyncPeers(node,
+		&syncPeer{chain: source, stall: true}, // Ranked first: fast, but never sends blocks
+		&syncPeer{chain: source, delay: 20 * time.Millisecond},
+	)
+	if err := node.SyncFromBestPeer(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got := node.Chain.Height(); got != 5 {
+		t.Errorf("synced to height %d, want 5", got)
+	}
+}
+
+func TestCandidateWhitelistEnforcedUnlessAllowed(t *testing.T) {
+	authority, _, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zero, err := NewChain(GenesisBlock(GenesisConfig{ChainID: DefaultChainID, AuthorityKey: authority}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !zero.EnforcesCandidateWhitelist() {
+		t.Error("a config that does not mention the whitelist turned it off")
+	}
+
+	for _, allowAny := range []bool{false, true} {
+		cfg := DefaultGenesisConfig()
+		cfg.AuthorityKey, cfg.AllowAnyCandidate = authority, allowAny
+		c, err := NewChain(GenesisBlock(cfg))
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = c.AppendBlock(testBlock(c.Tip(), testVote("e", "unregistered", 1)))
+		if allowAny && err != nil {
+			t.Errorf("AllowAnyCandidate: vote for an unregistered candidate refused: %v", err)
+		}
+		if !allowAny && !errors.Is(err, ErrUnknownCandidate) {
+			t.Errorf("whitelist enforced: got %v, want ErrUnknownCandidate", err)
+		}
+	}
+}
+
+// countingHasher is SHA3-256 over a prefix, counting its calls, so hashes made
+// through it are told apart from the default.
+type countingHasher struct{ calls int }
+
+func (*countingHasher) Name() string { return "counting" }
+
+func (h *countingHasher) Sum(data []byte) []byte {
+	h.calls++
+	return SHA3Hasher{}.Sum(append([]byte("counting|"), data...))
+}
+
+func TestHashingGoesThroughChainHasher(t *testing.T) {
+	h := &countingHasher{}
+	hashers[h.Name()] = h
+	t.Cleanup(func() { delete(hashers, h.Name()) })
+	cfg := DefaultGenesisConfig()
+	cfg.HashAlgorithm = "md5"
+	if _, err := NewChain(GenesisBlock(cfg)); err == nil {
+		t.Error("NewChain accepted a genesis naming an unknown hash algorithm")
+	}
+
+	// Two chains in one process, hashing differently
+	cfg.InitialValidators = [][]byte{bytes.Repeat([]byte{1}, ed25519.PublicKeySize)}
+	cfg.HashAlgorithm = ""
+	plain, err := NewChain(GenesisBlock(cfg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.HashAlgorithm = h.Name()
+	counting, err := NewChain(GenesisBlock(cfg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if counting.Hasher() != h || plain.Hasher() != (SHA3Hasher{}) {
+		t.Fatalf("hashers %v and %v, want the counting one and SHA3", counting.Hasher(), plain.Hasher())
+	}
+	if bytes.Equal(counting.Genesis().Header.Hash, plain.Genesis().Header.Hash) {
+		t.Error("genesis hash does not depend on the hash algorithm")
+	}
+	if again, _ := NewChain(GenesisBlock(cfg)); !bytes.Equal(again.Genesis().Header.Hash, counting.Genesis().Header.Hash) {
+		t.Error("the same genesis config hashed to two genesis hashes")
+	}
+
+	txs := []*Transaction{testVote("e", "a", 1), testVote("e", "a", 2)}
+	for name, hash := range map[string]func(c *Chain){
+		"proposal":         func(c *Chain) { c.ProposeBlock(nil, txs) },
+		"tally digest":     func(c *Chain) { c.TallyDigest("") },
+		"leader selection": func(c *Chain) { c.Validators.LeaderForHeight(1, c.Tip().Header.Hash) },
+	} {
+		calls := h.calls
+		hash(plain)
+		if h.calls != calls {
+			t.Errorf("%s on the SHA3 chain used the other chain's hasher", name)
+		}
+		hash(counting)
+		if h.calls == calls {
+			t.Errorf("%s hashed without the chain's hasher", name)
+		}
+	}
+
+	for _, tx := range txs {
+		tx.Hash = counting.Hasher().Sum(tx.SigningBytes()) // As the other chain's clients hash them
+	}
+	blk := counting.ProposeBlock(nil, txs)
+	if err := counting.ValidateBlockContents(blk); err != nil {
+		t.Errorf("chain refused its own proposal: %v", err)
+	}
+	if err := plain.ValidateBlockContents(blk); !errors.Is(err, ErrBadTxHash) {
+		t.Errorf("SHA3 chain validated a block hashed otherwise: got %v, want ErrBadTxHash", err)
+	}
+}
+
+func TestBlockVerificationReportsFirstBadTransaction(t *testing.T) {
+	var txs []*Transaction
+	for i := 1; i <= 40; i++ {
+		txs = append(txs, testVote("e", "a", i))
+	}
+	txs[30].Signature[0] ^= 0xff
+	txs[12].Signature[0] ^= 0xff
+	for _, workers := range []int{1, 8} {
+		c := newTestChain(t, 0)
+		c.VerifyWorkers = workers
+		blk := testBlock(c.Tip(), txs...)
+		err := c.ValidateBlockContents(blk)
+		if !errors.Is(err, ErrBadSignature) || !strings.Contains(err.Error(), fmt.Sprintf("%x", txs[12].Hash)) {
+			t.Errorf("%d workers: got %v, want ErrBadSignature for transaction %x", workers, err, txs[12].Hash)
+		}
+	}
+}
+
+func TestProposeBlockStaysUnderMaxBlockBytes(t *testing.T) {
+	c := newTestChain(t, 0)
+	c.MaxBlockBytes = 2048
+	var txs []*Transaction
+	for i := 1; i <= 50; i++ {
+		txs = append(txs, testVote("e", "a", i))
+	}
+	blk := c.ProposeBlock(nil, txs)
+	if size := blk.Size(); size > c.MaxBlockBytes {
+		t.Fatalf("proposed a %d-byte block, limit is %d", size, c.MaxBlockBytes)
+	}
+	if len(blk.Transactions) == 0 || len(blk.Transactions) == len(txs) {
+		t.Fatalf("proposed %d of %d transactions, want some left for a later block", len(blk.Transactions), len(txs))
+	}
+	if next := blk.Size() + len(txs[len(blk.Transactions)].MarshalProto()); next <= c.MaxBlockBytes {
+		t.Errorf("block of %d bytes had room for another transaction", blk.Size())
+	}
+
+	full := testBlock(c.Tip(), txs...)
+	if err := c.AppendBlock(full); !errors.Is(err, ErrBlockTooLarge) {
+		t.Errorf("appending a %d-byte block: got %v, want ErrBlockTooLarge", full.Size(), err)
+	}
+}
+
+func TestProposeBlockSkipsWhatAppendRejects(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := DefaultGenesisConfig()
+	cfg.AuthorityKey = pub
+	c, err := NewChain(GenesisBlock(cfg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.AppendBlock(testBlock(c.Tip(), candidateTx(priv, "e", "a"), testVote("e", "a", 1))); err != nil {
+		t.Fatal(err)
+	}
+
+	again := testVote("e", "a", 2)
+	again.Recipient, again.BallotType = nil, BallotAbstain
+	signTestTx(again, testVoterKey(2))
+	pending := []*Transaction{
+		testVote("e", "a", 1), // Voted on chain
+		testVote("e", "a", 2),
+		again,                 // Voted earlier in this block
+		testVote("e", "z", 3), // Unknown candidate
+		validatorChangeTx(&ValidatorChange{Op: ValidatorAdd, PubKey: pub, ActivationHeight: 100}), // Unapproved
+		candidateTx(priv, "e", "b"),
+		testVote("e", "b", 4), // Candidate registered earlier in this block
+	}
+	blk := c.ProposeBlock(nil, pending)
+	if want := []*Transaction{pending[1], pending[5], pending[6]}; !slices.Equal(blk.Transactions, want) {
+		t.Errorf("proposed %d transactions, want %d", len(blk.Transactions), len(want))
+	}
+	if err := c.AppendBlock(blk); err != nil {
+		t.Errorf("own proposal rejected: %v", err)
+	}
+}
+
+func TestWaitForInclusionReturnsHeight(t *testing.T) {
+	node := newTestNode(t)
+	tx := testVote("e", "b", 5)
+	type result struct {
+		height uint64
+		err    error
+	}
+	done := make(chan result, 1)
+	go func() {
+		height, err := node.WaitForInclusion(context.Background(), tx.Hash)
+		done <- result{height, err}
+	}()
+
+	extendChain(t, node.Chain, 1) // A block without the transaction
+	select {
+	case r := <-done:
+		t.Fatalf("waiter returned %d, %v before the transaction was included", r.height, r.err)
+	case <-time.After(20 * time.Millisecond):
+	}
+	if err := node.Chain.AppendBlock(testBlock(node.Chain.Tip(), tx)); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case r := <-done:
+		if r.err != nil || r.height != 2 {
+			t.Errorf("waiter returned %d, %v; want height 2", r.height, r.err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("waiter still blocked after the transaction was included")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := node.WaitForInclusion(ctx, testVote("e", "a", 9).Hash); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("waiting for a transaction never included: got %v, want DeadlineExceeded", err)
+	}
+}
+
+// candidateTx returns a registration of candidate in election signed by priv.
+func candidateTx(priv ed25519.PrivateKey, election, candidate string) *Transaction {
+	reg := &CandidateRegistration{ElectionID: election, CandidateID: candidate}
+	tx := &Transaction{Kind: TxKindRegisterCandidate, Sender: priv.Public().(ed25519.PublicKey), ChainId: DefaultChainID, Payload: reg.MarshalProto()}
+	tx.Hash = testHash(tx.SigningBytes())
+	tx.Signature = ed25519.Sign(priv, tx.SigningBytes())
+	return tx
+}
+
+func TestVotesNeedRegisteredCandidates(t *testing.T) {
+	pub, priv, _ := ed25519.GenerateKey(nil)
+	_, impostor, _ := ed25519.GenerateKey(nil)
+	cfg := DefaultGenesisConfig()
+	cfg.AuthorityKey = pub
+	c, err := NewChain(GenesisBlock(cfg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.AppendBlock(testBlock(c.Tip(), candidateTx(impostor, "e", "a"))); !errors.Is(err, ErrNotAuthorized) {
+		t.Fatalf("registration not from the authority: got %v, want ErrNotAuthorized", err)
+	}
+	if err := c.AppendBlock(testBlock(c.Tip(), candidateTx(priv, "e", "a"))); err != nil {
+		t.Fatal(err)
+	}
+	if got, ok := c.Candidates.Lookup("e", "a"); !ok || got == nil {
+		t.Fatal("registered candidate not found")
+	}
+
+	for _, tt := range []struct {
+		name string
+		vote *Transaction
+	}{
+		{"unregistered candidate", testVote("e", "b", 1)},
+		{"candidate registered in another election", testVote("f", "a", 1)},
+	} {
+		if err := c.AppendBlock(testBlock(c.Tip(), tt.vote)); !errors.Is(err, ErrUnknownCandidate) {
+			t.Errorf("%s: got %v, want ErrUnknownCandidate", tt.name, err)
+		}
+	}
+	if err := c.AppendBlock(testBlock(c.Tip(), testVote("e", "a", 1))); err != nil {
+		t.Errorf("vote for a registered candidate: %v", err)
+	}
+	// A registration earlier in the same block counts
+	if err := c.AppendBlock(testBlock(c.Tip(), candidateTx(priv, "e", "b"), testVote("e", "b", 2))); err != nil {
+		t.Errorf("vote after its candidate's registration in one block: %v", err)
+	}
+	if got := c.Tally.ElectionCounts("e"); !maps.Equal(got, map[string]uint64{"a": 1, "b": 1}) {
+		t.Errorf("tally %v, want a=1 b=1", got)
+	}
+}
+
+func TestBlockTimestampsMustAdvanceAndNotRunAhead(t *testing.T) {
+	c := newTestChain(t, 0)
+	now := time.Unix(1_700_000_000, 0)
+	c.Clock = func() time.Time { return now }
+	parent := testBlock(c.Tip())
+	parent.Header.Timestamp = uint64(now.Unix())
+	parent.Header.Hash = parent.Header.ComputeHash(SHA3Hasher{})
+	if err := c.AppendBlock(parent); err != nil {
+		t.Fatal(err)
+	}
+
+	retimed := func(ts time.Time) *Block {
+		blk := testBlock(parent, testVote("e", "a", 1))
+		blk.Header.Timestamp = uint64(ts.Unix())
+		blk.Header.Hash = blk.Header.ComputeHash(SHA3Hasher{})
+		return blk
+	}
+	for name, ts := range map[string]time.Time{
+		"before parent":  now.Add(-time.Second),
+		"same as parent": now,
+		"too far ahead":  now.Add(c.MaxBlockDrift + time.Minute),
+	} {
+		if err := c.AppendBlock(retimed(ts)); !errors.Is(err, ErrBadBlockTime) {
+			t.Errorf("%s: got %v, want ErrBadBlockTime", name, err)
+		}
+	}
+
+	// A block from the future is accepted once local time catches up
+	ahead := retimed(now.Add(c.MaxBlockDrift + time.Minute))
+	now = now.Add(2 * time.Minute)
+	if err := c.AppendBlock(ahead); err != nil {
+		t.Errorf("block within the drift window: %v", err)
+	}
+}
+
+func TestIncludedTransactionIsNeverMissingOrBoth(t *testing.T) {
+	c := newTestChain(t, 0)
+	c.Mempool = NewMempool(10)
+	for i := 1; i <= 20; i++ {
+		tx := testVote("e", "a", i)
+		if err := c.Mempool.Add(tx); err != nil {
+			t.Fatal(err)
+		}
+		observed := make(chan string, 1)
+		go func() {
+			for {
+				_, height, ok := c.FindTx(tx.Hash)
+				switch {
+				case !ok:
+					observed <- "missing from both mempool and chain"
+					return
+				case height > 0:
+					_, pending := c.Mempool.Get(tx.Hash)
+					if pending {
+						observed <- "included but still pending"
+					} else {
+						observed <- ""
+					}
+					return
+				}
+			}
+		}()
+		if err := c.AppendBlock(testBlock(c.Tip(), tx)); err != nil {
+			t.Fatal(err)
+		}
+		if bad := <-observed; bad != "" {
+			t.Fatalf("vote %d seen %s while its block was applied", i, bad)
+		}
+	}
+}
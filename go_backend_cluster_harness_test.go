@@ -0,0 +1,213 @@
+// go_backend_cluster_harness_test.go
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"maps"
+	mrand "math/rand"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// --- In-Process Cluster ---
+
+// clusterPollInterval is how often Cluster waits re-check node state.
+const clusterPollInterval = 10 * time.Millisecond
+
+// ClusterBlockInterval is how far Step advances a Cluster's clock per block.
+const ClusterBlockInterval = time.Second
+
+// ManualClock is a clock that moves only when advanced. A Cluster's nodes
+// share one, so block and vote timestamps, leader rounds and launch checks do
+// not depend on how fast the test runs.
+type ManualClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+// NewManualClock returns a clock reading start.
+func NewManualClock(start time.Time) *ManualClock {
+	return &ManualClock{now: start}
+}
+
+// Now returns the clock's current reading.
+func (c *ManualClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// Advance moves the clock forward by d.
+func (c *ManualClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// Cluster is a network of nodes in one process, wired together over a
+// MemoryNetwork, for end-to-end tests. Every node is a validator in a shared
+// genesis. Blocks are produced only when Step is called, never on a timer, and
+// every node reads Clock, so a scenario plays out the same way on every run.
+type Cluster struct {
+	Network *MemoryNetwork
+	Nodes   []*P2PNode
+	APIs    []http.Handler // HTTP API of each node, as served by main
+	Clock   *ManualClock   // Starts at the genesis launch time; Step advances it
+}
+
+// NewCluster creates size nodes sharing a genesis in which each of them is a
+// validator with equal stake. Call Start to connect them.
+func NewCluster(size int) (*Cluster, error) {
+	if size < 1 {
+		return nil, fmt.Errorf("cluster needs at least one node, got %d", size)
+	}
+	c := &Cluster{Network: NewMemoryNetwork()}
+	genesis := DefaultGenesisConfig()
+	for i := 0; i < size; i++ {
+		node := NewP2PNode(fmt.Sprintf("127.0.0.1:%d", 50051+i))
+		node.Mode = ModeValidator
+		node.AllowInsecure = true
+		node.Transport = c.Network.NewTransport()
+		node.GossipFanout = float64(size) // Reach every peer directly, so no node depends on relays
+		c.Nodes = append(c.Nodes, node)
+		genesis.InitialValidators = append(genesis.InitialValidators, node.PublicKey())
+	}
+	c.Clock = NewManualClock(time.Unix(int64(GenesisBlock(genesis).Header.Timestamp), 0))
+	for _, node := range c.Nodes {
+		chain, err := NewChain(GenesisBlock(genesis))
+		if err != nil {
+			return nil, err
+		}
+		chain.Clock = c.Clock.Now
+		node.UseChain(chain)
+		c.APIs = append(c.APIs, NewAPIHandler(node))
+	}
+	return c, nil
+}
+
+// Seed gives node i a random source seeded with seed+i, so that its random
+// choices, such as gossip peer sampling, repeat exactly from run to run.
+// Call it before Start.
+func (c *Cluster) Seed(seed int64) {
+	for i, node := range c.Nodes {
+		node.UseRandSource(mrand.NewSource(seed + int64(i)))
+	}
+}
+
+// Start serves every node, connects each to all the others and starts block
+// import and vote broadcast. It returns once the mesh is fully connected.
+func (c *Cluster) Start(ctx context.Context) error {
+	for _, node := range c.Nodes {
+		go func(node *P2PNode) {
+			if err := node.StartGRPCServer(); err != nil {
+				log.Printf("Cluster node %s stopped serving: %v", node.Addr, err)
+			}
+		}(node)
+		go node.ImportBlocks()
+		go node.BroadcastSubmissions()
+	}
+	for _, node := range c.Nodes {
+		for _, peer := range c.Nodes {
+			if peer == node {
+				continue
+			}
+			if err := c.waitFor(ctx, func() error { return node.ConnectToPeer(ctx, peer.Addr) }); err != nil {
+				return fmt.Errorf("node %s could not connect to %s: %w", node.Addr, peer.Addr, err)
+			}
+		}
+	}
+	return nil
+}
+
+// Step advances the clock by ClusterBlockInterval, has the leader for the next
+// height propose a block from its mempool, then waits until every node has
+// imported it.
+func (c *Cluster) Step(ctx context.Context) (*Block, error) {
+	height := c.Nodes[0].Chain.Height()
+	if err := c.WaitForHeight(ctx, height); err != nil {
+		return nil, err
+	}
+	c.Clock.Advance(ClusterBlockInterval)
+	var blk *Block
+	for _, node := range c.Nodes {
+		if proposed := node.ProposeBlock(); proposed != nil {
+			blk = proposed
+		}
+	}
+	if blk == nil {
+		return nil, fmt.Errorf("no node proposed block %d", height+1)
+	}
+	return blk, c.WaitForHeight(ctx, height+1)
+}
+
+// WaitForHeight waits until every node's chain has reached height.
+func (c *Cluster) WaitForHeight(ctx context.Context, height uint64) error {
+	return c.waitFor(ctx, func() error {
+		for _, node := range c.Nodes {
+			if h := node.Chain.Height(); h < height {
+				return fmt.Errorf("node %s is at height %d, want %d", node.Addr, h, height)
+			}
+		}
+		return nil
+	})
+}
+
+// WaitForMempools waits until every node holds the transaction txHash.
+func (c *Cluster) WaitForMempools(ctx context.Context, txHash []byte) error {
+	return c.waitFor(ctx, func() error {
+		for _, node := range c.Nodes {
+			if _, ok := node.Mempool.Get(txHash); !ok {
+				return fmt.Errorf("node %s has not received transaction %x", node.Addr, txHash)
+			}
+		}
+		return nil
+	})
+}
+
+// Converged reports whether every node has the same tip and tally, returning
+// an error naming the first node that differs from the first.
+func (c *Cluster) Converged() error {
+	first := c.Nodes[0]
+	tip, counts := first.Chain.Tip().Header.Hash, first.Chain.Tally.Counts()
+	for _, node := range c.Nodes[1:] {
+		if other := node.Chain.Tip().Header.Hash; !bytes.Equal(other, tip) {
+			return fmt.Errorf("node %s has tip %x, node %s has %x", node.Addr, other, first.Addr, tip)
+		}
+		if other := node.Chain.Tally.Counts(); !maps.Equal(other, counts) {
+			return fmt.Errorf("node %s has tally %v, node %s has %v", node.Addr, other, first.Addr, counts)
+		}
+	}
+	return nil
+}
+
+// Close shuts every node down.
+func (c *Cluster) Close(ctx context.Context) error {
+	for _, node := range c.Nodes {
+		if err := node.Close(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// waitFor polls check until it succeeds or ctx is done, returning check's last error.
+func (c *Cluster) waitFor(ctx context.Context, check func() error) error {
+	ticker := time.NewTicker(clusterPollInterval)
+	defer ticker.Stop()
+	for {
+		err := check()
+		if err == nil {
+			return nil
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return fmt.Errorf("%w: %v", ctx.Err(), err)
+		}
+	}
+}
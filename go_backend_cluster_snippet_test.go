@@ -0,0 +1,167 @@
+// go_backend_cluster_snippet_test.go
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+var clusterSize = flag.Int("cluster-size", 4, "nodes in the end-to-end cluster test")
+
+// clusterVoter returns the key of the named voter, the same on every run.
+func clusterVoter(name string) ed25519.PrivateKey {
+	return ed25519.NewKeyFromSeed(bytes.Repeat([]byte(name[len(name)-1:]), ed25519.SeedSize))
+}
+
+// clusterVote submits a vote signed by voter through the HTTP API of node i
+// and returns its transaction hash.
+func clusterVote(t *testing.T, c *Cluster, i int, voter ed25519.PrivateKey, election, candidate string) []byte {
+	t.Helper()
+	vote := map[string]string{"voter_id": hex.EncodeToString(voter.Public().(ed25519.PublicKey)), "election_id": election, "candidate": candidate}
+	data, err := json.Marshal(signBallotAt(t, voter, c.Nodes[i].ChainID, c.Clock.Now(), vote))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	c.APIs[i].ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/vote", bytes.NewReader(data)))
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("vote via node %d: status %d, body %s", i, rr.Code, rr.Body)
+	}
+	var resp struct {
+		TxHash string `json:"tx_hash"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	hash, err := hex.DecodeString(resp.TxHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hash
+}
+
+// startCluster starts a seeded cluster of size nodes, closed when the test ends.
+func startCluster(t *testing.T, ctx context.Context, size int) *Cluster {
+	t.Helper()
+	oldVoted, oldCommitted := votedSet, committedSet
+	votedSet, committedSet = NewVotedSet(), NewVotedSet()
+	t.Cleanup(func() { votedSet, committedSet = oldVoted, oldCommitted })
+
+	c, err := NewCluster(size)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Seed(1)
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := c.Close(ctx); err != nil {
+			t.Error(err)
+		}
+	})
+	if err := c.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestClusterVotesConverge(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	c := startCluster(t, ctx, *clusterSize)
+	launch := c.Clock.Now()
+
+	votes := map[string]string{"voter-1": "candidate-a", "voter-2": "candidate-a", "voter-3": "candidate-b"}
+	var hashes [][]byte
+	for i, voter := range []string{"voter-1", "voter-2", "voter-3"} {
+		hash := clusterVote(t, c, i%len(c.Nodes), clusterVoter(voter), "e2e", votes[voter])
+		if err := c.WaitForMempools(ctx, hash); err != nil {
+			t.Fatalf("vote from %s did not propagate: %v", voter, err)
+		}
+		hashes = append(hashes, hash)
+	}
+
+	blk, err := c.Step(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(blk.Transactions) != len(hashes) {
+		t.Fatalf("block 1 has %d transactions, want the %d votes", len(blk.Transactions), len(hashes))
+	}
+	for _, hash := range hashes {
+		for _, node := range c.Nodes {
+			if height, ok := node.Chain.TxHeight(hash); !ok || height != 1 {
+				t.Errorf("node %s has vote %x at height %d (found %v), want 1", node.Addr, hash, height, ok)
+			}
+		}
+	}
+
+	// Bury the votes under empty blocks; every node must agree on each of them
+	for i := 0; i < 3; i++ {
+		if _, err := c.Step(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	last := len(c.Nodes) - 1
+	rr := httptest.NewRecorder()
+	c.APIs[last].ServeHTTP(rr, httptest.NewRequest(http.MethodGet, fmt.Sprintf("/tx/%x/wait?timeout=1", hashes[0]), nil))
+	var wait struct {
+		Included bool   `json:"included"`
+		Height   uint64 `json:"height"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &wait); err != nil {
+		t.Fatal(err)
+	}
+	if !wait.Included || wait.Height != 1 {
+		t.Errorf("node %d reports vote included %v at height %d, want true at 1", last, wait.Included, wait.Height)
+	}
+
+	if err := c.Converged(); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]uint64{"candidate-a": 2, "candidate-b": 1}
+	for _, node := range c.Nodes {
+		if got := node.Chain.Tally.ElectionCounts("e2e"); !maps.Equal(got, want) {
+			t.Errorf("node %s tallies %v, want %v", node.Addr, got, want)
+		}
+	}
+
+	// Block times come from the shared clock, not the wall clock
+	for h := uint64(1); h <= c.Nodes[0].Chain.Height(); h++ {
+		header, _ := c.Nodes[0].Chain.Header(h)
+		if want := uint64(launch.Add(time.Duration(h) * ClusterBlockInterval).Unix()); header.Timestamp != want {
+			t.Errorf("block %d timestamp %d, want %d", h, header.Timestamp, want)
+		}
+	}
+}
+
+func TestClusterSizes(t *testing.T) {
+	for _, size := range []int{1, 3} {
+		t.Run(fmt.Sprintf("%d nodes", size), func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			defer cancel()
+			c := startCluster(t, ctx, size)
+			hash := clusterVote(t, c, 0, clusterVoter("voter-1"), "sizes", "candidate-a")
+			if err := c.WaitForMempools(ctx, hash); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := c.Step(ctx); err != nil {
+				t.Fatal(err)
+			}
+			if err := c.Converged(); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
@@ -0,0 +1,335 @@
+// go_backend_commitreveal_snippet.go
+
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+)
+
+// --- Commit-Reveal Voting ---
+
+// MinRevealNonceLen is the shortest nonce a vote may be revealed with. An
+// election has few candidates, so a short nonce would let anyone recover the
+// vote from its commitment by trying each one.
+const MinRevealNonceLen = 16
+
+// Commit-reveal validation errors, wrapped with detail like the errors of
+// ValidateBlock.
+var (
+	ErrCommitRevealPhase  = errors.New("wrong commit-reveal phase")
+	ErrCommitmentMismatch = errors.New("vote does not match its commitment")
+)
+
+// VoteCommitment returns the commitment a voter submits before the reveal
+// window for the vote it reveals later: the chain hash of the vote with a
+// secret nonce, under hasher, which must be the chain's. The sender is bound
+// in, so nobody can copy another voter's commitment and reveal it as their own.
+func VoteCommitment(hasher Hasher, sender []byte, electionID, candidate string, ballot BallotType, nonce []byte) []byte {
+	return hasher.Sum([]byte(fmt.Sprintf("vote-commit|%x|%q|%q|%d|%x", sender, electionID, candidate, ballot, nonce)))
+}
+
+// voterKey identifies one voter in one election. It is a struct rather than a
+// joined string so that no election ID can alias another's voters.
+type voterKey struct {
+	electionID string
+	sender     string
+}
+
+func commitmentKey(electionID string, sender []byte) voterKey {
+	return voterKey{electionID: electionID, sender: string(sender)}
+}
+
+// voteCommitment is one voter's commitment in one election.
+type voteCommitment struct {
+	hash     []byte
+	revealed bool
+}
+
+// CommitmentRegistry holds the vote commitments on the current best chain and
+// whether each has been revealed. Like CandidateRegistry, blocks are applied as
+// they are appended and reverted when a reorg orphans them.
+type CommitmentRegistry struct {
+	mu          sync.RWMutex
+	commitments map[voterKey]*voteCommitment
+}
+
+// NewCommitmentRegistry creates an empty registry.
+func NewCommitmentRegistry() *CommitmentRegistry {
+	return &CommitmentRegistry{commitments: make(map[voterKey]*voteCommitment)}
+}
+
+// lookup returns the commitment sender made in the election, if any.
+func (r *CommitmentRegistry) lookup(electionID string, sender []byte) (voteCommitment, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	c, ok := r.commitments[commitmentKey(electionID, sender)]
+	if !ok {
+		return voteCommitment{}, false
+	}
+	return *c, true
+}
+
+// applyBlock records blk's commitments and marks the ones its votes reveal.
+// blk must already be validated.
+func (r *CommitmentRegistry) applyBlock(blk *Block) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, tx := range blk.Transactions {
+		key := commitmentKey(string(tx.GetPayload()), tx.GetSender())
+		switch {
+		case tx.GetKind() == TxKindVoteCommit:
+			r.commitments[key] = &voteCommitment{hash: tx.GetRecipient()}
+		case tx.GetKind() == TxKindVote && len(tx.GetNonce()) > 0:
+			r.commitments[key].revealed = true
+		}
+	}
+}
+
+// revertBlock undoes applyBlock for a previously applied blk.
+func (r *CommitmentRegistry) revertBlock(blk *Block) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, tx := range blk.Transactions {
+		key := commitmentKey(string(tx.GetPayload()), tx.GetSender())
+		switch {
+		case tx.GetKind() == TxKindVoteCommit:
+			delete(r.commitments, key)
+		case tx.GetKind() == TxKindVote && len(tx.GetNonce()) > 0:
+			r.commitments[key].revealed = false
+		}
+	}
+}
+
+// Unrevealed returns how many commitments in the election have not been
+// revealed on the best chain. Once the reveal window has closed, these are
+// votes that will never be counted.
+func (r *CommitmentRegistry) Unrevealed(electionID string) uint64 {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	var count uint64
+	for key, c := range r.commitments {
+		if key.electionID == electionID && !c.revealed {
+			count++
+		}
+	}
+	return count
+}
+
+// Elections returns the IDs of the elections with commitments on the best
+// chain, sorted.
+func (r *CommitmentRegistry) Elections() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	seen := make(map[string]bool)
+	var ids []string
+	for key := range r.commitments {
+		if !seen[key.electionID] {
+			seen[key.electionID] = true
+			ids = append(ids, key.electionID)
+		}
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// reset removes every commitment.
+func (r *CommitmentRegistry) reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.commitments = make(map[voterKey]*voteCommitment)
+}
+
+// RevealWindow returns the heights between which votes are revealed under
+// commit-reveal voting, as fixed at genesis: from start until end, exclusive,
+// or with no end if end is 0. start is 0 if votes are public.
+func (c *Chain) RevealWindow() (start, end uint64) {
+	return c.revealStart, c.revealEnd
+}
+
+// checkCommitReveal enforces commit-reveal voting on a commitment or vote in
+// the block at height. Before the reveal window only commitments are
+// accepted, one per voter and election; within it only votes that reveal
+// their voter's commitment, and after it no votes at all. seen holds the
+// voters who committed or revealed earlier in the same block (nil outside
+// block validation); one map serves both, as a block is in only one phase. Without commit-reveal, commitments and nonces are refused and
+// votes are not checked.
+func (c *Chain) checkCommitReveal(tx *Transaction, height uint64, seen map[voterKey]bool) error {
+	switch tx.GetKind() {
+	case TxKindVoteCommit:
+		if c.revealStart == 0 {
+			return fmt.Errorf("%w: commitment %x: commit-reveal voting is not enabled", ErrMalformedTx, tx.GetHash())
+		}
+		if height >= c.revealStart {
+			return fmt.Errorf("%w: commitment %x at height %d: votes are revealed from height %d", ErrCommitRevealPhase, tx.GetHash(), height, c.revealStart)
+		}
+		if size := len(c.hasher.Sum(nil)); len(tx.GetRecipient()) != size {
+			return fmt.Errorf("%w: commitment %x is %d bytes, must be %d", ErrMalformedTx, tx.GetHash(), len(tx.GetRecipient()), size)
+		}
+		electionID := string(tx.GetPayload())
+		key := commitmentKey(electionID, tx.GetSender())
+		if _, ok := c.Commitments.lookup(electionID, tx.GetSender()); ok || seen[key] {
+			return fmt.Errorf("%w: voter %x already committed in election %q", ErrDuplicateVote, tx.GetSender(), electionID)
+		}
+		if seen != nil {
+			seen[key] = true
+		}
+	case TxKindVote:
+		if c.revealStart == 0 {
+			if len(tx.GetNonce()) > 0 {
+				return fmt.Errorf("%w: vote %x has a nonce but commit-reveal voting is not enabled", ErrMalformedTx, tx.GetHash())
+			}
+			return nil
+		}
+		if height < c.revealStart {
+			return fmt.Errorf("%w: vote %x at height %d: only commitments are accepted before height %d", ErrCommitRevealPhase, tx.GetHash(), height, c.revealStart)
+		}
+		if c.revealEnd != 0 && height >= c.revealEnd {
+			return fmt.Errorf("%w: %w: vote %x at height %d: reveals closed at height %d", ErrElectionClosed, ErrCommitRevealPhase, tx.GetHash(), height, c.revealEnd)
+		}
+		if len(tx.GetNonce()) < MinRevealNonceLen {
+			return fmt.Errorf("%w: vote %x must reveal a nonce of at least %d bytes", ErrMalformedTx, tx.GetHash(), MinRevealNonceLen)
+		}
+		electionID := string(tx.GetPayload())
+		commitment, ok := c.Commitments.lookup(electionID, tx.GetSender())
+		if !ok {
+			return fmt.Errorf("%w: vote %x: voter %x made no commitment in election %q", ErrCommitmentMismatch, tx.GetHash(), tx.GetSender(), electionID)
+		}
+		key := commitmentKey(electionID, tx.GetSender())
+		if commitment.revealed || seen[key] {
+			return fmt.Errorf("%w: voter %x already revealed in election %q", ErrDuplicateVote, tx.GetSender(), electionID)
+		}
+		if !bytes.Equal(commitment.hash, VoteCommitment(c.hasher, tx.GetSender(), electionID, string(tx.GetRecipient()), tx.GetBallotType(), tx.GetNonce())) {
+			return fmt.Errorf("%w: vote %x", ErrCommitmentMismatch, tx.GetHash())
+		}
+		if seen != nil {
+			seen[key] = true
+		}
+	}
+	return nil
+}
+
+// writeCommitRevealError reports a commitment or reveal refused by
+// checkCommitReveal.
+func writeCommitRevealError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, ErrDuplicateVote):
+		writeError(w, http.StatusConflict, ErrCodeAlreadyVoted, err.Error())
+	case errors.Is(err, ErrElectionClosed):
+		writeError(w, http.StatusConflict, ErrCodeElectionClosed, err.Error())
+	case errors.Is(err, ErrCommitRevealPhase):
+		writeError(w, http.StatusConflict, ErrCodeWrongPhase, err.Error())
+	case errors.Is(err, ErrCommitmentMismatch):
+		writeError(w, http.StatusBadRequest, ErrCodeBadReveal, err.Error())
+	default:
+		writeError(w, http.StatusBadRequest, ErrCodeInvalidRequest, err.Error())
+	}
+}
+
+// committedSet tracks which voters have committed in each election, as
+// votedSet does for votes.
+var committedSet = NewVotedSet()
+
+// SubmitVoteCommitment handles POST /vote/commit, the commit phase of
+// commit-reveal voting. The voter sends the hex VoteCommitment of their vote
+// and later reveals it through /vote with the same nonce. Like a vote, the
+// commitment is signed by the voter, and broadcast by BroadcastSubmissions
+// after the response.
+func SubmitVoteCommitment(node *P2PNode, w http.ResponseWriter, r *http.Request) {
+	if node.LoadShedding() || node.submitQueueFull() {
+		writeMempoolFull(w)
+		return
+	}
+	var req struct {
+		VoterID    string `json:"voter_id"`
+		ElectionID string `json:"election_id"`
+		Commitment string `json:"commitment"` // Hex VoteCommitment
+		Timestamp  uint64 `json:"timestamp"`  // Unix seconds; part of what the voter signs
+		Signature  string `json:"signature"`  // Hex Ed25519 signature of the commitment's SigningBytes by voter_id
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, ErrCodeInvalidRequest, err.Error())
+		return
+	}
+	sender, _, err := parseVoteParties(req.VoterID, "")
+	if err != nil {
+		writeError(w, http.StatusBadRequest, ErrCodeInvalidRequest, err.Error())
+		return
+	}
+	req.VoterID = hex.EncodeToString(sender)
+	commitment, err := hex.DecodeString(req.Commitment)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, ErrCodeInvalidRequest, "commitment must be hex")
+		return
+	}
+	signature, err := hex.DecodeString(req.Signature)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, ErrCodeInvalidRequest, "signature must be hex")
+		return
+	}
+
+	tx := &Transaction{
+		Sender:    sender,
+		Recipient: commitment,
+		Timestamp: req.Timestamp,
+		ChainId:   node.ChainID,
+		Kind:      TxKindVoteCommit,
+		Payload:   []byte(req.ElectionID),
+		Signature: signature,
+	}
+	tx.Hash = node.Chain.Hasher().Sum(tx.SigningBytes())
+	if err := node.checkTxTimestamp(tx, node.Chain.now()); err != nil {
+		writeError(w, http.StatusBadRequest, ErrCodeInvalidRequest, err.Error())
+		return
+	}
+	if err := VerifyTransaction(node.Chain.Hasher(), tx); err != nil {
+		code := ErrCodeInvalidRequest
+		if errors.Is(err, ErrBadSignature) {
+			code = ErrCodeInvalidSignature
+		}
+		writeError(w, http.StatusBadRequest, code, err.Error())
+		return
+	}
+	if err := node.Chain.checkElectionWindow(tx, node.Chain.now()); err != nil {
+		writeError(w, http.StatusConflict, ErrCodeElectionClosed, err.Error())
+		return
+	}
+	if err := node.Chain.checkCommitReveal(tx, node.Chain.Height()+1, nil); err != nil {
+		writeCommitRevealError(w, err)
+		return
+	}
+	if !committedSet.MarkVoted(req.VoterID, req.ElectionID) {
+		writeError(w, http.StatusConflict, ErrCodeAlreadyVoted, "Voter has already committed in this election")
+		return
+	}
+	switch err := node.Mempool.Add(tx); {
+	case err == nil:
+		node.recordAccepted(tx)
+		node.logOutbound(tx)
+	case !errors.Is(err, ErrTxKnown):
+		committedSet.Unmark(req.VoterID, req.ElectionID)
+		writeMempoolFull(w)
+		return
+	}
+	log.Printf("Received vote commitment from %s in election %s", req.VoterID, req.ElectionID)
+
+	message := "Commitment accepted and queued for broadcast."
+	if !node.queueSubmission(tx) {
+		message = "Commitment accepted; it will be broadcast on the next rebroadcast round."
+	}
+	start, end := node.Chain.RevealWindow()
+	w.Header().Set("Content-Type", contentTypeJSON)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message":      message + " Reveal the vote with its nonce once voting opens for reveals.",
+		"tx_hash":      hex.EncodeToString(tx.Hash),
+		"reveal_start": start,
+		"reveal_end":   end,
+	})
+}
@@ -0,0 +1,202 @@
+// go_backend_commitreveal_snippet_test.go
+
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+)
+
+// newRevealChain returns a chain whose votes are revealed from height start
+// until end.
+func newRevealChain(t *testing.T, start, end uint64) *Chain {
+	t.Helper()
+	cfg := DefaultGenesisConfig()
+	cfg.RevealStart, cfg.RevealEnd = start, end
+	c, err := NewChain(GenesisBlock(cfg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+// testCommit returns voter n's commitment to testVote(election, candidate, n)
+// and the vote that reveals it.
+func testCommit(election, candidate string, n int) (commit, reveal *Transaction) {
+	nonce := bytes.Repeat([]byte{byte(n)}, MinRevealNonceLen)
+	reveal = testVote(election, candidate, n)
+	reveal.Nonce = nonce
+	signTestTx(reveal, testVoterKey(n))
+	commit = &Transaction{
+		Kind:      TxKindVoteCommit,
+		Sender:    reveal.Sender,
+		Recipient: VoteCommitment(SHA3Hasher{}, reveal.Sender, election, candidate, reveal.GetBallotType(), nonce),
+		ChainId:   DefaultChainID,
+		Payload:   []byte(election),
+	}
+	signTestTx(commit, testVoterKey(n))
+	return commit, reveal
+}
+
+func TestRevealsCountedOnlyWithinWindow(t *testing.T) {
+	c := newRevealChain(t, 2, 4)
+	var commits, reveals []*Transaction
+	for n := 1; n <= 3; n++ {
+		commit, reveal := testCommit("e", "a", n)
+		commits, reveals = append(commits, commit), append(reveals, reveal)
+	}
+	if err := c.AppendBlock(testBlock(c.Tip(), commits...)); err != nil {
+		t.Fatal(err)
+	}
+	// Voter 1 reveals within the window, voter 2 too late and voter 3 never
+	if err := c.AppendBlock(testBlock(c.Tip(), reveals[0])); err != nil {
+		t.Fatal(err)
+	}
+	for c.Height() < 4 {
+		if err := c.AppendBlock(testBlock(c.Tip())); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := c.AppendBlock(testBlock(c.Tip(), reveals[1])); !errors.Is(err, ErrElectionClosed) {
+		t.Fatalf("late reveal: got %v, want ErrElectionClosed", err)
+	}
+	if got := c.Tally.ElectionTurnout("e"); got != 1 {
+		t.Errorf("turnout %d, want 1", got)
+	}
+	if got := c.Commitments.Unrevealed("e"); got != 2 {
+		t.Errorf("unrevealed %d, want 2 (the late and the missing reveal)", got)
+	}
+}
+
+func TestUnrevealedIsExactPerElection(t *testing.T) {
+	c := newRevealChain(t, 2, 0)
+	commitE, revealE := testCommit("e", "a", 1)
+	commitX, _ := testCommit("e|x", "a", 2)
+	if err := c.AppendBlock(testBlock(c.Tip(), commitE, commitX)); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.AppendBlock(testBlock(c.Tip(), revealE)); err != nil {
+		t.Fatal(err)
+	}
+	if got := c.Commitments.Unrevealed("e"); got != 0 {
+		t.Errorf(`election "e": %d unrevealed, want 0`, got)
+	}
+	if got := c.Commitments.Unrevealed("e|x"); got != 1 {
+		t.Errorf(`election "e|x": %d unrevealed, want 1`, got)
+	}
+}
+
+func TestCommitmentOnlyElectionHasTurnout(t *testing.T) {
+	node := newTestNode(t)
+	node.Chain = newRevealChain(t, 5, 0)
+	commit, _ := testCommit("sealed", "a", 1)
+	if err := node.Chain.AppendBlock(testBlock(node.Chain.Tip(), commit)); err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Contains(node.Chain.Elections(), "sealed") {
+		t.Fatalf("elections %q do not include the commitment-only election", node.Chain.Elections())
+	}
+	req := httptest.NewRequest(http.MethodGet, "/elections/sealed/turnout", nil)
+	req.SetPathValue("id", "sealed")
+	rec := httptest.NewRecorder()
+	GetElectionTurnout(node, rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("turnout: status %d, want 200: %s", rec.Code, rec.Body)
+	}
+	var report TurnoutReport
+	if err := json.Unmarshal(rec.Body.Bytes(), &report); err != nil {
+		t.Fatal(err)
+	}
+	if report.Voters != 0 || report.Unrevealed != 1 {
+		t.Errorf("report counts %d voters and %d unrevealed, want 0 and 1", report.Voters, report.Unrevealed)
+	}
+	if err := VerifyTurnoutReport(&report, node.PublicKey()); err != nil {
+		t.Error(err)
+	}
+}
+
+// signCommitment returns the /vote/commit request body for commit on node's
+// chain, signed by the voter testVoterID made for its voter_id.
+func signCommitment(t *testing.T, node *P2PNode, commit map[string]string) map[string]any {
+	t.Helper()
+	v, ok := testVoters.Load(commit["voter_id"])
+	if !ok {
+		t.Fatalf("voter %s was not made by testVoterID", commit["voter_id"])
+	}
+	voter := v.(testVoter)
+	commitment, err := hex.DecodeString(commit["commitment"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx := &Transaction{
+		Sender:    voter.priv.Public().(ed25519.PublicKey),
+		Recipient: commitment,
+		Timestamp: uint64(voter.signedAt.Unix()),
+		ChainId:   node.ChainID,
+		Kind:      TxKindVoteCommit,
+		Payload:   []byte(commit["election_id"]),
+	}
+	body := map[string]any{"timestamp": tx.Timestamp, "signature": hex.EncodeToString(ed25519.Sign(voter.priv, tx.SigningBytes()))}
+	for k, v := range commit {
+		body[k] = v
+	}
+	return body
+}
+
+func TestCommitThenRevealOverHTTP(t *testing.T) {
+	node := newTestNode(t)
+	c := newRevealChain(t, 2, 0)
+	node.UseChain(c)
+	voter := testVoterID(t)
+	sender, _ := hex.DecodeString(voter)
+	nonce := bytes.Repeat([]byte{7}, MinRevealNonceLen)
+
+	commit := map[string]string{
+		"voter_id":    voter,
+		"election_id": "e",
+		"commitment":  hex.EncodeToString(VoteCommitment(SHA3Hasher{}, sender, "e", "candidate-a", BallotValid, nonce)),
+	}
+	submitCommit := func(w http.ResponseWriter, r *http.Request) { SubmitVoteCommitment(node, w, r) }
+	if rr := postJSON(t, submitCommit, "/vote/commit", commit); rr.Code != http.StatusBadRequest {
+		t.Fatalf("unsigned commitment: status %d, body %s", rr.Code, rr.Body)
+	}
+	rr := postJSON(t, submitCommit, "/vote/commit", signCommitment(t, node, commit))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("commitment: status %d, body %s", rr.Code, rr.Body)
+	}
+	if len(node.submissions) != 1 {
+		t.Fatalf("%d submissions queued, want the commitment queued for broadcast", len(node.submissions))
+	}
+	commitTx := <-node.submissions
+	if err := c.AppendBlock(testBlock(c.Tip(), commitTx)); err != nil {
+		t.Fatal(err)
+	}
+
+	// Votes are revealed from height 2, the next block
+	reveal := func(candidate string) *httptest.ResponseRecorder {
+		vote := map[string]string{"voter_id": voter, "election_id": "e", "candidate": candidate, "nonce": hex.EncodeToString(nonce)}
+		return postJSON(t, func(w http.ResponseWriter, r *http.Request) { SubmitVote(node, w, r) }, "/vote", signBallot(t, node, vote))
+	}
+	if rr := reveal("candidate-b"); rr.Code != http.StatusBadRequest || errorCode(t, rr) != ErrCodeBadReveal {
+		t.Fatalf("reveal of a different vote: status %d, body %s; want 400 %s", rr.Code, rr.Body, ErrCodeBadReveal)
+	}
+	if rr := reveal("candidate-a"); rr.Code != http.StatusAccepted {
+		t.Fatalf("matching reveal: status %d, body %s", rr.Code, rr.Body)
+	}
+	if err := c.AppendBlock(testBlock(c.Tip(), <-node.submissions)); err != nil {
+		t.Fatal(err)
+	}
+	if got := c.Tally.ElectionCounts("e"); got["candidate-a"] != 1 || len(got) != 1 {
+		t.Errorf("tally %v, want one vote for candidate-a", got)
+	}
+	if got := c.Commitments.Unrevealed("e"); got != 0 {
+		t.Errorf("%d commitments unrevealed, want 0", got)
+	}
+}
@@ -0,0 +1,542 @@
+// go_backend_elections_snippet.go
+
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// --- Candidate Registration ---
+
+// CandidateRegistration is the payload of a TxKindRegisterCandidate transaction
+// (see proto/transaction.proto). Only the election authority may send one.
+type CandidateRegistration struct {
+	ElectionID  string
+	CandidateID string // What votes carry as their Recipient
+	DisplayName string
+}
+
+// MarshalProto encodes the registration in protobuf wire format.
+func (r *CandidateRegistration) MarshalProto() []byte {
+	var b []byte
+	b = protowire.AppendTag(b, 1, protowire.BytesType)
+	b = protowire.AppendString(b, r.ElectionID)
+	b = protowire.AppendTag(b, 2, protowire.BytesType)
+	b = protowire.AppendString(b, r.CandidateID)
+	b = protowire.AppendTag(b, 3, protowire.BytesType)
+	b = protowire.AppendString(b, r.DisplayName)
+	return b
+}
+
+// UnmarshalProto decodes a registration from protobuf wire format.
+func (r *CandidateRegistration) UnmarshalProto(b []byte) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+		if typ != protowire.BytesType || num < 1 || num > 3 {
+			n := protowire.ConsumeFieldValue(num, typ, b)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			b = b[n:]
+			continue
+		}
+		v, n := protowire.ConsumeString(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+		switch num {
+		case 1:
+			r.ElectionID = v
+		case 2:
+			r.CandidateID = v
+		case 3:
+			r.DisplayName = v
+		}
+	}
+	return nil
+}
+
+// Candidate is a candidate registered on chain.
+type Candidate struct {
+	ElectionID   string `json:"election_id"`
+	ID           string `json:"id"`
+	DisplayName  string `json:"display_name"`
+	RegisteredAt uint64 `json:"registered_at"` // Height of the registering block
+}
+
+// CandidateRegistry holds the candidates registered on the current best chain.
+// Like Tally, blocks are applied as they are appended and reverted when a reorg
+// orphans them.
+type CandidateRegistry struct {
+	mu         sync.RWMutex
+	candidates map[string]*Candidate // electionID|candidateID -> candidate
+}
+
+// NewCandidateRegistry creates an empty registry.
+func NewCandidateRegistry() *CandidateRegistry {
+	return &CandidateRegistry{candidates: make(map[string]*Candidate)}
+}
+
+func candidateKey(electionID, candidateID string) string {
+	return electionID + "|" + candidateID
+}
+
+// Lookup returns the candidate registered under candidateID in the election, if any.
+func (r *CandidateRegistry) Lookup(electionID, candidateID string) (*Candidate, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	c, ok := r.candidates[candidateKey(electionID, candidateID)]
+	return c, ok
+}
+
+// Candidates returns every candidate registered in the election, sorted by ID.
+func (r *CandidateRegistry) Candidates(electionID string) []*Candidate {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	var list []*Candidate
+	for _, c := range r.candidates {
+		if c.ElectionID == electionID {
+			list = append(list, c)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	return list
+}
+
+// Elections returns the IDs of every election with a registered candidate, sorted.
+func (r *CandidateRegistry) Elections() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	seen := make(map[string]bool)
+	var ids []string
+	for _, c := range r.candidates {
+		if !seen[c.ElectionID] {
+			seen[c.ElectionID] = true
+			ids = append(ids, c.ElectionID)
+		}
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// applyBlock registers blk's candidates. blk must already be validated.
+func (r *CandidateRegistry) applyBlock(blk *Block) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, tx := range blk.Transactions {
+		if tx.GetKind() != TxKindRegisterCandidate {
+			continue
+		}
+		reg := &CandidateRegistration{}
+		reg.UnmarshalProto(tx.GetPayload())
+		r.candidates[candidateKey(reg.ElectionID, reg.CandidateID)] = &Candidate{
+			ElectionID:   reg.ElectionID,
+			ID:           reg.CandidateID,
+			DisplayName:  reg.DisplayName,
+			RegisteredAt: blk.Header.Height,
+		}
+	}
+}
+
+// revertBlock removes the candidates a previously applied blk registered.
+func (r *CandidateRegistry) revertBlock(blk *Block) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, tx := range blk.Transactions {
+		if tx.GetKind() != TxKindRegisterCandidate {
+			continue
+		}
+		reg := &CandidateRegistration{}
+		reg.UnmarshalProto(tx.GetPayload())
+		delete(r.candidates, candidateKey(reg.ElectionID, reg.CandidateID))
+	}
+}
+
+// reset removes every registered candidate.
+func (r *CandidateRegistry) reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.candidates = make(map[string]*Candidate)
+}
+
+// checkElectionTx validates a registration or vote against the chain's election
+// authority and candidate registry. registered holds candidates registered
+// earlier in the same block (nil outside block validation). Votes are only
+// checked against the registry if the chain enforces the candidate whitelist.
+func (c *Chain) checkElectionTx(tx *Transaction, registered map[string]bool) error {
+	switch tx.GetKind() {
+	case TxKindRegisterCandidate:
+		if c.authorityKey == nil {
+			return fmt.Errorf("%w: candidate registration %x: no election authority is configured", ErrNotAuthorized, tx.GetHash())
+		}
+		if !bytes.Equal(tx.GetSender(), c.authorityKey) {
+			return fmt.Errorf("%w: candidate registration %x is not from the election authority", ErrNotAuthorized, tx.GetHash())
+		}
+		if !ed25519.Verify(c.authorityKey, tx.SigningBytes(), tx.GetSignature()) {
+			return fmt.Errorf("%w: candidate registration %x", ErrBadSignature, tx.GetHash())
+		}
+		reg := &CandidateRegistration{}
+		if err := reg.UnmarshalProto(tx.GetPayload()); err != nil {
+			return fmt.Errorf("%w: candidate registration %x: %v", ErrMalformedTx, tx.GetHash(), err)
+		}
+		if reg.ElectionID == "" || reg.CandidateID == "" {
+			return fmt.Errorf("%w: candidate registration %x needs an election ID and candidate ID", ErrMalformedTx, tx.GetHash())
+		}
+		key := candidateKey(reg.ElectionID, reg.CandidateID)
+		if _, ok := c.Candidates.Lookup(reg.ElectionID, reg.CandidateID); ok || registered[key] {
+			return fmt.Errorf("%w: %q in election %q", ErrDuplicateCandidate, reg.CandidateID, reg.ElectionID)
+		}
+		if registered != nil {
+			registered[key] = true
+		}
+	case TxKindVote:
+		if c.Tally.HasVoted(string(tx.GetPayload()), tx.GetSender()) {
+			return fmt.Errorf("%w: voter %x in election %q", ErrDuplicateVote, tx.GetSender(), tx.GetPayload())
+		}
+		if !c.EnforcesCandidateWhitelist() || tx.GetBallotType() != BallotValid {
+			return nil // Abstentions and spoiled ballots name no candidate
+		}
+		electionID, candidateID := string(tx.GetPayload()), string(tx.GetRecipient())
+		if _, ok := c.Candidates.Lookup(electionID, candidateID); !ok && !registered[candidateKey(electionID, candidateID)] {
+			return fmt.Errorf("%w: vote %x is for %q in election %q", ErrUnknownCandidate, tx.GetHash(), candidateID, electionID)
+		}
+	}
+	return nil
+}
+
+// checkElectionTxs runs checkElectionTx over blk in order, so a vote may follow
+// its candidate's registration within the same block. It also rejects a second
+// vote in blk from a voter, as checkElectionTx does one on the chain. Callers
+// hold c.mu.
+func (c *Chain) checkElectionTxs(blk *Block) error {
+	registered := make(map[string]bool)
+	voters := make(map[voterKey]bool)
+	committed := make(map[voterKey]bool)
+	for _, tx := range blk.Transactions {
+		if err := c.checkBlockTx(tx, blk.Header, voters, registered, committed); err != nil {
+			return fmt.Errorf("block %d: %w", blk.Header.Height, err)
+		}
+	}
+	return nil
+}
+
+// checkBlockTx checks tx for the block with header after the block's earlier
+// votes, candidate registrations and commitments, tracked in voters,
+// registered and committed, and records tx in them if it passes. Validator
+// changes are checked separately, by ValidatorSet.validateChange.
+func (c *Chain) checkBlockTx(tx *Transaction, header *BlockHeader, voters map[voterKey]bool, registered map[string]bool, committed map[voterKey]bool) error {
+	var key voterKey
+	if tx.GetKind() == TxKindVote {
+		key = commitmentKey(string(tx.GetPayload()), tx.GetSender())
+		if voters[key] {
+			return fmt.Errorf("%w: voter %x in election %q", ErrDuplicateVote, tx.GetSender(), tx.GetPayload())
+		}
+	}
+	if err := c.checkElectionTx(tx, registered); err != nil {
+		return err
+	}
+	if err := c.checkElectionWindow(tx, time.Unix(int64(header.Timestamp), 0)); err != nil {
+		return err
+	}
+	if err := c.checkCommitReveal(tx, header.Height, committed); err != nil {
+		return err
+	}
+	if tx.GetKind() == TxKindVote {
+		voters[key] = true
+	}
+	return nil
+}
+
+// rejectsTx reports whether tx could not be included on its own in the next
+// block, whatever else the block held.
+func (c *Chain) rejectsTx(tx *Transaction) bool {
+	next := &BlockHeader{Height: c.Height() + 1, Timestamp: uint64(c.now().Unix())}
+	if tx.GetKind() == TxKindValidatorChange {
+		if _, err := c.Validators.validateChange(tx, next.Height); err != nil {
+			return true
+		}
+	}
+	return c.checkBlockTx(tx, next, make(map[voterKey]bool), make(map[string]bool), make(map[voterKey]bool)) != nil
+}
+
+// --- Election Schedules ---
+
+// Election is an election scheduled by the genesis block, open for votes from
+// its StartTime until its EndTime. Each scheduled election keeps its own
+// window, so many can be open at once. Elections that are not scheduled, named
+// only by votes and candidate registrations, are always open.
+type Election struct {
+	ID        string
+	StartTime time.Time // Votes are refused in blocks timestamped before it; zero opens the election at launch
+	EndTime   time.Time // Votes are refused in blocks timestamped at or after it; the result is then finalized
+
+	// The result meets quorum once at least MinTurnout voters, and at least
+	// MinTurnoutPercent of EligibleVoters, have voted. Zero thresholds are
+	// always met.
+	MinTurnout        uint64
+	MinTurnoutPercent uint64 // 0-100; needs EligibleVoters
+	EligibleVoters    uint64 // Voters registered for the election
+}
+
+// MarshalProto encodes the election in protobuf wire format.
+func (e *Election) MarshalProto() []byte {
+	var b []byte
+	b = protowire.AppendTag(b, 1, protowire.BytesType)
+	b = protowire.AppendString(b, e.ID)
+	b = protowire.AppendTag(b, 2, protowire.VarintType)
+	b = protowire.AppendVarint(b, uint64(e.EndTime.Unix()))
+	if e.MinTurnout != 0 {
+		b = protowire.AppendTag(b, 3, protowire.VarintType)
+		b = protowire.AppendVarint(b, e.MinTurnout)
+	}
+	if e.MinTurnoutPercent != 0 {
+		b = protowire.AppendTag(b, 4, protowire.VarintType)
+		b = protowire.AppendVarint(b, e.MinTurnoutPercent)
+	}
+	if e.EligibleVoters != 0 {
+		b = protowire.AppendTag(b, 5, protowire.VarintType)
+		b = protowire.AppendVarint(b, e.EligibleVoters)
+	}
+	if e.StartTime.Unix() > 0 {
+		b = protowire.AppendTag(b, 6, protowire.VarintType)
+		b = protowire.AppendVarint(b, uint64(e.StartTime.Unix()))
+	}
+	return b
+}
+
+// UnmarshalProto decodes an election from protobuf wire format.
+func (e *Election) UnmarshalProto(b []byte) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+		switch {
+		case num == 1 && typ == protowire.BytesType:
+			v, n := protowire.ConsumeString(b)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			b = b[n:]
+			e.ID = v
+		case num == 2 && typ == protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			b = b[n:]
+			e.EndTime = time.Unix(int64(v), 0)
+		case num == 6 && typ == protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			b = b[n:]
+			e.StartTime = time.Unix(int64(v), 0)
+		case num >= 3 && num <= 5 && typ == protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			b = b[n:]
+			switch num {
+			case 3:
+				e.MinTurnout = v
+			case 4:
+				e.MinTurnoutPercent = v
+			case 5:
+				e.EligibleVoters = v
+			}
+		default:
+			n := protowire.ConsumeFieldValue(num, typ, b)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			b = b[n:]
+		}
+	}
+	return nil
+}
+
+// QuorumMet reports whether turnout distinct voters meet the election's
+// turnout thresholds.
+func (e Election) QuorumMet(turnout uint64) bool {
+	return turnout >= e.MinTurnout && turnout*100 >= e.MinTurnoutPercent*e.EligibleVoters
+}
+
+// ScheduledElections returns the elections scheduled by the genesis block,
+// sorted by ID.
+func (c *Chain) ScheduledElections() []Election {
+	list := make([]Election, 0, len(c.elections))
+	for _, e := range c.elections {
+		list = append(list, e)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	return list
+}
+
+// ScheduledElection returns the schedule of the election, if the genesis block
+// has one.
+func (c *Chain) ScheduledElection(electionID string) (Election, bool) {
+	e, ok := c.elections[electionID] // Fixed by the genesis block, so no lock is needed
+	return e, ok
+}
+
+// checkElectionWindow rejects a vote or commitment made outside its election's
+// window, at being the block timestamp, or the current time for a transaction
+// not yet in a block. Only the window of the election the vote names applies.
+func (c *Chain) checkElectionWindow(tx *Transaction, at time.Time) error {
+	if tx.GetKind() != TxKindVote && tx.GetKind() != TxKindVoteCommit {
+		return nil
+	}
+	e, ok := c.elections[string(tx.GetPayload())]
+	switch {
+	case !ok:
+		return nil
+	case at.Before(e.StartTime):
+		return fmt.Errorf("%w: %q opens at %s", ErrElectionClosed, e.ID, e.StartTime.UTC().Format(time.RFC3339))
+	case !at.Before(e.EndTime):
+		return fmt.Errorf("%w: %q ended at %s", ErrElectionClosed, e.ID, e.EndTime.UTC().Format(time.RFC3339))
+	}
+	return nil
+}
+
+// ClosingHeight returns the height of the first block on the best chain
+// timestamped at or after end, which closes an election ending then, if the
+// chain has one yet.
+func (c *Chain) ClosingHeight(end time.Time) (uint64, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	ts := uint64(end.Unix())
+	i := sort.Search(len(c.blocks), func(i int) bool { return c.blocks[i].Header.Timestamp >= ts }) // Timestamps strictly increase
+	return uint64(i), i < len(c.blocks)
+}
+
+// --- Election Listing ---
+
+// ElectionSummary is one entry of the /elections response.
+type ElectionSummary struct {
+	ElectionID   string   `json:"election_id"`
+	Candidates   int      `json:"candidates"` // Registered candidates; 0 without an election authority
+	TotalVotes   uint64   `json:"total_votes"`
+	AbstainVotes uint64   `json:"abstain_votes"`
+	SpoiledVotes uint64   `json:"spoiled_votes"`
+	Leaders      []string `json:"leaders"`
+	Tie          bool     `json:"tie"`
+	Open         bool     `json:"open"`                 // Whether the election's window admits votes now
+	StartTime    uint64   `json:"start_time,omitempty"` // Unix seconds; set for a scheduled election opening after launch
+	EndTime      uint64   `json:"end_time,omitempty"`   // Unix seconds; set for a scheduled election
+}
+
+// ListElections handles GET /elections, summarizing every election on the best
+// chain by ID. Each election is tallied, and opens and closes, independently
+// of the others.
+func ListElections(node *P2PNode, w http.ResponseWriter, r *http.Request) {
+	now := node.Chain.now()
+	elections := []ElectionSummary{}
+	for _, id := range node.Chain.Elections() {
+		ballots := node.Chain.Tally.ElectionBallots(id)
+		summary := ElectionSummary{
+			ElectionID:   id,
+			Candidates:   len(node.Chain.Candidates.Candidates(id)),
+			TotalVotes:   node.Chain.Tally.ElectionTotal(id),
+			AbstainVotes: ballots[BallotAbstain],
+			SpoiledVotes: ballots[BallotSpoiled],
+		}
+		summary.Leaders, summary.Tie = leadingCandidates(sortedTallies(node.Chain.Tally.ElectionCounts(id)))
+		summary.Open = true
+		if e, ok := node.Chain.ScheduledElection(id); ok {
+			summary.Open = !now.Before(e.StartTime) && now.Before(e.EndTime)
+			summary.EndTime = uint64(e.EndTime.Unix())
+			if e.StartTime.Unix() > 0 {
+				summary.StartTime = uint64(e.StartTime.Unix())
+			}
+		}
+		elections = append(elections, summary)
+	}
+	w.Header().Set("Content-Type", contentTypeJSON)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"height":    node.Chain.Height(),
+		"elections": elections,
+	})
+}
+
+// --- Voter Turnout ---
+
+// TurnoutReport is a node's signed statement of an election's turnout at a
+// given height, checkable offline with VerifyTurnoutReport. It carries no
+// percentage: the voter registry is node-wide, not per election, so it says
+// nothing about how many voters were eligible in this one.
+type TurnoutReport struct {
+	ElectionID string `json:"election_id"`
+	Height     uint64 `json:"height"`               // Best chain height the turnout was counted at
+	Voters     uint64 `json:"voters"`               // Distinct senders with a vote on chain
+	Unrevealed uint64 `json:"unrevealed,omitempty"` // Commit-reveal commitments not (yet) revealed, so not counted
+	NodePubKey []byte `json:"node_pub_key"`
+	Timestamp  uint64 `json:"timestamp"` // Unix seconds when the report was issued
+	Signature  []byte `json:"signature"`
+}
+
+// SigningBytes returns the message the issuing node signs.
+func (r *TurnoutReport) SigningBytes() []byte {
+	msg := fmt.Sprintf("turnout|%q|%d|%d|%x|%d", r.ElectionID, r.Height, r.Voters, r.NodePubKey, r.Timestamp)
+	if r.Unrevealed != 0 {
+		msg += fmt.Sprintf("|unrevealed=%d", r.Unrevealed)
+	}
+	return []byte(msg)
+}
+
+// SignTurnoutReport reports the election's turnout on the best chain, signed
+// with the node's identity key.
+func (n *P2PNode) SignTurnoutReport(electionID string) *TurnoutReport {
+	report := &TurnoutReport{
+		ElectionID: electionID,
+		Height:     n.Chain.Height(),
+		Voters:     n.Chain.Tally.ElectionTurnout(electionID),
+		Unrevealed: n.Chain.Commitments.Unrevealed(electionID),
+		NodePubKey: n.PublicKey(),
+		Timestamp:  uint64(time.Now().Unix()),
+	}
+	report.Signature = ed25519.Sign(n.identityKey, report.SigningBytes())
+	return report
+}
+
+// VerifyTurnoutReport checks that report was signed by the node with public
+// key nodePubKey.
+func VerifyTurnoutReport(report *TurnoutReport, nodePubKey ed25519.PublicKey) error {
+	if !bytes.Equal(report.NodePubKey, nodePubKey) {
+		return fmt.Errorf("turnout report was issued by %x, expected %x", report.NodePubKey, []byte(nodePubKey))
+	}
+	if !ed25519.Verify(nodePubKey, report.SigningBytes(), report.Signature) {
+		return fmt.Errorf("turnout report signature is invalid")
+	}
+	return nil
+}
+
+// GetElectionTurnout handles GET /elections/{id}/turnout, returning a signed
+// TurnoutReport for an election known on the best chain.
+func GetElectionTurnout(node *P2PNode, w http.ResponseWriter, r *http.Request) {
+	electionID := r.PathValue("id")
+	if !slices.Contains(node.Chain.Elections(), electionID) {
+		writeError(w, http.StatusNotFound, ErrCodeNotFound, "no election with that ID is on chain")
+		return
+	}
+	w.Header().Set("Content-Type", contentTypeJSON)
+	json.NewEncoder(w).Encode(node.SignTurnoutReport(electionID))
+}
@@ -0,0 +1,98 @@
+// go_backend_elections_snippet_test.go
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestConcurrentElectionsTallyIndependently(t *testing.T) {
+	node := newTestNode(t)
+	c := newTestChain(t, 0)
+	node.UseChain(c)
+	// Interleaved across blocks; "a" stands in two elections
+	for _, votes := range [][]*Transaction{
+		{testVote("president", "a", 1), testVote("senate", "a", 2), testVote("local", "x", 3)},
+		{testVote("senate", "b", 4), testVote("president", "a", 5)},
+		{testVote("local", "y", 6), testVote("senate", "b", 7), testVote("president", "c", 8)},
+	} {
+		if err := c.AppendBlock(testBlock(c.Tip(), votes...)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rr := httptest.NewRecorder()
+	NewAPIHandler(node).ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/elections", nil))
+	var resp struct {
+		Height    uint64            `json:"height"`
+		Elections []ElectionSummary `json:"elections"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	want := []ElectionSummary{
+		{ElectionID: "local", TotalVotes: 2, Leaders: []string{"x", "y"}, Tie: true},
+		{ElectionID: "president", TotalVotes: 3, Leaders: []string{"a"}},
+		{ElectionID: "senate", TotalVotes: 3, Leaders: []string{"b"}},
+	}
+	if resp.Height != 3 || !slices.EqualFunc(resp.Elections, want, func(a, b ElectionSummary) bool {
+		return a.ElectionID == b.ElectionID && a.TotalVotes == b.TotalVotes && slices.Equal(a.Leaders, b.Leaders) && a.Tie == b.Tie
+	}) {
+		t.Errorf("/elections at %d: %+v, want %+v", resp.Height, resp.Elections, want)
+	}
+	if got := c.Tally.ElectionCounts("senate")["a"]; got != 1 {
+		t.Errorf("a has %d senate votes, want only its own 1", got)
+	}
+}
+
+func TestConcurrentElectionsKeepIndependentWindows(t *testing.T) {
+	launch := time.Unix(1_700_000_000, 0)
+	node, clock := scheduledNode(t, launch,
+		Election{ID: "president", EndTime: launch.Add(time.Hour)},
+		Election{ID: "senate", StartTime: launch.Add(10 * time.Minute), EndTime: launch.Add(time.Hour)},
+		Election{ID: "local", EndTime: launch.Add(5 * time.Minute)},
+	)
+	c := node.Chain
+
+	clock.Advance(time.Minute)
+	early := blockAt(c.Tip(), clock.Now(), testVote("president", "a", 1), testVote("senate", "b", 2))
+	if err := c.AppendBlock(early); !errors.Is(err, ErrElectionClosed) {
+		t.Errorf("senate vote before it opens: got %v, want ErrElectionClosed", err)
+	}
+	if err := c.AppendBlock(blockAt(c.Tip(), clock.Now(), testVote("president", "a", 1), testVote("local", "x", 3))); err != nil {
+		t.Fatal(err)
+	}
+
+	clock.Advance(19 * time.Minute)
+	if err := node.checkTxKind(testVote("local", "y", 4)); !errors.Is(err, ErrElectionClosed) {
+		t.Errorf("local vote after it ended: got %v, want ErrElectionClosed", err)
+	}
+	if err := c.AppendBlock(blockAt(c.Tip(), clock.Now(), testVote("senate", "b", 2), testVote("president", "c", 5))); err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	NewAPIHandler(node).ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/elections", nil))
+	var resp struct {
+		Elections []ElectionSummary `json:"elections"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	want := []ElectionSummary{
+		{ElectionID: "local", TotalVotes: 1, Open: false, EndTime: uint64(launch.Add(5 * time.Minute).Unix())},
+		{ElectionID: "president", TotalVotes: 2, Open: true, EndTime: uint64(launch.Add(time.Hour).Unix())},
+		{ElectionID: "senate", TotalVotes: 1, Open: true, StartTime: uint64(launch.Add(10 * time.Minute).Unix()), EndTime: uint64(launch.Add(time.Hour).Unix())},
+	}
+	if !slices.EqualFunc(resp.Elections, want, func(a, b ElectionSummary) bool {
+		return a.ElectionID == b.ElectionID && a.TotalVotes == b.TotalVotes && a.Open == b.Open && a.StartTime == b.StartTime && a.EndTime == b.EndTime
+	}) {
+		t.Errorf("/elections: %+v, want %+v", resp.Elections, want)
+	}
+}
@@ -0,0 +1,113 @@
+// go_backend_explorer_snippet.go
+
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// --- Explorer Summary ---
+
+// ExplorerRateWindow is how far back /explorer/summary looks to measure the
+// block rate.
+const ExplorerRateWindow = 10 * time.Minute
+
+// ExplorerCacheTTL is the longest a summary is reused while the tip is
+// unchanged, bounding how stale its peer count and rates get.
+const ExplorerCacheTTL = 5 * time.Second
+
+// ExplorerElection is one election's turnout in an ExplorerSummary.
+type ExplorerElection struct {
+	ElectionID string `json:"election_id"`
+	TotalVotes uint64 `json:"total_votes"`
+	Voters     uint64 `json:"voters"` // Distinct senders with a vote on chain
+}
+
+// ExplorerSummary is the /explorer/summary response: what a block explorer's
+// dashboard shows, in one call.
+type ExplorerSummary struct {
+	TipHeight          uint64             `json:"tip_height"`
+	TipHash            string             `json:"tip_hash"`
+	BlocksPerMinute    float64            `json:"blocks_per_minute"` // Over the last ExplorerRateWindow
+	TotalTransactions  uint64             `json:"total_transactions"`
+	ValidatorsActive   int                `json:"validators_active"`
+	Elections          []ExplorerElection `json:"elections"` // Only those still accepting votes
+	PeerCount          int                `json:"peer_count"`
+	AvgFinalitySeconds float64            `json:"avg_finality_seconds"` // Mean time from mempool admission to inclusion, over recent transactions
+	GeneratedAt        uint64             `json:"generated_at"`         // Unix seconds when the summary was computed
+}
+
+// explorerCache holds the last summary computed, so explorers polling the
+// endpoint do not each walk the chain and tally.
+type explorerCache struct {
+	mu      sync.Mutex
+	summary *ExplorerSummary
+	tipHash []byte
+	at      time.Time
+}
+
+// TxCount returns how many transactions the best chain includes.
+func (c *Chain) TxCount() uint64 {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return uint64(len(c.txHeight))
+}
+
+// BlocksSince returns how many blocks on the best chain are timestamped at or
+// after t, walking back from the tip.
+func (c *Chain) BlocksSince(t time.Time) int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	count := 0
+	for h := len(c.blocks) - 1; h > 0 && c.blocks[h].Header.Timestamp >= uint64(t.Unix()); h-- {
+		count++
+	}
+	return count
+}
+
+// ExplorerSummary returns the node's explorer summary, reusing the cached one
+// if the tip has not moved and it is less than ExplorerCacheTTL old.
+func (n *P2PNode) ExplorerSummary() *ExplorerSummary {
+	now := time.Now()
+	tip := n.Chain.Tip()
+	n.explorer.mu.Lock()
+	defer n.explorer.mu.Unlock()
+	if n.explorer.summary != nil && bytes.Equal(n.explorer.tipHash, tip.Header.Hash) && now.Sub(n.explorer.at) < ExplorerCacheTTL {
+		return n.explorer.summary
+	}
+
+	summary := &ExplorerSummary{
+		TipHeight:          tip.Header.Height,
+		TipHash:            hex.EncodeToString(tip.Header.Hash),
+		BlocksPerMinute:    float64(n.Chain.BlocksSince(now.Add(-ExplorerRateWindow))) / ExplorerRateWindow.Minutes(),
+		TotalTransactions:  n.Chain.TxCount(),
+		ValidatorsActive:   len(n.Chain.Validators.ActiveAt(tip.Header.Height)),
+		Elections:          []ExplorerElection{},
+		PeerCount:          n.PeerCount(),
+		AvgFinalitySeconds: n.inclusionLatency.Mean().Seconds(),
+		GeneratedAt:        uint64(now.Unix()),
+	}
+	// Votes close for every election together, at the genesis RevealEnd
+	if _, end := n.Chain.RevealWindow(); end == 0 || tip.Header.Height+1 < end {
+		for _, id := range n.Chain.Elections() {
+			summary.Elections = append(summary.Elections, ExplorerElection{
+				ElectionID: id,
+				TotalVotes: n.Chain.Tally.ElectionTotal(id),
+				Voters:     n.Chain.Tally.ElectionTurnout(id),
+			})
+		}
+	}
+	n.explorer.summary, n.explorer.tipHash, n.explorer.at = summary, tip.Header.Hash, now
+	return summary
+}
+
+// GetExplorerSummary handles GET /explorer/summary.
+func GetExplorerSummary(node *P2PNode, w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", contentTypeJSON)
+	json.NewEncoder(w).Encode(node.ExplorerSummary())
+}
@@ -0,0 +1,64 @@
+// go_backend_explorer_snippet_test.go
+
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+)
+
+func TestExplorerSummaryReflectsChain(t *testing.T) {
+	node := newTestNode(t)
+	c := newTestChain(t, 0)
+	node.UseChain(c)
+	extendChain(t, c, 3)
+
+	summary := node.ExplorerSummary()
+	tip := c.Tip()
+	if summary.TipHeight != 3 || summary.TipHash != hex.EncodeToString(tip.Header.Hash) {
+		t.Errorf("tip %d %s, want 3 %x", summary.TipHeight, summary.TipHash, tip.Header.Hash)
+	}
+	if summary.TotalTransactions != 3 {
+		t.Errorf("%d transactions, want 3", summary.TotalTransactions)
+	}
+	if want := len(c.Validators.ActiveAt(3)); summary.ValidatorsActive != want {
+		t.Errorf("%d validators active, want %d", summary.ValidatorsActive, want)
+	}
+	if want := []ExplorerElection{{ElectionID: "e", TotalVotes: 3, Voters: 3}}; !slices.Equal(summary.Elections, want) {
+		t.Errorf("elections %+v, want %+v", summary.Elections, want)
+	}
+
+	rr := httptest.NewRecorder()
+	GetElectionStatus(node, rr, httptest.NewRequest(http.MethodGet, "/status?election_id=e", nil))
+	var status ElectionStatus
+	if err := json.Unmarshal(rr.Body.Bytes(), &status); err != nil {
+		t.Fatal(err)
+	}
+	if status.BlockHeight != summary.TipHeight || status.LatestBlockHash != "0x"+summary.TipHash || int(status.ValidatorsActive) != summary.ValidatorsActive {
+		t.Errorf("/status reports height %d, hash %s, %d validators; summary has %d, %s, %d",
+			status.BlockHeight, status.LatestBlockHash, status.ValidatorsActive, summary.TipHeight, summary.TipHash, summary.ValidatorsActive)
+	}
+}
+
+func TestExplorerSummaryOmitsClosedElections(t *testing.T) {
+	node := newTestNode(t)
+	c := newRevealChain(t, 2, 3)
+	node.UseChain(c)
+	commit, _ := testCommit("sealed", "a", 1)
+	if err := c.AppendBlock(testBlock(c.Tip(), commit)); err != nil {
+		t.Fatal(err)
+	}
+	if got := node.ExplorerSummary().Elections; len(got) != 1 {
+		t.Fatalf("elections %+v while votes are open, want sealed", got)
+	}
+	if err := c.AppendBlock(testBlock(c.Tip())); err != nil {
+		t.Fatal(err)
+	}
+	if got := node.ExplorerSummary().Elections; len(got) != 0 {
+		t.Errorf("elections %+v after votes closed, want none", got)
+	}
+}
@@ -0,0 +1,148 @@
+// go_backend_fees_snippet.go
+
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/bits"
+	"sync"
+)
+
+// --- Transaction Fees ---
+
+// ErrBadFees is returned for a block whose header fee total does not match
+// its transactions, or whose fees are credited to a proposer that is not a
+// validator.
+var ErrBadFees = errors.New("invalid block fees")
+
+// SumFees returns the total Fee of txs, or an error if it overflows.
+func SumFees(txs []*Transaction) (uint64, error) {
+	var total uint64
+	for _, tx := range txs {
+		sum, carry := bits.Add64(total, tx.GetFee(), 0)
+		if carry != 0 {
+			return 0, fmt.Errorf("%w: fee total overflows", ErrBadFees)
+		}
+		total = sum
+	}
+	return total, nil
+}
+
+// checkBlockFees rejects a block whose header Fees is not the sum of its
+// transactions' fees.
+func checkBlockFees(blk *Block) error {
+	total, err := SumFees(blk.Transactions)
+	if err != nil {
+		return fmt.Errorf("block %d: %w", blk.Header.Height, err)
+	}
+	if total != blk.Header.GetFees() {
+		return fmt.Errorf("%w: block %d declares fees %d, its transactions pay %d", ErrBadFees, blk.Header.Height, blk.Header.GetFees(), total)
+	}
+	return nil
+}
+
+// FeeLedger accounts for the fees of the blocks applied to it. Depending on the
+// genesis fee policy, each block's fees are either credited to its proposer or
+// burned. Like Tally, blocks are reverted when a reorg orphans them, so the
+// ledger always matches the current best chain.
+type FeeLedger struct {
+	mu       sync.RWMutex
+	burn     bool
+	credited map[string]uint64 // hex(proposer key) -> fees credited
+	burned   uint64
+}
+
+// NewFeeLedger creates an empty ledger that burns fees if burn is set and
+// credits them to block proposers otherwise.
+func NewFeeLedger(burn bool) *FeeLedger {
+	return &FeeLedger{burn: burn, credited: make(map[string]uint64)}
+}
+
+// BurnsFees reports whether fees are burned rather than credited to proposers.
+func (l *FeeLedger) BurnsFees() bool {
+	return l.burn
+}
+
+// checkProposer rejects a block that credits fees to a proposer which is not
+// a validator at the block's height, since nobody else could have produced it.
+func (l *FeeLedger) checkProposer(blk *Block, validators *ValidatorSet) error {
+	if l.burn || blk.Header.GetFees() == 0 {
+		return nil
+	}
+	for _, key := range validators.ActiveAt(blk.Header.Height) {
+		if bytes.Equal(key, blk.Header.GetProposer()) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: block %d credits fees to %x, which is not a validator", ErrBadFees, blk.Header.Height, blk.Header.GetProposer())
+}
+
+// applyBlock credits or burns the fees of blk. blk must already be validated.
+func (l *FeeLedger) applyBlock(blk *Block) {
+	fees := blk.Header.GetFees()
+	if fees == 0 {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.burn {
+		l.burned += fees
+		return
+	}
+	l.credited[hex.EncodeToString(blk.Header.GetProposer())] += fees
+}
+
+// revertBlock undoes applyBlock for a previously applied blk.
+func (l *FeeLedger) revertBlock(blk *Block) {
+	fees := blk.Header.GetFees()
+	if fees == 0 {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.burn {
+		l.burned -= fees
+		return
+	}
+	proposer := hex.EncodeToString(blk.Header.GetProposer())
+	l.credited[proposer] -= fees
+	if l.credited[proposer] == 0 {
+		delete(l.credited, proposer)
+	}
+}
+
+// reset clears every credit and the burned total.
+func (l *FeeLedger) reset() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.credited = make(map[string]uint64)
+	l.burned = 0
+}
+
+// Credited returns the fees credited to proposer over the best chain.
+func (l *FeeLedger) Credited(proposer []byte) uint64 {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.credited[hex.EncodeToString(proposer)]
+}
+
+// Burned returns the fees burned over the best chain.
+func (l *FeeLedger) Burned() uint64 {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.burned
+}
+
+// Total returns all fees paid over the best chain, credited or burned.
+func (l *FeeLedger) Total() uint64 {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	total := l.burned
+	for _, fees := range l.credited {
+		total += fees
+	}
+	return total
+}
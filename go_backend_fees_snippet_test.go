@@ -0,0 +1,88 @@
+// go_backend_fees_snippet_test.go
+
+package main
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"testing"
+)
+
+// feeChain returns a chain whose sole validator is proposer, burning fees if burn is set.
+func feeChain(t *testing.T, proposer []byte, burn bool) *Chain {
+	t.Helper()
+	cfg := DefaultGenesisConfig()
+	cfg.InitialValidators, cfg.BurnFees = [][]byte{proposer}, burn
+	c, err := NewChain(GenesisBlock(cfg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+// feeVotes returns a vote paying each of fees.
+func feeVotes(fees ...uint64) []*Transaction {
+	var txs []*Transaction
+	for i, fee := range fees {
+		tx := testVote("e", "a", i+1)
+		tx.Fee = fee
+		signTestTx(tx, testVoterKey(i+1))
+		txs = append(txs, tx)
+	}
+	return txs
+}
+
+func TestBlockFeesAreCreditedOrBurned(t *testing.T) {
+	proposer, _, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	txs := feeVotes(3, 0, 5, 7)
+	nodes := []*Chain{feeChain(t, proposer, false), feeChain(t, proposer, false)}
+	blk := nodes[0].ProposeBlock(proposer, txs)
+	if blk.Header.Fees != 15 {
+		t.Fatalf("block declares fees %d, want 15", blk.Header.Fees)
+	}
+	for i, c := range nodes {
+		if err := c.AppendBlock(blk); err != nil {
+			t.Fatal(err)
+		}
+		if c.Fees.Credited(proposer) != 15 || c.Fees.Burned() != 0 || c.Fees.Total() != 15 {
+			t.Errorf("node %d: credited %d, burned %d, total %d; want 15 credited to the proposer",
+				i, c.Fees.Credited(proposer), c.Fees.Burned(), c.Fees.Total())
+		}
+	}
+
+	burner := feeChain(t, proposer, true)
+	if err := burner.AppendBlock(burner.ProposeBlock(proposer, feeVotes(3, 0, 5, 7))); err != nil {
+		t.Fatal(err)
+	}
+	if burner.Fees.Burned() != 15 || burner.Fees.Credited(proposer) != 0 {
+		t.Errorf("burned %d, credited %d; want all 15 burned", burner.Fees.Burned(), burner.Fees.Credited(proposer))
+	}
+}
+
+func TestBlockFeesMustMatchTransactions(t *testing.T) {
+	proposer, _, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	outsider, _, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := feeChain(t, proposer, false)
+
+	overstated := c.ProposeBlock(proposer, feeVotes(3, 5))
+	overstated.Header.Fees++
+	overstated.Header.Hash = overstated.Header.ComputeHash(SHA3Hasher{})
+	if err := c.AppendBlock(overstated); !errors.Is(err, ErrBadFees) {
+		t.Errorf("overstated fees: got %v, want ErrBadFees", err)
+	}
+	if err := c.AppendBlock(c.ProposeBlock(outsider, feeVotes(3, 5))); !errors.Is(err, ErrBadFees) {
+		t.Errorf("fees credited to a non-validator: got %v, want ErrBadFees", err)
+	}
+	if c.Height() != 0 || c.Fees.Total() != 0 {
+		t.Errorf("rejected blocks left height %d and fees %d", c.Height(), c.Fees.Total())
+	}
+}
@@ -0,0 +1,128 @@
+// go_backend_identity_snippet.go
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// --- Voter Identity Verification ---
+
+// HTTPIdentityVerifier defaults: each attempt times out after
+// DefaultIdentityVerifyTimeout, and a failed attempt is retried up to
+// DefaultIdentityVerifyRetries more times, waiting DefaultIdentityVerifyBackoff
+// before the first retry and doubling after each.
+const (
+	DefaultIdentityVerifyTimeout = 5 * time.Second
+	DefaultIdentityVerifyRetries = 2
+	DefaultIdentityVerifyBackoff = 500 * time.Millisecond
+)
+
+// IdentityVerifier checks a voter's NIN/BVN with an external identity
+// authority, such as NIMC, before the voter is registered.
+type IdentityVerifier interface {
+	// Verify reports whether ninBvn identifies a real person who may register.
+	// An error means the authority could not answer, not that it said no.
+	Verify(ninBvn string) (bool, error)
+}
+
+// MockIdentityVerifier accepts every NIN/BVN except those in Reject. It stands
+// in for NIMC in development and in tests.
+type MockIdentityVerifier struct {
+	Reject map[string]bool
+}
+
+// Verify implements IdentityVerifier.
+func (m MockIdentityVerifier) Verify(ninBvn string) (bool, error) {
+	return !m.Reject[ninBvn], nil
+}
+
+// HTTPIdentityVerifier asks an external verification service over HTTP. It
+// POSTs {"nin_bvn": "..."} to URL and expects {"verified": true|false} with
+// status 200. Network errors and 5xx responses are retried; any other status
+// is an error straight away.
+type HTTPIdentityVerifier struct {
+	URL     string
+	Token   string        // Sent as a bearer token if set
+	Client  *http.Client  // Its Timeout bounds each attempt
+	Retries int           // Further attempts after a failed one
+	Backoff time.Duration // Wait before the first retry, doubled after each
+}
+
+// NewHTTPIdentityVerifier creates a verifier for the service at url with the
+// default timeout and retries.
+func NewHTTPIdentityVerifier(url string) *HTTPIdentityVerifier {
+	return &HTTPIdentityVerifier{
+		URL:     url,
+		Client:  &http.Client{Timeout: DefaultIdentityVerifyTimeout},
+		Retries: DefaultIdentityVerifyRetries,
+		Backoff: DefaultIdentityVerifyBackoff,
+	}
+}
+
+// Verify implements IdentityVerifier.
+func (v *HTTPIdentityVerifier) Verify(ninBvn string) (bool, error) {
+	body, err := json.Marshal(map[string]string{"nin_bvn": ninBvn})
+	if err != nil {
+		return false, err
+	}
+	backoff := v.Backoff
+	for attempt := 0; ; attempt++ {
+		verified, retry, err := v.ask(body)
+		if err == nil {
+			return verified, nil
+		}
+		if !retry || attempt >= v.Retries {
+			return false, fmt.Errorf("identity verification failed after %d attempts: %w", attempt+1, err)
+		}
+		log.Printf("Identity verification attempt %d failed, retrying in %v: %v", attempt+1, backoff, err)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// ask makes one verification request, reporting whether a failure is worth
+// retrying.
+func (v *HTTPIdentityVerifier) ask(body []byte) (verified, retry bool, err error) {
+	req, err := http.NewRequest(http.MethodPost, v.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, false, err
+	}
+	req.Header.Set("Content-Type", contentTypeJSON)
+	if v.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+v.Token)
+	}
+	resp, err := v.Client.Do(req)
+	if err != nil {
+		return false, true, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return false, resp.StatusCode >= 500, fmt.Errorf("verification service returned %s", resp.Status)
+	}
+	var result struct {
+		Verified bool `json:"verified"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return false, false, fmt.Errorf("malformed verification response: %v", err)
+	}
+	return result.Verified, false, nil
+}
+
+// RejectingIdentityVerifier rejects every NIN/BVN. It is the default, so a
+// node nobody configured a verifier for registers no one rather than everyone.
+type RejectingIdentityVerifier struct{}
+
+// Verify implements IdentityVerifier.
+func (RejectingIdentityVerifier) Verify(ninBvn string) (bool, error) {
+	return false, nil
+}
+
+// identityVerifier checks every registration. Deployments replace the default,
+// which rejects everyone, e.g. with an HTTPIdentityVerifier.
+var identityVerifier IdentityVerifier = RejectingIdentityVerifier{}
@@ -0,0 +1,139 @@
+// go_backend_identity_snippet_test.go
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// countingVerifier wraps an IdentityVerifier, counting its calls.
+type countingVerifier struct {
+	IdentityVerifier
+	calls atomic.Int32
+}
+
+func (v *countingVerifier) Verify(ninBvn string) (bool, error) {
+	v.calls.Add(1)
+	return v.IdentityVerifier.Verify(ninBvn)
+}
+
+// failingVerifier is an identity authority that cannot be reached.
+type failingVerifier struct{}
+
+func (failingVerifier) Verify(string) (bool, error) { return false, errors.New("unreachable") }
+
+func TestRegisterVoterVerifiesIdentity(t *testing.T) {
+	useVoterStore(t)
+	identityVerifier = MockIdentityVerifier{Reject: map[string]bool{"99999999999": true}}
+
+	if rr := postJSON(t, RegisterVoter, "/register", map[string]string{"nin_bvn": "12345678901", "password": "pw"}); rr.Code != http.StatusOK {
+		t.Errorf("verified voter: status %d, body %s", rr.Code, rr.Body)
+	}
+	rr := postJSON(t, RegisterVoter, "/register", map[string]string{"nin_bvn": "99999999999", "password": "pw"})
+	if rr.Code != http.StatusUnprocessableEntity || errorCode(t, rr) != ErrCodeNotVerified {
+		t.Errorf("rejected voter: status %d, body %s", rr.Code, rr.Body)
+	}
+	if voterStore.Registered(hashNINBVN("99999999999")) {
+		t.Error("rejected voter was registered")
+	}
+
+	identityVerifier = failingVerifier{}
+	rr = postJSON(t, RegisterVoter, "/register", map[string]string{"nin_bvn": "55555555555", "password": "pw"})
+	if rr.Code != http.StatusServiceUnavailable || errorCode(t, rr) != ErrCodeUnavailable {
+		t.Errorf("verifier down: status %d, body %s", rr.Code, rr.Body)
+	}
+}
+
+func TestRegisterVoterDefaultVerifierRejects(t *testing.T) {
+	useVoterStore(t)
+	identityVerifier = RejectingIdentityVerifier{}
+	rr := postJSON(t, RegisterVoter, "/register", map[string]string{"nin_bvn": "12345678901", "password": "pw"})
+	if rr.Code != http.StatusUnprocessableEntity {
+		t.Errorf("unconfigured verifier: status %d, want 422", rr.Code)
+	}
+}
+
+func TestRegisterVoterChecksDuplicateBeforeVerifying(t *testing.T) {
+	useVoterStore(t)
+	verifier := &countingVerifier{IdentityVerifier: MockIdentityVerifier{}}
+	identityVerifier = verifier
+	req := map[string]string{"nin_bvn": "12345678901", "password": "pw"}
+	postJSON(t, RegisterVoter, "/register", req)
+	if rr := postJSON(t, RegisterVoter, "/register", req); rr.Code != http.StatusOK {
+		t.Fatalf("duplicate: status %d, want 200", rr.Code)
+	}
+	if calls := verifier.calls.Load(); calls != 1 {
+		t.Errorf("verifier called %d times, want 1", calls)
+	}
+}
+
+// verificationService serves the HTTPIdentityVerifier protocol, answering
+// with handle.
+func verificationService(t *testing.T, handle func(w http.ResponseWriter, ninBvn string)) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			NINBVN string `json:"nin_bvn"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		handle(w, req.NINBVN)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestHTTPIdentityVerifier(t *testing.T) {
+	srv := verificationService(t, func(w http.ResponseWriter, ninBvn string) {
+		json.NewEncoder(w).Encode(map[string]bool{"verified": ninBvn == "12345678901"})
+	})
+	v := NewHTTPIdentityVerifier(srv.URL)
+	if ok, err := v.Verify("12345678901"); !ok || err != nil {
+		t.Errorf("known NIN: got %v, %v", ok, err)
+	}
+	if ok, err := v.Verify("99999999999"); ok || err != nil {
+		t.Errorf("unknown NIN: got %v, %v", ok, err)
+	}
+}
+
+func TestHTTPIdentityVerifierRetriesServerErrors(t *testing.T) {
+	var calls atomic.Int32
+	srv := verificationService(t, func(w http.ResponseWriter, ninBvn string) {
+		if calls.Add(1) == 1 {
+			http.Error(w, "busy", http.StatusServiceUnavailable)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]bool{"verified": true})
+	})
+	v := NewHTTPIdentityVerifier(srv.URL)
+	v.Backoff = time.Millisecond
+	if ok, err := v.Verify("12345678901"); !ok || err != nil {
+		t.Errorf("after a 503: got %v, %v", ok, err)
+	}
+	if n := calls.Load(); n != 2 {
+		t.Errorf("%d attempts, want 2", n)
+	}
+}
+
+func TestHTTPIdentityVerifierTimesOut(t *testing.T) {
+	release := make(chan struct{})
+	srv := verificationService(t, func(w http.ResponseWriter, ninBvn string) {
+		<-release
+	})
+	defer close(release)
+	v := NewHTTPIdentityVerifier(srv.URL)
+	v.Client.Timeout = 20 * time.Millisecond
+	v.Retries, v.Backoff = 1, time.Millisecond
+
+	start := time.Now()
+	if _, err := v.Verify("12345678901"); err == nil {
+		t.Fatal("a hung service verified the voter")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("gave up after %s", elapsed)
+	}
+}
@@ -0,0 +1,56 @@
+// go_backend_logging_snippet.go
+
+package main
+
+import (
+	"io"
+	"log"
+	"os"
+
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+// --- Log Output ---
+
+// LogConfig controls where the node writes its logs. With an empty File, logs
+// go to stderr only, as before.
+type LogConfig struct {
+	File       string // Log file path; rotated by size when set
+	MaxSizeMB  int    // Size at which the current file is rotated
+	MaxBackups int    // Rotated files to keep; older ones are deleted
+	MaxAgeDays int    // Delete rotated files older than this; 0 keeps them regardless of age
+	Compress   bool   // Gzip rotated files
+	Stderr     bool   // Also mirror logs to stderr when File is set
+}
+
+// DefaultLogConfig returns the log settings for a node with no overrides: 100MB
+// files, ten kept, mirrored to stderr.
+func DefaultLogConfig() LogConfig {
+	return LogConfig{
+		MaxSizeMB:  100,
+		MaxBackups: 10,
+		Stderr:     true,
+	}
+}
+
+// SetupLogging points the standard logger at the output cfg describes. The
+// returned func closes the log file; call it on shutdown.
+func SetupLogging(cfg LogConfig) func() error {
+	if cfg.File == "" {
+		log.SetOutput(os.Stderr)
+		return func() error { return nil }
+	}
+	file := &lumberjack.Logger{
+		Filename:   cfg.File,
+		MaxSize:    cfg.MaxSizeMB,
+		MaxBackups: cfg.MaxBackups,
+		MaxAge:     cfg.MaxAgeDays,
+		Compress:   cfg.Compress,
+	}
+	if cfg.Stderr {
+		log.SetOutput(io.MultiWriter(file, os.Stderr))
+	} else {
+		log.SetOutput(file)
+	}
+	return file.Close
+}
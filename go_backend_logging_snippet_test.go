@@ -0,0 +1,46 @@
+// go_backend_logging_snippet_test.go
+
+package main
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLogFileRotatesAndPrunes(t *testing.T) {
+	dir := t.TempDir()
+	cfg := LogConfig{File: filepath.Join(dir, "node.log"), MaxSizeMB: 1, MaxBackups: 2}
+	closeLog := SetupLogging(cfg)
+	t.Cleanup(func() {
+		closeLog()
+		log.SetOutput(os.Stderr)
+	})
+
+	line := strings.Repeat("x", 1023)
+	for i := 0; i < 4*1024; i++ { // About four files' worth
+		log.Print(line)
+	}
+
+	backups := func() []string {
+		matches, err := filepath.Glob(filepath.Join(dir, "node-*.log"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return matches
+	}
+	// Old files are pruned in the background
+	waitUntil(t, func() bool { return len(backups()) <= cfg.MaxBackups })
+	if len(backups()) == 0 {
+		t.Fatal("log never rolled over")
+	}
+	info, err := os.Stat(cfg.File)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() > int64(cfg.MaxSizeMB)<<20 {
+		t.Errorf("current log is %d bytes, over the %dMB limit", info.Size(), cfg.MaxSizeMB)
+	}
+}
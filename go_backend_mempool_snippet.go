@@ -0,0 +1,648 @@
+// go_backend_mempool_snippet.go
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// --- Mempool ---
+
+// DefaultMempoolCapacity is the maximum number of pending transactions a node holds.
+const DefaultMempoolCapacity = 1000
+
+// DefaultMempoolHighWater is the saturation above which /vote stops accepting
+// votes, leaving headroom for transactions gossiped from peers.
+const DefaultMempoolHighWater = 0.9
+
+// Anti-entropy defaults: every DefaultAntiEntropyInterval, compare mempools with
+// DefaultAntiEntropyPeers random peers and fetch what we are missing.
+const (
+	DefaultAntiEntropyInterval = 30 * time.Second
+	DefaultAntiEntropyPeers    = 1
+)
+
+// MaxTxFetch caps how many transactions one GetTransactions call returns.
+const MaxTxFetch = 500
+
+// antiEntropyFetched counts transactions recovered by anti-entropy that push gossip missed.
+var antiEntropyFetched = expvar.NewInt("mempool_anti_entropy_fetched_txs")
+
+// DefaultMempoolSweepInterval is how often expired transactions are removed from the mempool.
+const DefaultMempoolSweepInterval = time.Minute
+
+// mempoolSwept counts transactions removed from the mempool because they expired.
+var mempoolSwept = expvar.NewInt("mempool_swept_txs")
+
+// DefaultMaxMempoolAge bounds how long a transaction may wait for inclusion,
+// however recent its timestamp, so votes that can never be included (say, for
+// a closed election) do not occupy the mempool forever.
+const DefaultMaxMempoolAge = 30 * time.Minute
+
+// mempoolEvictedAged counts transactions evicted for exceeding MaxMempoolAge.
+var mempoolEvictedAged = expvar.NewInt("mempool_evicted_aged_txs")
+
+// Rebroadcast defaults: a transaction still pending DefaultRebroadcastAfter
+// after it was last broadcast is gossiped again, at most DefaultMaxRebroadcasts times.
+const (
+	DefaultRebroadcastAfter = 2 * time.Minute
+	DefaultMaxRebroadcasts  = 3
+)
+
+// mempoolRebroadcast counts transactions gossiped again because they were not included in time.
+var mempoolRebroadcast = expvar.NewInt("mempool_rebroadcast_txs")
+
+// inclusionLatencyBuckets are the upper bounds of the tx_inclusion_latency
+// histogram. Slower inclusions are counted under "inf".
+var inclusionLatencyBuckets = []time.Duration{
+	500 * time.Millisecond, time.Second, 3 * time.Second, 10 * time.Second,
+	30 * time.Second, time.Minute, 5 * time.Minute,
+}
+
+// txInclusionLatency counts included transactions by how long they waited in
+// this node's mempool, keyed by bucket upper bound (see inclusionLatencyBuckets).
+var txInclusionLatency = expvar.NewMap("tx_inclusion_latency")
+
+// InclusionLatencySamples is how many recent inclusions the status percentiles cover.
+const InclusionLatencySamples = 1024
+
+var (
+	ErrMempoolFull = errors.New("mempool is full")
+	ErrTxKnown     = errors.New("transaction is already in the mempool")
+)
+
+// MempoolEntry is a pending transaction and when this node accepted it.
+type MempoolEntry struct {
+	Tx      *Transaction
+	AddedAt time.Time
+
+	Rebroadcasts    int       // Times the transaction has been gossiped again for not being included
+	LastRebroadcast time.Time // Zero until the first rebroadcast
+}
+
+// Mempool holds accepted transactions that are not yet in a block, keyed by hash.
+type Mempool struct {
+	mu       sync.RWMutex
+	txs      map[string]*MempoolEntry // hex(tx hash) -> entry
+	capacity int
+
+	Clock func() time.Time // Stamps AddedAt; defaults to time.Now (see UseChain)
+}
+
+// NewMempool creates an empty mempool holding at most capacity transactions.
+func NewMempool(capacity int) *Mempool {
+	return &Mempool{
+		txs:      make(map[string]*MempoolEntry),
+		capacity: capacity,
+	}
+}
+
+// now returns the current time according to the mempool's clock.
+func (m *Mempool) now() time.Time {
+	if m.Clock != nil {
+		return m.Clock()
+	}
+	return time.Now()
+}
+
+// Add inserts tx, returning ErrTxKnown if it is already pending and
+// ErrMempoolFull if the mempool is at capacity.
+func (m *Mempool) Add(tx *Transaction) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := fmt.Sprintf("%x", tx.GetHash())
+	if _, ok := m.txs[key]; ok {
+		return ErrTxKnown
+	}
+	if len(m.txs) >= m.capacity {
+		return ErrMempoolFull
+	}
+	m.txs[key] = &MempoolEntry{Tx: tx, AddedAt: m.now()}
+	return nil
+}
+
+// AddBatch inserts every transaction of txs that is not already pending,
+// reporting which ones it added. If the new ones do not all fit, it adds none
+// and returns ErrMempoolFull.
+func (m *Mempool) AddBatch(txs []*Transaction) ([]bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	added := make([]bool, len(txs))
+	fresh := make(map[string]bool, len(txs))
+	for i, tx := range txs {
+		key := fmt.Sprintf("%x", tx.GetHash())
+		if _, ok := m.txs[key]; !ok && !fresh[key] {
+			fresh[key] = true
+			added[i] = true
+		}
+	}
+	if len(m.txs)+len(fresh) > m.capacity {
+		return nil, ErrMempoolFull
+	}
+	now := m.now()
+	for i, tx := range txs {
+		if added[i] {
+			m.txs[fmt.Sprintf("%x", tx.GetHash())] = &MempoolEntry{Tx: tx, AddedAt: now}
+		}
+	}
+	return added, nil
+}
+
+// Remove drops the given transactions, e.g. once they are included in a block,
+// returning the entries that were actually pending.
+func (m *Mempool) Remove(txs []*Transaction) []*MempoolEntry {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var removed []*MempoolEntry
+	for _, tx := range txs {
+		key := fmt.Sprintf("%x", tx.GetHash())
+		if entry, ok := m.txs[key]; ok {
+			removed = append(removed, entry)
+			delete(m.txs, key)
+		}
+	}
+	return removed
+}
+
+// Get returns the pending transaction with the given hash, if any.
+func (m *Mempool) Get(hash []byte) (*Transaction, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	entry, ok := m.txs[fmt.Sprintf("%x", hash)]
+	if !ok {
+		return nil, false
+	}
+	return entry.Tx, true
+}
+
+// Len returns the number of pending transactions.
+func (m *Mempool) Len() int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return len(m.txs)
+}
+
+// Saturation returns how full the mempool is, from 0 (empty) to 1 (at capacity).
+func (m *Mempool) Saturation() float64 {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if m.capacity <= 0 {
+		return 1
+	}
+	return float64(len(m.txs)) / float64(m.capacity)
+}
+
+// Pending returns a snapshot of every pending transaction, oldest timestamp
+// first (ties broken by hash) so block proposals are deterministic.
+func (m *Mempool) Pending() []*Transaction {
+	entries := m.Entries()
+	txs := make([]*Transaction, len(entries))
+	for i, entry := range entries {
+		txs[i] = entry.Tx
+	}
+	return txs
+}
+
+// Entries returns a snapshot of every pending entry in the same order as Pending.
+func (m *Mempool) Entries() []MempoolEntry {
+	m.mu.RLock()
+	entries := make([]MempoolEntry, 0, len(m.txs))
+	for _, entry := range m.txs {
+		entries = append(entries, *entry)
+	}
+	m.mu.RUnlock()
+	sort.Slice(entries, func(i, j int) bool {
+		a, b := entries[i].Tx, entries[j].Tx
+		if a.GetTimestamp() != b.GetTimestamp() {
+			return a.GetTimestamp() < b.GetTimestamp()
+		}
+		return bytes.Compare(a.GetHash(), b.GetHash()) < 0
+	})
+	return entries
+}
+
+// SweepExpired removes transactions timestamped before cutoff and returns how
+// many it removed.
+func (m *Mempool) SweepExpired(cutoff time.Time) int {
+	return m.removeWhere(func(entry *MempoolEntry) bool {
+		return time.Unix(int64(entry.Tx.GetTimestamp()), 0).Before(cutoff)
+	})
+}
+
+// EvictAddedBefore removes transactions this node accepted before cutoff,
+// whatever their timestamp, and returns how many it removed.
+func (m *Mempool) EvictAddedBefore(cutoff time.Time) int {
+	return m.removeWhere(func(entry *MempoolEntry) bool {
+		return entry.AddedAt.Before(cutoff)
+	})
+}
+
+// removeWhere removes the entries matching pred. Matches are collected under
+// the read lock so Add and readers are only blocked for the deletes.
+func (m *Mempool) removeWhere(pred func(*MempoolEntry) bool) int {
+	m.mu.RLock()
+	var matched []string
+	for key, entry := range m.txs {
+		if pred(entry) {
+			matched = append(matched, key)
+		}
+	}
+	m.mu.RUnlock()
+	if len(matched) == 0 {
+		return 0
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, key := range matched {
+		delete(m.txs, key)
+	}
+	return len(matched)
+}
+
+// SweepMempool periodically removes transactions older than MaxTxAge, which
+// peers would reject anyway, so stale votes don't pile up in the mempool, and
+// transactions that have waited longer than MaxMempoolAge for a block. Both
+// are dropped from the WAL as well. Ages are measured on the chain's clock. It
+// stops when the node closes. This method should be run in a goroutine.
+func (n *P2PNode) SweepMempool() {
+	ticker := time.NewTicker(n.MempoolSweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			n.sweepMempoolAt(n.Chain.now())
+		case <-n.closing.Done():
+			return
+		}
+	}
+}
+
+// sweepMempoolAt runs one SweepMempool pass as of now.
+func (n *P2PNode) sweepMempoolAt(now time.Time) {
+	swept := n.Mempool.SweepExpired(now.Add(-n.MaxTxAge))
+	if swept > 0 {
+		mempoolSwept.Add(int64(swept))
+		log.Printf("Swept %d expired transactions from the mempool", swept)
+	}
+	evicted := 0
+	if n.MaxMempoolAge > 0 {
+		evicted = n.Mempool.EvictAddedBefore(now.Add(-n.MaxMempoolAge))
+	}
+	if evicted > 0 {
+		mempoolEvictedAged.Add(int64(evicted))
+		log.Printf("Evicted %d transactions pending for over %s from the mempool", evicted, n.MaxMempoolAge)
+	}
+	if swept+evicted > 0 {
+		n.pruneTxWAL() // Abandoned votes must not come back on restart
+	}
+}
+
+// DueForRebroadcast returns the transactions that have waited since before
+// cutoff, counting from their last rebroadcast or else their admission, and
+// have been rebroadcast fewer than maxAttempts times. Each returned transaction
+// is counted as rebroadcast at now.
+func (m *Mempool) DueForRebroadcast(cutoff, now time.Time, maxAttempts int) []*Transaction {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var due []*Transaction
+	for _, entry := range m.txs {
+		last := entry.AddedAt
+		if !entry.LastRebroadcast.IsZero() {
+			last = entry.LastRebroadcast
+		}
+		if entry.Rebroadcasts >= maxAttempts || !last.Before(cutoff) {
+			continue
+		}
+		entry.Rebroadcasts++
+		entry.LastRebroadcast = now
+		due = append(due, entry.Tx)
+	}
+	return due
+}
+
+// RebroadcastPending gossips transactions again that have been pending for
+// RebroadcastAfter, e.g. because their broadcast only reached one side of a
+// partition, up to MaxRebroadcasts times each. Included transactions leave the
+// mempool and so are never rebroadcast. It checks every RebroadcastAfter,
+// skipping rounds while the node sheds load, until the node closes, and
+// returns immediately if that is not positive. This method should be run in a
+// goroutine.
+func (n *P2PNode) RebroadcastPending() {
+	if n.RebroadcastAfter <= 0 {
+		return
+	}
+	ticker := time.NewTicker(n.RebroadcastAfter)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if !n.LoadShedding() {
+				n.rebroadcastRound(n.Chain.now())
+			}
+		case <-n.closing.Done():
+			return
+		}
+	}
+}
+
+// rebroadcastRound rebroadcasts the transactions due at now and returns how many.
+func (n *P2PNode) rebroadcastRound(now time.Time) int {
+	due := n.Mempool.DueForRebroadcast(now.Add(-n.RebroadcastAfter), now, n.MaxRebroadcasts)
+	for _, tx := range due {
+		n.BroadcastTransaction(tx)
+	}
+	if len(due) > 0 {
+		mempoolRebroadcast.Add(int64(len(due)))
+		log.Printf("Rebroadcast %d transactions pending for over %s", len(due), n.RebroadcastAfter)
+	}
+	return len(due)
+}
+
+// --- Mempool Anti-Entropy ---
+
+// GetMempool is a gRPC method that returns the hashes of every pending transaction.
+func (n *P2PNode) GetMempool(ctx context.Context, req *GetMempoolRequest) (*GetMempoolResponse, error) {
+	pending := n.Mempool.Pending()
+	resp := &GetMempoolResponse{TxHashes: make([][]byte, len(pending))}
+	for i, tx := range pending {
+		resp.TxHashes[i] = tx.GetHash()
+	}
+	return resp, nil
+}
+
+// GetTransactions is a gRPC method that returns the requested transactions that
+// are still pending here, at most MaxTxFetch of them.
+func (n *P2PNode) GetTransactions(ctx context.Context, req *GetTransactionsRequest) (*GetTransactionsResponse, error) {
+	resp := &GetTransactionsResponse{}
+	for _, hash := range req.GetHashes() {
+		if len(resp.Transactions) == MaxTxFetch {
+			break
+		}
+		if tx, ok := n.Mempool.Get(hash); ok {
+			resp.Transactions = append(resp.Transactions, tx)
+		}
+	}
+	return resp, nil
+}
+
+// RunAntiEntropy runs an anti-entropy round every AntiEntropyInterval, healing
+// gaps that push gossip leaves, e.g. when this node was briefly offline.
+// Rounds are skipped while the node sheds load, since they only add
+// transactions. It stops when the node closes. This method should be run in a
+// goroutine.
+func (n *P2PNode) RunAntiEntropy() {
+	ticker := time.NewTicker(n.AntiEntropyInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if !n.LoadShedding() {
+				n.antiEntropyRound()
+			}
+		case <-n.closing.Done():
+			return
+		}
+	}
+}
+
+// antiEntropyRound asks AntiEntropyPeers random peers for their mempool hashes,
+// fetches the transactions we don't have, and admits them as if they were pushed.
+// It returns how many transactions were added.
+func (n *P2PNode) antiEntropyRound() int {
+	n.mu.RLock()
+	var peers map[string]NodeServiceClient
+	if len(n.connectedPeers()) > 0 {
+		peers = n.randomPeers(n.AntiEntropyPeers)
+	}
+	n.mu.RUnlock()
+
+	added := 0
+	for addr, client := range peers {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		added += n.reconcileMempool(ctx, addr, client)
+		cancel()
+	}
+	if added > 0 {
+		antiEntropyFetched.Add(int64(added))
+		log.Printf("Anti-entropy recovered %d transactions", added)
+	}
+	return added
+}
+
+// reconcileMempool fetches and admits the transactions addr has pending that we lack.
+func (n *P2PNode) reconcileMempool(ctx context.Context, addr string, client NodeServiceClient) int {
+	resp, err := client.GetMempool(ctx, &GetMempoolRequest{})
+	if err != nil {
+		log.Printf("Anti-entropy: failed to get mempool from %s: %v", addr, err)
+		return 0
+	}
+	var missing [][]byte
+	for _, hash := range resp.GetTxHashes() {
+		if _, ok := n.Mempool.Get(hash); !ok {
+			if _, included := n.Chain.TxHeight(hash); !included {
+				missing = append(missing, hash)
+			}
+		}
+	}
+	if len(missing) == 0 {
+		return 0
+	}
+	if len(missing) > MaxTxFetch {
+		missing = missing[:MaxTxFetch] // The rest are picked up in later rounds
+	}
+
+	txResp, err := client.GetTransactions(ctx, &GetTransactionsRequest{Hashes: missing})
+	if err != nil {
+		log.Printf("Anti-entropy: failed to fetch transactions from %s: %v", addr, err)
+		return 0
+	}
+	added := 0
+	for _, tx := range txResp.GetTransactions() {
+		if err := n.checkPeerTx(tx); err != nil {
+			log.Printf("Anti-entropy: rejecting transaction %x from %s: %v", tx.GetHash(), addr, err)
+			continue
+		}
+		if err := n.Mempool.Add(tx); err != nil {
+			continue // Already have it, or full
+		}
+		n.recordAccepted(tx)
+		added++
+	}
+	return added
+}
+
+// --- Mempool Inspection ---
+
+// Page sizes for GET /admin/mempool
+const (
+	defaultMempoolPageSize = 100
+	maxMempoolPageSize     = 1000
+)
+
+// MempoolTxInfo describes one pending transaction in the /admin/mempool listing.
+type MempoolTxInfo struct {
+	TxHash     string `json:"tx_hash"`
+	Sender     string `json:"sender"`
+	Kind       TxKind `json:"kind"`
+	ElectionID string `json:"election_id,omitempty"` // Set for votes
+	AddedAt    int64  `json:"added_at"`              // Unix seconds when this node accepted it
+	AgeSeconds int64  `json:"age_seconds"`
+}
+
+// MempoolListing is the GET /admin/mempool response.
+type MempoolListing struct {
+	Total        int             `json:"total"` // Matching transactions across all pages
+	Offset       int             `json:"offset"`
+	Transactions []MempoolTxInfo `json:"transactions"`
+}
+
+// ListMempool handles GET /admin/mempool, listing pending transactions so an
+// operator can tell whether a vote was accepted but not yet included. Results
+// are in proposal order and paginated with ?offset= and ?limit=; ?election=
+// restricts them to votes in one election.
+func ListMempool(node *P2PNode, w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(node, w, r) {
+		return
+	}
+	query := r.URL.Query()
+	offset, limit := 0, defaultMempoolPageSize
+	if v := query.Get("offset"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			writeError(w, http.StatusBadRequest, ErrCodeInvalidRequest, "offset must be a non-negative integer")
+			return
+		}
+		offset = n
+	}
+	if v := query.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > maxMempoolPageSize {
+			writeError(w, http.StatusBadRequest, ErrCodeInvalidRequest, fmt.Sprintf("limit must be between 1 and %d", maxMempoolPageSize))
+			return
+		}
+		limit = n
+	}
+	election := query.Get("election")
+
+	now := node.Chain.now()
+	listing := MempoolListing{Offset: offset, Transactions: []MempoolTxInfo{}}
+	for _, entry := range node.Mempool.Entries() {
+		tx := entry.Tx
+		var electionID string
+		if tx.GetKind() == TxKindVote {
+			electionID = string(tx.GetPayload())
+		}
+		if election != "" && electionID != election {
+			continue
+		}
+		listing.Total++
+		if listing.Total <= offset || len(listing.Transactions) == limit {
+			continue
+		}
+		listing.Transactions = append(listing.Transactions, MempoolTxInfo{
+			TxHash:     hex.EncodeToString(tx.GetHash()),
+			Sender:     hex.EncodeToString(tx.GetSender()),
+			Kind:       tx.GetKind(),
+			ElectionID: electionID,
+			AddedAt:    entry.AddedAt.Unix(),
+			AgeSeconds: int64(now.Sub(entry.AddedAt).Seconds()),
+		})
+	}
+	w.Header().Set("Content-Type", contentTypeJSON)
+	json.NewEncoder(w).Encode(listing)
+}
+
+// --- Inclusion Latency ---
+
+// LatencyTracker keeps the most recent transaction inclusion latencies so the
+// status endpoint can report percentiles without an unbounded history.
+type LatencyTracker struct {
+	mu      sync.Mutex
+	samples []time.Duration // Ring buffer of at most InclusionLatencySamples
+	next    int
+}
+
+// NewLatencyTracker creates an empty tracker.
+func NewLatencyTracker() *LatencyTracker {
+	return &LatencyTracker{samples: make([]time.Duration, 0, InclusionLatencySamples)}
+}
+
+// Observe records one inclusion latency, replacing the oldest once full, and
+// adds it to the tx_inclusion_latency histogram.
+func (t *LatencyTracker) Observe(d time.Duration) {
+	bucket := "inf"
+	for _, bound := range inclusionLatencyBuckets {
+		if d <= bound {
+			bucket = bound.String()
+			break
+		}
+	}
+	txInclusionLatency.Add(bucket, 1)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.samples) < cap(t.samples) {
+		t.samples = append(t.samples, d)
+		return
+	}
+	t.samples[t.next] = d
+	t.next = (t.next + 1) % len(t.samples)
+}
+
+// Percentile returns the p-th percentile (0-100) of the recorded latencies,
+// or 0 if none have been recorded.
+func (t *LatencyTracker) Percentile(p float64) time.Duration {
+	t.mu.Lock()
+	sorted := append([]time.Duration(nil), t.samples...)
+	t.mu.Unlock()
+	if len(sorted) == 0 {
+		return 0
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	idx := int(p / 100 * float64(len(sorted)-1))
+	return sorted[min(max(idx, 0), len(sorted)-1)]
+}
+
+// Mean returns the average of the recorded latencies, or 0 if none have been
+// recorded.
+func (t *LatencyTracker) Mean() time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.samples) == 0 {
+		return 0
+	}
+	var total time.Duration
+	for _, d := range t.samples {
+		total += d
+	}
+	return total / time.Duration(len(t.samples))
+}
+
+// observeInclusion records how long each mempool entry a block included waited
+// since this node accepted it. It is the chain's OnInclude hook (see UseChain).
+// Transactions this node never held (e.g. included by a faster peer before
+// gossip reached us) are not measured.
+func (n *P2PNode) observeInclusion(included []*MempoolEntry) {
+	now := n.Chain.now()
+	for _, entry := range included {
+		n.inclusionLatency.Observe(now.Sub(entry.AddedAt))
+	}
+}
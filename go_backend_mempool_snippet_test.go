@@ -0,0 +1,240 @@
+// go_backend_mempool_snippet_test.go
+
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+)
+
+// timedVotes adds a vote to m for each timestamp, returning them in order.
+func timedVotes(t *testing.T, m *Mempool, timestamps ...time.Time) []*Transaction {
+	t.Helper()
+	var txs []*Transaction
+	for i, ts := range timestamps {
+		tx := testVote("e", "a", i+1)
+		tx.Timestamp = uint64(ts.Unix())
+		signTestTx(tx, testVoterKey(i+1))
+		if err := m.Add(tx); err != nil {
+			t.Fatal(err)
+		}
+		txs = append(txs, tx)
+	}
+	return txs
+}
+
+func TestSweepExpiredRemovesOnlyExpired(t *testing.T) {
+	m := NewMempool(10)
+	now := time.Unix(1_700_000_000, 0)
+	txs := timedVotes(t, m, now.Add(-20*time.Minute), now.Add(-5*time.Minute), now)
+
+	if swept := m.SweepExpired(now.Add(-DefaultMaxTxAge)); swept != 1 {
+		t.Fatalf("swept %d transactions, want the one older than %s", swept, DefaultMaxTxAge)
+	}
+	if _, ok := m.Get(txs[0].Hash); ok {
+		t.Error("expired transaction still pending")
+	}
+	if m.Len() != 2 {
+		t.Errorf("%d transactions pending, want 2", m.Len())
+	}
+
+	// Eight minutes later the second one has expired too
+	if swept := m.SweepExpired(now.Add(8*time.Minute - DefaultMaxTxAge)); swept != 1 {
+		t.Errorf("swept %d transactions after advancing the clock, want 1", swept)
+	}
+	if _, ok := m.Get(txs[2].Hash); !ok || m.Len() != 1 {
+		t.Errorf("%d transactions pending, want only the newest", m.Len())
+	}
+}
+
+func TestSweepEvictsTransactionsPendingPastMaxAge(t *testing.T) {
+	node := newTestNode(t)
+	clock := NewManualClock(time.Now())
+	node.Chain.Clock = clock.Now
+	later := clock.Now().Add(node.MaxMempoolAge + time.Minute)
+	timedVotes(t, node.Mempool, later, later) // Never expired by MaxTxAge
+	evicted := mempoolEvictedAged.Value()
+
+	clock.Advance(node.MaxMempoolAge - time.Minute)
+	node.sweepMempoolAt(node.Chain.now())
+	if node.Mempool.Len() != 2 {
+		t.Fatalf("%d transactions pending before the max age, want 2", node.Mempool.Len())
+	}
+	if entry := node.Mempool.Entries()[0]; clock.Now().Sub(entry.AddedAt) != node.MaxMempoolAge-time.Minute {
+		t.Errorf("transaction pending for %s on the chain's clock, want %s", clock.Now().Sub(entry.AddedAt), node.MaxMempoolAge-time.Minute)
+	}
+	clock.Advance(2 * time.Minute)
+	node.sweepMempoolAt(node.Chain.now())
+	if node.Mempool.Len() != 0 {
+		t.Errorf("%d transactions pending past the max age, want 0", node.Mempool.Len())
+	}
+	if got := mempoolEvictedAged.Value() - evicted; got != 2 {
+		t.Errorf("mempool_evicted_aged_txs rose by %d, want 2", got)
+	}
+}
+
+func TestAntiEntropyFetchesMissedTransactions(t *testing.T) {
+	nodes := memoryNodes(t, 2)
+	tx := signedVote(t)
+	if err := nodes[0].Mempool.Add(tx); err != nil { // Never pushed to nodes[1]
+		t.Fatal(err)
+	}
+	fetched := antiEntropyFetched.Value()
+
+	if added := nodes[1].antiEntropyRound(); added != 1 {
+		t.Fatalf("anti-entropy added %d transactions, want 1", added)
+	}
+	if _, ok := nodes[1].Mempool.Get(tx.Hash); !ok {
+		t.Error("missed transaction still absent after anti-entropy")
+	}
+	if got := antiEntropyFetched.Value() - fetched; got != 1 {
+		t.Errorf("mempool_anti_entropy_fetched_txs rose by %d, want 1", got)
+	}
+	if added := nodes[1].antiEntropyRound(); added != 0 {
+		t.Errorf("second round added %d transactions, want 0", added)
+	}
+}
+
+func TestAdminMempoolListsPendingUntilIncluded(t *testing.T) {
+	node := newTestNode(t)
+	node.AdminToken = "secret"
+	c := newTestChain(t, 0)
+	clock := NewManualClock(time.Now())
+	c.Clock = clock.Now
+	node.UseChain(c)
+	votes := []*Transaction{testVote("e", "a", 1), testVote("f", "a", 2)}
+	for _, tx := range votes {
+		if err := node.Mempool.Add(tx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	list := func(query, token string) (int, MempoolListing) {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, "/admin/mempool"+query, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rr := httptest.NewRecorder()
+		NewAdminHandler(node).ServeHTTP(rr, req)
+		var listing MempoolListing
+		if rr.Code == http.StatusOK {
+			if err := json.Unmarshal(rr.Body.Bytes(), &listing); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return rr.Code, listing
+	}
+	hashes := func(listing MempoolListing) []string {
+		var out []string
+		for _, info := range listing.Transactions {
+			out = append(out, info.TxHash)
+		}
+		return out
+	}
+	var order []string // Proposal order, which the listing follows
+	for _, entry := range node.Mempool.Entries() {
+		order = append(order, hex.EncodeToString(entry.Tx.Hash))
+	}
+
+	if code, _ := list("", ""); code != http.StatusUnauthorized {
+		t.Errorf("without a token: status %d, want 401", code)
+	}
+	clock.Advance(90 * time.Second)
+	if _, listing := list("", "secret"); listing.Total != 2 || !slices.Equal(hashes(listing), order) {
+		t.Errorf("listing %+v, want both votes", listing)
+	} else if age := listing.Transactions[0].AgeSeconds; age != 90 {
+		t.Errorf("age %ds, want 90s on the chain's clock", age)
+	}
+	if _, listing := list("?election=f", "secret"); listing.Total != 1 || listing.Transactions[0].ElectionID != "f" ||
+		listing.Transactions[0].Sender != hex.EncodeToString(votes[1].Sender) {
+		t.Errorf("election f listing %+v, want only its vote", listing)
+	}
+	if _, listing := list("?offset=1&limit=1", "secret"); listing.Total != 2 || !slices.Equal(hashes(listing), order[1:]) {
+		t.Errorf("second page %+v, want the second entry", listing)
+	}
+
+	if err := c.AppendBlock(testBlock(c.Tip(), votes[0])); err != nil {
+		t.Fatal(err)
+	}
+	if _, listing := list("", "secret"); listing.Total != 1 || hashes(listing)[0] != hex.EncodeToString(votes[1].Hash) {
+		t.Errorf("listing %+v after inclusion, want only the pending vote", listing)
+	}
+}
+
+func TestInclusionLatencyReachesStatus(t *testing.T) {
+	node := newTestNode(t)
+	c := newTestChain(t, 0)
+	node.UseChain(c)
+	var votes []*Transaction
+	for i, waited := range []time.Duration{time.Second, 2 * time.Second, 3 * time.Second, 10 * time.Second} {
+		tx := testVote("e", "a", i+1)
+		if err := node.Mempool.Add(tx); err != nil {
+			t.Fatal(err)
+		}
+		node.Mempool.mu.Lock()
+		node.Mempool.txs[hex.EncodeToString(tx.Hash)].AddedAt = time.Now().Add(-waited)
+		node.Mempool.mu.Unlock()
+		votes = append(votes, tx)
+	}
+	if err := c.AppendBlock(testBlock(c.Tip(), votes...)); err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	GetElectionStatus(node, rr, httptest.NewRequest(http.MethodGet, "/status?election_id=e", nil))
+	var status ElectionStatus
+	if err := json.Unmarshal(rr.Body.Bytes(), &status); err != nil {
+		t.Fatal(err)
+	}
+	// Allow for the time the test itself takes
+	if p50 := status.InclusionLatencyP50Ms; p50 < 2000 || p50 >= 2500 {
+		t.Errorf("p50 inclusion latency %dms, want about 2s", p50)
+	}
+	if p95 := status.InclusionLatencyP95Ms; p95 < 3000 || p95 >= 3500 {
+		t.Errorf("p95 inclusion latency %dms, want about 3s", p95)
+	}
+}
+
+func TestPendingTransactionsAreRebroadcastUntilIncluded(t *testing.T) {
+	node := newTestNode(t)
+	c := newTestChain(t, 0)
+	node.UseChain(c)
+	sent := make(chan []byte, 10)
+	node.peers["peer:9000"] = &peerState{state: PeerConnected, client: &slowPeer{sent: sent}}
+	stuck, included := testVote("e", "a", 1), testVote("e", "a", 2)
+	for _, tx := range []*Transaction{stuck, included} {
+		if err := node.Mempool.Add(tx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	now := time.Now()
+
+	if got := node.rebroadcastRound(now.Add(node.RebroadcastAfter - time.Second)); got != 0 {
+		t.Fatalf("rebroadcast %d transactions before the threshold", got)
+	}
+	if err := c.AppendBlock(testBlock(c.Tip(), included)); err != nil {
+		t.Fatal(err)
+	}
+	for attempt := 1; attempt <= node.MaxRebroadcasts+1; attempt++ {
+		now = now.Add(node.RebroadcastAfter + time.Second)
+		want := 1
+		if attempt > node.MaxRebroadcasts {
+			want = 0
+		}
+		if got := node.rebroadcastRound(now); got != want {
+			t.Fatalf("round %d rebroadcast %d transactions, want %d", attempt, got, want)
+		}
+		if want == 0 {
+			break
+		}
+		if hash := <-sent; !bytes.Equal(hash, stuck.Hash) {
+			t.Errorf("round %d sent %x, want the stuck vote %x", attempt, hash, stuck.Hash)
+		}
+	}
+}
@@ -0,0 +1,169 @@
+// go_backend_mtls_snippet.go
+
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"expvar"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"time"
+)
+
+// --- Validator Mutual TLS ---
+
+// ValidatorCertValidity is how long a certificate from NewValidatorCertificate is valid.
+const ValidatorCertValidity = 365 * 24 * time.Hour
+
+// ErrPeerCertNotAllowed is returned from the TLS handshake when the peer's
+// certificate key is not an allowlisted validator key.
+var ErrPeerCertNotAllowed = errors.New("peer certificate is not an allowlisted validator key")
+
+// tlsRejectedPeers counts TLS handshakes refused because the peer's certificate
+// key was not allowlisted. Peers presenting no certificate at all are refused
+// by the TLS stack itself and not counted.
+var tlsRejectedPeers = expvar.NewInt("tls_rejected_peers")
+
+// ValidatorTLSConfig returns a copy of base requiring mutual TLS in which each
+// side must present a certificate whose Ed25519 public key is one of allowed,
+// typically the genesis validator keys, so that on a permissioned network only
+// validators can connect. Connections are refused during the TLS handshake,
+// before any RPC is served.
+//
+// Operators with a PKI set ClientCAs and RootCAs on base and the chains are
+// verified as usual, with the key allowlist checked on top. Without them, the
+// allowlist is the only check, so self-signed certificates (see
+// NewValidatorCertificate) are accepted for allowlisted keys.
+func ValidatorTLSConfig(base *tls.Config, allowed [][]byte) *tls.Config {
+	cfg := base.Clone()
+	if cfg == nil {
+		cfg = &tls.Config{}
+	}
+	cfg.MinVersion = max(cfg.MinVersion, tls.VersionTLS13)
+	cfg.ClientAuth = tls.RequireAnyClientCert
+	if cfg.ClientCAs != nil {
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	if cfg.RootCAs == nil {
+		// The key allowlist below takes the place of chain and hostname checks
+		cfg.InsecureSkipVerify = true
+	}
+	keys := make([][]byte, len(allowed))
+	copy(keys, allowed)
+	cfg.VerifyConnection = func(cs tls.ConnectionState) error {
+		if err := checkValidatorCert(cs.PeerCertificates, keys); err != nil {
+			tlsRejectedPeers.Add(1)
+			return err
+		}
+		return nil
+	}
+	return cfg
+}
+
+// checkValidatorCert accepts a certificate chain whose leaf carries one of the
+// allowed Ed25519 keys.
+func checkValidatorCert(chain []*x509.Certificate, allowed [][]byte) error {
+	if len(chain) == 0 {
+		return fmt.Errorf("%w: no certificate presented", ErrPeerCertNotAllowed)
+	}
+	key, ok := chain[0].PublicKey.(ed25519.PublicKey)
+	if !ok {
+		return fmt.Errorf("%w: certificate key is not Ed25519", ErrPeerCertNotAllowed)
+	}
+	for _, allowedKey := range allowed {
+		if bytes.Equal(key, allowedKey) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %x", ErrPeerCertNotAllowed, []byte(key))
+}
+
+// NewValidatorCertificate returns a self-signed certificate for key, valid for
+// ValidatorCertValidity, for networks that authenticate validators by key
+// rather than through a CA. hosts are added as DNS names or IP addresses.
+func NewValidatorCertificate(key ed25519.PrivateKey, hosts ...string) (tls.Certificate, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	now := time.Now()
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: fmt.Sprintf("validator %x", []byte(key.Public().(ed25519.PublicKey)))},
+		NotBefore:    now.Add(-time.Hour), // Tolerate modest clock skew between peers
+		NotAfter:     now.Add(ValidatorCertValidity),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			tmpl.IPAddresses = append(tmpl.IPAddresses, ip)
+		} else {
+			tmpl.DNSNames = append(tmpl.DNSNames, host)
+		}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, key.Public(), key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, nil
+}
+
+// EnableValidatorTLS sets TLSConfig to mutual TLS restricted to the genesis
+// validators of the node's chain (see ValidatorTLSConfig). If base has no
+// certificate, a self-signed one for the node's identity key is used, which
+// peers accept only if that key is itself a genesis validator.
+func (n *P2PNode) EnableValidatorTLS(base *tls.Config) error {
+	cfg := base.Clone()
+	if cfg == nil {
+		cfg = &tls.Config{}
+	}
+	if len(cfg.Certificates) == 0 && cfg.GetCertificate == nil {
+		host, _, err := net.SplitHostPort(n.AdvertisedAddr())
+		if err != nil {
+			return fmt.Errorf("invalid advertise address %q: %v", n.AdvertisedAddr(), err)
+		}
+		cert, err := NewValidatorCertificate(n.identityKey, host)
+		if err != nil {
+			return fmt.Errorf("failed to create validator certificate: %v", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	n.TLSConfig = ValidatorTLSConfig(cfg, n.Chain.Validators.ActiveAt(0))
+	return nil
+}
+
+// LoadValidatorTLS builds a base config for EnableValidatorTLS from PEM files:
+// the node's certificate and key, and a CA bundle that peers' certificates
+// must chain to. Each is optional; with no certificate a self-signed one is
+// generated, and with no CA bundle the key allowlist is the only check.
+func LoadValidatorTLS(certFile, keyFile, caFile string) (*tls.Config, error) {
+	cfg := &tls.Config{}
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load validator certificate: %v", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	if caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA bundle: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in CA bundle %s", caFile)
+		}
+		cfg.ClientCAs, cfg.RootCAs = pool, pool
+	}
+	return cfg, nil
+}
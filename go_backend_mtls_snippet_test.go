@@ -0,0 +1,84 @@
+// go_backend_mtls_snippet_test.go
+
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/tls"
+	"errors"
+	"testing"
+)
+
+// validatorKey returns a fresh key and a self-signed certificate for it.
+func validatorKey(t *testing.T) (ed25519.PublicKey, tls.Certificate) {
+	t.Helper()
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := NewValidatorCertificate(priv, "127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pub, cert
+}
+
+// tlsHandshake connects a client with clientCert to a server with serverCert,
+// both allowing only the allowed keys, and returns the server's handshake
+// error.
+func tlsHandshake(t *testing.T, serverCert, clientCert tls.Certificate, allowed [][]byte) error {
+	t.Helper()
+	lis, err := tls.Listen("tcp", "127.0.0.1:0", ValidatorTLSConfig(&tls.Config{Certificates: []tls.Certificate{serverCert}}, allowed))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lis.Close()
+	go func() {
+		conn, err := tls.Dial("tcp", lis.Addr().String(), ValidatorTLSConfig(&tls.Config{Certificates: []tls.Certificate{clientCert}}, allowed))
+		if err == nil {
+			conn.Close()
+		}
+	}()
+	conn, err := lis.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	return conn.(*tls.Conn).Handshake()
+}
+
+func TestValidatorTLSAcceptsOnlyAllowlistedCerts(t *testing.T) {
+	serverKey, serverCert := validatorKey(t)
+	validator, validatorCert := validatorKey(t)
+	_, outsiderCert := validatorKey(t)
+	allowed := [][]byte{serverKey, validator}
+
+	if err := tlsHandshake(t, serverCert, validatorCert, allowed); err != nil {
+		t.Fatalf("allowlisted validator refused: %v", err)
+	}
+	rejected := tlsRejectedPeers.Value()
+	if err := tlsHandshake(t, serverCert, outsiderCert, allowed); !errors.Is(err, ErrPeerCertNotAllowed) {
+		t.Fatalf("non-allowlisted cert: got %v, want ErrPeerCertNotAllowed", err)
+	}
+	if got := tlsRejectedPeers.Value() - rejected; got != 1 {
+		t.Errorf("tls_rejected_peers rose by %d, want 1", got)
+	}
+}
+
+func TestEnableValidatorTLSUsesGenesisValidators(t *testing.T) {
+	node := newTestNode(t)
+	_, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	node.UseIdentityKey(priv)
+	if err := node.EnableValidatorTLS(nil); err != nil {
+		t.Fatal(err)
+	}
+	if len(node.TLSConfig.Certificates) != 1 {
+		t.Fatalf("got %d certificates, want a generated one", len(node.TLSConfig.Certificates))
+	}
+	if node.TLSConfig.ClientAuth != tls.RequireAnyClientCert {
+		t.Errorf("ClientAuth %v, want RequireAnyClientCert", node.TLSConfig.ClientAuth)
+	}
+}
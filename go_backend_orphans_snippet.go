@@ -0,0 +1,168 @@
+// go_backend_orphans_snippet.go
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"expvar"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// --- Orphan Blocks ---
+
+// OrphanPool defaults. The pool holds at most DefaultMaxOrphanBytes of blocks
+// and DefaultMaxOrphansPerPeer from any one host, and an orphan whose parent
+// has not arrived within DefaultOrphanTTL is dropped.
+const (
+	DefaultMaxOrphanBytes    = 8 << 20 // 8 MiB
+	DefaultMaxOrphansPerPeer = 16
+	DefaultOrphanTTL         = 2 * time.Minute
+)
+
+// OrphanPenalty is the score a peer loses for each orphan it sends over its
+// share of the pool and for each of its orphans that expires unresolved.
+const OrphanPenalty = 5
+
+// ErrOrphanPoolFull is returned when an orphan is refused because its sender
+// already has its share of the pool or the block alone exceeds the pool.
+var ErrOrphanPoolFull = errors.New("orphan pool full")
+
+// orphanEvictions counts orphans dropped from a full pool to make room for
+// newer ones.
+var orphanEvictions = expvar.NewInt("orphan_pool_evictions")
+
+// orphanBlock is a block waiting in an OrphanPool for its parent.
+type orphanBlock struct {
+	blk   *Block
+	host  string // Remote host that sent the block, charged against MaxPerPeer
+	peer  string // remotePeer key of the sender, penalized if the block never resolves
+	size  int
+	added time.Time
+}
+
+// OrphanPool holds blocks received ahead of their parent until the parent is
+// imported. Since anyone can fabricate blocks with unknown parents, the pool
+// is bounded by total bytes, evicting the oldest orphans first, and each host
+// may hold only a few orphans at once.
+type OrphanPool struct {
+	MaxBytes   int           // Total size of the orphans held; the oldest are evicted past it
+	MaxPerPeer int           // Orphans one host may have in the pool at once
+	TTL        time.Duration // How long an orphan waits for its parent
+	mu         sync.Mutex
+	orphans    []*orphanBlock // Oldest first
+	size       int
+	perHost    map[string]int
+}
+
+// NewOrphanPool creates an empty pool with the default limits.
+func NewOrphanPool() *OrphanPool {
+	return &OrphanPool{
+		MaxBytes:   DefaultMaxOrphanBytes,
+		MaxPerPeer: DefaultMaxOrphansPerPeer,
+		TTL:        DefaultOrphanTTL,
+		perHost:    make(map[string]int),
+	}
+}
+
+// Add holds blk, received from peer at host, until its parent arrives,
+// evicting the oldest orphans if the pool grows past MaxBytes. Orphans older
+// than TTL are dropped first. Both are returned, the expired ones so the
+// caller can penalize peers whose orphans never resolve.
+func (p *OrphanPool) Add(blk *Block, host, peer string, now time.Time) (expired, evicted []*orphanBlock, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	expired = p.expire(now)
+	size := blk.Size()
+	if size > p.MaxBytes {
+		return expired, nil, fmt.Errorf("%w: block %d is %d bytes, pool holds %d", ErrOrphanPoolFull, blk.Header.Height, size, p.MaxBytes)
+	}
+	if p.perHost[host] >= p.MaxPerPeer {
+		return expired, nil, fmt.Errorf("%w: %s already has %d orphans", ErrOrphanPoolFull, host, p.perHost[host])
+	}
+	p.orphans = append(p.orphans, &orphanBlock{blk: blk, host: host, peer: peer, size: size, added: now})
+	p.size += size
+	p.perHost[host]++
+	for p.size > p.MaxBytes {
+		evicted = append(evicted, p.orphans[0])
+		p.remove(0)
+		orphanEvictions.Add(1)
+	}
+	return expired, evicted, nil
+}
+
+// expire drops and returns the orphans older than TTL. Callers hold p.mu.
+func (p *OrphanPool) expire(now time.Time) []*orphanBlock {
+	var expired []*orphanBlock
+	for len(p.orphans) > 0 && now.Sub(p.orphans[0].added) >= p.TTL {
+		expired = append(expired, p.orphans[0])
+		p.remove(0)
+	}
+	return expired
+}
+
+// remove drops the orphan at index i. Callers hold p.mu.
+func (p *OrphanPool) remove(i int) {
+	o := p.orphans[i]
+	p.orphans = append(p.orphans[:i], p.orphans[i+1:]...)
+	p.size -= o.size
+	if p.perHost[o.host]--; p.perHost[o.host] == 0 {
+		delete(p.perHost, o.host)
+	}
+}
+
+// Children removes and returns the orphans whose parent is the block with
+// hash parent, oldest first.
+func (p *OrphanPool) Children(parent []byte) []*Block {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var children []*Block
+	for i := 0; i < len(p.orphans); {
+		if blk := p.orphans[i].blk; bytes.Equal(blk.Header.PrevBlockHash, parent) {
+			children = append(children, blk)
+			p.remove(i)
+			continue
+		}
+		i++
+	}
+	return children
+}
+
+// Len returns how many orphans the pool holds.
+func (p *OrphanPool) Len() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.orphans)
+}
+
+// Size returns the total size in bytes of the orphans the pool holds.
+func (p *OrphanPool) Size() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.size
+}
+
+// holdOrphan adds blk, which is ahead of the chain tip, to the orphan pool,
+// penalizing its sender if the sender is over its share and the senders of
+// any orphans that expired unresolved. An expired orphan the chain has since
+// reached, e.g. through sync, is not held against its sender. Dropped orphans
+// are forgotten by seenBlocks, so they are accepted again if resent.
+func (n *P2PNode) holdOrphan(blk *Block, host, peer string) error {
+	expired, evicted, err := n.Orphans.Add(blk, host, peer, time.Now())
+	for _, o := range evicted {
+		n.seenBlocks.Forget(o.blk.Header.Hash)
+	}
+	height := n.Chain.Height()
+	for _, o := range expired {
+		n.seenBlocks.Forget(o.blk.Header.Hash)
+		if o.blk.Header.Height > height {
+			n.PenalizePeer(o.peer, OrphanPenalty)
+		}
+	}
+	if err != nil {
+		n.PenalizePeer(peer, OrphanPenalty)
+	}
+	return err
+}
@@ -0,0 +1,95 @@
+// go_backend_orphans_snippet_test.go
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"fmt"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// fabricatedOrphan returns a block at height whose parent nobody has.
+func fabricatedOrphan(t *testing.T, height uint64) *Block {
+	t.Helper()
+	parent := &Block{Header: &BlockHeader{Hash: make([]byte, 32), Height: height - 1, ChainId: DefaultChainID}}
+	if _, err := rand.Read(parent.Header.Hash); err != nil {
+		t.Fatal(err)
+	}
+	return testBlock(parent)
+}
+
+func TestOrphanFloodIsBoundedAndPenalized(t *testing.T) {
+	node := newTestNode(t)
+	const flooder = "10.0.0.9:5000"
+	ctx := inboundCtx(flooder, nil)
+	const extra = 3
+	for i := 0; i < node.Orphans.MaxPerPeer+extra; i++ {
+		_, err := node.SendBlock(ctx, &SendBlockRequest{Block: fabricatedOrphan(t, uint64(10+i))})
+		if i < node.Orphans.MaxPerPeer && err != nil {
+			t.Fatalf("orphan %d: %v", i, err)
+		}
+		if i >= node.Orphans.MaxPerPeer && status.Code(err) != codes.ResourceExhausted {
+			t.Fatalf("orphan %d over the peer's share: got %v, want ResourceExhausted", i, err)
+		}
+	}
+	if got := node.Orphans.Len(); got != node.Orphans.MaxPerPeer {
+		t.Errorf("pool holds %d orphans, want the peer's share of %d", got, node.Orphans.MaxPerPeer)
+	}
+	if got, want := node.PeerScore(addrHost(flooder)), -extra*OrphanPenalty; got != want {
+		t.Errorf("flooder score %d, want %d", got, want)
+	}
+
+	// Orphans that never resolve cost their sender too once they expire
+	node.Orphans.TTL = 0
+	if _, err := node.SendBlock(inboundCtx("10.0.0.10:5000", nil), &SendBlockRequest{Block: fabricatedOrphan(t, 100)}); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := node.PeerScore(addrHost(flooder)), -extra*OrphanPenalty-node.Orphans.MaxPerPeer*OrphanPenalty; got != want {
+		t.Errorf("flooder score %d after its orphans expired, want %d", got, want)
+	}
+}
+
+func TestOrphanPoolEvictsByBytesAndForgetsEvicted(t *testing.T) {
+	node := newTestNode(t)
+	first := fabricatedOrphan(t, 10)
+	node.Orphans.MaxBytes = 2 * first.Size()
+	if _, err := node.SendBlock(inboundCtx("10.0.0.1:5000", nil), &SendBlockRequest{Block: first}); err != nil {
+		t.Fatal(err)
+	}
+	for i := 2; i <= 3; i++ {
+		if _, err := node.SendBlock(inboundCtx(fmt.Sprintf("10.0.0.%d:5000", i), nil), &SendBlockRequest{Block: fabricatedOrphan(t, 10)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if node.Orphans.Size() > node.Orphans.MaxBytes {
+		t.Fatalf("pool holds %d bytes, over its %d", node.Orphans.Size(), node.Orphans.MaxBytes)
+	}
+	if !node.seenBlocks.Add(first.Header.Hash) {
+		t.Error("evicted orphan is still marked seen, so a resend would be ignored")
+	}
+}
+
+func TestSyncImportsWaitingOrphans(t *testing.T) {
+	source := newTestChain(t, 0)
+	extendChain(t, source, 3)
+	child := testBlock(source.Tip(), testVote("e", "a", 4))
+	node := NewP2PNode("127.0.0.1:0")
+	if err := node.holdOrphan(child, "10.0.0.1", "10.0.0.1:5000"); err != nil {
+		t.Fatal(err)
+	}
+	addSyncPeers(node, &syncPeer{chain: source})
+	if err := node.SyncWithPeer(context.Background(), "peer-0"); err != nil {
+		t.Fatal(err)
+	}
+	if tip := node.Chain.Tip(); !bytes.Equal(tip.Header.Hash, child.Header.Hash) {
+		t.Errorf("tip at height %d after sync, want the waiting orphan at 4", tip.Header.Height)
+	}
+	if node.Orphans.Len() != 0 {
+		t.Errorf("%d orphans left in the pool", node.Orphans.Len())
+	}
+}
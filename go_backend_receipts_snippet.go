@@ -0,0 +1,133 @@
+// go_backend_receipts_snippet.go
+
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/skip2/go-qrcode"
+)
+
+// --- Vote Receipts ---
+
+// Bounds for the ?size= of a receipt QR image, in pixels
+const (
+	DefaultReceiptQRSize = 256
+	minReceiptQRSize     = 128
+	maxReceiptQRSize     = 1024
+)
+
+const contentTypePNG = "image/png"
+
+// VoteReceipt is a node's signed statement about a vote, compact enough to
+// print as a QR code at a voting kiosk. JSON keys are single letters to keep
+// the code small; anyone holding the node's public key can check it offline
+// with VerifyVoteReceipt.
+type VoteReceipt struct {
+	TxHash     []byte `json:"h"`
+	ElectionID string `json:"e"`
+	Height     uint64 `json:"b,omitempty"` // Block including the vote; omitted while it is still pending
+	NodePubKey []byte `json:"k"`           // Ed25519 identity key of the signing node
+	Timestamp  uint64 `json:"t"`           // Unix seconds when the receipt was issued
+	Signature  []byte `json:"s"`
+}
+
+// SigningBytes returns the message the issuing node signs.
+func (r *VoteReceipt) SigningBytes() []byte {
+	return []byte(fmt.Sprintf("vote-receipt|%x|%q|%d|%x|%d", r.TxHash, r.ElectionID, r.Height, r.NodePubKey, r.Timestamp))
+}
+
+// Encode returns the receipt payload served as JSON and embedded in the QR code.
+func (r *VoteReceipt) Encode() []byte {
+	b, _ := json.Marshal(r) // Only byte slices, strings and integers; cannot fail
+	return b
+}
+
+// SignVoteReceipt produces a signed receipt for vote tx. height is the block
+// that includes it, or 0 if it is still in the mempool (genesis holds no votes).
+func (n *P2PNode) SignVoteReceipt(tx *Transaction, height uint64) *VoteReceipt {
+	receipt := &VoteReceipt{
+		TxHash:     tx.GetHash(),
+		ElectionID: string(tx.GetPayload()),
+		Height:     height,
+		NodePubKey: n.PublicKey(),
+		Timestamp:  uint64(time.Now().Unix()),
+	}
+	receipt.Signature = ed25519.Sign(n.identityKey, receipt.SigningBytes())
+	return receipt
+}
+
+// VerifyVoteReceipt decodes a receipt payload, as scanned from a QR code, and
+// checks that it was signed by the node with public key nodePubKey.
+func VerifyVoteReceipt(payload []byte, nodePubKey ed25519.PublicKey) (*VoteReceipt, error) {
+	receipt := &VoteReceipt{}
+	if err := json.Unmarshal(payload, receipt); err != nil {
+		return nil, fmt.Errorf("malformed vote receipt: %v", err)
+	}
+	if !bytes.Equal(receipt.NodePubKey, nodePubKey) {
+		return nil, fmt.Errorf("receipt was issued by %x, expected %x", receipt.NodePubKey, []byte(nodePubKey))
+	}
+	if !ed25519.Verify(nodePubKey, receipt.SigningBytes(), receipt.Signature) {
+		return nil, fmt.Errorf("receipt signature is invalid")
+	}
+	return receipt, nil
+}
+
+// findVote looks a vote up in the chain and the mempool, returning the height
+// of the block including it (0 while pending).
+func (n *P2PNode) findVote(txHash []byte) (*Transaction, uint64, bool) {
+	tx, height, ok := n.Chain.FindTx(txHash)
+	if !ok || tx.GetKind() != TxKindVote {
+		return nil, 0, false
+	}
+	return tx, height, true
+}
+
+// GetVoteReceipt handles GET /vote/{tx_hash}/receipt, returning a signed
+// VoteReceipt for a vote this node has seen. Clients sending `Accept: image/png`
+// get the same payload as a QR code, sized by ?size= in pixels.
+func GetVoteReceipt(node *P2PNode, w http.ResponseWriter, r *http.Request) {
+	txHash, err := hex.DecodeString(strings.TrimPrefix(r.PathValue("tx_hash"), "0x"))
+	if err != nil || len(txHash) == 0 {
+		writeError(w, http.StatusBadRequest, ErrCodeInvalidRequest, "transaction hash must be hex")
+		return
+	}
+	wantPNG := strings.Contains(r.Header.Get("Accept"), contentTypePNG)
+	size := DefaultReceiptQRSize
+	if v := r.URL.Query().Get("size"); v != "" && wantPNG {
+		size, err = strconv.Atoi(v)
+		if err != nil || size < minReceiptQRSize || size > maxReceiptQRSize {
+			writeError(w, http.StatusBadRequest, ErrCodeInvalidRequest,
+				fmt.Sprintf("size must be between %d and %d pixels", minReceiptQRSize, maxReceiptQRSize))
+			return
+		}
+	}
+
+	tx, height, ok := node.findVote(txHash)
+	if !ok {
+		writeError(w, http.StatusNotFound, ErrCodeNotFound, "no vote with that hash is pending or on chain")
+		return
+	}
+	payload := node.SignVoteReceipt(tx, height).Encode()
+
+	if wantPNG {
+		png, err := qrcode.Encode(string(payload), qrcode.Medium, size)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, ErrCodeInternal, "failed to render receipt QR code")
+			return
+		}
+		w.Header().Set("Content-Type", contentTypePNG)
+		w.Write(png)
+		return
+	}
+	w.Header().Set("Content-Type", contentTypeJSON)
+	w.Write(payload)
+}
@@ -0,0 +1,90 @@
+// go_backend_receipts_snippet_test.go
+
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/makiuchi-d/gozxing"
+	qrreader "github.com/makiuchi-d/gozxing/qrcode"
+)
+
+// getVoteReceipt fetches the receipt for the vote with txHash, as accept.
+func getVoteReceipt(node *P2PNode, txHash []byte, accept string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/vote/"+hex.EncodeToString(txHash)+"/receipt", nil)
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	rr := httptest.NewRecorder()
+	NewAPIHandler(node).ServeHTTP(rr, req)
+	return rr
+}
+
+func TestVoteReceiptVerifiesOffline(t *testing.T) {
+	node := newTestNode(t)
+	c := newTestChain(t, 0)
+	node.UseChain(c)
+	extendChain(t, c, 2)
+	vote := c.Tip().Transactions[0]
+
+	rr := getVoteReceipt(node, vote.Hash, "")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rr.Code, rr.Body)
+	}
+	receipt, err := VerifyVoteReceipt(rr.Body.Bytes(), node.PublicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(receipt.TxHash, vote.Hash) || receipt.ElectionID != "e" || receipt.Height != 2 {
+		t.Errorf("receipt for %x in %q at %d, want %x in \"e\" at 2", receipt.TxHash, receipt.ElectionID, receipt.Height, vote.Hash)
+	}
+
+	other := newTestNode(t)
+	if _, err := VerifyVoteReceipt(rr.Body.Bytes(), other.PublicKey()); err == nil {
+		t.Error("receipt verified against another node's key")
+	}
+	receipt.Height = 1
+	if _, err := VerifyVoteReceipt(receipt.Encode(), node.PublicKey()); err == nil {
+		t.Error("receipt with an altered height verified")
+	}
+	if rr := getVoteReceipt(node, bytes.Repeat([]byte{1}, 32), ""); rr.Code != http.StatusNotFound {
+		t.Errorf("unknown vote: status %d, want 404", rr.Code)
+	}
+}
+
+func TestVoteReceiptQRDecodesToPayload(t *testing.T) {
+	node := newTestNode(t)
+	c := newTestChain(t, 0)
+	node.UseChain(c)
+	extendChain(t, c, 1)
+	vote := c.Tip().Transactions[0]
+
+	rr := getVoteReceipt(node, vote.Hash, contentTypePNG)
+	if ct := rr.Header().Get("Content-Type"); rr.Code != http.StatusOK || ct != contentTypePNG {
+		t.Fatalf("status %d, Content-Type %q: %s", rr.Code, ct, rr.Body)
+	}
+	img, err := png.Decode(rr.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bmp, err := gozxing.NewBinaryBitmapFromImage(img)
+	if err != nil {
+		t.Fatal(err)
+	}
+	scanned, err := qrreader.NewQRCodeReader().Decode(bmp, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	receipt, err := VerifyVoteReceipt([]byte(scanned.GetText()), node.PublicKey())
+	if err != nil {
+		t.Fatalf("scanned payload does not verify: %v", err)
+	}
+	if !bytes.Equal(receipt.TxHash, vote.Hash) || receipt.Height != 1 {
+		t.Errorf("scanned receipt for %x at %d, want %x at 1", receipt.TxHash, receipt.Height, vote.Hash)
+	}
+}
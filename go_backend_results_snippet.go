@@ -0,0 +1,216 @@
+// go_backend_results_snippet.go
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"slices"
+	"sync"
+	"time"
+)
+
+// --- Election Results ---
+
+// Election finalization defaults. An election's result is frozen once the
+// block closing it has DefaultFinalityDepth blocks on top, deep enough that a
+// reorg will not replace it.
+const (
+	DefaultFinalityDepth    = 6
+	DefaultFinalizeInterval = 5 * time.Second
+)
+
+// ElectionResult is an election's tally as served by /results. While voting is
+// open, or its closing block is not yet final, it is recounted on every
+// request; once final it is frozen in the node's ResultStore and never changes.
+type ElectionResult struct {
+	ElectionID    string           `json:"election_id"`
+	Final         bool             `json:"final"`
+	ClosingHeight uint64           `json:"closing_height,omitempty"` // First block at or after the election's end; set once final
+	Height        uint64           `json:"height"`                   // Best chain height the votes were counted at
+	TotalVotes    uint64           `json:"total_votes"`
+	Turnout       uint64           `json:"turnout"` // Distinct voters, whatever their ballot type
+	Candidates    []CandidateTally `json:"candidates"`
+	AbstainVotes  uint64           `json:"abstain_votes"`
+	SpoiledVotes  uint64           `json:"spoiled_votes"`
+	Leaders       []string         `json:"leaders"`
+	Tie           bool             `json:"tie"`
+	QuorumMet     bool             `json:"quorum_met"`             // Whether turnout meets the election's minimum; always true without one
+	FinalizedAt   uint64           `json:"finalized_at,omitempty"` // Unix seconds when the result was frozen
+}
+
+// countResult tallies the election on the best chain as it stands.
+func (n *P2PNode) countResult(electionID string) *ElectionResult {
+	ballots := n.Chain.Tally.ElectionBallots(electionID)
+	result := &ElectionResult{
+		ElectionID:   electionID,
+		Height:       n.Chain.Height(),
+		TotalVotes:   n.Chain.Tally.ElectionTotal(electionID),
+		Turnout:      n.Chain.Tally.ElectionTurnout(electionID),
+		Candidates:   sortedTallies(n.Chain.Tally.ElectionCounts(electionID)),
+		AbstainVotes: ballots[BallotAbstain],
+		SpoiledVotes: ballots[BallotSpoiled],
+	}
+	setPercentages(result.Candidates, n.ResultPrecision)
+	result.Leaders, result.Tie = leadingCandidates(result.Candidates)
+	e, _ := n.Chain.ScheduledElection(electionID) // Unscheduled elections have no thresholds
+	result.QuorumMet = e.QuorumMet(result.Turnout)
+	return result
+}
+
+// ResultStore holds the final results of closed elections. One opened from a
+// file appends each result to it as a JSON line, synced before it is served,
+// so a restarted node serves the same frozen results rather than recounting.
+type ResultStore struct {
+	mu      sync.RWMutex
+	f       *os.File // Nil for a store kept only in memory
+	results map[string]*ElectionResult
+}
+
+// NewResultStore creates an empty store kept only in memory.
+func NewResultStore() *ResultStore {
+	return &ResultStore{results: make(map[string]*ElectionResult)}
+}
+
+// OpenResultStore opens (or creates) the store at path and loads the results
+// already frozen there. A torn final line, left by a crash mid-write, is
+// skipped, so that election is finalized again.
+func OpenResultStore(path string) (*ResultStore, error) {
+	s := NewResultStore()
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read election results: %w", err)
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(nil, 1<<20)
+	var torn error
+	for scanner.Scan() {
+		if torn != nil {
+			return nil, torn // Only the last line may be torn
+		}
+		var result ElectionResult
+		if err := json.Unmarshal(scanner.Bytes(), &result); err != nil {
+			torn = fmt.Errorf("corrupt election result in %s: %w", path, err)
+			continue
+		}
+		s.results[result.ElectionID] = &result
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read election results: %w", err)
+	}
+	if torn != nil {
+		log.Printf("Skipping a torn final election result in %s", path)
+	}
+	if s.f, err = os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600); err != nil {
+		return nil, fmt.Errorf("failed to open election results: %w", err)
+	}
+	return s, nil
+}
+
+// errResultFrozen is returned by Put for an election already finalized.
+var errResultFrozen = errors.New("election result is already final")
+
+// Get returns the frozen result of the election, if it has been finalized.
+func (s *ResultStore) Get(electionID string) (*ElectionResult, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	result, ok := s.results[electionID]
+	return result, ok
+}
+
+// Put freezes result, syncing it to the store's file first if it has one. A
+// result once frozen is never replaced.
+func (s *ResultStore) Put(result *ElectionResult) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.results[result.ElectionID]; ok {
+		return fmt.Errorf("%w: %q", errResultFrozen, result.ElectionID)
+	}
+	if s.f != nil {
+		line, err := json.Marshal(result)
+		if err != nil {
+			return err
+		}
+		if _, err := s.f.Write(append(line, '\n')); err != nil {
+			return fmt.Errorf("failed to write election result: %w", err)
+		}
+		if err := s.f.Sync(); err != nil {
+			return fmt.Errorf("failed to sync election result: %w", err)
+		}
+	}
+	s.results[result.ElectionID] = result
+	return nil
+}
+
+// Close closes the store's file, if it has one.
+func (s *ResultStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.f == nil {
+		return nil
+	}
+	return s.f.Close()
+}
+
+// FinalizeElections freezes the result of each scheduled election once its
+// EndTime has passed on the chain's clock and the block closing it has
+// FinalityDepth blocks on top, checking every FinalizeInterval until the node
+// closes. This method should be run in a goroutine.
+func (n *P2PNode) FinalizeElections() {
+	ticker := time.NewTicker(n.FinalizeInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			n.finalizeElections()
+		case <-n.closing.Done():
+			return
+		}
+	}
+}
+
+// finalizeElections runs one FinalizeElections pass. No vote can land after an
+// election's closing block, so once that block is final the tally on the best
+// chain is the election's final count.
+func (n *P2PNode) finalizeElections() {
+	now := n.Chain.now()
+	for _, e := range n.Chain.ScheduledElections() {
+		if _, done := n.Results.Get(e.ID); done || now.Before(e.EndTime) {
+			continue
+		}
+		closing, ok := n.Chain.ClosingHeight(e.EndTime)
+		if !ok || n.Chain.Height() < closing+n.FinalityDepth {
+			continue // Wait for the closing block, then for it to be buried
+		}
+		result := n.countResult(e.ID)
+		result.Final, result.ClosingHeight, result.FinalizedAt = true, closing, uint64(now.Unix())
+		if err := n.Results.Put(result); err != nil {
+			log.Printf("ERROR: failed to finalize election %q: %v", e.ID, err)
+			continue
+		}
+		log.Printf("Finalized election %q closed at block %d: %d votes, leaders %v", e.ID, closing, result.TotalVotes, result.Leaders)
+	}
+}
+
+// GetElectionResults handles GET /results?election_id=, returning the frozen
+// result of a finalized election, or else a fresh count of its votes so far.
+func GetElectionResults(node *P2PNode, w http.ResponseWriter, r *http.Request) {
+	electionID := r.URL.Query().Get("election_id")
+	if !slices.Contains(node.Chain.Elections(), electionID) {
+		writeError(w, http.StatusNotFound, ErrCodeNotFound, "no election with that ID is on chain; see /elections")
+		return
+	}
+	result, ok := node.Results.Get(electionID)
+	if !ok {
+		result = node.countResult(electionID)
+	}
+	w.Header().Set("Content-Type", contentTypeJSON)
+	json.NewEncoder(w).Encode(result)
+}
@@ -0,0 +1,190 @@
+// go_backend_results_snippet_test.go
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// scheduledNode returns a node whose genesis launches at launch and schedules
+// elections, on a ManualClock reading launch.
+func scheduledNode(t *testing.T, launch time.Time, elections ...Election) (*P2PNode, *ManualClock) {
+	t.Helper()
+	cfg := DefaultGenesisConfig()
+	cfg.LaunchTime, cfg.Elections = launch, elections
+	chain, err := NewChain(GenesisBlock(cfg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock := NewManualClock(launch)
+	chain.Clock = clock.Now
+	node := newTestNode(t)
+	node.UseChain(chain)
+	return node, clock
+}
+
+// blockAt returns a block of txs on parent timestamped at.
+func blockAt(parent *Block, at time.Time, txs ...*Transaction) *Block {
+	blk := testBlock(parent, txs...)
+	blk.Header.Timestamp = uint64(at.Unix())
+	blk.Header.Hash = blk.Header.ComputeHash(SHA3Hasher{})
+	return blk
+}
+
+// getResults fetches /results for the election from node.
+func getResults(t *testing.T, node *P2PNode, electionID string) *ElectionResult {
+	t.Helper()
+	rr := httptest.NewRecorder()
+	NewAPIHandler(node).ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/results?election_id="+electionID, nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("/results: status %d, body %s", rr.Code, rr.Body)
+	}
+	var result ElectionResult
+	if err := json.Unmarshal(rr.Body.Bytes(), &result); err != nil {
+		t.Fatal(err)
+	}
+	return &result
+}
+
+func TestElectionResultFreezesOnceClosingBlockIsFinal(t *testing.T) {
+	launch := time.Unix(1_700_000_000, 0)
+	end := launch.Add(time.Minute)
+	node, clock := scheduledNode(t, launch, Election{ID: "e", EndTime: end})
+	node.FinalityDepth = 2
+	node.Results = NewResultStore()
+
+	chain := node.Chain
+	clock.Advance(10 * time.Second)
+	if err := chain.AppendBlock(blockAt(chain.Tip(), launch.Add(time.Second), testVote("e", "a", 1), testVote("e", "b", 2), testVote("e", "a", 3))); err != nil {
+		t.Fatal(err)
+	}
+	node.finalizeElections()
+	if result := getResults(t, node, "e"); result.Final || result.TotalVotes != 3 {
+		t.Fatalf("open election: got %+v, want a provisional count of 3 votes", result)
+	}
+
+	// Past the end, the election waits for its closing block and then for
+	// FinalityDepth blocks on top of it
+	clock.Advance(time.Minute)
+	node.finalizeElections()
+	closing := blockAt(chain.Tip(), end)
+	if err := chain.AppendBlock(closing); err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= 2; i++ {
+		node.finalizeElections()
+		if _, ok := node.Results.Get("e"); ok {
+			t.Fatalf("finalized with %d of 2 blocks on the closing block", i-1)
+		}
+		if err := chain.AppendBlock(blockAt(chain.Tip(), end.Add(time.Duration(i)*time.Second))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	node.finalizeElections()
+	frozen := getResults(t, node, "e")
+	if !frozen.Final || frozen.ClosingHeight != closing.Header.Height || frozen.TotalVotes != 3 || !reflect.DeepEqual(frozen.Leaders, []string{"a"}) {
+		t.Fatalf("finalized result %+v, want 3 votes led by a, closed at %d", frozen, closing.Header.Height)
+	}
+
+	// A late vote is refused, in a block or at admission, and the result stands
+	late := testVote("e", "b", 4)
+	if err := chain.AppendBlock(blockAt(chain.Tip(), end.Add(3*time.Second), late)); !errors.Is(err, ErrElectionClosed) {
+		t.Errorf("block with a late vote: got %v, want ErrElectionClosed", err)
+	}
+	if err := node.checkTxKind(late); !errors.Is(err, ErrElectionClosed) {
+		t.Errorf("admitting a late vote: got %v, want ErrElectionClosed", err)
+	}
+	node.finalizeElections()
+	if again := getResults(t, node, "e"); !reflect.DeepEqual(again, frozen) {
+		t.Errorf("result changed after finalization: %+v, was %+v", again, frozen)
+	}
+}
+
+func TestResultReportsWhetherTurnoutMetQuorum(t *testing.T) {
+	launch := time.Unix(1_700_000_000, 0)
+	end := launch.Add(time.Minute)
+	node, clock := scheduledNode(t, launch,
+		Election{ID: "count", EndTime: end, MinTurnout: 3},
+		Election{ID: "percent", EndTime: end, MinTurnoutPercent: 50, EligibleVoters: 4},
+	)
+	node.FinalityDepth = 0
+	node.Results = NewResultStore()
+	clock.Advance(10 * time.Second)
+
+	chain := node.Chain
+	if err := chain.AppendBlock(blockAt(chain.Tip(), launch.Add(time.Second),
+		testVote("count", "a", 1), testVote("count", "b", 2), testVote("percent", "a", 3))); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"count", "percent", "unscheduled"} {
+		result := node.countResult(id)
+		if want := id == "unscheduled"; result.QuorumMet != want {
+			t.Errorf("%s below its threshold: quorum_met %v, want %v", id, result.QuorumMet, want)
+		}
+	}
+
+	if err := chain.AppendBlock(blockAt(chain.Tip(), launch.Add(2*time.Second),
+		testVote("count", "a", 4), testVote("percent", "b", 5))); err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(time.Minute)
+	if err := chain.AppendBlock(blockAt(chain.Tip(), end)); err != nil {
+		t.Fatal(err)
+	}
+	node.finalizeElections()
+	for _, id := range []string{"count", "percent"} {
+		if result := getResults(t, node, id); !result.Final || !result.QuorumMet {
+			t.Errorf("%s at its threshold: got %+v, want a final result meeting quorum", id, result)
+		}
+	}
+}
+
+func TestResultStoreSurvivesRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "results.log")
+	store, err := OpenResultStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := &ElectionResult{ElectionID: "e", Final: true, ClosingHeight: 7, TotalVotes: 2, Candidates: []CandidateTally{{Candidate: "a", Votes: 2, Percent: "100.00"}}, Leaders: []string{"a"}}
+	if err := store.Put(result); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Put(&ElectionResult{ElectionID: "e"}); !errors.Is(err, errResultFrozen) {
+		t.Errorf("replacing a frozen result: got %v, want errResultFrozen", err)
+	}
+	store.Close()
+
+	reopened, err := OpenResultStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	if got, ok := reopened.Get("e"); !ok || !reflect.DeepEqual(got, result) {
+		t.Errorf("reopened store holds %+v, want %+v", got, result)
+	}
+}
+
+func TestGenesisRefusesBadElectionSchedule(t *testing.T) {
+	end := time.Unix(1_700_000_000, 0)
+	for name, elections := range map[string][]Election{
+		"empty ID":               {{EndTime: end}},
+		"repeated ID":            {{ID: "e", EndTime: end}, {ID: "e", EndTime: end.Add(time.Hour)}},
+		"no end time":            {{ID: "e"}},
+		"starts at its end":      {{ID: "e", StartTime: end, EndTime: end}},
+		"percent over 100":       {{ID: "e", EndTime: end, MinTurnoutPercent: 101, EligibleVoters: 10}},
+		"percent without voters": {{ID: "e", EndTime: end, MinTurnoutPercent: 50}},
+	} {
+		cfg := DefaultGenesisConfig()
+		cfg.Elections = elections
+		if _, err := NewChain(GenesisBlock(cfg)); !errors.Is(err, ErrMalformedTx) {
+			t.Errorf("%s: got %v, want ErrMalformedTx", name, err)
+		}
+	}
+}
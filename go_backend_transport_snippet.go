@@ -0,0 +1,407 @@
+// go_backend_transport_snippet.go
+
+package main
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/keepalive"
+	"google.golang.org/grpc/status"
+)
+
+// --- Transport ---
+
+// Transport is how a node reaches its peers and accepts their connections.
+// Production nodes use gRPC; tests can wire nodes together in memory with a
+// MemoryNetwork and run a whole cluster in one process.
+type Transport interface {
+	// Dial connects to the node serving at addr and returns a client for it,
+	// failing if no connection is established within the node's DialTimeout.
+	// The handshake that follows checks who the peer is. If the returned
+	// client also implements io.Closer,
+	// the node closes it when the connection is abandoned.
+	Dial(ctx context.Context, addr string) (NodeServiceClient, error)
+	// Serve makes srv reachable at each of addrs and blocks until Stop. It fails
+	// only if none of the addresses could be bound.
+	Serve(srv NodeServiceServer, addrs ...string) error
+	// Stop stops serving, letting in-flight requests finish.
+	Stop()
+}
+
+// Keepalive pings on idle peer connections, so a peer that silently disappears
+// fails the next RPC instead of hanging it.
+const (
+	PeerKeepaliveInterval = 30 * time.Second
+	PeerKeepaliveTimeout  = 10 * time.Second
+)
+
+// DefaultMaxInboundConns caps the inbound peer connections a node holds open,
+// so a flood of connections cannot exhaust its file descriptors.
+const DefaultMaxInboundConns = 256
+
+// inboundConnsRefused counts inbound connections closed on accept because the
+// node already held MaxInboundConns.
+var inboundConnsRefused = expvar.NewInt("grpc_inbound_conns_refused")
+
+// refusedLogInterval is the least time between log lines about refused inbound
+// connections.
+const refusedLogInterval = 10 * time.Second
+
+// limitListener wraps a listener so that at most cap(slots) connections
+// accepted through it, and any other listener sharing slots, are open at once.
+// Connections beyond that are closed as soon as they are accepted.
+type limitListener struct {
+	net.Listener
+	slots      chan struct{}
+	lastLogged time.Time // When a refusal was last logged; Accept runs on one goroutine, so no lock
+	unlogged   int       // Refusals since lastLogged
+}
+
+func (l *limitListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		select {
+		case l.slots <- struct{}{}:
+			return &limitConn{Conn: conn, slots: l.slots}, nil
+		default:
+			inboundConnsRefused.Add(1)
+			l.logRefused(conn.RemoteAddr())
+			conn.Close()
+		}
+	}
+}
+
+// logRefused logs a refused connection at most once per refusedLogInterval,
+// with a count of those refused in between, so a connection flood does not
+// flood the log too. grpc_inbound_conns_refused counts every refusal.
+func (l *limitListener) logRefused(addr net.Addr) {
+	now := time.Now()
+	if now.Sub(l.lastLogged) < refusedLogInterval {
+		l.unlogged++
+		return
+	}
+	log.Printf("Refusing connection from %s: already holding %d inbound connections (%d more refused since the last report)", addr, cap(l.slots), l.unlogged)
+	l.lastLogged, l.unlogged = now, 0
+}
+
+// limitConn frees its limitListener slot when first closed.
+type limitConn struct {
+	net.Conn
+	slots chan struct{}
+	once  sync.Once
+}
+
+func (c *limitConn) Close() error {
+	err := c.Conn.Close()
+	c.once.Do(func() { <-c.slots })
+	return err
+}
+
+// grpcTransport is the default Transport: gRPC over TCP, secured with the
+// node's TLSConfig. Dial connects eagerly and returns only once the connection
+// is ready, so a peer is never marked connected on the strength of a lazy client.
+type grpcTransport struct {
+	node    *P2PNode
+	mu      sync.Mutex
+	server  *grpc.Server
+	stopped bool // Stop was called; a later Serve returns at once
+}
+
+// grpcClient pairs a generated client with the connection it runs over.
+type grpcClient struct {
+	NodeServiceClient
+	conn *grpc.ClientConn
+}
+
+func (c *grpcClient) Close() error { return c.conn.Close() }
+
+func (t *grpcTransport) Dial(ctx context.Context, addr string) (NodeServiceClient, error) {
+	creds, err := t.node.transportCredentials()
+	if err != nil {
+		return nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	conn, err := grpc.NewClient(addr,
+		grpc.WithTransportCredentials(creds),
+		grpc.WithKeepaliveParams(keepalive.ClientParameters{
+			Time:                PeerKeepaliveInterval,
+			Timeout:             PeerKeepaliveTimeout,
+			PermitWithoutStream: true,
+		}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	if err := waitReady(ctx, conn, t.node.DialTimeout); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("connecting to %s: %w", addr, err)
+	}
+	return &grpcClient{NodeServiceClient: &mockNodeServiceClient{}, conn: conn}, nil // Replace the mock with pb.NewNodeServiceClient(conn)
+}
+
+// waitReady starts connecting conn and waits up to timeout for it to become
+// ready, returning the last state seen if it does not.
+func waitReady(ctx context.Context, conn *grpc.ClientConn, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	conn.Connect()
+	for state := conn.GetState(); state != connectivity.Ready; state = conn.GetState() {
+		if !conn.WaitForStateChange(ctx, state) {
+			return fmt.Errorf("%w (connection %s)", ctx.Err(), state)
+		}
+	}
+	return nil
+}
+
+func (t *grpcTransport) Serve(srv NodeServiceServer, addrs ...string) error {
+	creds, err := t.node.transportCredentials()
+	if err != nil {
+		return err
+	}
+	if t.node.TLSConfig == nil {
+		log.Printf("WARNING: ********************************************************")
+		log.Printf("WARNING: gRPC server on %s is running WITHOUT transport security", t.node.Addr)
+		log.Printf("WARNING: AllowInsecure is set; do not use this in production")
+		log.Printf("WARNING: ********************************************************")
+	}
+
+	var slots chan struct{} // Shared, so the cap covers every listen address together
+	if t.node.MaxInboundConns > 0 {
+		slots = make(chan struct{}, t.node.MaxInboundConns)
+	}
+	listeners := make([]net.Listener, 0, len(addrs))
+	for _, addr := range addrs {
+		lis, err := net.Listen("tcp", addr)
+		if err != nil {
+			log.Printf("failed to listen on %s: %v", addr, err)
+			continue
+		}
+		if slots != nil {
+			lis = &limitListener{Listener: lis, slots: slots}
+		}
+		listeners = append(listeners, lis)
+	}
+	if len(listeners) == 0 {
+		return fmt.Errorf("failed to listen on any of %v", addrs)
+	}
+
+	server := grpc.NewServer(
+		grpc.Creds(creds),
+		// Accept the keepalive pings our own dialers send
+		grpc.KeepaliveEnforcementPolicy(keepalive.EnforcementPolicy{MinTime: PeerKeepaliveInterval / 2, PermitWithoutStream: true}),
+		// Recovery runs innermost so logging and metrics still see the resulting error
+		grpc.ChainUnaryInterceptor(loggingInterceptor, metricsInterceptor, t.node.rateLimitInterceptor, recoveryInterceptor),
+	)
+	// In a real project: pb.RegisterNodeServiceServer(server, srv)
+	t.mu.Lock()
+	if t.stopped {
+		// Stop ran before there was a server to stop
+		t.mu.Unlock()
+		for _, lis := range listeners {
+			lis.Close()
+		}
+		return nil
+	}
+	t.server = server
+	t.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, lis := range listeners {
+		wg.Add(1)
+		go func(lis net.Listener) {
+			defer wg.Done()
+			log.Printf("gRPC server listening on %s", lis.Addr())
+			if err := server.Serve(lis); err != nil {
+				log.Printf("gRPC server on %s stopped: %v", lis.Addr(), err)
+			}
+		}(lis)
+	}
+	wg.Wait()
+	return nil
+}
+
+func (t *grpcTransport) Stop() {
+	t.mu.Lock()
+	t.stopped = true
+	server := t.server
+	t.mu.Unlock()
+	if server != nil {
+		server.GracefulStop()
+	}
+}
+
+// MemoryNetwork connects nodes in the same process without sockets. Each node
+// gets its own Transport from NewTransport; clients call the target node's
+// handlers directly, so a dial fails with Unavailable exactly when no node is
+// serving at that address.
+type MemoryNetwork struct {
+	mu      sync.RWMutex
+	servers map[string]NodeServiceServer
+}
+
+// NewMemoryNetwork creates an empty in-memory network.
+func NewMemoryNetwork() *MemoryNetwork {
+	return &MemoryNetwork{servers: make(map[string]NodeServiceServer)}
+}
+
+// NewTransport returns a Transport attached to the network, for one node.
+func (m *MemoryNetwork) NewTransport() Transport {
+	return &memoryTransport{network: m, stop: make(chan struct{})}
+}
+
+func (m *MemoryNetwork) server(addr string) (NodeServiceServer, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	srv, ok := m.servers[addr]
+	if !ok {
+		return nil, status.Errorf(codes.Unavailable, "no node serving at %s", addr)
+	}
+	return srv, nil
+}
+
+type memoryTransport struct {
+	network  *MemoryNetwork
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+func (t *memoryTransport) Dial(ctx context.Context, addr string) (NodeServiceClient, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if _, err := t.network.server(addr); err != nil {
+		return nil, err
+	}
+	return &memoryClient{network: t.network, addr: addr}, nil
+}
+
+func (t *memoryTransport) Serve(srv NodeServiceServer, addrs ...string) error {
+	m := t.network
+	m.mu.Lock()
+	var bound []string
+	for _, addr := range addrs {
+		if _, taken := m.servers[addr]; taken {
+			log.Printf("failed to listen on %s: address already in use", addr)
+			continue
+		}
+		m.servers[addr] = srv
+		bound = append(bound, addr)
+	}
+	m.mu.Unlock()
+	if len(bound) == 0 {
+		return fmt.Errorf("failed to listen on any of %v", addrs)
+	}
+
+	<-t.stop
+	m.mu.Lock()
+	for _, addr := range bound {
+		delete(m.servers, addr)
+	}
+	m.mu.Unlock()
+	return nil
+}
+
+func (t *memoryTransport) Stop() {
+	t.stopOnce.Do(func() { close(t.stop) })
+}
+
+// memoryClient looks its server up on every call, so a node that stops
+// serving becomes unreachable to peers already connected to it.
+type memoryClient struct {
+	network *MemoryNetwork
+	addr    string
+}
+
+func (c *memoryClient) Handshake(ctx context.Context, in *HandshakeRequest, opts ...grpc.CallOption) (*HandshakeResponse, error) {
+	srv, err := c.network.server(c.addr)
+	if err != nil {
+		return nil, err
+	}
+	return srv.Handshake(ctx, in)
+}
+
+func (c *memoryClient) GetKnownPeers(ctx context.Context, in *GetKnownPeersRequest, opts ...grpc.CallOption) (*GetKnownPeersResponse, error) {
+	srv, err := c.network.server(c.addr)
+	if err != nil {
+		return nil, err
+	}
+	return srv.GetKnownPeers(ctx, in)
+}
+
+func (c *memoryClient) SendTransaction(ctx context.Context, in *SendTransactionRequest, opts ...grpc.CallOption) (*SendTransactionResponse, error) {
+	srv, err := c.network.server(c.addr)
+	if err != nil {
+		return nil, err
+	}
+	return srv.SendTransaction(ctx, in)
+}
+
+func (c *memoryClient) SendBlock(ctx context.Context, in *SendBlockRequest, opts ...grpc.CallOption) (*SendBlockResponse, error) {
+	srv, err := c.network.server(c.addr)
+	if err != nil {
+		return nil, err
+	}
+	return srv.SendBlock(ctx, in)
+}
+
+func (c *memoryClient) GetBlock(ctx context.Context, in *GetBlockRequest, opts ...grpc.CallOption) (*GetBlockResponse, error) {
+	srv, err := c.network.server(c.addr)
+	if err != nil {
+		return nil, err
+	}
+	return srv.GetBlock(ctx, in)
+}
+
+func (c *memoryClient) GetBlocks(ctx context.Context, in *GetBlocksRequest, opts ...grpc.CallOption) (*GetBlocksResponse, error) {
+	srv, err := c.network.server(c.addr)
+	if err != nil {
+		return nil, err
+	}
+	return srv.GetBlocks(ctx, in)
+}
+
+func (c *memoryClient) GetPeerInfo(ctx context.Context, in *GetPeerInfoRequest, opts ...grpc.CallOption) (*GetPeerInfoResponse, error) {
+	srv, err := c.network.server(c.addr)
+	if err != nil {
+		return nil, err
+	}
+	return srv.GetPeerInfo(ctx, in)
+}
+
+func (c *memoryClient) GetStatus(ctx context.Context, in *GetStatusRequest, opts ...grpc.CallOption) (*GetStatusResponse, error) {
+	srv, err := c.network.server(c.addr)
+	if err != nil {
+		return nil, err
+	}
+	return srv.GetStatus(ctx, in)
+}
+
+func (c *memoryClient) GetMempool(ctx context.Context, in *GetMempoolRequest, opts ...grpc.CallOption) (*GetMempoolResponse, error) {
+	srv, err := c.network.server(c.addr)
+	if err != nil {
+		return nil, err
+	}
+	return srv.GetMempool(ctx, in)
+}
+
+func (c *memoryClient) GetTransactions(ctx context.Context, in *GetTransactionsRequest, opts ...grpc.CallOption) (*GetTransactionsResponse, error) {
+	srv, err := c.network.server(c.addr)
+	if err != nil {
+		return nil, err
+	}
+	return srv.GetTransactions(ctx, in)
+}
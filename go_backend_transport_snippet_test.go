@@ -0,0 +1,494 @@
+// go_backend_transport_snippet_test.go
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// freeAddr returns a loopback address nothing is listening on.
+func freeAddr(t *testing.T) string {
+	t.Helper()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := lis.Addr().String()
+	lis.Close()
+	return addr
+}
+
+func TestGRPCDialConnectsToServingPeer(t *testing.T) {
+	server := NewP2PNode(freeAddr(t))
+	server.AllowInsecure = true
+	go server.Transport.Serve(server, server.Addr)
+	defer server.Transport.Stop()
+
+	node := NewP2PNode("127.0.0.1:0")
+	node.AllowInsecure = true
+	client, err := node.Transport.Dial(context.Background(), server.Addr)
+	if err != nil {
+		t.Fatalf("dialing a serving peer: %v", err)
+	}
+	c, ok := client.(*grpcClient)
+	if !ok || c.conn == nil {
+		t.Fatalf("Dial returned %T without a connection", client)
+	}
+	closeClient(client)
+}
+
+func TestGRPCRequiresTLSOrExplicitInsecure(t *testing.T) {
+	node := NewP2PNode(freeAddr(t))
+	if err := node.StartGRPCServer(); !errors.Is(err, errNoTransportSecurity) {
+		t.Fatalf("starting without TLS or AllowInsecure: got %v, want errNoTransportSecurity", err)
+	}
+	if _, err := node.Transport.Dial(context.Background(), freeAddr(t)); !errors.Is(err, errNoTransportSecurity) {
+		t.Errorf("dialing without TLS or AllowInsecure: got %v, want errNoTransportSecurity", err)
+	}
+
+	node.AllowInsecure = true
+	go node.StartGRPCServer()
+	defer node.Transport.Stop()
+	client := NewP2PNode("127.0.0.1:0")
+	client.AllowInsecure = true
+	c, err := client.Transport.Dial(context.Background(), node.Addr)
+	if err != nil {
+		t.Fatalf("server started with AllowInsecure is unreachable: %v", err)
+	}
+	closeClient(c)
+}
+
+func TestGRPCServesEveryListenAddress(t *testing.T) {
+	taken, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer taken.Close()
+	addrs := []string{freeAddr(t), freeAddr(t)}
+	server := NewP2PNode(addrs[0])
+	server.AllowInsecure = true
+	// The address already in use is skipped; the other two still serve
+	go server.Transport.Serve(server, addrs[0], taken.Addr().String(), addrs[1])
+	defer server.Transport.Stop()
+
+	node := NewP2PNode("127.0.0.1:0")
+	node.AllowInsecure = true
+	for _, addr := range addrs {
+		client, err := node.Transport.Dial(context.Background(), addr)
+		if err != nil {
+			t.Fatalf("dialing %s: %v", addr, err)
+		}
+		closeClient(client)
+	}
+}
+
+func TestGRPCServeFailsWhenNothingBinds(t *testing.T) {
+	taken, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer taken.Close()
+	node := NewP2PNode(taken.Addr().String())
+	node.AllowInsecure = true
+	if err := node.Transport.Serve(node, taken.Addr().String()); err == nil {
+		t.Fatal("Serve succeeded with no address to bind")
+	}
+}
+
+func TestGRPCDialUnreachablePeerFails(t *testing.T) {
+	node := NewP2PNode("127.0.0.1:0")
+	node.AllowInsecure = true
+	node.DialTimeout = 200 * time.Millisecond
+	addr := freeAddr(t)
+
+	start := time.Now()
+	if _, err := node.Transport.Dial(context.Background(), addr); err == nil {
+		t.Fatal("dialing a closed port succeeded")
+	}
+	if elapsed := time.Since(start); elapsed > 5*node.DialTimeout {
+		t.Errorf("dial gave up after %s, DialTimeout is %s", elapsed, node.DialTimeout)
+	}
+	if err := node.ConnectToPeer(context.Background(), addr); err == nil {
+		t.Fatal("ConnectToPeer connected to a closed port")
+	}
+	if got := node.PeerCount(); got != 0 {
+		t.Errorf("%d peers connected after a failed dial, want 0", got)
+	}
+}
+
+func TestGRPCDialHonorsContextDeadline(t *testing.T) {
+	node := NewP2PNode("127.0.0.1:0")
+	node.AllowInsecure = true // DialTimeout stays at its default, far longer than the context
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	err := node.ConnectToPeer(ctx, "[100::1]:9000") // The IPv6 discard prefix, so the dial never connects
+	if err == nil {
+		t.Fatal("ConnectToPeer connected to an unreachable address")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("dial returned after %s, context deadline was 100ms", elapsed)
+	}
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got %v, want context.DeadlineExceeded", err)
+	}
+}
+
+func TestGRPCStopBeforeServeReturns(t *testing.T) {
+	node := NewP2PNode(freeAddr(t))
+	node.AllowInsecure = true
+	node.Transport.Stop()
+	done := make(chan error, 1)
+	go func() { done <- node.Transport.Serve(node, node.Addr) }()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Serve kept serving after Stop")
+	}
+	lis, err := net.Listen("tcp", node.Addr)
+	if err != nil {
+		t.Fatalf("Serve left its listener open: %v", err)
+	}
+	lis.Close()
+}
+
+// memoryNodes starts n nodes serving on one MemoryNetwork, each connected to
+// every other, and stops them when the test ends.
+func memoryNodes(t *testing.T, n int) []*P2PNode {
+	t.Helper()
+	network := NewMemoryNetwork()
+	var nodes []*P2PNode
+	for i := 0; i < n; i++ {
+		node := NewP2PNode(fmt.Sprintf("127.0.0.1:%d", 40000+i))
+		node.AllowInsecure = true
+		node.Transport = network.NewTransport()
+		node.GossipFanout = float64(n)
+		go node.StartGRPCServer()
+		t.Cleanup(node.Transport.Stop)
+		nodes = append(nodes, node)
+	}
+	for _, node := range nodes {
+		for _, peer := range nodes {
+			if peer == node {
+				continue
+			}
+			waitUntil(t, func() bool { return node.ConnectToPeer(context.Background(), peer.Addr) == nil })
+		}
+	}
+	return nodes
+}
+
+// servingNodes starts n nodes serving on one MemoryNetwork without connecting
+// them, after applying configure to each, and stops them when the test ends.
+func servingNodes(t *testing.T, n int, configure func(i int, node *P2PNode)) []*P2PNode {
+	t.Helper()
+	network := NewMemoryNetwork()
+	var nodes []*P2PNode
+	for i := 0; i < n; i++ {
+		node := NewP2PNode(fmt.Sprintf("127.0.0.1:%d", 41000+i))
+		node.AllowInsecure = true
+		node.Transport = network.NewTransport()
+		configure(i, node)
+		go node.StartGRPCServer()
+		t.Cleanup(node.Transport.Stop)
+		nodes = append(nodes, node)
+	}
+	return nodes
+}
+
+// blockSendCounter counts the SendBlock calls made through its clients.
+type blockSendCounter struct {
+	Transport
+	sends *atomic.Int32
+}
+
+func (t blockSendCounter) Dial(ctx context.Context, addr string) (NodeServiceClient, error) {
+	client, err := t.Transport.Dial(ctx, addr)
+	if err != nil {
+		return nil, err
+	}
+	return countingClient{client, t.sends}, nil
+}
+
+type countingClient struct {
+	NodeServiceClient
+	sends *atomic.Int32
+}
+
+func (c countingClient) SendBlock(ctx context.Context, in *SendBlockRequest, opts ...grpc.CallOption) (*SendBlockResponse, error) {
+	c.sends.Add(1)
+	return c.NodeServiceClient.SendBlock(ctx, in, opts...)
+}
+
+// waitUntil polls cond until it holds, failing the test after five seconds.
+func waitUntil(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not reached in time")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestMemoryNetworkGossipsTransactions(t *testing.T) {
+	nodes := memoryNodes(t, 3)
+	tx := signedVote(t)
+	if err := nodes[0].Mempool.Add(tx); err != nil {
+		t.Fatal(err)
+	}
+	nodes[0].BroadcastTransaction(tx)
+	for _, node := range nodes[1:] {
+		waitUntil(t, func() bool {
+			_, ok := node.Mempool.Get(tx.Hash)
+			return ok
+		})
+	}
+}
+
+func TestMemoryNetworkSyncsChain(t *testing.T) {
+	nodes := memoryNodes(t, 2)
+	extendChain(t, nodes[0].Chain, 5)
+	if err := nodes[1].SyncWithPeer(context.Background(), nodes[0].Addr); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := nodes[1].Chain.Tip().Header.Hash, nodes[0].Chain.Tip().Header.Hash; !bytes.Equal(got, want) {
+		t.Errorf("synced to tip %x at height %d, want %x", got, nodes[1].Chain.Height(), want)
+	}
+}
+
+func TestMemoryNetworkStoppedNodeIsUnreachable(t *testing.T) {
+	nodes := memoryNodes(t, 2)
+	client, ok := nodes[1].Peer(nodes[0].Addr)
+	if !ok {
+		t.Fatal("not connected")
+	}
+	nodes[0].Transport.Stop()
+	waitUntil(t, func() bool {
+		_, err := client.GetStatus(context.Background(), &GetStatusRequest{})
+		return status.Code(err) == codes.Unavailable
+	})
+	if _, err := nodes[1].Transport.Dial(context.Background(), nodes[0].Addr); status.Code(err) != codes.Unavailable {
+		t.Errorf("dialing a stopped node: got %v, want Unavailable", err)
+	}
+}
+
+func TestPeersOnAnotherChainAreRefused(t *testing.T) {
+	nodes := servingNodes(t, 3, func(i int, node *P2PNode) {
+		if i == 2 {
+			node.ChainID = "testnet"
+		}
+	})
+	waitUntil(t, func() bool { return nodes[0].ConnectToPeer(context.Background(), nodes[1].Addr) == nil })
+
+	for _, pair := range [][2]*P2PNode{{nodes[0], nodes[2]}, {nodes[2], nodes[0]}} {
+		err := pair[0].ConnectToPeer(context.Background(), pair[1].Addr)
+		if err == nil || !strings.Contains(err.Error(), "chain") {
+			t.Errorf("%s on %q connecting to %s on %q: got %v, want a chain ID refusal",
+				pair[0].Addr, pair[0].ChainID, pair[1].Addr, pair[1].ChainID, err)
+		}
+	}
+	if got := nodes[2].PeerCount(); got != 0 {
+		t.Errorf("testnet node has %d peers, want 0", got)
+	}
+	if got := nodes[0].PeerCount(); got != 1 {
+		t.Errorf("mainnet node has %d peers, want only the other mainnet node", got)
+	}
+}
+
+func TestPeersOnAnotherGenesisAreRefused(t *testing.T) {
+	var keys [][]byte
+	for i := 0; i < 3; i++ {
+		pub, _, err := ed25519.GenerateKey(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys = append(keys, pub)
+	}
+	genesis := func(validators ...[]byte) GenesisConfig {
+		cfg := DefaultGenesisConfig()
+		cfg.InitialValidators = validators
+		cfg.AuthorityKey = keys[0]
+		return cfg
+	}
+	nodes := servingNodes(t, 3, func(i int, node *P2PNode) {
+		cfg := genesis(keys[1], keys[2])
+		if i == 2 {
+			cfg = genesis(keys[1]) // Same chain ID, different validators
+		}
+		chain, err := NewChain(GenesisBlock(cfg))
+		if err != nil {
+			t.Fatal(err)
+		}
+		node.UseChain(chain)
+	})
+
+	a, b := nodes[0].Chain, nodes[1].Chain
+	want := [][]byte{keys[1], keys[2]}
+	slices.SortFunc(want, bytes.Compare) // ActiveAt orders by key
+	if got := a.Validators.ActiveAt(0); !slices.EqualFunc(got, want, bytes.Equal) {
+		t.Errorf("validators %x, want the genesis ones %x", got, want)
+	}
+	if !slices.EqualFunc(a.Validators.ActiveAt(0), b.Validators.ActiveAt(0), bytes.Equal) || !bytes.Equal(a.AuthorityKey(), b.AuthorityKey()) {
+		t.Error("nodes on the same genesis config disagree on the authority set")
+	}
+	waitUntil(t, func() bool { return nodes[0].ConnectToPeer(context.Background(), nodes[1].Addr) == nil })
+
+	for _, pair := range [][2]*P2PNode{{nodes[0], nodes[2]}, {nodes[2], nodes[0]}} {
+		err := pair[0].ConnectToPeer(context.Background(), pair[1].Addr)
+		if err == nil || !strings.Contains(err.Error(), "genesis") {
+			t.Errorf("%s connecting to %s: got %v, want a genesis refusal", pair[0].Addr, pair[1].Addr, err)
+		}
+	}
+	if got := nodes[2].PeerCount(); got != 0 {
+		t.Errorf("node on the other genesis has %d peers, want 0", got)
+	}
+}
+
+func TestBlocksCrossARingRelayedOncePerNode(t *testing.T) {
+	const n = 5
+	sends := make([]atomic.Int32, n)
+	nodes := servingNodes(t, n, func(i int, node *P2PNode) {
+		node.Transport = blockSendCounter{node.Transport, &sends[i]}
+		node.GossipFanout = n // Every neighbour, not a sample
+		go node.ImportBlocks()
+	})
+	for i, node := range nodes {
+		for _, peer := range []*P2PNode{nodes[(i+1)%n], nodes[(i+n-1)%n]} {
+			waitUntil(t, func() bool { return node.ConnectToPeer(context.Background(), peer.Addr) == nil })
+		}
+	}
+
+	origin := nodes[0]
+	blk := testBlock(origin.Chain.Tip(), testVote("e", "a", 1))
+	if err := origin.Chain.AppendBlock(blk); err != nil {
+		t.Fatal(err)
+	}
+	origin.seenBlocks.Add(blk.Header.Hash)
+	origin.BroadcastBlock(blk)
+
+	for _, node := range nodes {
+		waitUntil(t, func() bool { return node.Chain.Height() == 1 })
+	}
+	// Each node sends the block once to each of its two neighbours
+	waitUntil(t, func() bool { return sends[n-1].Load() == 2 })
+	time.Sleep(50 * time.Millisecond) // Give any extra relay time to show up
+	for i := range sends {
+		if got := sends[i].Load(); got != 2 {
+			t.Errorf("node %d sent the block %d times, want once to each neighbour", i, got)
+		}
+	}
+}
+
+func TestLosingAllPeersIsolatesUntilReconnected(t *testing.T) {
+	nodes := memoryNodes(t, 2)
+	node := nodes[0]
+	node.IsolationRetryInterval = 10 * time.Millisecond
+	events := isolationEvents.Value()
+
+	node.removePeer(nodes[1].Addr)
+	if !node.Isolated() {
+		t.Fatal("node with no peers left is not isolated")
+	}
+	if got := isolationEvents.Value() - events; got != 1 {
+		t.Errorf("isolation events rose by %d, want 1", got)
+	}
+	rr := httptest.NewRecorder()
+	NewAPIHandler(node).ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/status?election_id=e", nil))
+	var resp ElectionStatus
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil || !resp.Isolated {
+		t.Errorf("/status while isolated: %s", rr.Body)
+	}
+
+	// The retry loop reconnects to the known peer on its own
+	waitUntil(t, func() bool { return !node.Isolated() })
+	if node.PeerCount() != 1 {
+		t.Errorf("%d peers after leaving isolation, want 1", node.PeerCount())
+	}
+}
+
+func TestLimitListenerRefusesBeyondCap(t *testing.T) {
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lis := &limitListener{Listener: inner, slots: make(chan struct{}, 2)}
+	defer lis.Close()
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+	refused := inboundConnsRefused.Value()
+
+	accepted := make(chan net.Conn, 3)
+	go func() {
+		for {
+			conn, err := lis.Accept()
+			if err != nil {
+				return
+			}
+			accepted <- conn
+		}
+	}()
+	dial := func() net.Conn {
+		conn, err := net.Dial("tcp", inner.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		return conn
+	}
+	var held []net.Conn
+	for i := 0; i < cap(lis.slots); i++ {
+		dial()
+		held = append(held, <-accepted)
+	}
+
+	for i := 0; i < 3; i++ {
+		conn := dial()
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		if _, err := conn.Read(make([]byte, 1)); !errors.Is(err, io.EOF) {
+			t.Fatalf("connection beyond the cap: read got %v, want it closed", err)
+		}
+	}
+	if got := inboundConnsRefused.Value() - refused; got != 3 {
+		t.Errorf("grpc_inbound_conns_refused rose by %d, want 3", got)
+	}
+	if n := strings.Count(logs.String(), "Refusing connection"); n != 1 {
+		t.Errorf("logged %d refusals, want 1 within refusedLogInterval", n)
+	}
+
+	// Closing a held connection frees its slot
+	held[0].Close()
+	dial()
+	select {
+	case conn := <-accepted:
+		conn.Close()
+	case <-time.After(time.Second):
+		t.Error("connection refused after a slot was freed")
+	}
+}
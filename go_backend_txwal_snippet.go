@@ -0,0 +1,356 @@
+// go_backend_txwal_snippet.go
+
+package main
+
+import (
+	"bufio"
+	"encoding/hex"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"sync"
+	"time"
+)
+
+// --- Outbound Transaction WAL ---
+
+// walRecord is one line of the transaction WAL: either a transaction this node
+// accepted for broadcast, or a marker that an earlier one is now in a block.
+type walRecord struct {
+	Op   string `json:"op"`             // "add" or "done"
+	Tx   string `json:"tx,omitempty"`   // Hex protobuf encoding, for "add"
+	Hash string `json:"hash,omitempty"` // Hex transaction hash, for "done"
+}
+
+// txWALCorruptRecords counts transaction WAL records that could not be read
+// and were not a torn final write, so a vote they held may have been lost.
+var txWALCorruptRecords = expvar.NewInt("tx_wal_corrupt_records")
+
+// TxWAL is a write-ahead log of transactions this node accepted from clients
+// and must get into a block. Each transaction is synced to disk before it is
+// broadcast, so a crash between accepting and broadcasting a vote does not lose
+// it: on restart ReplayTxWAL re-adds and re-broadcasts everything not yet confirmed.
+type TxWAL struct {
+	mu      sync.Mutex
+	f       *os.File
+	path    string
+	pending map[string]*Transaction // hex(tx hash) -> transaction
+	order   []string                // pending keys in the order they were appended
+	corrupt int                     // Unreadable records found by load, other than a torn final line
+}
+
+// OpenTxWAL opens (or creates) the WAL at path and loads the transactions still
+// pending. The file is compacted to just those transactions before new records
+// are appended, so confirmed entries do not accumulate across restarts. If
+// records were corrupt, the original file is kept at path+".corrupt" for
+// inspection rather than compacted away.
+func OpenTxWAL(path string) (*TxWAL, error) {
+	w := &TxWAL{path: path, pending: make(map[string]*Transaction)}
+	if err := w.load(); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if w.corrupt > 0 {
+		if err := os.Rename(path, path+".corrupt"); err != nil {
+			return nil, fmt.Errorf("failed to preserve corrupt transaction WAL: %w", err)
+		}
+		log.Printf("ERROR: transaction WAL had %d corrupt records; the original is kept at %s.corrupt", w.corrupt, path)
+	}
+	if err := w.compact(); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open transaction WAL: %w", err)
+	}
+	w.f = f
+	return w, nil
+}
+
+// load replays the records at w.path into w.pending. A crash mid-write leaves
+// a torn final line, which is skipped; an unreadable record followed by others
+// is corrupt, and is counted in w.corrupt and tx_wal_corrupt_records.
+func (w *TxWAL) load() error {
+	f, err := os.Open(w.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	var (
+		badLine int // Last unreadable line, until a later record shows it was not torn
+		badErr  error
+	)
+	for line := 1; scanner.Scan(); line++ {
+		if badLine != 0 {
+			w.corrupt++
+			txWALCorruptRecords.Add(1)
+			log.Printf("ERROR: skipping corrupt transaction WAL record on line %d: %v", badLine, badErr)
+			badLine = 0
+		}
+		rec, tx, err := parseWALRecord(scanner.Bytes())
+		if err != nil {
+			badLine, badErr = line, err
+			continue
+		}
+		switch rec.Op {
+		case "add":
+			key := hex.EncodeToString(tx.Hash)
+			if _, ok := w.pending[key]; !ok {
+				w.order = append(w.order, key)
+			}
+			w.pending[key] = tx
+		case "done":
+			if _, ok := w.pending[rec.Hash]; ok {
+				delete(w.pending, rec.Hash)
+				w.order = slices.DeleteFunc(w.order, func(key string) bool { return key == rec.Hash })
+			}
+		}
+	}
+	if badLine != 0 {
+		log.Printf("WARNING: ignoring torn final transaction WAL record on line %d: %v", badLine, badErr)
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read transaction WAL: %w", err)
+	}
+	return nil
+}
+
+// parseWALRecord decodes one WAL line, and for an "add" record its transaction.
+func parseWALRecord(line []byte) (walRecord, *Transaction, error) {
+	var rec walRecord
+	if err := json.Unmarshal(line, &rec); err != nil {
+		return rec, nil, err
+	}
+	switch rec.Op {
+	case "add":
+		raw, err := hex.DecodeString(rec.Tx)
+		if err != nil {
+			return rec, nil, err
+		}
+		tx := &Transaction{}
+		if err := tx.UnmarshalProto(raw); err != nil {
+			return rec, nil, err
+		}
+		return rec, tx, nil
+	case "done":
+		return rec, nil, nil
+	}
+	return rec, nil, fmt.Errorf("unknown op %q", rec.Op)
+}
+
+// compact atomically rewrites the file with one "add" record per pending transaction.
+func (w *TxWAL) compact() error {
+	tmp := w.path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to compact transaction WAL: %w", err)
+	}
+	kept := w.order[:0]
+	for _, key := range w.order {
+		tx, ok := w.pending[key]
+		if !ok {
+			continue
+		}
+		if err := writeWALRecord(f, walRecord{Op: "add", Tx: hex.EncodeToString(tx.MarshalProto())}); err != nil {
+			f.Close()
+			return err
+		}
+		kept = append(kept, key)
+	}
+	w.order = kept
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to sync transaction WAL: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, w.path)
+}
+
+func writeWALRecord(f *os.File, rec walRecord) error {
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("failed to write transaction WAL record: %w", err)
+	}
+	return nil
+}
+
+// Append durably records tx as pending. It returns once the record is synced.
+func (w *TxWAL) Append(tx *Transaction) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	key := hex.EncodeToString(tx.Hash)
+	if _, ok := w.pending[key]; ok {
+		return nil
+	}
+	if err := writeWALRecord(w.f, walRecord{Op: "add", Tx: hex.EncodeToString(tx.MarshalProto())}); err != nil {
+		return err
+	}
+	if err := w.f.Sync(); err != nil {
+		return fmt.Errorf("failed to sync transaction WAL: %w", err)
+	}
+	w.pending[key] = tx
+	w.order = append(w.order, key)
+	return nil
+}
+
+// Confirm marks the given transactions as done, whether included in a block or
+// abandoned, so they are not replayed. It returns how many were pending.
+func (w *TxWAL) Confirm(hashes [][]byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	confirmed := 0
+	for _, hash := range hashes {
+		key := hex.EncodeToString(hash)
+		if _, ok := w.pending[key]; !ok {
+			continue
+		}
+		if err := writeWALRecord(w.f, walRecord{Op: "done", Hash: key}); err != nil {
+			return confirmed, err
+		}
+		delete(w.pending, key)
+		confirmed++
+	}
+	if confirmed == 0 {
+		return 0, nil
+	}
+	w.order = slices.DeleteFunc(w.order, func(key string) bool {
+		_, ok := w.pending[key]
+		return !ok
+	})
+	if err := w.f.Sync(); err != nil {
+		return confirmed, fmt.Errorf("failed to sync transaction WAL: %w", err)
+	}
+	return confirmed, nil
+}
+
+// Pending returns the unconfirmed transactions in the order they were appended.
+func (w *TxWAL) Pending() []*Transaction {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	txs := make([]*Transaction, 0, len(w.pending))
+	for _, key := range w.order {
+		if tx, ok := w.pending[key]; ok {
+			txs = append(txs, tx)
+		}
+	}
+	return txs
+}
+
+// Close closes the underlying file.
+func (w *TxWAL) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.f.Close()
+}
+
+// logOutbound records tx in the node's WAL, if one is configured, before it is
+// broadcast. Failures are logged rather than returned: the transaction is already accepted.
+func (n *P2PNode) logOutbound(tx *Transaction) {
+	if n.WAL == nil {
+		return
+	}
+	if err := n.WAL.Append(tx); err != nil {
+		log.Printf("ERROR: failed to write transaction %x to the WAL: %v", tx.GetHash(), err)
+	}
+}
+
+// ReplayTxWAL restores the WAL's pending transactions after a restart: expired
+// ones are dropped, the rest go back into the mempool and are re-broadcast once
+// the node has a peer. This method should be run in a goroutine.
+func (n *P2PNode) ReplayTxWAL() {
+	if n.WAL == nil {
+		return
+	}
+	var expired [][]byte
+	var txs []*Transaction
+	for _, tx := range n.WAL.Pending() {
+		if _, included := n.Chain.TxHeight(tx.GetHash()); included {
+			expired = append(expired, tx.GetHash())
+			continue
+		}
+		if err := n.checkTxTimestamp(tx, n.Chain.now()); err != nil {
+			log.Printf("Dropping WAL transaction %x: %v", tx.GetHash(), err)
+			expired = append(expired, tx.GetHash())
+			continue
+		}
+		n.Mempool.Add(tx) // Already pending is fine; if full, the broadcast below still spreads it
+		txs = append(txs, tx)
+	}
+	if _, err := n.WAL.Confirm(expired); err != nil {
+		log.Printf("ERROR: failed to drop stale WAL transactions: %v", err)
+	}
+	if len(txs) == 0 {
+		return
+	}
+	log.Printf("Replaying %d transactions from the WAL", len(txs))
+
+	ticker := time.NewTicker(n.IsolationRetryInterval)
+	defer ticker.Stop()
+	for n.PeerCount() == 0 {
+		select {
+		case <-ticker.C:
+		case <-n.closing.Done():
+			return // Close broadcasts the mempool, which holds them
+		}
+	}
+	for _, tx := range txs {
+		n.BroadcastTransaction(tx)
+	}
+}
+
+// pruneTxWAL drops WAL entries whose transactions have left the mempool,
+// whether included in a block or swept or evicted unconfirmed, so a restart
+// does not replay them.
+func (n *P2PNode) pruneTxWAL() {
+	if n.WAL == nil {
+		return
+	}
+	var gone [][]byte
+	for _, tx := range n.WAL.Pending() {
+		if _, ok := n.Mempool.Get(tx.GetHash()); !ok {
+			gone = append(gone, tx.GetHash())
+		}
+	}
+	if _, err := n.WAL.Confirm(gone); err != nil {
+		log.Printf("ERROR: failed to drop evicted WAL transactions: %v", err)
+	}
+}
+
+// ConfirmTxWAL removes WAL entries as their transactions land in blocks on the
+// best chain, until the node closes. This method should be run in a goroutine.
+func (n *P2PNode) ConfirmTxWAL() {
+	if n.WAL == nil {
+		return
+	}
+	blocks, unsubscribe := n.Chain.Events.SubscribeBlocks(16)
+	defer unsubscribe()
+
+	// Events can be dropped, so each one is a prompt to re-check every pending entry
+	for {
+		select {
+		case <-blocks:
+		case <-n.closing.Done():
+			return
+		}
+		var included [][]byte
+		for _, tx := range n.WAL.Pending() {
+			if _, ok := n.Chain.TxHeight(tx.GetHash()); ok {
+				included = append(included, tx.GetHash())
+			}
+		}
+		if _, err := n.WAL.Confirm(included); err != nil {
+			log.Printf("ERROR: failed to confirm WAL transactions: %v", err)
+		}
+	}
+}
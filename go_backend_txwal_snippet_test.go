@@ -0,0 +1,122 @@
+// go_backend_txwal_snippet_test.go
+
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// openTestWAL opens the WAL at path, closing it when the test ends.
+func openTestWAL(t *testing.T, path string) *TxWAL {
+	t.Helper()
+	w, err := OpenTxWAL(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { w.Close() })
+	return w
+}
+
+func TestTxWALReplaysPendingAfterRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tx.wal")
+	w := openTestWAL(t, path)
+	txs := []*Transaction{signedVote(t), signedVote(t), signedVote(t)}
+	for _, tx := range txs {
+		if err := w.Append(tx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := w.Confirm([][]byte{txs[1].Hash}); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+
+	// Restart: a new node reopens the WAL and re-broadcasts what is pending
+	node := newTestNode(t)
+	node.WAL = openTestWAL(t, path)
+	node.IsolationRetryInterval = 10 * time.Millisecond
+	sent := make(chan []byte, len(txs))
+	node.peers["peer:1"] = &peerState{state: PeerConnected, client: &slowPeer{sent: sent}}
+	go node.ReplayTxWAL()
+	got := map[string]bool{}
+	for range 2 {
+		select {
+		case hash := <-sent:
+			got[string(hash)] = true
+		case <-time.After(5 * time.Second):
+			t.Fatalf("re-broadcast %d of 2 pending transactions", len(got))
+		}
+	}
+	if !got[string(txs[0].Hash)] || !got[string(txs[2].Hash)] {
+		t.Error("re-broadcast the confirmed transaction instead of a pending one")
+	}
+}
+
+func TestTxWALConfirmPrunesOrder(t *testing.T) {
+	w := openTestWAL(t, filepath.Join(t.TempDir(), "tx.wal"))
+	var hashes [][]byte
+	for range 5 {
+		tx := signedVote(t)
+		if err := w.Append(tx); err != nil {
+			t.Fatal(err)
+		}
+		hashes = append(hashes, tx.Hash)
+	}
+	if _, err := w.Confirm(hashes); err != nil {
+		t.Fatal(err)
+	}
+	if len(w.order) != 0 || len(w.Pending()) != 0 {
+		t.Errorf("%d keys ordered and %d pending after confirming everything", len(w.order), len(w.Pending()))
+	}
+}
+
+func TestTxWALSurfacesCorruptRecords(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tx.wal")
+	w := openTestWAL(t, path)
+	first, second := signedVote(t), signedVote(t)
+	if err := w.Append(first); err != nil {
+		t.Fatal(err)
+	}
+	w.f.WriteString("{not json\n")
+	if err := w.Append(second); err != nil {
+		t.Fatal(err)
+	}
+	w.f.WriteString(`{"op":"add","tx":"0a`) // Torn final write
+	w.Close()
+
+	corrupt := txWALCorruptRecords.Value()
+	w = openTestWAL(t, path)
+	if pending := w.Pending(); len(pending) != 2 || !bytes.Equal(pending[0].Hash, first.Hash) || !bytes.Equal(pending[1].Hash, second.Hash) {
+		t.Fatalf("%d transactions pending, want the two intact ones", len(pending))
+	}
+	if got := txWALCorruptRecords.Value() - corrupt; got != 1 {
+		t.Errorf("tx_wal_corrupt_records rose by %d, want 1 (the torn final line is not corrupt)", got)
+	}
+	if _, err := os.Stat(path + ".corrupt"); err != nil {
+		t.Errorf("corrupt WAL was not preserved: %v", err)
+	}
+}
+
+func TestPruneTxWALDropsEvictedTransactions(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tx.wal")
+	node := newTestNode(t)
+	node.WAL = openTestWAL(t, path)
+	evicted, kept := signedVote(t), signedVote(t)
+	for _, tx := range []*Transaction{evicted, kept} {
+		if err := node.Mempool.Add(tx); err != nil {
+			t.Fatal(err)
+		}
+		node.logOutbound(tx)
+	}
+	node.Mempool.Remove([]*Transaction{evicted})
+	node.pruneTxWAL()
+	node.WAL.Close()
+
+	if pending := openTestWAL(t, path).Pending(); len(pending) != 1 || !bytes.Equal(pending[0].Hash, kept.Hash) {
+		t.Errorf("%d transactions would be replayed, want only the one still in the mempool", len(pending))
+	}
+}
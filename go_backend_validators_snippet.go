@@ -0,0 +1,649 @@
+// go_backend_validators_snippet.go
+
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"log"
+	"math"
+	"math/bits"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// --- Validator Set ---
+
+// MinValidatorChangeDelay is how many blocks after inclusion a validator change
+// may activate at the earliest, so the block carrying it is final before the
+// set changes.
+const MinValidatorChangeDelay = 10
+
+// DefaultValidatorStake is the stake of a validator added without one, so a
+// set with no stakes configured elects leaders with equal weight.
+const DefaultValidatorStake = 1
+
+// ValidatorOp is the action a ValidatorChange performs.
+type ValidatorOp uint32
+
+const (
+	ValidatorAdd    ValidatorOp = 0
+	ValidatorRemove ValidatorOp = 1
+)
+
+// ValidatorChange is the payload of a TxKindValidatorChange transaction
+// (see proto/transaction.proto).
+type ValidatorChange struct {
+	Op               ValidatorOp
+	PubKey           []byte // Ed25519 key of the validator being added or removed
+	ActivationHeight uint64 // First height at which the new set is used
+	Approvals        []*ValidatorApproval
+	Stake            uint64 // Stake of an added validator; zero means DefaultValidatorStake. Re-adding a validator updates it
+}
+
+// ValidatorApproval is one current validator's signature over a ValidatorChange.
+type ValidatorApproval struct {
+	PubKey    []byte
+	Signature []byte
+}
+
+// SigningBytes returns the message each approving validator signs. The chain ID
+// keeps an approval for one network from being replayed on another. A zero
+// Stake is left out, so approvals signed before stakes existed stay valid.
+func (c *ValidatorChange) SigningBytes(chainID string) []byte {
+	msg := fmt.Sprintf("validator-change|%s|%d|%x|%d", chainID, c.Op, c.PubKey, c.ActivationHeight)
+	if c.Stake != 0 {
+		msg += fmt.Sprintf("|%d", c.Stake)
+	}
+	return []byte(msg)
+}
+
+// MarshalProto encodes the change in protobuf wire format.
+func (c *ValidatorChange) MarshalProto() []byte {
+	var b []byte
+	b = protowire.AppendTag(b, 1, protowire.VarintType)
+	b = protowire.AppendVarint(b, uint64(c.Op))
+	b = protowire.AppendTag(b, 2, protowire.BytesType)
+	b = protowire.AppendBytes(b, c.PubKey)
+	b = protowire.AppendTag(b, 3, protowire.VarintType)
+	b = protowire.AppendVarint(b, c.ActivationHeight)
+	for _, a := range c.Approvals {
+		var entry []byte
+		entry = protowire.AppendTag(entry, 1, protowire.BytesType)
+		entry = protowire.AppendBytes(entry, a.PubKey)
+		entry = protowire.AppendTag(entry, 2, protowire.BytesType)
+		entry = protowire.AppendBytes(entry, a.Signature)
+		b = protowire.AppendTag(b, 4, protowire.BytesType)
+		b = protowire.AppendBytes(b, entry)
+	}
+	if c.Stake != 0 {
+		b = protowire.AppendTag(b, 5, protowire.VarintType)
+		b = protowire.AppendVarint(b, c.Stake)
+	}
+	return b
+}
+
+// UnmarshalProto decodes a change from protobuf wire format.
+func (c *ValidatorChange) UnmarshalProto(b []byte) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+		switch {
+		case typ == protowire.VarintType && (num == 1 || num == 3 || num == 5):
+			v, n := protowire.ConsumeVarint(b)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			b = b[n:]
+			switch num {
+			case 1:
+				c.Op = ValidatorOp(v)
+			case 3:
+				c.ActivationHeight = v
+			case 5:
+				c.Stake = v
+			}
+		case typ == protowire.BytesType && (num == 2 || num == 4):
+			v, n := protowire.ConsumeBytes(b)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			b = b[n:]
+			if num == 2 {
+				c.PubKey = append([]byte(nil), v...)
+				continue
+			}
+			a := &ValidatorApproval{}
+			if err := a.unmarshalProto(v); err != nil {
+				return err
+			}
+			c.Approvals = append(c.Approvals, a)
+		default:
+			n := protowire.ConsumeFieldValue(num, typ, b)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			b = b[n:]
+		}
+	}
+	return nil
+}
+
+func (a *ValidatorApproval) unmarshalProto(b []byte) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+		if typ != protowire.BytesType || (num != 1 && num != 2) {
+			n := protowire.ConsumeFieldValue(num, typ, b)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			b = b[n:]
+			continue
+		}
+		v, n := protowire.ConsumeBytes(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+		if num == 1 {
+			a.PubKey = append([]byte(nil), v...)
+		} else {
+			a.Signature = append([]byte(nil), v...)
+		}
+	}
+	return nil
+}
+
+// scheduledChange is a ValidatorChange included on chain, waiting for or past activation.
+type scheduledChange struct {
+	change     *ValidatorChange
+	includedAt uint64 // Height of the block that carried it
+	index      int    // Position within that block, for a deterministic order
+}
+
+// Validator is an active validator and the stake weighting its turns as leader.
+type Validator struct {
+	PubKey []byte
+	Stake  uint64
+}
+
+// ValidatorSet tracks which validators are active at each height. It starts from
+// the genesis validators and applies VALIDATOR_CHANGE transactions at their
+// activation heights. Like Tally, blocks are applied as they are appended and
+// reverted when a reorg orphans them.
+type ValidatorSet struct {
+	mu      sync.RWMutex
+	genesis []Validator
+	changes []scheduledChange // Sorted by activation height, then inclusion order
+	hasher  Hasher            // Seeds leader selection; the owning chain's, set by NewChain
+}
+
+// NewValidatorSet creates a set starting from the given genesis validators,
+// picking leaders with SHA3Hasher.
+func NewValidatorSet(genesis []Validator) *ValidatorSet {
+	return &ValidatorSet{genesis: genesis, hasher: SHA3Hasher{}}
+}
+
+// ActiveAt returns the keys of the validators active at height, sorted.
+func (vs *ValidatorSet) ActiveAt(height uint64) [][]byte {
+	active := vs.ValidatorsAt(height)
+	keys := make([][]byte, len(active))
+	for i, v := range active {
+		keys[i] = v.PubKey
+	}
+	return keys
+}
+
+// ValidatorsAt returns the validators active at height with their stakes,
+// sorted by public key.
+func (vs *ValidatorSet) ValidatorsAt(height uint64) []Validator {
+	vs.mu.RLock()
+	defer vs.mu.RUnlock()
+	return vs.activeAt(height)
+}
+
+func (vs *ValidatorSet) activeAt(height uint64) []Validator {
+	active := make(map[string]Validator, len(vs.genesis))
+	for _, v := range vs.genesis {
+		active[string(v.PubKey)] = v
+	}
+	for _, sc := range vs.changes {
+		if sc.change.ActivationHeight > height {
+			break
+		}
+		switch sc.change.Op {
+		case ValidatorAdd:
+			active[string(sc.change.PubKey)] = Validator{PubKey: sc.change.PubKey, Stake: sc.change.addedStake()}
+		case ValidatorRemove:
+			if len(active) > 1 { // Never remove the last validator
+				delete(active, string(sc.change.PubKey))
+			}
+		}
+	}
+
+	vals := make([]Validator, 0, len(active))
+	for _, v := range active {
+		vals = append(vals, v)
+	}
+	sort.Slice(vals, func(i, j int) bool { return bytes.Compare(vals[i].PubKey, vals[j].PubKey) < 0 })
+	return vals
+}
+
+// LeaderForHeight returns the validator expected to propose the block at height
+// on top of the block hashed prevHash. The leader is drawn from the set active
+// at height with probability proportional to stake, using a hash of prevHash and
+// height as the random source, so every node computes the same leader while
+// proposers cannot predict their turns far in advance.
+func (vs *ValidatorSet) LeaderForHeight(height uint64, prevHash []byte) []byte {
+	return vs.LeaderForRound(height, 0, prevHash)
+}
+
+// LeaderForRound returns the proposer for round of height. Round 0 is the
+// stake-weighted LeaderForHeight pick; each later round passes to the next
+// active validator in key order, so successive rounds try distinct validators
+// until every one of them has had a turn.
+func (vs *ValidatorSet) LeaderForRound(height uint64, round uint32, prevHash []byte) []byte {
+	active := vs.ValidatorsAt(height)
+	total, ok := totalStake(active)
+	if total == 0 || !ok {
+		return nil // validateChange and ParseGenesisState keep stakes from overflowing
+	}
+	seed := vs.hasher.Sum([]byte(fmt.Sprintf("leader|%x|%d", prevHash, height)))
+	pick := binary.BigEndian.Uint64(seed[:8]) % total // Bias is negligible while total stake is far below 2^64
+	for i, v := range active {
+		if pick < v.Stake {
+			return active[(i+int(round%uint32(len(active))))%len(active)].PubKey
+		}
+		pick -= v.Stake
+	}
+	return nil // Unreachable: pick < total
+}
+
+// totalStake returns the summed stake of vals, or math.MaxUint64 and false if
+// the sum overflows.
+func totalStake(vals []Validator) (uint64, bool) {
+	var total uint64
+	for _, v := range vals {
+		sum, carry := bits.Add64(total, v.Stake, 0)
+		if carry != 0 {
+			return math.MaxUint64, false
+		}
+		total = sum
+	}
+	return total, true
+}
+
+// stakeBound returns an upper bound on the total stake of any future validator
+// set: the genesis stakes plus the stake of every validator ever added. Callers
+// hold vs.mu.
+func (vs *ValidatorSet) stakeBound() []Validator {
+	vals := append([]Validator(nil), vs.genesis...)
+	for _, sc := range vs.changes {
+		if sc.change.Op == ValidatorAdd {
+			vals = append(vals, Validator{PubKey: sc.change.PubKey, Stake: sc.change.addedStake()})
+		}
+	}
+	return vals
+}
+
+// addedStake returns the stake an add change gives its validator.
+func (c *ValidatorChange) addedStake() uint64 {
+	if c.Stake == 0 {
+		return DefaultValidatorStake
+	}
+	return c.Stake
+}
+
+// validateChange checks that tx is a well-formed validator change for inclusion
+// at height, approved by more than two thirds of the validators active there.
+// An add whose stake could take the total stake past math.MaxUint64 is refused,
+// so leader selection can always sum the stakes.
+func (vs *ValidatorSet) validateChange(tx *Transaction, height uint64) (*ValidatorChange, error) {
+	change := &ValidatorChange{}
+	if err := change.UnmarshalProto(tx.GetPayload()); err != nil {
+		return nil, fmt.Errorf("%w: validator change: %v", ErrMalformedTx, err)
+	}
+	if change.Op != ValidatorAdd && change.Op != ValidatorRemove {
+		return nil, fmt.Errorf("%w: unknown validator op %d", ErrMalformedTx, change.Op)
+	}
+	if len(change.PubKey) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("%w: validator key must be %d bytes, got %d", ErrMalformedTx, ed25519.PublicKeySize, len(change.PubKey))
+	}
+	if change.ActivationHeight < height+MinValidatorChangeDelay {
+		return nil, fmt.Errorf("%w: validator change activates at %d, must be at least %d", ErrMalformedTx, change.ActivationHeight, height+MinValidatorChangeDelay)
+	}
+
+	vs.mu.RLock()
+	active := vs.activeAt(height)
+	bound := vs.stakeBound()
+	vs.mu.RUnlock()
+	if change.Op == ValidatorAdd {
+		bound = append(bound, Validator{PubKey: change.PubKey, Stake: change.addedStake()})
+		if _, ok := totalStake(bound); !ok {
+			return nil, fmt.Errorf("%w: validator stake %d would overflow the total stake", ErrMalformedTx, change.Stake)
+		}
+	}
+	isActive := make(map[string]bool, len(active))
+	for _, v := range active {
+		isActive[string(v.PubKey)] = true
+	}
+
+	msg := change.SigningBytes(tx.GetChainId())
+	approved := make(map[string]bool)
+	for _, a := range change.Approvals {
+		if !isActive[string(a.PubKey)] || approved[string(a.PubKey)] {
+			continue // Not a current validator, or a duplicate approval
+		}
+		if !ed25519.Verify(ed25519.PublicKey(a.PubKey), msg, a.Signature) {
+			return nil, fmt.Errorf("%w: approval from validator %x", ErrBadSignature, a.PubKey)
+		}
+		approved[string(a.PubKey)] = true
+	}
+	if 3*len(approved) <= 2*len(active) {
+		return nil, fmt.Errorf("%w: validator change has %d of %d approvals, needs a two-thirds supermajority", ErrNotAuthorized, len(approved), len(active))
+	}
+	return change, nil
+}
+
+// validateBlock checks every validator change in blk against the set at blk's height.
+func (vs *ValidatorSet) validateBlock(blk *Block) error {
+	for _, tx := range blk.Transactions {
+		if tx.GetKind() != TxKindValidatorChange {
+			continue
+		}
+		if _, err := vs.validateChange(tx, blk.Header.Height); err != nil {
+			return fmt.Errorf("block %d: %w", blk.Header.Height, err)
+		}
+	}
+	return nil
+}
+
+// applyBlock schedules blk's validator changes. blk must already be validated.
+func (vs *ValidatorSet) applyBlock(blk *Block) {
+	vs.mu.Lock()
+	defer vs.mu.Unlock()
+	for i, tx := range blk.Transactions {
+		if tx.GetKind() != TxKindValidatorChange {
+			continue
+		}
+		change := &ValidatorChange{}
+		change.UnmarshalProto(tx.GetPayload())
+		vs.changes = append(vs.changes, scheduledChange{change: change, includedAt: blk.Header.Height, index: i})
+	}
+	sort.SliceStable(vs.changes, func(i, j int) bool {
+		a, b := vs.changes[i], vs.changes[j]
+		if a.change.ActivationHeight != b.change.ActivationHeight {
+			return a.change.ActivationHeight < b.change.ActivationHeight
+		}
+		if a.includedAt != b.includedAt {
+			return a.includedAt < b.includedAt
+		}
+		return a.index < b.index
+	})
+}
+
+// revertBlock unschedules the validator changes a previously applied blk carried.
+func (vs *ValidatorSet) revertBlock(blk *Block) {
+	vs.mu.Lock()
+	defer vs.mu.Unlock()
+	kept := vs.changes[:0]
+	for _, sc := range vs.changes {
+		if sc.includedAt != blk.Header.Height {
+			kept = append(kept, sc)
+		}
+	}
+	vs.changes = kept
+}
+
+// reset drops every scheduled change, leaving only the genesis validators.
+func (vs *ValidatorSet) reset() {
+	vs.mu.Lock()
+	defer vs.mu.Unlock()
+	vs.changes = nil
+}
+
+// --- Validator Listing ---
+
+// ValidatorInfo is one entry of the /validators response.
+type ValidatorInfo struct {
+	PubKey    string `json:"pub_key"` // Hex Ed25519 key
+	NodeID    string `json:"node_id"`
+	Stake     uint64 `json:"stake"`
+	Reachable bool   `json:"reachable"`      // The validator is this node or a connected peer
+	Peer      string `json:"peer,omitempty"` // Address of that peer
+}
+
+// validatorPeers maps the hex identity key of every connected peer to its
+// address. Keys are proven in the handshake, so a peer cannot claim another
+// node's validator key.
+func (n *P2PNode) validatorPeers() map[string]string {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	peers := make(map[string]string)
+	for addr, ps := range n.peers {
+		if ps.state == PeerConnected && len(ps.identityKey) > 0 {
+			peers[hex.EncodeToString(ps.identityKey)] = addr
+		}
+	}
+	return peers
+}
+
+// ListValidators handles GET /validators, listing the validators active at the
+// best chain's tip, from genesis and governance changes already activated,
+// sorted by public key, and whether this node can currently reach each one.
+func ListValidators(node *P2PNode, w http.ResponseWriter, r *http.Request) {
+	height := node.Chain.Height()
+	peers := node.validatorPeers()
+	self := node.PublicKey()
+	validators := []ValidatorInfo{}
+	active := node.Chain.Validators.ValidatorsAt(height)
+	total, _ := totalStake(active) // Saturates, though validateChange keeps it from overflowing
+	for _, v := range active {
+		info := ValidatorInfo{
+			PubKey: hex.EncodeToString(v.PubKey),
+			NodeID: NodeIDFor(v.PubKey),
+			Stake:  v.Stake,
+		}
+		info.Peer, info.Reachable = peers[info.PubKey]
+		if bytes.Equal(v.PubKey, self) {
+			info.Reachable = true
+		}
+		validators = append(validators, info)
+	}
+	w.Header().Set("Content-Type", contentTypeJSON)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"height":      height,
+		"total_stake": total,
+		"validators":  validators,
+	})
+}
+
+// checkTxKind rejects transactions of an unknown kind, and governance or election
+// transactions that would not be valid in the next block, before they enter the mempool.
+func (n *P2PNode) checkTxKind(tx *Transaction) error {
+	switch tx.GetKind() {
+	case TxKindVote, TxKindRegisterCandidate, TxKindVoteCommit:
+		if err := n.Chain.checkElectionTx(tx, nil); err != nil {
+			return err
+		}
+		if err := n.Chain.checkElectionWindow(tx, n.Chain.now()); err != nil {
+			return err
+		}
+		return n.Chain.checkCommitReveal(tx, n.Chain.Height()+1, nil)
+	case TxKindValidatorChange:
+		_, err := n.Chain.Validators.validateChange(tx, n.Chain.Height()+1)
+		return err
+	default:
+		return fmt.Errorf("%w: %d", ErrUnknownTxKind, tx.GetKind())
+	}
+}
+
+// --- Block Production ---
+
+// NodeMode is the role a node plays in the network.
+type NodeMode int
+
+const (
+	ModeValidator NodeMode = iota // Proposes blocks when it is the leader
+	ModeFull                      // Validates, relays and serves, but never proposes
+	ModeArchive                   // Only syncs and serves RPC/HTTP queries, e.g. for explorers
+)
+
+func (m NodeMode) String() string {
+	switch m {
+	case ModeValidator:
+		return "validator"
+	case ModeFull:
+		return "full"
+	case ModeArchive:
+		return "archive"
+	default:
+		return fmt.Sprintf("NodeMode(%d)", int(m))
+	}
+}
+
+// DefaultBlockInterval matches the 3-second finality target shown on /status.
+const DefaultBlockInterval = 3 * time.Second
+
+// DefaultRoundTimeout is how long a node waits for the leader of a round to
+// produce the next block before moving to the next round and its leader. It
+// spans several block intervals so a leader is not skipped for one slow tick.
+const DefaultRoundTimeout = 10 * time.Second
+
+// roundTimeouts counts rounds abandoned because their leader produced no block.
+var roundTimeouts = expvar.NewInt("consensus_round_timeouts")
+
+// Round returns the proposal round this node is in for height, advancing one
+// round per RoundTimeout that passes without the chain growing past height-1.
+// The round restarts at 0 whenever a new height is reached.
+func (n *P2PNode) Round(height uint64) uint32 {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	now := n.Chain.now()
+	if height != n.roundHeight {
+		n.roundHeight, n.round, n.roundStarted = height, 0, now
+		return 0
+	}
+	if n.RoundTimeout > 0 {
+		for now.Sub(n.roundStarted) >= n.RoundTimeout {
+			n.round++
+			n.roundStarted = n.roundStarted.Add(n.RoundTimeout)
+			roundTimeouts.Add(1)
+			log.Printf("No block at height %d; advancing to round %d", height, n.round)
+		}
+	}
+	return n.round
+}
+
+// ProposeBlock builds, appends and broadcasts the next block from the mempool
+// when this node is a validator, the network has launched, the node has at
+// least MinPeersToPropose peers and it is the leader for the current round of
+// the next height. It returns nil, doing nothing, in every other case,
+// including Full and Archive modes.
+//
+// A leader that comes back after its round timed out may still propose,
+// forking the chain for a height; the heavier branch wins as for any fork.
+func (n *P2PNode) ProposeBlock() *Block {
+	if n.Mode != ModeValidator {
+		return nil
+	}
+	if n.Chain.now().Before(n.Chain.LaunchTime()) {
+		return nil // Sync and gossip continue, but the network has not launched
+	}
+	if n.waitForPeers() {
+		return nil // A block nobody witnesses risks a fork
+	}
+	tip := n.Chain.Tip()
+	height := tip.Header.Height + 1
+	round := n.Round(height)
+	if !bytes.Equal(n.Chain.Validators.LeaderForRound(height, round, tip.Header.Hash), n.PublicKey()) {
+		return nil
+	}
+
+	blk := n.Chain.ProposeBlock(n.PublicKey(), n.Mempool.Pending())
+	if err := n.Chain.AppendBlock(blk); err != nil {
+		// A pending transaction may have become invalid since it was proposed,
+		// e.g. a synced block landed another vote from its voter. Evicting it
+		// stops every later proposal failing the same way.
+		log.Printf("Failed to append own block %d: %v", height, err)
+		n.evictRejected(blk.Transactions)
+		return nil
+	}
+	n.seenBlocks.Add(blk.Header.Hash) // Ignore our own block when peers relay it back
+	n.BroadcastBlock(blk)
+	log.Printf("Proposed block %d in round %d with %d transactions", height, round, len(blk.Transactions))
+	return blk
+}
+
+// evictRejected drops from the mempool and WAL each of txs that the chain no
+// longer accepts.
+func (n *P2PNode) evictRejected(txs []*Transaction) {
+	var rejected []*Transaction
+	for _, tx := range txs {
+		if n.Chain.rejectsTx(tx) {
+			rejected = append(rejected, tx)
+		}
+	}
+	if evicted := n.Mempool.Remove(rejected); len(evicted) > 0 {
+		log.Printf("Evicted %d pending transactions the chain now rejects", len(evicted))
+		n.pruneTxWAL()
+	}
+}
+
+// waitForPeers reports whether the node has fewer than MinPeersToPropose
+// connected peers, logging when it starts and stops waiting for more.
+func (n *P2PNode) waitForPeers() bool {
+	n.mu.Lock()
+	peers := len(n.connectedPeers())
+	waiting := peers < n.MinPeersToPropose
+	changed := waiting != n.waitingForPeers
+	n.waitingForPeers = waiting
+	n.mu.Unlock()
+
+	if changed && waiting {
+		log.Printf("Connected to %d of %d peers needed to propose; waiting for more", peers, n.MinPeersToPropose)
+	} else if changed {
+		log.Printf("Connected to %d peers; resuming block proposals", peers)
+	}
+	return waiting
+}
+
+// ProduceBlocks calls ProposeBlock every BlockInterval until the node closes.
+// Non-validator nodes return immediately. This method should be run in a
+// goroutine.
+func (n *P2PNode) ProduceBlocks() {
+	if n.Mode != ModeValidator {
+		log.Printf("Node %s is in %s mode and will not propose blocks", n, n.Mode)
+		return
+	}
+	if launch := n.Chain.LaunchTime(); n.Chain.now().Before(launch) {
+		log.Printf("Network launches at %s; not proposing blocks until then", launch.UTC().Format(time.RFC3339))
+	}
+	ticker := time.NewTicker(n.BlockInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			n.ProposeBlock()
+		case <-n.closing.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,426 @@
+// go_backend_validators_snippet_test.go
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+func TestValidatorChangeOmitsZeroStake(t *testing.T) {
+	key := bytes.Repeat([]byte{7}, 32)
+	change := &ValidatorChange{Op: ValidatorAdd, PubKey: key, ActivationHeight: 20}
+	legacy := fmt.Sprintf("validator-change|%s|%d|%x|%d", DefaultChainID, ValidatorAdd, key, 20)
+	if got := string(change.SigningBytes(DefaultChainID)); got != legacy {
+		t.Errorf("signing bytes %q, want %q", got, legacy)
+	}
+	b := change.MarshalProto()
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			t.Fatal(protowire.ParseError(n))
+		}
+		if num == 5 {
+			t.Fatal("zero stake encoded as field 5")
+		}
+		m := protowire.ConsumeFieldValue(num, typ, b[n:])
+		if m < 0 {
+			t.Fatal(protowire.ParseError(m))
+		}
+		b = b[n+m:]
+	}
+
+	change.Stake = 4
+	if got := string(change.SigningBytes(DefaultChainID)); got != legacy+"|4" {
+		t.Errorf("signing bytes %q, want the stake appended", got)
+	}
+	decoded := &ValidatorChange{}
+	if err := decoded.UnmarshalProto(change.MarshalProto()); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Stake != 4 {
+		t.Errorf("decoded stake %d, want 4", decoded.Stake)
+	}
+}
+
+// validatorChangeTx returns a transaction carrying change, approved by each of approvers.
+func validatorChangeTx(change *ValidatorChange, approvers ...ed25519.PrivateKey) *Transaction {
+	for _, priv := range approvers {
+		change.Approvals = append(change.Approvals, &ValidatorApproval{
+			PubKey:    priv.Public().(ed25519.PublicKey),
+			Signature: ed25519.Sign(priv, change.SigningBytes(DefaultChainID)),
+		})
+	}
+	tx := &Transaction{Kind: TxKindValidatorChange, ChainId: DefaultChainID, Payload: change.MarshalProto()}
+	tx.Hash = testHash(tx.SigningBytes())
+	return tx
+}
+
+// threeValidatorChain returns a chain whose genesis names three fresh
+// validators of stake 1, and their keys.
+func threeValidatorChain(t *testing.T) (*Chain, []ed25519.PrivateKey) {
+	t.Helper()
+	cfg := DefaultGenesisConfig()
+	cfg.InitialValidators, cfg.InitialStakes = nil, nil
+	var privs []ed25519.PrivateKey
+	for i := 0; i < 3; i++ {
+		pub, priv, err := ed25519.GenerateKey(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cfg.InitialValidators = append(cfg.InitialValidators, pub)
+		cfg.InitialStakes = append(cfg.InitialStakes, 1)
+		privs = append(privs, priv)
+	}
+	c, err := NewChain(GenesisBlock(cfg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c, privs
+}
+
+func TestAddedValidatorLeadsAfterActivation(t *testing.T) {
+	c, privs := threeValidatorChain(t)
+	added, _, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	activation := uint64(1 + MinValidatorChangeDelay)
+	change := func() *ValidatorChange {
+		return &ValidatorChange{Op: ValidatorAdd, PubKey: added, ActivationHeight: activation, Stake: 1000}
+	}
+
+	// Two of three approvals is not a supermajority
+	if err := c.AppendBlock(testBlock(c.Tip(), validatorChangeTx(change(), privs[:2]...))); !errors.Is(err, ErrNotAuthorized) {
+		t.Fatalf("change approved by two of three: got %v, want ErrNotAuthorized", err)
+	}
+	if err := c.AppendBlock(testBlock(c.Tip(), validatorChangeTx(change(), privs...))); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := len(c.Validators.ActiveAt(activation - 1)); got != 3 {
+		t.Errorf("%d validators before activation, want 3", got)
+	}
+	if got := c.Validators.ActiveAt(activation); len(got) != 4 || !slices.ContainsFunc(got, func(k []byte) bool { return bytes.Equal(k, added) }) {
+		t.Errorf("validators at activation %x, want the added one among four", got)
+	}
+	var before, after int
+	for i := 0; i < 200; i++ {
+		prev := testHash([]byte(fmt.Sprint(i)))
+		if bytes.Equal(c.Validators.LeaderForHeight(activation-1, prev), added) {
+			before++
+		}
+		if bytes.Equal(c.Validators.LeaderForHeight(activation, prev), added) {
+			after++
+		}
+	}
+	if before != 0 {
+		t.Errorf("added validator led %d times before activation", before)
+	}
+	if after < 190 {
+		t.Errorf("added validator with 1000 of 1003 stake led %d of 200 times after activation", after)
+	}
+}
+
+func TestValidatorsEndpointFollowsActiveSet(t *testing.T) {
+	c, privs := threeValidatorChain(t)
+	node := newTestNode(t)
+	node.UseIdentityKey(privs[0])
+	node.UseChain(c)
+	node.peers["10.0.0.2:9000"] = &peerState{state: PeerConnected, identityKey: privs[1].Public().(ed25519.PublicKey)}
+	list := func() (uint64, []ValidatorInfo) {
+		t.Helper()
+		rr := httptest.NewRecorder()
+		ListValidators(node, rr, httptest.NewRequest(http.MethodGet, "/validators", nil))
+		var resp struct {
+			TotalStake uint64          `json:"total_stake"`
+			Validators []ValidatorInfo `json:"validators"`
+		}
+		if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		return resp.TotalStake, resp.Validators
+	}
+	keys := func(validators []ValidatorInfo) []string {
+		var out []string
+		for _, v := range validators {
+			out = append(out, v.PubKey)
+		}
+		return out
+	}
+	active := func() []string {
+		var out []string
+		for _, key := range c.Validators.ActiveAt(c.Height()) {
+			out = append(out, hex.EncodeToString(key))
+		}
+		return out
+	}
+
+	total, validators := list()
+	if total != 3 || !slices.Equal(keys(validators), active()) {
+		t.Fatalf("validators %+v with stake %d, want the three from genesis", validators, total)
+	}
+	for _, v := range validators {
+		pub, _ := hex.DecodeString(v.PubKey)
+		self, peer := bytes.Equal(pub, node.PublicKey()), bytes.Equal(pub, privs[1].Public().(ed25519.PublicKey))
+		if v.NodeID != NodeIDFor(pub) || v.Reachable != (self || peer) || (v.Peer != "") != peer {
+			t.Errorf("validator %+v: want node ID %s, reachable %v, peer %v", v, NodeIDFor(pub), self || peer, peer)
+		}
+	}
+
+	added, _, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	activation := uint64(1 + MinValidatorChangeDelay)
+	change := &ValidatorChange{Op: ValidatorAdd, PubKey: added, ActivationHeight: activation, Stake: 5}
+	if err := c.AppendBlock(testBlock(c.Tip(), validatorChangeTx(change, privs...))); err != nil {
+		t.Fatal(err)
+	}
+	for c.Height() < activation-1 {
+		if err := c.AppendBlock(testBlock(c.Tip())); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if total, validators := list(); total != 3 || len(validators) != 3 {
+		t.Errorf("%d validators with stake %d before activation, want the three from genesis", len(validators), total)
+	}
+	if err := c.AppendBlock(testBlock(c.Tip())); err != nil {
+		t.Fatal(err)
+	}
+	total, validators = list()
+	if total != 8 || !slices.Equal(keys(validators), active()) || !slices.Contains(keys(validators), hex.EncodeToString(added)) {
+		t.Errorf("validators %+v with stake %d after activation, want the added one among four", validators, total)
+	}
+}
+
+func TestValidatorStakeOverflowRejected(t *testing.T) {
+	vs := NewValidatorSet([]Validator{{PubKey: bytes.Repeat([]byte{1}, 32), Stake: math.MaxUint64 - 1}})
+	change := &ValidatorChange{Op: ValidatorAdd, PubKey: bytes.Repeat([]byte{2}, 32), ActivationHeight: MinValidatorChangeDelay, Stake: 2}
+	tx := &Transaction{Kind: TxKindValidatorChange, ChainId: DefaultChainID, Payload: change.MarshalProto()}
+	_, err := vs.validateChange(tx, 0)
+	if !errors.Is(err, ErrMalformedTx) || !strings.Contains(err.Error(), "overflow") {
+		t.Fatalf("validateChange: got %v, want a stake overflow", err)
+	}
+
+	cfg := DefaultGenesisConfig()
+	cfg.InitialValidators = [][]byte{bytes.Repeat([]byte{1}, 32), bytes.Repeat([]byte{2}, 32)}
+	cfg.InitialStakes = []uint64{math.MaxUint64, 1}
+	if _, err := ParseGenesisState(GenesisBlock(cfg)); !errors.Is(err, ErrMalformedTx) {
+		t.Fatalf("ParseGenesisState: got %v, want ErrMalformedTx", err)
+	}
+}
+
+func TestLeaderFollowsStake(t *testing.T) {
+	light, heavy := bytes.Repeat([]byte{1}, 32), bytes.Repeat([]byte{2}, 32)
+	vs := NewValidatorSet([]Validator{{PubKey: light, Stake: 1}, {PubKey: heavy, Stake: 3}})
+	const rounds = 4000
+	var heavyTurns int
+	for h := uint64(1); h <= rounds; h++ {
+		prev := testHash([]byte(fmt.Sprint(h)))
+		leader := vs.LeaderForHeight(h, prev)
+		if !bytes.Equal(leader, vs.LeaderForHeight(h, prev)) {
+			t.Fatalf("leader for height %d is not deterministic", h)
+		}
+		if bytes.Equal(leader, heavy) {
+			heavyTurns++
+		}
+		if next := vs.LeaderForRound(h, 1, prev); bytes.Equal(next, leader) {
+			t.Fatalf("round 1 of height %d kept the round 0 leader", h)
+		}
+	}
+	if share := float64(heavyTurns) / rounds; share < 0.7 || share > 0.8 {
+		t.Errorf("validator with 3/4 of the stake led %.2f of heights", share)
+	}
+}
+
+// soleValidator returns a node that is the only validator, and so the leader
+// of every height, on a chain launching at launch.
+func soleValidator(t *testing.T, launch time.Time) *P2PNode {
+	t.Helper()
+	node := newTestNode(t)
+	cfg := DefaultGenesisConfig()
+	cfg.InitialValidators, cfg.InitialStakes = [][]byte{node.PublicKey()}, []uint64{1}
+	cfg.LaunchTime = launch
+	c, err := NewChain(GenesisBlock(cfg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	node.UseChain(c)
+	node.Mode, node.MinPeersToPropose = ModeValidator, 0
+	return node
+}
+
+func TestOnlyValidatorsPropose(t *testing.T) {
+	for _, mode := range []NodeMode{ModeFull, ModeArchive} {
+		node := soleValidator(t, time.Time{})
+		node.Mode = mode
+		if blk := node.ProposeBlock(); blk != nil || node.Chain.Height() != 0 {
+			t.Errorf("%s node proposed block %d as the leader", mode, node.Chain.Height())
+		}
+		done := make(chan struct{})
+		go func() {
+			node.ProduceBlocks()
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatalf("%s node kept a block production loop running", mode)
+		}
+	}
+
+	node := soleValidator(t, time.Time{})
+	if blk := node.ProposeBlock(); blk == nil || node.Chain.Height() != 1 {
+		t.Error("validator did not propose as the leader")
+	}
+}
+
+func TestNoProposalsBeforeLaunch(t *testing.T) {
+	launch := time.Unix(1_700_000_000, 0)
+	node := soleValidator(t, launch)
+	now := launch.Add(-time.Hour)
+	node.Chain.Clock = func() time.Time { return now }
+
+	for _, at := range []time.Time{launch.Add(-time.Hour), launch.Add(-time.Second)} {
+		now = at
+		if blk := node.ProposeBlock(); blk != nil || node.Chain.Height() != 0 {
+			t.Fatalf("leader proposed block %d %s before launch", node.Chain.Height(), launch.Sub(at))
+		}
+	}
+	now = launch.Add(time.Second)
+	blk := node.ProposeBlock()
+	if blk == nil || node.Chain.Height() != 1 {
+		t.Fatal("leader did not propose once the network launched")
+	}
+	if ts := time.Unix(int64(blk.Header.Timestamp), 0); ts.Before(launch) {
+		t.Errorf("first block timestamped %s, before launch at %s", ts, launch)
+	}
+}
+
+func TestProposingWaitsForMinPeers(t *testing.T) {
+	node := soleValidator(t, time.Time{})
+	node.MinPeersToPropose = 2
+	node.Transport = fixedTransport{client: &mockNodeServiceClient{}}
+	waiting := func() bool {
+		rr := httptest.NewRecorder()
+		GetNodeInfo(node, rr, httptest.NewRequest(http.MethodGet, "/nodeinfo", nil))
+		var info NodeInfo
+		if err := json.Unmarshal(rr.Body.Bytes(), &info); err != nil {
+			t.Fatal(err)
+		}
+		return info.WaitingForPeers
+	}
+
+	for peers := 0; peers < node.MinPeersToPropose; peers++ {
+		if peers > 0 {
+			if err := node.ConnectToPeer(context.Background(), fmt.Sprintf("peer-%d:9000", peers)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if blk := node.ProposeBlock(); blk != nil || node.Chain.Height() != 0 {
+			t.Fatalf("leader proposed with %d of %d peers", peers, node.MinPeersToPropose)
+		}
+		if !waiting() {
+			t.Errorf("/nodeinfo does not report waiting for peers with %d of %d", peers, node.MinPeersToPropose)
+		}
+	}
+	if err := node.ConnectToPeer(context.Background(), "peer-2:9000"); err != nil {
+		t.Fatal(err)
+	}
+	if blk := node.ProposeBlock(); blk == nil || node.Chain.Height() != 1 {
+		t.Fatal("leader did not propose once enough peers connected")
+	}
+	if waiting() {
+		t.Error("/nodeinfo still reports waiting for peers")
+	}
+}
+
+func TestRoundAdvancesPastSilentLeader(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	c := startCluster(t, ctx, 3)
+	nodeFor := func(key []byte) *P2PNode {
+		for _, node := range c.Nodes {
+			if bytes.Equal(node.PublicKey(), key) {
+				return node
+			}
+		}
+		t.Fatalf("no node has key %x", key)
+		return nil
+	}
+	prevHash, validators := c.Nodes[0].Chain.Tip().Header.Hash, c.Nodes[0].Chain.Validators
+	silent := nodeFor(validators.LeaderForRound(1, 0, prevHash))
+	round := uint32(1)
+	for bytes.Equal(validators.LeaderForRound(1, round, prevHash), silent.PublicKey()) {
+		round++
+	}
+	next := nodeFor(validators.LeaderForRound(1, round, prevHash))
+	for _, node := range c.Nodes {
+		node.RoundTimeout = ClusterBlockInterval
+		node.Round(1) // Every node starts round 0 at the same clock reading
+	}
+
+	// The round-0 leader never proposes
+	if blk := next.ProposeBlock(); blk != nil {
+		t.Fatal("proposed before the silent leader's round timed out")
+	}
+	c.Clock.Advance(time.Duration(round) * ClusterBlockInterval)
+	blk := next.ProposeBlock()
+	if blk == nil {
+		t.Fatalf("round %d leader did not propose after the timeout", round)
+	}
+	if !bytes.Equal(blk.Header.Proposer, next.PublicKey()) {
+		t.Errorf("block proposed by %x, want the round %d leader %x", blk.Header.Proposer, round, next.PublicKey())
+	}
+	if err := c.WaitForHeight(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Converged(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestEvictRejectedDropsFromMempoolAndWAL(t *testing.T) {
+	node := newTestNode(t)
+	node.WAL = openTestWAL(t, filepath.Join(t.TempDir(), "tx.wal"))
+	stale, fresh := testVote("e", "a", 1), testVote("e", "a", 2)
+	for _, tx := range []*Transaction{stale, fresh} {
+		if err := node.Mempool.Add(tx); err != nil {
+			t.Fatal(err)
+		}
+		if err := node.WAL.Append(tx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// A peer's block lands another ballot from stale's voter
+	if err := node.Chain.AppendBlock(testBlock(node.Chain.Tip(), testVote("e", "b", 1))); err != nil {
+		t.Fatal(err)
+	}
+
+	node.evictRejected([]*Transaction{stale, fresh})
+	if _, ok := node.Mempool.Get(stale.Hash); ok {
+		t.Error("rejected transaction left in the mempool")
+	}
+	if _, ok := node.Mempool.Get(fresh.Hash); !ok {
+		t.Error("valid transaction evicted from the mempool")
+	}
+	if pending := node.WAL.Pending(); len(pending) != 1 || !bytes.Equal(pending[0].Hash, fresh.Hash) {
+		t.Errorf("WAL holds %d transactions, want only the valid one", len(pending))
+	}
+}
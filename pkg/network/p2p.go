@@ -1,46 +1,150 @@
-
-
 package network
 
 import (
+	"bytes"
 	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha3"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"expvar"
 	"fmt"
+	"io"
 	"log"
+	"math"
+	mrand "math/rand"
 	"net"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
 	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials/insecure" // For simplicity, use insecure for now
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure" // Only used when AllowInsecure is set
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
 	// pb "your_project/proto" // In a real project, this would be your generated gRPC proto package
 )
 
 // --- Mock gRPC Protobuf Definitions (replace with actual generated code) ---
 // These structs mimic the generated gRPC types for demonstration.
 type Transaction struct {
-	Hash      []byte
-	Sender    []byte
-	Recipient []byte
-	Amount    uint64
-	Signature []byte
+	Hash       []byte
+	Sender     []byte
+	Recipient  []byte
+	Amount     uint64
+	Timestamp  uint64 // Unix seconds, covered by the signature to bound replay
+	ChainId    string // Network the transaction is valid on, covered by the signature
+	Kind       TxKind // What the transaction does; the zero value is a vote
+	Payload    []byte // Kind-specific data, e.g. an encoded ValidatorChange
+	Signature  []byte
+	SigScheme  SigScheme  // How Sender and Signature are encoded; the zero value is Ed25519
+	BallotType BallotType // For votes: valid, abstain or spoiled; the zero value is a vote for Recipient
+	Fee        uint64     // Paid to the block proposer, or burned, per the genesis fee policy
+	Nonce      []byte     // For votes revealed under commit-reveal: the nonce their commitment was made with
+	Version    uint32     // Wire format version; 0 predates versioning and is read as version 1
+}
+
+// TxKind distinguishes votes from governance transactions.
+type TxKind uint32
+
+const (
+	TxKindVote              TxKind = 0 // Recipient is the candidate ID, Payload the election ID
+	TxKindValidatorChange   TxKind = 1 // Payload is a ValidatorChange
+	TxKindRegisterCandidate TxKind = 2 // Payload is a CandidateRegistration
+	TxKindVoteCommit        TxKind = 4 // Recipient is a vote commitment, Payload the election ID
+)
+
+// SigScheme identifies the signature algorithm of a transaction's sender key.
+// The scheme is not covered by the signature: verifying under the wrong scheme
+// fails anyway, since the key and signature encodings differ.
+type SigScheme uint32
+
+const (
+	SigSchemeEd25519   SigScheme = 0
+	SigSchemeSecp256k1 SigScheme = 1 // ECDSA over SHA-256, for hardware-wallet compatibility
+)
+
+// BallotType distinguishes a vote for a candidate from an abstention or a
+// spoiled ballot, which count towards turnout but not towards any candidate.
+type BallotType uint32
+
+const (
+	BallotValid   BallotType = 0 // Recipient is the candidate voted for
+	BallotAbstain BallotType = 1 // Voter took part but chose no candidate; Recipient is empty
+	BallotSpoiled BallotType = 2 // Ballot was cast but is unusable, e.g. marked for several candidates; Recipient is empty
+)
+
+// SigningBytes returns the message the sender signs: every field except Hash,
+// Signature and SigScheme. Including ChainId stops a transaction signed for one
+// network being replayed on another. BallotType, Fee, Nonce and Version are
+// appended only when set, so the signing bytes of ordinary votes are unchanged.
+func (tx *Transaction) SigningBytes() []byte {
+	msg := fmt.Sprintf("%s|%d|%x|%x|%d|%d|%x", tx.ChainId, tx.Kind, tx.Sender, tx.Recipient, tx.Amount, tx.Timestamp, tx.Payload)
+	if tx.BallotType != BallotValid {
+		msg += fmt.Sprintf("|%d", tx.BallotType)
+	}
+	if tx.Fee != 0 {
+		msg += fmt.Sprintf("|fee=%d", tx.Fee)
+	}
+	if len(tx.Nonce) > 0 {
+		msg += fmt.Sprintf("|nonce=%x", tx.Nonce)
+	}
+	if tx.Version != 0 {
+		msg += fmt.Sprintf("|v=%d", tx.Version)
+	}
+	return []byte(msg)
 }
 
 type BlockHeader struct {
+	Hash          []byte // sha3-256 of the other header fields
 	Version       uint32
 	PrevBlockHash []byte
 	MerkleRoot    []byte
 	Timestamp     uint64
 	Height        uint64
+	ChainId       string
 }
 
 type Block struct {
-	Header *BlockHeader
+	Header       *BlockHeader
 	Transactions []*Transaction
 }
 
+type HandshakeRequest struct {
+	ChainId         string
+	Addr            string
+	Timestamp       int64  // Sender's clock in Unix milliseconds, used to measure skew
+	ProtocolVersion string // See ProtocolVersion; empty from nodes that predate it
+	NodeId          string // See P2PNode.NodeID; informational, for logs
+}
+type HandshakeResponse struct {
+	ChainId         string
+	Timestamp       int64 // Responder's clock in Unix milliseconds
+	ProtocolVersion string
+	NodeId          string
+}
+
 type GetKnownPeersRequest struct{}
 type GetKnownPeersResponse struct {
-	PeerAddresses []string
+	PeerAddresses []string       // Addresses of Peers, kept for older clients
+	Peers         []*PeerAddress // Healthy peers with their last-contact time, freshest first
+}
+
+type PeerAddress struct {
+	Addr     string
+	LastSeen uint64 // Unix seconds of the last successful contact
 }
 
 type SendTransactionRequest struct {
@@ -48,6 +152,20 @@ type SendTransactionRequest struct {
 }
 type SendTransactionResponse struct {
 	Success bool
+	Receipt *TxReceipt // Signed proof of acceptance, set when Success is true
+}
+
+// TxReceipt is a node's signed statement that it accepted a transaction.
+type TxReceipt struct {
+	TxHash     []byte `json:"tx_hash"`
+	NodePubKey []byte `json:"node_pub_key"` // Ed25519 identity key of the accepting node
+	Timestamp  uint64 `json:"timestamp"`    // Unix seconds when the node accepted the transaction
+	Signature  []byte `json:"signature"`
+}
+
+// SigningBytes returns the message the accepting node signs.
+func (r *TxReceipt) SigningBytes() []byte {
+	return []byte(fmt.Sprintf("receipt|%x|%x|%d", r.TxHash, r.NodePubKey, r.Timestamp))
 }
 
 type SendBlockRequest struct {
@@ -59,6 +177,7 @@ type SendBlockResponse struct {
 
 // NodeServiceServer interface (mimics generated gRPC server interface)
 type NodeServiceServer interface {
+	Handshake(context.Context, *HandshakeRequest) (*HandshakeResponse, error)
 	GetKnownPeers(context.Context, *GetKnownPeersRequest) (*GetKnownPeersResponse, error)
 	SendTransaction(context.Context, *SendTransactionRequest) (*SendTransactionResponse, error)
 	SendBlock(context.Context, *SendBlockRequest) (*SendBlockResponse, error)
@@ -66,6 +185,7 @@ type NodeServiceServer interface {
 
 // NodeServiceClient interface (mimics generated gRPC client interface)
 type NodeServiceClient interface {
+	Handshake(ctx context.Context, in *HandshakeRequest, opts ...grpc.CallOption) (*HandshakeResponse, error)
 	GetKnownPeers(ctx context.Context, in *GetKnownPeersRequest, opts ...grpc.CallOption) (*GetKnownPeersResponse, error)
 	SendTransaction(ctx context.Context, in *SendTransactionRequest, opts ...grpc.CallOption) (*SendTransactionResponse, error)
 	SendBlock(ctx context.Context, in *SendBlockRequest, opts ...grpc.CallOption) (*SendBlockResponse, error)
@@ -73,106 +193,747 @@ type NodeServiceClient interface {
 
 // --- End Mock gRPC Protobuf Definitions ---
 
+// Default replay window for incoming transactions
+const (
+	DefaultMaxTxAge       = 10 * time.Minute // Reject transactions signed longer ago than this
+	DefaultMaxTxClockSkew = 30 * time.Second // Tolerate senders whose clocks run slightly ahead
+)
+
+// DefaultMaxPeerClockSkew is how far a peer's clock may drift from ours before
+// we warn. Larger skew makes a node reject valid blocks and tokens as too-future.
+const DefaultMaxPeerClockSkew = 5 * time.Second
+
+// ProtocolVersion is the "major.minor" wire protocol version sent in the
+// handshake. Peers must share the major version; a minor bump only adds
+// fields older nodes ignore.
+const ProtocolVersion = "1.0"
+
+// checkProtocolVersion returns an error unless version shares our major
+// version. Peers that predate versioning send none and are treated as 1.0.
+func checkProtocolVersion(version string) error {
+	if version == "" {
+		version = "1.0"
+	}
+	ours, _, _ := strings.Cut(ProtocolVersion, ".")
+	theirs, _, _ := strings.Cut(version, ".")
+	major, err := strconv.ParseUint(theirs, 10, 32)
+	if err != nil {
+		return fmt.Errorf("malformed protocol version %q", version)
+	}
+	if strconv.FormatUint(major, 10) != ours {
+		return fmt.Errorf("incompatible protocol version %s, expected %s.x", version, ours)
+	}
+	return nil
+}
+
+// Peer exchange (PEX) limits. Only peers contacted within PeerHealthyWindow are
+// shared or dialed, so dead addresses don't circulate through the network.
+const (
+	MaxPeerExchange   = 32
+	PeerHealthyWindow = 10 * time.Minute
+)
+
+// DefaultChainID identifies the network a node belongs to. Testnets and devnets
+// must override it so their nodes never peer with or accept messages from mainnet.
+const DefaultChainID = "naijavote-mainnet"
+
+// DefaultGossipFanout sends each message to sqrt(peers) peers; re-broadcast by
+// receivers carries it the rest of the way.
+const DefaultGossipFanout = 1.0
+
+// DefaultBroadcastQueueLen is how many gossip sends may wait for one peer
+// before the node starts dropping them.
+const DefaultBroadcastQueueLen = 256
+
+// DropPolicy chooses which send is dropped when a peer's outbound queue is
+// full.
+type DropPolicy int
+
+const (
+	DropOldest DropPolicy = iota // Drop the longest-waiting send, favouring fresh messages
+	DropNewest                   // Drop the send being queued, favouring those already waiting
+)
+
+// Gossip sends dropped because a peer's outbound queue was full. The per-peer
+// counts show which peers cannot keep up; an address's entry is removed when
+// the node forgets the address, so the map is bounded by MaxKnownNodes.
+var (
+	broadcastDrops      = expvar.NewMap("p2p_broadcast_drops")
+	broadcastDropsTotal = expvar.NewInt("p2p_broadcast_drops_total")
+)
+
+// Bootstrap backoff: DiscoverPeers retries its seed peers starting at
+// BootstrapInitialBackoff and doubling up to BootstrapMaxBackoff until one connects.
+const (
+	BootstrapInitialBackoff = 500 * time.Millisecond
+	BootstrapMaxBackoff     = 30 * time.Second
+)
+
+// DefaultIsolationRetryInterval is how often an isolated node (zero peers) retries
+// its bootstrap and known peers, much faster than the normal discovery cadence.
+const DefaultIsolationRetryInterval = 5 * time.Second
+
+// DefaultDialTimeout bounds how long ConnectToPeer waits to connect to a peer
+// and complete the handshake.
+const DefaultDialTimeout = 5 * time.Second
+
+// DefaultMaxKnownNodes caps how many peer addresses a node remembers.
+const DefaultMaxKnownNodes = 1000
+
+// Reconnection to saved peers on startup: up to DefaultReconnectWorkers dials
+// run at once, and dialing stops once DefaultTargetPeers peers are connected.
+const (
+	DefaultReconnectWorkers = 4
+	DefaultTargetPeers      = 8
+)
+
+// Inbound RPC limits per remote host. Each request over the limit costs the
+// peer RateLimitPenalty score; a peer whose score falls to BanScore is banned.
+// An inbound peer that is not a known peer address is refused for
+// InboundBanDuration, and its score is forgotten InboundRecordTTL after its
+// last penalty.
+const (
+	DefaultPeerRPCLimit  = 200
+	DefaultPeerRPCWindow = time.Second
+	RateLimitPenalty     = 1
+	BanScore             = -100
+	InboundBanDuration   = time.Hour
+	InboundRecordTTL     = 10 * time.Minute
+)
+
+// isolationEvents counts how many times this node has lost all of its peers.
+var isolationEvents = expvar.NewInt("p2p_isolation_events")
 
 // P2PNode represents a lightweight network node
 type P2PNode struct {
-	Addr        string
-	Peers       map[string]NodeServiceClient // Connected peers' gRPC clients
-	KnownNodes  map[string]bool              // All known peer addresses
-	TxPool      chan *Transaction            // Channel for incoming transactions
-	BlockChan   chan *Block                  // Channel for incoming blocks
-	mu          sync.RWMutex                 // Mutex for protecting shared state
-	grpcServer  *grpc.Server
+	Addr                   string                    // Bind address for the gRPC server
+	AdvertiseAddr          string                    // Address peers use to reach us (e.g. behind NAT); defaults to Addr
+	ChainID                string                    // Network identifier checked in the handshake and on every message
+	TxPool                 chan *Transaction         // Channel for incoming transactions
+	BlockChan              chan *Block               // Channel for incoming blocks
+	MaxTxAge               time.Duration             // Oldest acceptable transaction timestamp
+	MaxTxSkew              time.Duration             // How far in the future a transaction timestamp may be
+	IsolationRetryInterval time.Duration             // Reconnect cadence while the node has no peers
+	DialTimeout            time.Duration             // How long to wait to connect to and handshake with a peer
+	Transport              Transport                 // How the node dials and serves peers; gRPC unless replaced, e.g. by a MemoryNetwork
+	MaxKnownNodes          int                       // Cap on known peer addresses; least recently useful addresses are evicted
+	ReconnectWorkers       int                       // Saved peer addresses dialed at once on startup; 1 dials serially
+	TargetPeers            int                       // Connected peers at which startup stops dialing saved addresses; 0 dials them all
+	PeerLimiter            *RateLimiter              // Inbound RPCs allowed per remote host; excess requests get ResourceExhausted
+	MaxInboundConns        int                       // Inbound peer connections held open at once across all listen addresses; 0 means no limit
+	TLSConfig              *tls.Config               // Transport security for the gRPC server and outbound dials
+	AllowInsecure          bool                      // Explicit opt-in to plaintext gRPC; never enable in production
+	GossipFanout           float64                   // Broadcast to GossipFanout * sqrt(connected peers) peers per message
+	BroadcastQueueLen      int                       // Block or transaction sends queued per peer before dropping; see BroadcastDropPolicy
+	BroadcastDropPolicy    DropPolicy                // Which send a full peer queue drops
+	MaxPeerClockSkew       time.Duration             // Clock difference above which a peer is reported as skewed
+	RefuseSkewedPeers      bool                      // Refuse to peer with skewed nodes instead of only warning
+	mu                     sync.RWMutex              // Mutex for protecting shared state
+	identityKey            ed25519.PrivateKey        // Signs transaction receipts
+	nodeID                 string                    // Derived from identityKey; see NodeID
+	peers                  map[string]*peerState     // Every known peer address and its connection state
+	inbound                map[string]*inboundRecord // Misbehaviour of inbound peers by remotePeer key; see PenalizePeer
+	nextInboundPrune       time.Time                 // When PenalizePeer next drops forgotten inbound records
+	bootstrapPeers         []string                  // Seed peers passed to DiscoverPeers, retried when isolated
+	isolated               bool                      // True after the node lost its last peer, until it reconnects
+	broadcasts             sync.WaitGroup            // In-flight outbound sends, waited on by Close
+	outboxMu               sync.Mutex
+	outboxes               map[string]*peerOutbox // Gossip sends waiting per peer address; see enqueueSend
+	closeOnce              sync.Once
+	closing                context.Context // Cancelled by Close so in-flight dials and retry loops stop
+	stopClosing            context.CancelFunc
+	rngMu                  sync.Mutex  // rand.Rand is not safe for concurrent use
+	rng                    *mrand.Rand // Source for gossip peer sampling; seeded from crypto/rand unless replaced by UseRandSource
 }
 
 // NewP2PNode creates a new P2P network node
 func NewP2PNode(addr string) *P2PNode {
-	return &P2PNode{
-		Addr:       addr,
-		Peers:      make(map[string]NodeServiceClient),
-		KnownNodes: make(map[string]bool),
-		TxPool:     make(chan *Transaction, 1000), // Buffered channel for transactions
-		BlockChan:  make(chan *Block, 100),        // Buffered channel for blocks
+	// Replaced via UseIdentityKey by deployments that keep a key across restarts
+	_, identityKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		log.Fatalf("failed to generate node identity key: %v", err)
+	}
+	closing, stopClosing := context.WithCancel(context.Background())
+	n := &P2PNode{
+		Addr:                   addr,
+		ChainID:                DefaultChainID,
+		peers:                  make(map[string]*peerState),
+		inbound:                make(map[string]*inboundRecord),
+		outboxes:               make(map[string]*peerOutbox),
+		identityKey:            identityKey,
+		nodeID:                 NodeIDFor(identityKey.Public().(ed25519.PublicKey)),
+		closing:                closing,
+		stopClosing:            stopClosing,
+		TxPool:                 make(chan *Transaction, 1000), // Buffered channel for transactions
+		BlockChan:              make(chan *Block, 100),        // Buffered channel for blocks
+		MaxTxAge:               DefaultMaxTxAge,
+		MaxTxSkew:              DefaultMaxTxClockSkew,
+		GossipFanout:           DefaultGossipFanout,
+		BroadcastQueueLen:      DefaultBroadcastQueueLen,
+		MaxPeerClockSkew:       DefaultMaxPeerClockSkew,
+		IsolationRetryInterval: DefaultIsolationRetryInterval,
+		DialTimeout:            DefaultDialTimeout,
+		MaxKnownNodes:          DefaultMaxKnownNodes,
+		ReconnectWorkers:       DefaultReconnectWorkers,
+		TargetPeers:            DefaultTargetPeers,
+		PeerLimiter:            NewRateLimiter(DefaultPeerRPCLimit, DefaultPeerRPCWindow),
+		MaxInboundConns:        DefaultMaxInboundConns,
+		rng:                    newRand(),
+	}
+	n.Transport = &grpcTransport{node: n}
+	return n
+}
+
+// gRPC server metrics, keyed by full method name.
+var (
+	grpcRequests      = expvar.NewMap("grpc_requests")
+	grpcErrors        = expvar.NewMap("grpc_errors")
+	grpcLatencyMicros = expvar.NewMap("grpc_latency_micros") // Cumulative; divide by grpc_requests for the mean
+	grpcPanics        = expvar.NewInt("grpc_panics")
+	grpcRateLimited   = expvar.NewInt("grpc_rate_limited")
+	grpcBannedPeers   = expvar.NewInt("grpc_banned_peer_requests")
+)
+
+// recoveryInterceptor turns a panic in a handler into an Internal error so one
+// malformed peer request cannot crash the node.
+func recoveryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			grpcPanics.Add(1)
+			log.Printf("ERROR: panic in %s: %v\n%s", info.FullMethod, r, debug.Stack())
+			resp, err = nil, status.Errorf(codes.Internal, "internal error handling %s", info.FullMethod)
+		}
+	}()
+	return handler(ctx, req)
+}
+
+// loggingInterceptor logs each request with its duration and resulting status code.
+func loggingInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	start := time.Now()
+	resp, err := handler(ctx, req)
+	log.Printf("gRPC %s %s in %v", info.FullMethod, status.Code(err), time.Since(start))
+	return resp, err
+}
+
+// metricsInterceptor records per-method request counts, errors and latency.
+func metricsInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	start := time.Now()
+	resp, err := handler(ctx, req)
+	grpcRequests.Add(info.FullMethod, 1)
+	grpcLatencyMicros.Add(info.FullMethod, time.Since(start).Microseconds())
+	if err != nil {
+		grpcErrors.Add(info.FullMethod, 1)
 	}
+	return resp, err
 }
 
-// StartGRPCServer starts the gRPC server for the node.
-// This method should be run in a goroutine.
-func (n *P2PNode) StartGRPCServer() {
-	lis, err := net.Listen("tcp", n.Addr)
+// rateLimitInterceptor refuses requests from banned peers with
+// PermissionDenied, and rejects requests from a remote host over PeerLimiter
+// with ResourceExhausted, so one noisy or hostile peer cannot starve the rest.
+// Every rejection also penalizes the peer that sent it.
+func (n *P2PNode) rateLimitInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	key, ok := remotePeer(ctx)
+	if !ok {
+		return handler(ctx, req)
+	}
+	if n.peerBanned(key, time.Now()) {
+		grpcBannedPeers.Add(1)
+		return nil, status.Errorf(codes.PermissionDenied, "peer %s is banned", key)
+	}
+	p, _ := peer.FromContext(ctx)
+	host, _, err := net.SplitHostPort(p.Addr.String())
 	if err != nil {
-		log.Fatalf("failed to listen: %v", err)
+		host = p.Addr.String()
 	}
-	n.grpcServer = grpc.NewServer()
-	// In a real project, you'd use pb.RegisterNodeServiceServer(n.grpcServer, n)
-	// For this mock, we'll just log that it's ready.
-	log.Printf("gRPC server listening on %s", n.Addr)
-	if err := n.grpcServer.Serve(lis); err != nil {
-		log.Fatalf("failed to serve: %v", err)
+	if !n.PeerLimiter.Allow(host) {
+		grpcRateLimited.Add(1)
+		n.PenalizePeer(key, RateLimitPenalty)
+		return nil, status.Errorf(codes.ResourceExhausted, "rate limit of %d requests per %s exceeded", n.PeerLimiter.Limit, n.PeerLimiter.Window)
+	}
+	return handler(ctx, req)
+}
+
+// remotePeer returns the key the sender of an inbound RPC is scored and banned
+// under: "id:" and the hex identity key its TLS certificate proves, or else its
+// remote host:port. Keying by host alone would punish every peer behind the
+// same NAT for one peer's misbehaviour.
+func remotePeer(ctx context.Context) (string, bool) {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return "", false
+	}
+	if info, ok := p.AuthInfo.(credentials.TLSInfo); ok && len(info.State.PeerCertificates) > 0 {
+		if key, ok := info.State.PeerCertificates[0].PublicKey.(ed25519.PublicKey); ok {
+			return "id:" + hex.EncodeToString(key), true
+		}
 	}
+	return p.Addr.String(), true
 }
 
-// ConnectToPeer establishes a gRPC connection to another peer.
-func (n *P2PNode) ConnectToPeer(peerAddr string) error {
+// inboundRecord is the misbehaviour score of an inbound peer by remotePeer key.
+type inboundRecord struct {
+	score       int
+	lastPenalty time.Time
+	bannedUntil time.Time // Zero unless the score reached BanScore
+}
+
+// PenalizePeer lowers the score of the inbound peer with remotePeer key by
+// penalty, refusing it for InboundBanDuration once it reaches BanScore. A
+// known peer at address key is penalized, and banned, the same way.
+func (n *P2PNode) PenalizePeer(key string, penalty int) {
+	if key == "" {
+		return
+	}
 	n.mu.Lock()
 	defer n.mu.Unlock()
+	now := time.Now()
+	n.pruneInbound(now)
+	rec, ok := n.inbound[key]
+	if !ok {
+		rec = &inboundRecord{}
+		n.inbound[key] = rec
+	}
+	rec.score -= penalty
+	rec.lastPenalty = now
+	if rec.score <= BanScore && !now.Before(rec.bannedUntil) {
+		rec.bannedUntil = now.Add(InboundBanDuration)
+		rec.score = 0 // Starts clean once the ban ends
+		log.Printf("Banned inbound peer %s for %s", key, InboundBanDuration)
+	}
 
-	if _, ok := n.Peers[peerAddr]; ok {
-		return nil // Already connected
+	if ps, ok := n.peers[key]; ok && ps.state != PeerBanned {
+		ps.score -= penalty
+		if ps.score <= BanScore {
+			n.transition(key, PeerBanned)
+			log.Printf("Banned peer %s: score %d", key, ps.score)
+			n.checkIsolated()
+		}
 	}
+}
 
-	conn, err := grpc.Dial(peerAddr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+// pruneInbound drops, at most once per InboundRecordTTL, the inbound records
+// that are not banned and were last penalized over InboundRecordTTL ago, so
+// peers cycling through source ports cannot grow the map without bound.
+// Callers must hold n.mu.
+func (n *P2PNode) pruneInbound(now time.Time) {
+	if now.Before(n.nextInboundPrune) {
+		return
+	}
+	n.nextInboundPrune = now.Add(InboundRecordTTL)
+	for key, rec := range n.inbound {
+		if now.Sub(rec.lastPenalty) >= InboundRecordTTL && !now.Before(rec.bannedUntil) {
+			delete(n.inbound, key)
+		}
+	}
+}
+
+// peerBanned reports whether the inbound peer with remotePeer key is banned,
+// for its own misbehaviour or as a banned known peer.
+func (n *P2PNode) peerBanned(key string, now time.Time) bool {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	if rec, ok := n.inbound[key]; ok && now.Before(rec.bannedUntil) {
+		return true
+	}
+	ps, ok := n.peers[key]
+	return ok && ps.state == PeerBanned
+}
+
+// PeerScore returns the misbehaviour score of addr, a known peer address or
+// an inbound remotePeer key; 0 is a clean record.
+func (n *P2PNode) PeerScore(addr string) int {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	if ps, ok := n.peers[addr]; ok {
+		return ps.score
+	}
+	if rec, ok := n.inbound[addr]; ok {
+		return rec.score
+	}
+	return 0
+}
+
+// RateLimiter allows up to Limit events per key within each Window.
+type RateLimiter struct {
+	Limit   int
+	Window  time.Duration
+	mu      sync.Mutex
+	windows map[string]*rateWindow
+}
+
+type rateWindow struct {
+	start time.Time
+	count int
+}
+
+// NewRateLimiter creates a fixed-window rate limiter
+func NewRateLimiter(limit int, window time.Duration) *RateLimiter {
+	return &RateLimiter{
+		Limit:   limit,
+		Window:  window,
+		windows: make(map[string]*rateWindow),
+	}
+}
+
+// Allow records an event for key and reports whether it is within the limit.
+func (l *RateLimiter) Allow(key string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	win, ok := l.windows[key]
+	if !ok || now.Sub(win.start) >= l.Window {
+		l.windows[key] = &rateWindow{start: now, count: 1}
+		return true
+	}
+	if win.count >= l.Limit {
+		return false
+	}
+	win.count++
+	return true
+}
+
+// StartGRPCServer starts the gRPC server for the node on every listen address
+// (e.g. an IPv4 and an IPv6 address, or a public and a private interface).
+// With no addresses it listens on n.Addr; peers are always told AdvertisedAddr.
+// Addresses that fail to bind are logged and skipped as long as at least one succeeds.
+// It refuses to start unless TLSConfig is set or AllowInsecure is explicitly enabled.
+// This method blocks until the server stops and should be run in a goroutine.
+func (n *P2PNode) StartGRPCServer(listenAddrs ...string) error {
+	if err := validateAdvertiseAddr(n.AdvertisedAddr()); err != nil {
+		return err
+	}
+	if len(listenAddrs) == 0 {
+		listenAddrs = []string{n.Addr}
+	}
+	return n.Transport.Serve(n, listenAddrs...)
+}
+
+// AdvertisedAddr returns the address peers should dial to reach this node.
+func (n *P2PNode) AdvertisedAddr() string {
+	if n.AdvertiseAddr != "" {
+		return n.AdvertiseAddr
+	}
+	return n.Addr
+}
+
+// isSelf reports whether addr refers to this node, by bind or advertised address.
+func (n *P2PNode) isSelf(addr string) bool {
+	return addr == n.Addr || addr == n.AdvertisedAddr()
+}
+
+// validateAdvertiseAddr rejects addresses peers could not dial: a missing or
+// zero port, or a wildcard host such as 0.0.0.0 or [::].
+func validateAdvertiseAddr(addr string) error {
+	host, port, err := net.SplitHostPort(addr)
 	if err != nil {
-		return fmt.Errorf("failed to connect to peer %s: %v", peerAddr, err)
-	}
-	// In a real project, you'd use pb.NewNodeServiceClient(conn)
-	// For this mock, we'll create a dummy client.
-	client := &mockNodeServiceClient{} // Replace with actual gRPC client
-	n.Peers[peerAddr] = client
-	n.KnownNodes[peerAddr] = true
-	log.Printf("Connected to peer: %s", peerAddr)
+		return fmt.Errorf("invalid advertise address %q: %v", addr, err)
+	}
+	if p, err := strconv.Atoi(port); err != nil || p <= 0 || p > 65535 {
+		return fmt.Errorf("advertise address %q has an invalid port", addr)
+	}
+	if host == "" {
+		return fmt.Errorf("advertise address %q has no host; set AdvertiseAddr to a reachable address", addr)
+	}
+	if ip := net.ParseIP(host); ip != nil && ip.IsUnspecified() {
+		return fmt.Errorf("advertise address %q is a wildcard address; set AdvertiseAddr to a reachable address", addr)
+	}
 	return nil
 }
 
-// DiscoverPeers periodically discovers and connects to new peers.
-// This method should be run in a goroutine.
+// errNoTransportSecurity is returned when neither TLS nor insecure mode is configured.
+var errNoTransportSecurity = errors.New("no TLS configured and AllowInsecure is not set; refusing to use plaintext gRPC")
+
+// transportCredentials returns TLS credentials when configured, plaintext only
+// when AllowInsecure is explicitly set, and an error otherwise.
+func (n *P2PNode) transportCredentials() (credentials.TransportCredentials, error) {
+	if n.TLSConfig != nil {
+		return credentials.NewTLS(n.TLSConfig), nil
+	}
+	if n.AllowInsecure {
+		return insecure.NewCredentials(), nil
+	}
+	return nil, errNoTransportSecurity
+}
+
+// --- Peer State ---
+
+// PeerState is where a peer address is in its connection lifecycle.
+type PeerState int
+
+const (
+	PeerDiscovered  PeerState = iota // Known address, never dialed
+	PeerConnecting                   // Dialing
+	PeerHandshaking                  // Dialed; exchanging handshakes
+	PeerConnected                    // Handshake succeeded; the peer receives gossip
+	PeerFailed                       // Last attempt failed or the connection dropped; may be retried
+	PeerBanned                       // Never dialed or gossiped to until unbanned
+)
+
+func (s PeerState) String() string {
+	switch s {
+	case PeerDiscovered:
+		return "discovered"
+	case PeerConnecting:
+		return "connecting"
+	case PeerHandshaking:
+		return "handshaking"
+	case PeerConnected:
+		return "connected"
+	case PeerFailed:
+		return "failed"
+	case PeerBanned:
+		return "banned"
+	default:
+		return fmt.Sprintf("PeerState(%d)", int(s))
+	}
+}
+
+// peerTransitions lists the legal moves out of each state.
+var peerTransitions = map[PeerState][]PeerState{
+	PeerDiscovered:  {PeerConnecting, PeerBanned},
+	PeerConnecting:  {PeerHandshaking, PeerFailed, PeerBanned},
+	PeerHandshaking: {PeerConnected, PeerFailed, PeerBanned},
+	PeerConnected:   {PeerFailed, PeerBanned},
+	PeerFailed:      {PeerConnecting, PeerBanned},
+	PeerBanned:      {PeerDiscovered},
+}
+
+// peerState is everything the node tracks about one peer address.
+type peerState struct {
+	state       PeerState
+	client      NodeServiceClient // Set only while Connected
+	knownSince  time.Time         // When the address was first learned
+	lastContact time.Time         // Last successful exchange; zero if never reached
+	clockSkew   time.Duration     // Peer clock minus ours, measured at handshake
+	failures    int               // Consecutive failed attempts or drops
+	successes   int               // Successful connections over the address's lifetime
+	nodeID      string            // Node ID the peer reported at its last handshake
+	score       int               // Misbehaviour penalties; banned at BanScore, reset by UnbanPeer
+}
+
+// transition moves addr to state to, returning an error if addr is unknown or
+// the move is not in peerTransitions. Callers must hold n.mu.
+func (n *P2PNode) transition(addr string, to PeerState) error {
+	ps, ok := n.peers[addr]
+	if !ok {
+		return fmt.Errorf("peer %s is not known", addr)
+	}
+	if !slices.Contains(peerTransitions[ps.state], to) {
+		return fmt.Errorf("peer %s cannot move from %s to %s", addr, ps.state, to)
+	}
+	if ps.state == PeerConnected {
+		ps.client = nil
+		ps.clockSkew = 0
+	}
+	switch to {
+	case PeerConnected:
+		ps.lastContact = time.Now()
+		ps.failures = 0
+		ps.successes++
+	case PeerFailed:
+		ps.failures++
+	case PeerDiscovered:
+		ps.score = 0 // Only reachable from Banned
+	}
+	ps.state = to
+	return nil
+}
+
+// connectedPeers returns the clients of every Connected peer. Callers must hold n.mu.
+func (n *P2PNode) connectedPeers() map[string]NodeServiceClient {
+	peers := make(map[string]NodeServiceClient)
+	for addr, ps := range n.peers {
+		if ps.state == PeerConnected {
+			peers[addr] = ps.client
+		}
+	}
+	return peers
+}
+
+// Peer returns the client for addr if it is connected.
+func (n *P2PNode) Peer(addr string) (NodeServiceClient, bool) {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	ps, ok := n.peers[addr]
+	if !ok || ps.state != PeerConnected {
+		return nil, false
+	}
+	return ps.client, true
+}
+
+// PeerCount returns the number of connected peers.
+func (n *P2PNode) PeerCount() int {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	return len(n.connectedPeers())
+}
+
+// PeerStates returns the state of every known peer address.
+func (n *P2PNode) PeerStates() map[string]PeerState {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	states := make(map[string]PeerState, len(n.peers))
+	for addr, ps := range n.peers {
+		states[addr] = ps.state
+	}
+	return states
+}
+
+// BanPeer disconnects addr, if connected, and refuses to dial it until UnbanPeer.
+func (n *P2PNode) BanPeer(addr string) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.addKnownNode(addr)
+	if err := n.transition(addr, PeerBanned); err != nil {
+		return err
+	}
+	log.Printf("Banned peer %s", addr)
+	n.checkIsolated()
+	return nil
+}
+
+// UnbanPeer makes a banned addr eligible for discovery and dialing again, and
+// lifts any inbound ban on it.
+func (n *P2PNode) UnbanPeer(addr string) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	delete(n.inbound, addr)
+	if _, ok := n.peers[addr]; !ok {
+		return nil // Only an inbound key
+	}
+	return n.transition(addr, PeerDiscovered)
+}
+
+// ConnectToPeer establishes a gRPC connection to another peer, moving it
+// through Connecting and Handshaking to Connected, or to Failed. Cancelling ctx
+// aborts the dial and handshake, which also give up after DialTimeout.
+func (n *P2PNode) ConnectToPeer(ctx context.Context, peerAddr string) error {
+	n.mu.Lock()
+	n.addKnownNode(peerAddr)
+	switch n.peers[peerAddr].state {
+	case PeerConnected, PeerConnecting, PeerHandshaking:
+		n.mu.Unlock()
+		return nil // Already connected, or another goroutine is connecting
+	}
+	err := n.transition(peerAddr, PeerConnecting) // Fails for banned peers
+	n.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	// The node lock is not held while dialing or handshaking, so a slow peer
+	// does not stall gossip, discovery or RPCs to other peers.
+	client, skew, nodeID, err := n.dialPeer(ctx, peerAddr)
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if err != nil {
+		n.transition(peerAddr, PeerFailed) // No-op if the peer was banned meanwhile
+		return err
+	}
+	if err := n.transition(peerAddr, PeerConnected); err != nil {
+		return err
+	}
+	ps := n.peers[peerAddr]
+	ps.client = client
+	ps.clockSkew = skew
+	ps.nodeID = nodeID
+	log.Printf("Connected to peer: %s (node %s)", peerAddr, nodeID)
+	if n.isolated {
+		n.isolated = false
+		log.Printf("Node %s reconnected via %s and is no longer isolated", n, peerAddr)
+	}
+	return nil
+}
+
+// dialPeer dials peerAddr and performs the handshake, returning the client,
+// the peer's measured clock skew and the node ID it reported.
+func (n *P2PNode) dialPeer(ctx context.Context, peerAddr string) (NodeServiceClient, time.Duration, string, error) {
+	client, err := n.Transport.Dial(ctx, peerAddr)
+	if err != nil {
+		return nil, 0, "", fmt.Errorf("failed to connect to peer %s: %v", peerAddr, err)
+	}
+	n.mu.Lock()
+	err = n.transition(peerAddr, PeerHandshaking)
+	n.mu.Unlock()
+	if err != nil {
+		closeClient(client)
+		return nil, 0, "", err
+	}
+
+	// Refuse peers from a different network (e.g. a testnet node dialing mainnet)
+	hsCtx, cancel := context.WithTimeout(ctx, n.DialTimeout)
+	sent := time.Now()
+	resp, err := client.Handshake(hsCtx, &HandshakeRequest{ChainId: n.ChainID, Addr: n.AdvertisedAddr(), Timestamp: sent.UnixMilli(), ProtocolVersion: ProtocolVersion, NodeId: n.NodeID()})
+	received := time.Now()
+	cancel()
+	if err != nil {
+		closeClient(client)
+		return nil, 0, "", fmt.Errorf("handshake with peer %s failed: %v", peerAddr, err)
+	}
+	if resp.GetChainId() != n.ChainID {
+		closeClient(client)
+		return nil, 0, "", fmt.Errorf("peer %s is on chain %q, expected %q", peerAddr, resp.GetChainId(), n.ChainID)
+	}
+	if err := checkProtocolVersion(resp.GetProtocolVersion()); err != nil {
+		closeClient(client)
+		return nil, 0, "", fmt.Errorf("peer %s: %v", peerAddr, err)
+	}
+	// Compare the peer's clock against the midpoint of the round trip
+	skew := time.UnixMilli(resp.GetTimestamp()).Sub(sent.Add(received.Sub(sent) / 2))
+	if err := n.checkPeerClockSkew(peerAddr, skew); err != nil {
+		closeClient(client)
+		return nil, 0, "", err
+	}
+	return client, skew, resp.GetNodeId(), nil
+}
+
+// closeClient releases a client the node is abandoning, if its transport holds
+// a connection open.
+func closeClient(client NodeServiceClient) {
+	if c, ok := client.(io.Closer); ok {
+		c.Close()
+	}
+}
+
+// DiscoverPeers connects to the seed peers, then periodically discovers and
+// connects to new peers. This method should be run in a goroutine.
 func (n *P2PNode) DiscoverPeers(initialPeers []string) {
+	n.mu.Lock()
+	n.bootstrapPeers = initialPeers
 	for _, peer := range initialPeers {
-		n.KnownNodes[peer] = true
+		n.addKnownNode(peer)
 	}
+	n.mu.Unlock()
+
+	n.connectKnownPeers()
+	n.connectToSeeds(initialPeers)
 
 	ticker := time.NewTicker(30 * time.Second) // Discover every 30 seconds
 	defer ticker.Stop()
 
 	for range ticker.C {
 		n.mu.RLock()
-		peersToQuery := make([]string, 0, len(n.Peers))
-		for addr := range n.Peers {
-			peersToQuery = append(peersToQuery, addr)
-		}
+		peersToQuery := n.connectedPeers()
 		n.mu.RUnlock()
 
-		for _, peerAddr := range peersToQuery {
-			client, ok := n.Peers[peerAddr]
-			if !ok {
-				continue // Peer might have been removed by another goroutine
-			}
+		for peerAddr, client := range peersToQuery {
 			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 			resp, err := client.GetKnownPeers(ctx, &GetKnownPeersRequest{}) // Use mock request
 			cancel()
 			if err != nil {
 				log.Printf("Failed to get peers from %s: %v", peerAddr, err)
-				n.mu.Lock()
-				delete(n.Peers, peerAddr) // Remove disconnected peer
-				n.mu.Unlock()
+				n.removePeer(peerAddr) // Remove disconnected peer
 				continue
 			}
-			for _, newPeerAddr := range resp.GetPeerAddresses() {
-				if newPeerAddr != n.Addr { // Don't connect to self
+			n.markContact(peerAddr)
+			for _, newPeerAddr := range freshPeerAddresses(resp, time.Now()) {
+				if !n.isSelf(newPeerAddr) { // Don't connect to self
 					n.mu.Lock()
-					if _, known := n.KnownNodes[newPeerAddr]; !known {
-						n.KnownNodes[newPeerAddr] = true
-						go n.ConnectToPeer(newPeerAddr) // Connect in a new goroutine
+					if _, known := n.peers[newPeerAddr]; !known {
+						n.addKnownNode(newPeerAddr)
+						go n.ConnectToPeer(n.closing, newPeerAddr) // Connect in a new goroutine
 					}
 					n.mu.Unlock()
 				}
@@ -181,13 +942,393 @@ func (n *P2PNode) DiscoverPeers(initialPeers []string) {
 	}
 }
 
-// BroadcastTransaction broadcasts a transaction to all connected peers.
+// addKnownNode remembers addr as Discovered if it is new, first evicting the least
+// recently useful address if that would exceed MaxKnownNodes. Callers must hold n.mu.
+func (n *P2PNode) addKnownNode(addr string) {
+	if _, ok := n.peers[addr]; ok {
+		return
+	}
+	if n.MaxKnownNodes > 0 && len(n.peers) >= n.MaxKnownNodes {
+		n.evictKnownNodes(len(n.peers) - n.MaxKnownNodes + 1)
+	}
+	n.peers[addr] = &peerState{state: PeerDiscovered, knownSince: time.Now()}
+}
+
+// evictKnownNodes forgets up to count Discovered or Failed addresses that are not
+// seeds. Addresses we never reached go first, oldest-learned first, then those
+// whose last successful contact is oldest. Callers must hold n.mu.
+func (n *P2PNode) evictKnownNodes(count int) {
+	seeds := make(map[string]bool, len(n.bootstrapPeers))
+	for _, addr := range n.bootstrapPeers {
+		seeds[addr] = true
+	}
+	var candidates []string
+	for addr, ps := range n.peers {
+		if (ps.state == PeerDiscovered || ps.state == PeerFailed) && !seeds[addr] {
+			candidates = append(candidates, addr)
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		pi, pj := n.peers[candidates[i]], n.peers[candidates[j]]
+		reachedI, reachedJ := !pi.lastContact.IsZero(), !pj.lastContact.IsZero()
+		if reachedI != reachedJ {
+			return !reachedI
+		}
+		ci, cj := pi.lastContact, pj.lastContact
+		if !reachedI {
+			ci, cj = pi.knownSince, pj.knownSince
+		}
+		if !ci.Equal(cj) {
+			return ci.Before(cj)
+		}
+		return candidates[i] < candidates[j]
+	})
+	for _, addr := range candidates[:min(count, len(candidates))] {
+		delete(n.peers, addr)
+		broadcastDrops.Delete(addr)
+	}
+}
+
+// --- Address Book ---
+
+// PeerRecord is one entry of the address book written by SavePeers.
+type PeerRecord struct {
+	Addr      string `json:"addr"`
+	LastSeen  int64  `json:"last_seen,omitempty"` // Unix seconds of the last successful contact
+	Successes int    `json:"successes"`
+	Failures  int    `json:"failures"` // Consecutive failures since the last success
+	Score     int    `json:"score"`    // Misbehaviour penalties; see BanScore
+	Banned    bool   `json:"banned,omitempty"`
+}
+
+// SavePeers atomically writes every known peer address and its history to
+// path as JSON, so a restarted node can reconnect to historically good peers
+// first. Best-first, the same order reconnection uses.
+func (n *P2PNode) SavePeers(path string) error {
+	n.mu.RLock()
+	records := make([]PeerRecord, 0, len(n.peers))
+	for _, addr := range n.reconnectOrder(true) {
+		ps := n.peers[addr]
+		rec := PeerRecord{Addr: addr, Successes: ps.successes, Failures: ps.failures, Score: ps.score, Banned: ps.state == PeerBanned}
+		if !ps.lastContact.IsZero() {
+			rec.LastSeen = ps.lastContact.Unix()
+		}
+		records = append(records, rec)
+	}
+	n.mu.RUnlock()
+
+	data, err := json.MarshalIndent(records, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("failed to save peers: %w", err)
+	}
+	return os.Rename(tmp, path)
+}
+
+// LoadPeers restores an address book written by SavePeers. Addresses come back
+// Discovered, or Banned if they were, with their history intact. A missing file
+// is not an error: the node starts with no known peers, as on first run.
+func (n *P2PNode) LoadPeers(path string) error {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to load peers: %w", err)
+	}
+	var records []PeerRecord
+	if err := json.Unmarshal(data, &records); err != nil {
+		return fmt.Errorf("failed to load peers from %s: %w", path, err)
+	}
+
+	now := time.Now()
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for _, rec := range records {
+		if rec.Addr == "" || n.isSelf(rec.Addr) {
+			continue
+		}
+		if _, known := n.peers[rec.Addr]; known {
+			continue
+		}
+		n.addKnownNode(rec.Addr)
+		ps := n.peers[rec.Addr]
+		ps.successes, ps.failures, ps.score = rec.Successes, rec.Failures, rec.Score
+		if rec.LastSeen > 0 {
+			ps.lastContact = time.Unix(min(rec.LastSeen, now.Unix()), 0) // A file from a clock ahead of ours must not outrank live contacts
+		}
+		if rec.Banned {
+			ps.state = PeerBanned
+		}
+	}
+	log.Printf("Loaded %d peer addresses from %s", len(records), path)
+	return nil
+}
+
+// reconnectOrder returns known addresses best first: by successes minus
+// consecutive failures plus score, then most recent contact, then address.
+// Banned addresses are left out unless includeBanned. Callers must hold n.mu.
+func (n *P2PNode) reconnectOrder(includeBanned bool) []string {
+	addrs := make([]string, 0, len(n.peers))
+	for addr, ps := range n.peers {
+		if includeBanned || ps.state != PeerBanned {
+			addrs = append(addrs, addr)
+		}
+	}
+	reputation := func(ps *peerState) int { return ps.successes - ps.failures + ps.score }
+	sort.Slice(addrs, func(i, j int) bool {
+		pi, pj := n.peers[addrs[i]], n.peers[addrs[j]]
+		if ri, rj := reputation(pi), reputation(pj); ri != rj {
+			return ri > rj
+		}
+		if !pi.lastContact.Equal(pj.lastContact) {
+			return pi.lastContact.After(pj.lastContact)
+		}
+		return addrs[i] < addrs[j]
+	})
+	return addrs
+}
+
+// connectKnownPeers dials the known addresses this node has reached before,
+// best first, e.g. those restored by LoadPeers, so a restarted node rejoins
+// through peers that served it well rather than only through its seeds. Up to
+// ReconnectWorkers dials run at once, and no new dial starts once TargetPeers
+// peers are connected or the node is closing.
+func (n *P2PNode) connectKnownPeers() {
+	n.mu.RLock()
+	var addrs []string
+	for _, addr := range n.reconnectOrder(false) {
+		if n.peers[addr].successes > 0 {
+			addrs = append(addrs, addr)
+		}
+	}
+	n.mu.RUnlock()
+
+	sem := make(chan struct{}, max(n.ReconnectWorkers, 1))
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for _, addr := range addrs {
+		select {
+		case sem <- struct{}{}:
+		case <-n.closing.Done():
+			return
+		}
+		if n.TargetPeers > 0 && n.PeerCount() >= n.TargetPeers {
+			<-sem
+			return
+		}
+		wg.Add(1)
+		go func(addr string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			if err := n.ConnectToPeer(n.closing, addr); err != nil {
+				log.Printf("Failed to reconnect to known peer %s: %v", addr, err)
+			}
+		}(addr)
+	}
+}
+
+// markContact records a successful exchange with a peer.
+func (n *P2PNode) markContact(addr string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if ps, ok := n.peers[addr]; ok {
+		ps.lastContact = time.Now()
+	}
+}
+
+// freshPeerAddresses orders the addresses in a GetKnownPeers response freshest
+// first and drops any not contacted within PeerHealthyWindow. Contact times
+// after now count as now, so a peer cannot put its addresses ahead of others
+// by claiming future contact. Responses from peers that only fill
+// PeerAddresses are used as-is.
+func freshPeerAddresses(resp *GetKnownPeersResponse, now time.Time) []string {
+	if len(resp.GetPeers()) == 0 {
+		return resp.GetPeerAddresses()
+	}
+	nowSecs := uint64(now.Unix())
+	seen := func(p *PeerAddress) uint64 { return min(p.GetLastSeen(), nowSecs) }
+	peers := append([]*PeerAddress(nil), resp.GetPeers()...)
+	sort.SliceStable(peers, func(i, j int) bool { return seen(peers[i]) > seen(peers[j]) })
+
+	cutoff := uint64(now.Add(-PeerHealthyWindow).Unix())
+	addrs := make([]string, 0, len(peers))
+	for _, p := range peers {
+		if seen(p) < cutoff {
+			break // Sorted, so every remaining entry is stale too
+		}
+		addrs = append(addrs, p.GetAddr())
+	}
+	return addrs
+}
+
+// removePeer marks a connected peer Failed after the connection drops.
+func (n *P2PNode) removePeer(addr string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if ps, ok := n.peers[addr]; !ok || ps.state != PeerConnected {
+		return
+	}
+	n.transition(addr, PeerFailed)
+	n.checkIsolated()
+}
+
+// checkIsolated enters the isolated state once the last peer is gone: the node
+// is partitioned and broadcasts silently go nowhere, so it aggressively retries
+// bootstrap and known peers until one connects. Callers must hold n.mu.
+func (n *P2PNode) checkIsolated() {
+	if len(n.connectedPeers()) == 0 && !n.isolated {
+		n.isolated = true
+		isolationEvents.Add(1)
+		log.Printf("WARNING: node %s lost all peers and is isolated; retrying every %s", n, n.IsolationRetryInterval)
+		go n.reconnectWhileIsolated()
+	}
+}
+
+// connectToSeeds dials every seed with exponential backoff until the node has
+// a peer, so a node started just before its seeds joins as soon as they come up
+// instead of waiting a full discovery cycle. Retries wait at most
+// BootstrapMaxBackoff and stop when the node closes or has no seed but itself.
+func (n *P2PNode) connectToSeeds(seeds []string) {
+	backoff := BootstrapInitialBackoff
+	for {
+		dialed := false
+		for _, addr := range seeds {
+			if n.isSelf(addr) {
+				continue
+			}
+			if n.closing.Err() != nil {
+				return
+			}
+			dialed = true
+			if err := n.ConnectToPeer(n.closing, addr); err != nil {
+				log.Printf("Bootstrap: failed to connect to seed %s: %v", addr, err)
+			}
+		}
+		if !dialed || n.PeerCount() > 0 {
+			return // Connected, to a seed or a saved peer, or nothing to dial
+		}
+		log.Printf("Bootstrap: no seeds reachable, retrying in %s", backoff)
+		select {
+		case <-time.After(backoff):
+		case <-n.closing.Done():
+			return
+		}
+		backoff = min(backoff*2, BootstrapMaxBackoff)
+	}
+}
+
+// reconnectWhileIsolated retries bootstrap and known peers on a short interval
+// until the node has at least one connection again.
+func (n *P2PNode) reconnectWhileIsolated() {
+	ticker := time.NewTicker(n.IsolationRetryInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-n.closing.Done():
+			return
+		}
+		n.mu.RLock()
+		if !n.isolated {
+			n.mu.RUnlock()
+			return
+		}
+		candidates := append(n.reconnectOrder(false), n.bootstrapPeers...)
+		n.mu.RUnlock()
+
+		for _, addr := range candidates {
+			if n.isSelf(addr) {
+				continue
+			}
+			if err := n.ConnectToPeer(n.closing, addr); err != nil {
+				log.Printf("Isolated node %s failed to reconnect to %s: %v", n, addr, err)
+			}
+		}
+	}
+}
+
+// checkPeerClockSkew warns when a peer's clock differs from ours by more than
+// MaxPeerClockSkew, and returns an error if RefuseSkewedPeers is set.
+func (n *P2PNode) checkPeerClockSkew(peerAddr string, skew time.Duration) error {
+	if skew.Abs() <= n.MaxPeerClockSkew {
+		return nil
+	}
+	log.Printf("WARNING: peer %s clock is %s off from ours (limit %s); check its NTP configuration", peerAddr, skew, n.MaxPeerClockSkew)
+	if n.RefuseSkewedPeers {
+		return fmt.Errorf("peer %s clock skew %s exceeds %s", peerAddr, skew, n.MaxPeerClockSkew)
+	}
+	return nil
+}
+
+// PeerClockSkews returns the clock skew measured for each connected peer at handshake.
+func (n *P2PNode) PeerClockSkews() map[string]time.Duration {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	skews := make(map[string]time.Duration)
+	for addr, ps := range n.peers {
+		if ps.state == PeerConnected {
+			skews[addr] = ps.clockSkew
+		}
+	}
+	return skews
+}
+
+// Isolated reports whether the node has lost all of its peers.
+func (n *P2PNode) Isolated() bool {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	return n.isolated
+}
+
+// gossipTargets picks the subset of connected peers a message is pushed to:
+// ceil(GossipFanout * sqrt(connected peers)) peers chosen at random from the seeded
+// source. Broadcasting to every peer costs O(peers^2) traffic in dense networks,
+// while re-broadcast by receivers still reaches the whole network with high probability.
+// Callers must hold n.mu.
+func (n *P2PNode) gossipTargets() map[string]NodeServiceClient {
+	return n.randomPeers(int(math.Ceil(n.GossipFanout * math.Sqrt(float64(len(n.connectedPeers()))))))
+}
+
+// randomPeers returns count connected peers (at least one, at most all) chosen
+// at random from the seeded source. Callers must hold n.mu.
+func (n *P2PNode) randomPeers(count int) map[string]NodeServiceClient {
+	peers := n.connectedPeers()
+	addrs := make([]string, 0, len(peers))
+	for addr := range peers {
+		addrs = append(addrs, addr)
+	}
+	sort.Strings(addrs) // Map order is random; sort so the seeded source alone drives selection
+
+	if count < 1 {
+		count = 1
+	}
+	if count > len(addrs) {
+		count = len(addrs)
+	}
+
+	n.rngMu.Lock()
+	n.rng.Shuffle(len(addrs), func(i, j int) { addrs[i], addrs[j] = addrs[j], addrs[i] })
+	n.rngMu.Unlock()
+
+	targets := make(map[string]NodeServiceClient, count)
+	for _, addr := range addrs[:count] {
+		targets[addr] = peers[addr]
+	}
+	return targets
+}
+
+// BroadcastTransaction gossips a transaction to a random subset of connected peers.
 func (n *P2PNode) BroadcastTransaction(tx *Transaction) {
 	n.mu.RLock()
 	defer n.mu.RUnlock()
 
-	for addr, client := range n.Peers {
-		go func(addr string, client NodeServiceClient) {
+	for addr, client := range n.gossipTargets() {
+		n.enqueueSend(addr, false, func() {
 			ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
 			_, err := client.SendTransaction(ctx, &SendTransactionRequest{Transaction: tx}) // Use mock request
 			cancel()
@@ -195,17 +1336,17 @@ func (n *P2PNode) BroadcastTransaction(tx *Transaction) {
 				log.Printf("Failed to send transaction to %s: %v", addr, err)
 				// TODO: Implement peer disconnection handling or retry logic
 			}
-		}(addr, client)
+		})
 	}
 }
 
-// BroadcastBlock broadcasts a block to all connected peers.
+// BroadcastBlock gossips a block to a random subset of connected peers.
 func (n *P2PNode) BroadcastBlock(block *Block) {
 	n.mu.RLock()
 	defer n.mu.RUnlock()
 
-	for addr, client := range n.Peers {
-		go func(addr string, client NodeServiceClient) {
+	for addr, client := range n.gossipTargets() {
+		n.enqueueSend(addr, true, func() {
 			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 			_, err := client.SendBlock(ctx, &SendBlockRequest{Block: block}) // Use mock request
 			cancel()
@@ -213,26 +1354,190 @@ func (n *P2PNode) BroadcastBlock(block *Block) {
 				log.Printf("Failed to send block to %s: %v", addr, err)
 				// TODO: Implement peer disconnection handling or retry logic
 			}
-		}(addr, client)
+		})
+	}
+}
+
+// peerOutbox holds the gossip sends waiting for one peer.
+type peerOutbox struct {
+	blocks  []func() // Block sends, which run before any transaction send
+	txs     []func()
+	running bool // A drainOutbox goroutine is sending them
+}
+
+// enqueueSend queues send for the peer at addr, to run after the sends
+// already queued for it. Each peer's sends run one at a time on a single
+// goroutine, so a slow peer holds up only its own queue. Block sends are
+// queued separately and run first, so a backlog of transaction gossip never
+// delays a block. Once either queue holds BroadcastQueueLen sends, one is
+// dropped according to BroadcastDropPolicy and counted in p2p_broadcast_drops
+// and p2p_broadcast_drops_total.
+func (n *P2PNode) enqueueSend(addr string, block bool, send func()) {
+	n.outboxMu.Lock()
+	defer n.outboxMu.Unlock()
+	box, ok := n.outboxes[addr]
+	if !ok {
+		box = &peerOutbox{}
+		n.outboxes[addr] = box
+	}
+	queue := &box.txs
+	if block {
+		queue = &box.blocks
+	}
+	if len(*queue) >= max(n.BroadcastQueueLen, 1) {
+		broadcastDrops.Add(addr, 1)
+		broadcastDropsTotal.Add(1)
+		if n.BroadcastDropPolicy == DropNewest {
+			return
+		}
+		*queue = (*queue)[1:]
+		n.broadcasts.Done()
+	}
+	n.broadcasts.Add(1)
+	*queue = append(*queue, send)
+	if !box.running {
+		box.running = true
+		go n.drainOutbox(addr, box)
+	}
+}
+
+// drainOutbox runs the sends queued in box until it is empty, then removes it.
+func (n *P2PNode) drainOutbox(addr string, box *peerOutbox) {
+	for {
+		n.outboxMu.Lock()
+		var send func()
+		switch {
+		case len(box.blocks) > 0:
+			send, box.blocks = box.blocks[0], box.blocks[1:]
+		case len(box.txs) > 0:
+			send, box.txs = box.txs[0], box.txs[1:]
+		default:
+			box.running = false
+			delete(n.outboxes, addr)
+			n.outboxMu.Unlock()
+			return
+		}
+		n.outboxMu.Unlock()
+
+		send()
+		n.broadcasts.Done()
+	}
+}
+
+// Close shuts the node down without dropping queued transactions. It
+// re-broadcasts everything still in TxPool, stops serving peers (letting
+// in-flight RPCs finish), broadcasts anything those RPCs queued, waits for
+// outbound sends, and only then closes TxPool and BlockChan.
+// ctx bounds how long Close waits for outbound sends.
+func (n *P2PNode) Close(ctx context.Context) error {
+	var err error
+	n.closeOnce.Do(func() {
+		n.stopClosing() // Abort in-flight dials; no new peers are needed while shutting down
+		drained := n.drainTxPool()
+		n.Transport.Stop()
+		drained += n.drainTxPool() // Transactions received while the server was stopping
+
+		done := make(chan struct{})
+		go func() {
+			n.broadcasts.Wait()
+			close(done)
+		}()
+		select {
+		case <-done:
+			log.Printf("Node %s broadcast %d queued transactions before shutdown", n, drained)
+		case <-ctx.Done():
+			err = fmt.Errorf("shutdown timed out waiting for broadcasts: %w", ctx.Err())
+		}
+
+		close(n.TxPool)
+		close(n.BlockChan)
+	})
+	return err
+}
+
+// drainTxPool broadcasts every transaction currently queued in TxPool.
+func (n *P2PNode) drainTxPool() int {
+	drained := 0
+	for {
+		select {
+		case tx := <-n.TxPool:
+			n.BroadcastTransaction(tx)
+			drained++
+		default:
+			return drained
+		}
 	}
 }
 
 // --- gRPC Service Method Implementations (for P2PNode to act as a server) ---
 
-// GetKnownPeers is a gRPC method that returns the list of known peer addresses.
+// Handshake is a gRPC method called by a connecting peer. Peers on a different
+// chain are refused so testnet and mainnet traffic never mixes.
+func (n *P2PNode) Handshake(ctx context.Context, req *HandshakeRequest) (*HandshakeResponse, error) {
+	if req.GetChainId() != n.ChainID {
+		log.Printf("Refusing handshake from %s: chain %q, expected %q", req.GetAddr(), req.GetChainId(), n.ChainID)
+		return nil, status.Errorf(codes.FailedPrecondition, "chain ID mismatch: got %q, expected %q", req.GetChainId(), n.ChainID)
+	}
+	if err := checkProtocolVersion(req.GetProtocolVersion()); err != nil {
+		log.Printf("Refusing handshake from %s: %v", req.GetAddr(), err)
+		return nil, status.Error(codes.FailedPrecondition, err.Error())
+	}
+	now := time.Now()
+	if err := n.checkPeerClockSkew(req.GetAddr(), time.UnixMilli(req.GetTimestamp()).Sub(now)); err != nil {
+		return nil, status.Error(codes.FailedPrecondition, err.Error())
+	}
+	return &HandshakeResponse{ChainId: n.ChainID, Timestamp: now.UnixMilli(), ProtocolVersion: ProtocolVersion, NodeId: n.NodeID()}, nil
+}
+
+// GetKnownPeers is a gRPC method that returns our own advertised address followed
+// by connected peers we have heard from within PeerHealthyWindow, freshest first
+// and capped at MaxPeerExchange. Addresses we merely know about are not shared,
+// so dead ones stop spreading.
 func (n *P2PNode) GetKnownPeers(ctx context.Context, req *GetKnownPeersRequest) (*GetKnownPeersResponse, error) {
 	n.mu.RLock()
 	defer n.mu.RUnlock()
-	peers := make([]string, 0, len(n.KnownNodes))
-	for addr := range n.KnownNodes {
-		peers = append(peers, addr)
+
+	now := time.Now()
+	cutoff := now.Add(-PeerHealthyWindow)
+	peers := make([]*PeerAddress, 0, len(n.peers)+1)
+	for addr, ps := range n.peers {
+		if ps.state != PeerConnected {
+			continue
+		}
+		seen := ps.lastContact
+		if seen.Before(cutoff) {
+			continue // Connected but silent; may be dead
+		}
+		peers = append(peers, &PeerAddress{Addr: addr, LastSeen: uint64(seen.Unix())})
+	}
+	sort.Slice(peers, func(i, j int) bool { return peers[i].LastSeen > peers[j].LastSeen })
+	// We are always reachable to whoever asked, so advertise ourselves first
+	peers = append([]*PeerAddress{{Addr: n.AdvertisedAddr(), LastSeen: uint64(now.Unix())}}, peers...)
+	if len(peers) > MaxPeerExchange {
+		peers = peers[:MaxPeerExchange]
 	}
-	return &GetKnownPeersResponse{PeerAddresses: peers}, nil
+
+	addrs := make([]string, len(peers))
+	for i, p := range peers {
+		addrs[i] = p.Addr
+	}
+	return &GetKnownPeersResponse{PeerAddresses: addrs, Peers: peers}, nil
 }
 
 // SendTransaction is a gRPC method to receive a transaction from another node.
 func (n *P2PNode) SendTransaction(ctx context.Context, req *SendTransactionRequest) (*SendTransactionResponse, error) {
-	log.Printf("Node %s received transaction: %x", n.Addr, req.GetTransaction().GetHash())
+	if req.GetTransaction() == nil {
+		return &SendTransactionResponse{Success: false}, status.Error(codes.InvalidArgument, "transaction is missing")
+	}
+	log.Printf("Node %s received transaction: %x", n, req.GetTransaction().GetHash())
+	if req.GetTransaction().GetChainId() != n.ChainID {
+		log.Printf("Rejecting transaction %x from chain %q", req.GetTransaction().GetHash(), req.GetTransaction().GetChainId())
+		return &SendTransactionResponse{Success: false}, status.Errorf(codes.InvalidArgument, "transaction is for chain %q, expected %q", req.GetTransaction().GetChainId(), n.ChainID)
+	}
+	if err := n.checkTxTimestamp(req.GetTransaction(), time.Now()); err != nil {
+		log.Printf("Rejecting transaction %x: %v", req.GetTransaction().GetHash(), err)
+		return &SendTransactionResponse{Success: false}, status.Error(codes.InvalidArgument, err.Error())
+	}
 	// In a real system:
 	// 1. Validate the transaction (signature, format, etc.)
 	// 2. Add to local mempool
@@ -242,13 +1547,193 @@ func (n *P2PNode) SendTransaction(ctx context.Context, req *SendTransactionReque
 		// Successfully added to channel
 	default:
 		log.Printf("TxPool full, dropping transaction from %x", req.GetTransaction().GetHash())
+		return &SendTransactionResponse{Success: false}, nil // No receipt for a transaction we dropped
 	}
-	return &SendTransactionResponse{Success: true}, nil
+	return &SendTransactionResponse{Success: true, Receipt: n.SignReceipt(req.GetTransaction().GetHash())}, nil
+}
+
+// PublicKey returns the node's Ed25519 identity public key, against which its receipts verify.
+func (n *P2PNode) PublicKey() ed25519.PublicKey {
+	return n.identityKey.Public().(ed25519.PublicKey)
+}
+
+// NodeIDLen is how many bytes of the identity key's hash make up a node ID.
+const NodeIDLen = 8
+
+// NodeIDFor returns the node ID of the node with identity key pub: the hex of
+// the first NodeIDLen bytes of its SHA3-256 hash. It does not follow the
+// genesis hash algorithm, so a node keeps its ID on every network.
+func NodeIDFor(pub ed25519.PublicKey) string {
+	sum := sha3.Sum256(pub)
+	return hex.EncodeToString(sum[:NodeIDLen])
+}
+
+// NodeID returns the node's ID, which identifies it in logs, handshakes and
+// admin output. Unlike Addr it survives address changes, and it is stable
+// across restarts as long as the identity key is.
+func (n *P2PNode) NodeID() string {
+	return n.nodeID
+}
+
+// String returns the node's ID and listen address, for logs.
+func (n *P2PNode) String() string {
+	return n.nodeID + "@" + n.Addr
+}
+
+// UseIdentityKey replaces the node's randomly generated identity key, e.g.
+// with one from LoadIdentityKey so that its ID and receipt signatures survive
+// restarts. Call it before the node starts.
+func (n *P2PNode) UseIdentityKey(key ed25519.PrivateKey) {
+	n.identityKey = key
+	n.nodeID = NodeIDFor(key.Public().(ed25519.PublicKey))
+}
+
+// UseRandSource replaces the source of the node's random choices, such as
+// which peers a message is gossiped to, e.g. with rand.NewSource(seed) so a
+// test reproduces them exactly: nodes given the same seed and the same peers
+// make the same choices. Call it before the node starts.
+func (n *P2PNode) UseRandSource(src mrand.Source) {
+	n.rngMu.Lock()
+	defer n.rngMu.Unlock()
+	n.rng = mrand.New(src)
+}
+
+// newRand returns a generator seeded from crypto/rand, so peers cannot
+// predict a node's random choices.
+func newRand() *mrand.Rand {
+	var seed [8]byte
+	if _, err := rand.Read(seed[:]); err != nil {
+		log.Fatalf("failed to seed random source: %v", err)
+	}
+	return mrand.New(mrand.NewSource(int64(binary.LittleEndian.Uint64(seed[:]))))
+}
+
+// LoadIdentityKey reads a hex-encoded Ed25519 seed from path. If the file does
+// not exist, as on first run, a new key is generated and its seed written
+// there readable only by the owner (0600), creating the directory if needed,
+// and the new node ID is logged. A key file readable by other users is still
+// loaded, with a warning.
+func LoadIdentityKey(path string) (ed25519.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return generateIdentityKey(path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load identity key: %w", err)
+	}
+	if info, err := os.Stat(path); err == nil && info.Mode().Perm()&0077 != 0 {
+		log.Printf("Warning: identity key %s is accessible to other users (mode %v); restrict it with chmod 600", path, info.Mode().Perm())
+	}
+	seed, err := hex.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil || len(seed) != ed25519.SeedSize {
+		return nil, fmt.Errorf("identity key in %s must be a hex-encoded %d-byte seed", path, ed25519.SeedSize)
+	}
+	return ed25519.NewKeyFromSeed(seed), nil
+}
+
+// generateIdentityKey creates a new identity key and saves its seed to path for
+// LoadIdentityKey, through writeFileAtomic so a crash never leaves a truncated
+// key behind.
+func generateIdentityKey(path string) (ed25519.PrivateKey, error) {
+	pub, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate identity key: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, fmt.Errorf("failed to save identity key: %w", err)
+	}
+	if err := writeFileAtomic(path, []byte(hex.EncodeToString(key.Seed())), 0600); err != nil {
+		return nil, fmt.Errorf("failed to save identity key: %w", err)
+	}
+	log.Printf("Generated a new identity key in %s: node ID %s", path, NodeIDFor(pub))
+	return key, nil
+}
+
+// writeFileAtomic replaces path with data: it writes a temporary file with
+// mode perm, syncs it, renames it into place and syncs the directory, so after
+// a crash path holds either its old contents or all of data.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp := path + ".tmp"
+	os.Remove(tmp) // OpenFile keeps the mode of a leftover file, which may be looser
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_EXCL|os.O_WRONLY, perm)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	dir, err := os.Open(filepath.Dir(path))
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	return dir.Sync() // Persist the rename itself
+}
+
+// SignReceipt produces a signed receipt stating this node accepted txHash now.
+// Submitters can present it as proof of delivery when resolving disputes.
+func (n *P2PNode) SignReceipt(txHash []byte) *TxReceipt {
+	receipt := &TxReceipt{
+		TxHash:     txHash,
+		NodePubKey: n.PublicKey(),
+		Timestamp:  uint64(time.Now().Unix()),
+	}
+	receipt.Signature = ed25519.Sign(n.identityKey, receipt.SigningBytes())
+	return receipt
+}
+
+// VerifyReceipt checks that receipt was signed by the node with public key nodePubKey.
+func VerifyReceipt(receipt *TxReceipt, nodePubKey ed25519.PublicKey) error {
+	if receipt == nil {
+		return fmt.Errorf("receipt is missing")
+	}
+	if !bytes.Equal(receipt.NodePubKey, nodePubKey) {
+		return fmt.Errorf("receipt was issued by %x, expected %x", receipt.NodePubKey, []byte(nodePubKey))
+	}
+	if !ed25519.Verify(nodePubKey, receipt.SigningBytes(), receipt.Signature) {
+		return fmt.Errorf("receipt signature is invalid")
+	}
+	return nil
+}
+
+// checkTxTimestamp rejects transactions outside the replay window
+// [now - MaxTxAge, now + MaxTxSkew]. Combined with a seen-set this bounds how
+// long a captured transaction can be replayed.
+func (n *P2PNode) checkTxTimestamp(tx *Transaction, now time.Time) error {
+	ts := time.Unix(int64(tx.GetTimestamp()), 0)
+	if ts.Before(now.Add(-n.MaxTxAge)) {
+		return fmt.Errorf("transaction timestamp %s is older than %s", ts.UTC().Format(time.RFC3339), n.MaxTxAge)
+	}
+	if ts.After(now.Add(n.MaxTxSkew)) {
+		return fmt.Errorf("transaction timestamp %s is more than %s in the future", ts.UTC().Format(time.RFC3339), n.MaxTxSkew)
+	}
+	return nil
 }
 
 // SendBlock is a gRPC method to receive a block from another node.
 func (n *P2PNode) SendBlock(ctx context.Context, req *SendBlockRequest) (*SendBlockResponse, error) {
-	log.Printf("Node %s received block: %x at height %d", n.Addr, req.GetBlock().GetHeader().GetHash(), req.GetBlock().GetHeader().GetHeight())
+	if req.GetBlock() == nil {
+		return &SendBlockResponse{Success: false}, status.Error(codes.InvalidArgument, "block is missing")
+	}
+	if req.GetBlock().GetHeader() == nil {
+		return &SendBlockResponse{Success: false}, status.Error(codes.InvalidArgument, "block header is missing")
+	}
+	log.Printf("Node %s received block: %x at height %d", n, req.GetBlock().GetHeader().GetHash(), req.GetBlock().GetHeader().GetHeight())
+	if req.GetBlock().GetHeader().GetChainId() != n.ChainID {
+		log.Printf("Rejecting block %x from chain %q", req.GetBlock().GetHeader().GetHash(), req.GetBlock().GetHeader().GetChainId())
+		return &SendBlockResponse{Success: false}, status.Errorf(codes.InvalidArgument, "block is for chain %q, expected %q", req.GetBlock().GetHeader().GetChainId(), n.ChainID)
+	}
 	// In a real system:
 	// 1. Validate the block (PoS/PBFT signatures, transactions, etc.)
 	// 2. Add to local blockchain
@@ -266,9 +1751,21 @@ func (n *P2PNode) SendBlock(ctx context.Context, req *SendBlockRequest) (*SendBl
 // In a real scenario, this would be generated by protoc.
 type mockNodeServiceClient struct{}
 
+func (m *mockNodeServiceClient) Handshake(ctx context.Context, in *HandshakeRequest, opts ...grpc.CallOption) (*HandshakeResponse, error) {
+	// Simulate a peer on the same chain with a synchronized clock
+	return &HandshakeResponse{ChainId: in.ChainId, Timestamp: time.Now().UnixMilli(), ProtocolVersion: in.ProtocolVersion}, nil
+}
+
 func (m *mockNodeServiceClient) GetKnownPeers(ctx context.Context, in *GetKnownPeersRequest, opts ...grpc.CallOption) (*GetKnownPeersResponse, error) {
 	// Simulate returning some dummy peers
-	return &GetKnownPeersResponse{PeerAddresses: []string{"localhost:50052", "localhost:50053"}}, nil
+	now := uint64(time.Now().Unix())
+	return &GetKnownPeersResponse{
+		PeerAddresses: []string{"localhost:50052", "localhost:50053"},
+		Peers: []*PeerAddress{
+			{Addr: "localhost:50052", LastSeen: now},
+			{Addr: "localhost:50053", LastSeen: now},
+		},
+	}, nil
 }
 
 func (m *mockNodeServiceClient) SendTransaction(ctx context.Context, in *SendTransactionRequest, opts ...grpc.CallOption) (*SendTransactionResponse, error) {
@@ -285,12 +1782,22 @@ func (m *mockNodeServiceClient) SendBlock(ctx context.Context, in *SendBlockRequ
 func main() {
 	// Node 1
 	node1 := NewP2PNode("localhost:50051")
-	go node1.StartGRPCServer()
+	node1.AllowInsecure = true // Local demo only
+	go func() {
+		if err := node1.StartGRPCServer(); err != nil {
+			log.Fatalf("node1 gRPC server failed: %v", err)
+		}
+	}()
 	go node1.DiscoverPeers([]string{"localhost:50052"}) // Seed with a known peer
 
 	// Node 2
 	node2 := NewP2PNode("localhost:50052")
-	go node2.StartGRPCServer()
+	node2.AllowInsecure = true // Local demo only
+	go func() {
+		if err := node2.StartGRPCServer(); err != nil {
+			log.Fatalf("node2 gRPC server failed: %v", err)
+		}
+	}()
 	go node2.DiscoverPeers([]string{"localhost:50051"}) // Seed with node1
 
 	// Simulate a transaction being created and broadcast
@@ -300,6 +1807,8 @@ func main() {
 		Sender:    []byte("Alice"),
 		Recipient: []byte("Bob"),
 		Amount:    100,
+		Timestamp: uint64(time.Now().Unix()),
+		ChainId:   DefaultChainID,
 		Signature: []byte("sig123"),
 	}
 	log.Println("Node 1 broadcasting transaction...")
@@ -309,8 +1818,9 @@ func main() {
 	time.Sleep(2 * time.Second)
 	block := &Block{
 		Header: &BlockHeader{
-			Hash: []byte{0x04, 0x05, 0x06},
-			Height: 10,
+			Hash:    []byte{0x04, 0x05, 0x06},
+			Height:  10,
+			ChainId: DefaultChainID,
 		},
 		Transactions: []*Transaction{tx},
 	}